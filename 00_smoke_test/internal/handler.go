@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"io"
 	"log/slog"
 	"net"
@@ -8,16 +9,16 @@ import (
 
 // Handle handles a single client connection. This should be run in a separate gorutine so that requests can be handled
 // concurrently.
-func Handle(connection net.Conn) {
+func Handle(ctx context.Context, connection net.Conn) {
 	numBytes := int64(0)
-	slog.Info("client connected", "remote_address", connection.RemoteAddr().String())
+	slog.InfoContext(ctx, "client connected", "remote_address", connection.RemoteAddr().String())
 	defer func() {
-		slog.Info("client disconnected", "address", connection.RemoteAddr().String(), "num_bytes", numBytes)
+		slog.InfoContext(ctx, "client disconnected", "address", connection.RemoteAddr().String(), "num_bytes", numBytes)
 	}()
 	defer connection.Close()
 	numBytes, err := io.Copy(connection, connection)
 	if err != nil {
-		slog.Error("copy failed", "error", err)
+		slog.ErrorContext(ctx, "copy failed", "error", err)
 		return
 	}
 }
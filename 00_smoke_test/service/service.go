@@ -0,0 +1,34 @@
+// Package service exposes the smoke test server as a runner.Service, so that it can be started by both the
+// standalone server and the combined protohackers binary.
+package service
+
+import (
+	"time"
+
+	"github.com/ananthvk/protohackers-go/00_smoke_test/internal"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+)
+
+// Name is the name of the service, used in logs
+const Name = "smoke"
+
+// Config holds the settings of the service
+type Config struct {
+	Address string
+	// TLSAddress serves TLS on a separate address, see runner.Service.TLSAddress
+	TLSAddress string
+}
+
+// New creates the service
+func New(cfg Config) *runner.Service {
+	return &runner.Service{
+		Name:       Name,
+		Network:    "tcp",
+		Address:    cfg.Address,
+		TLSAddress: cfg.TLSAddress,
+		// Echo has no messages, so only inactivity and slow writers are limited
+		Timeouts: timeout.Policy{Idle: time.Minute, Write: 10 * time.Second},
+		Handler:  internal.Handle,
+	}
+}
@@ -2,37 +2,110 @@ package internal
 
 import (
 	"bufio"
+	"context"
 	"log/slog"
 	"net"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/limit"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
 )
 
+// Server holds the settings shared by the connections of the service
+type Server struct {
+	// LineRate limits the requests of each connection. A client that goes over it gets a malformed response, and is
+	// disconnected
+	LineRate limit.Rate
+	// MaxDigits is the largest number of digits accepted in a number, larger numbers are malformed. Zero uses
+	// DefaultMaxDigits
+	MaxDigits int
+	// MaxRange is the largest number of integers checked by a primesInRange request of 64-bit numbers, see Limits. Zero
+	// uses DefaultMaxRange
+	MaxRange int
+	// MaxBatch is the largest number of requests in a JSON-RPC batch, larger batches are invalid. Every request of a
+	// batch counts towards LineRate. Zero uses DefaultMaxBatch
+	MaxBatch int
+	// JSONRPC switches to JSON-RPC 2.0, see HandleRPC. Errors are then answered with error objects, instead of
+	// disconnecting the client
+	JSONRPC bool
+}
+
+// limits returns the limits of the requests, with the defaults filled in
+func (s *Server) limits() Limits {
+	limits := DefaultLimits()
+	if s.MaxDigits != 0 {
+		limits.MaxDigits = s.MaxDigits
+	}
+	if s.MaxRange != 0 {
+		limits.MaxRange = s.MaxRange
+	}
+	if s.MaxBatch != 0 {
+		limits.MaxBatch = s.MaxBatch
+	}
+	return limits
+}
+
 // Handle handles a single client connection. This should be run in a separate gorutine so that requests can be handled
 // concurrently.
-func Handle(connection net.Conn) {
+func Handle(ctx context.Context, server *Server, connection net.Conn) {
 	numRequests := int64(0)
-	slog.Info("client connected", "remote_address", connection.RemoteAddr().String())
+	slog.InfoContext(ctx, "client connected", "remote_address", connection.RemoteAddr().String())
 	defer func() {
-		slog.Info("client disconnected", "address", connection.RemoteAddr().String(), "num_requests", numRequests)
+		slog.InfoContext(ctx, "client disconnected", "address", connection.RemoteAddr().String(), "num_requests", numRequests)
 	}()
 	defer connection.Close()
 
 	lineReader := bufio.NewReader(connection)
+	lines := server.LineRate.NewBucket(time.Now())
+	limits := server.limits()
 	for {
 		line, err := lineReader.ReadBytes('\n')
 		numRequests++
 		if err != nil {
-			slog.Info("client disconnected", "remote_address", connection.RemoteAddr().String())
+			slog.InfoContext(ctx, "client disconnected", "remote_address", connection.RemoteAddr().String())
 			return
 		}
-		request, err := ParseRequest(line)
+		rateLimit := func() {
+			rateLimited.Inc()
+			slog.InfoContext(ctx, "line rate limit exceeded", "remote_address", connection.RemoteAddr().String())
+			if server.JSONRPC {
+				SendResponse(connection, RateLimitedRPC())
+			} else {
+				connection.Write([]byte("too many requests\n"))
+			}
+		}
+		if !lines.Allow() {
+			rateLimit()
+			return
+		}
+		if server.JSONRPC {
+			response, err := HandleRPC(ctx, line, limits, lines)
+			if err != nil {
+				rateLimit()
+				return
+			}
+			if response != nil {
+				if err := SendResponse(connection, response); err != nil {
+					return
+				}
+			}
+			timeout.EndMessage(connection)
+			continue
+		}
+		request, err := ParseRequest(line, limits)
 		if err != nil {
+			malformedRequests.Inc()
 			connection.Write([]byte(err.Error() + "\n"))
 			return
 		}
-		isPrime := IsPrime(request.Number)
-		response := Response{Method: "isPrime", Prime: isPrime}
+		requestsHandled.With(request.Method).Inc()
+		response, err := Respond(ctx, request)
+		if err != nil {
+			return
+		}
 		if err := SendResponse(connection, response); err != nil {
 			return
 		}
+		timeout.EndMessage(connection)
 	}
 }
@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ananthvk/protohackers-go/internal/limit"
+)
+
+// The error codes of JSON-RPC 2.0
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	// rpcRateLimited is in the range reserved for server errors
+	rpcRateLimited = -32000
+)
+
+// rpcError is the error object of a JSON-RPC response
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcResult is a successful JSON-RPC response
+type rpcResult struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  any             `json:"result"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcErrorResponse is a failed JSON-RPC response. A nil ID is written as null, for requests whose id is unknown
+type rpcErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Error   rpcError        `json:"error"`
+	ID      json.RawMessage `json:"id"`
+}
+
+func newRPCError(id json.RawMessage, code int, format string, args ...any) rpcErrorResponse {
+	return rpcErrorResponse{JSONRPC: "2.0", Error: rpcError{Code: code, Message: fmt.Sprintf(format, args...)}, ID: id}
+}
+
+// malformedRPC returns the error object of a request that the default mode would find malformed, and counts it in the
+// same metric
+func malformedRPC(code int, id json.RawMessage, format string, args ...any) rpcErrorResponse {
+	malformedRequests.Inc()
+	return newRPCError(id, code, format, args...)
+}
+
+// errRateLimited is returned by HandleRPC when a batch takes the connection over its line rate limit
+var errRateLimited = errors.New("too many requests")
+
+// RateLimitedRPC returns the error sent to a client that went over the line rate limit, before it's disconnected
+func RateLimitedRPC() any {
+	return newRPCError(nil, rpcRateLimited, "too many requests")
+}
+
+// HandleRPC answers a line holding a JSON-RPC 2.0 request, or a batch of them. It returns nil if there is nothing to
+// send back, which is the case for notifications. Errors are returned as error objects, the connection stays open.
+// The fields of the params are checked like those of ParseRequest. Requests that are still running when ctx is done
+// are answered with an internal error.
+//
+// The line has already been charged to lines, the line rate limit of the connection. Every other request of a batch is
+// charged too, and errRateLimited is returned if there aren't enough tokens, without answering any of them
+func HandleRPC(ctx context.Context, line []byte, limits Limits, lines *limit.TokenBucket) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	var message json.RawMessage
+	if err := decoder.Decode(&message); err != nil {
+		return malformedRPC(rpcParseError, nil, "parse error: %v", err), nil
+	}
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return malformedRPC(rpcParseError, nil, "parse error: request contains extra data after JSON value"), nil
+	}
+
+	if message[0] != '[' {
+		response, ok := handleRPCRequest(ctx, message, limits)
+		if !ok {
+			return nil, nil
+		}
+		return response, nil
+	}
+	var batch []json.RawMessage
+	if err := json.Unmarshal(message, &batch); err != nil {
+		return malformedRPC(rpcParseError, nil, "parse error: %v", err), nil
+	}
+	if len(batch) == 0 {
+		return malformedRPC(rpcInvalidRequest, nil, "invalid request: empty batch"), nil
+	}
+	if len(batch) > limits.MaxBatch {
+		return malformedRPC(rpcInvalidRequest, nil, "invalid request: batch holds more than %d requests", limits.MaxBatch), nil
+	}
+	for range batch[1:] {
+		if !lines.Allow() {
+			return nil, errRateLimited
+		}
+	}
+	responses := []any{}
+	for _, request := range batch {
+		if response, ok := handleRPCRequest(ctx, request, limits); ok {
+			responses = append(responses, response)
+		}
+	}
+	// A batch of notifications gets no response at all
+	if len(responses) == 0 {
+		return nil, nil
+	}
+	return responses, nil
+}
+
+// handleRPCRequest answers a single request of a batch. It returns false for notifications, which get no response
+func handleRPCRequest(ctx context.Context, message json.RawMessage, limits Limits) (any, bool) {
+	var envelope struct {
+		JSONRPC *string         `json:"jsonrpc"`
+		Method  *string         `json:"method"`
+		Params  json.RawMessage `json:"params"`
+		ID      json.RawMessage `json:"id"`
+	}
+	if message[0] != '{' {
+		return malformedRPC(rpcInvalidRequest, nil, "invalid request: request must be a JSON object"), true
+	}
+	decoder := json.NewDecoder(bytes.NewReader(message))
+	decoder.UseNumber()
+	if err := decoder.Decode(&envelope); err != nil {
+		return malformedRPC(rpcInvalidRequest, nil, "invalid request: %v", err), true
+	}
+	if !validRPCID(envelope.ID) {
+		return malformedRPC(rpcInvalidRequest, nil, "invalid request: 'id' must be a string, a number or null"), true
+	}
+	if envelope.JSONRPC == nil || *envelope.JSONRPC != "2.0" {
+		return malformedRPC(rpcInvalidRequest, envelope.ID, "invalid request: 'jsonrpc' must be \"2.0\""), true
+	}
+	if envelope.Method == nil {
+		return malformedRPC(rpcInvalidRequest, envelope.ID, "invalid request: required field 'method' is missing"), true
+	}
+
+	// Requests without an id are notifications. They are still checked and evaluated, but nothing is sent back
+	notification := envelope.ID == nil
+	method := *envelope.Method
+	if !isMethod(method) {
+		return malformedRPC(rpcMethodNotFound, envelope.ID, "method not found: %q", method), !notification
+	}
+	f, err := rpcParams(method, envelope.Params)
+	if err != nil {
+		return malformedRPC(rpcInvalidParams, envelope.ID, "invalid params: %v", err), !notification
+	}
+	request, err := parseFields(method, f, limits)
+	if err != nil {
+		return malformedRPC(rpcInvalidParams, envelope.ID, "invalid params: %v", err), !notification
+	}
+	requestsHandled.With(method).Inc()
+	response, err := Respond(ctx, request)
+	if err != nil {
+		return newRPCError(envelope.ID, rpcInternalError, "internal error: %v", err), !notification
+	}
+	result := rpcResultOf(response)
+	return rpcResult{JSONRPC: "2.0", Result: result, ID: envelope.ID}, !notification
+}
+
+// validRPCID returns true if id is missing, or is a string, a number or null
+func validRPCID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch id[0] {
+	case '{', '[', 't', 'f':
+		return false
+	}
+	return true
+}
+
+// rpcParams returns the fields held by params. They are given by name, as in the requests of the default mode, or by
+// position: [number] for most methods, and [from, to] for primesInRange
+func rpcParams(method string, params json.RawMessage) (fields, error) {
+	var f fields
+	if params == nil || string(params) == "null" {
+		return f, nil
+	}
+	switch params[0] {
+	case '{':
+		err := json.Unmarshal(params, &f)
+		return f, err
+	case '[':
+		var values []json.RawMessage
+		if err := json.Unmarshal(params, &values); err != nil {
+			return f, err
+		}
+		if method == MethodPrimesInRange {
+			if len(values) != 2 {
+				return f, fmt.Errorf("%s takes 2 params, got %d", method, len(values))
+			}
+			f.From, f.To = values[0], values[1]
+			return f, nil
+		}
+		if len(values) != 1 {
+			return f, fmt.Errorf("%s takes 1 param, got %d", method, len(values))
+		}
+		f.Number = values[0]
+		return f, nil
+	default:
+		return f, errors.New("'params' must be an object or an array")
+	}
+}
+
+// rpcResultOf returns the result of a JSON-RPC response, which is the value of the response of the default mode
+// without its envelope
+func rpcResultOf(response any) any {
+	switch r := response.(type) {
+	case FactorsResponse:
+		return r.Factors
+	case NumberResponse:
+		return r.Number
+	case PrimesResponse:
+		return r.Primes
+	case Response:
+		return r.Prime
+	}
+	panic(fmt.Sprintf("unknown response type %T", response))
+}
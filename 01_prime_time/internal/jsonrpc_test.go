@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/limit"
+)
+
+func TestHandleRPC(t *testing.T) {
+	table := []struct {
+		in, out string // out is empty if nothing is sent back
+	}{
+		// Params by name and by position, with every kind of id
+		{`{"jsonrpc":"2.0","method":"isPrime","params":{"number":7},"id":1}`, `{"jsonrpc":"2.0","result":true,"id":1}`},
+		{`{"jsonrpc":"2.0","method":"isPrime","params":[8],"id":"a"}`, `{"jsonrpc":"2.0","result":false,"id":"a"}`},
+		{`{"jsonrpc":"2.0","method":"isPrime","params":[7.5],"id":null}`, `{"jsonrpc":"2.0","result":false,"id":null}`},
+		{`{"jsonrpc":"2.0","method":"factorize","params":[12],"id":2.5}`, `{"jsonrpc":"2.0","result":[2,2,3],"id":2.5}`},
+		{`{"jsonrpc":"2.0","method":"prevPrime","params":{"number":2},"id":3}`, `{"jsonrpc":"2.0","result":null,"id":3}`},
+		{`{"jsonrpc":"2.0","method":"primesInRange","params":[10,20],"id":4}`, `{"jsonrpc":"2.0","result":[11,13,17,19],"id":4}`},
+		{`{"jsonrpc":"2.0","method":"primesInRange","params":{"from":24,"to":28},"id":5}`, `{"jsonrpc":"2.0","result":[],"id":5}`},
+
+		// Notifications get no response, even when they fail
+		{`{"jsonrpc":"2.0","method":"isPrime","params":[7]}`, ``},
+		{`{"jsonrpc":"2.0","method":"isComposite","params":[7]}`, ``},
+		{`{"jsonrpc":"2.0","method":"isPrime","params":["7"]}`, ``},
+
+		// Parse errors
+		{`{"jsonrpc":"2.0","method":"isPrime"`, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"parse error: unexpected EOF"},"id":null}`},
+		{`{"jsonrpc":"2.0","method":"isPrime","params":[7],"id":1}{}`, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"parse error: request contains extra data after JSON value"},"id":null}`},
+
+		// Invalid requests
+		{`7`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request: request must be a JSON object"},"id":null}`},
+		{`{"method":"isPrime","params":[7],"id":1}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request: 'jsonrpc' must be \"2.0\""},"id":1}`},
+		{`{"jsonrpc":"1.0","method":"isPrime","params":[7],"id":1}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request: 'jsonrpc' must be \"2.0\""},"id":1}`},
+		{`{"jsonrpc":"2.0","params":[7],"id":1}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request: required field 'method' is missing"},"id":1}`},
+		{`{"jsonrpc":"2.0","method":"isPrime","params":[7],"id":{}}`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request: 'id' must be a string, a number or null"},"id":null}`},
+		{`[]`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request: empty batch"},"id":null}`},
+
+		// Unknown methods
+		{`{"jsonrpc":"2.0","method":"isComposite","params":[7],"id":1}`, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found: \"isComposite\""},"id":1}`},
+
+		// Invalid params keep the rules of the default mode
+		{`{"jsonrpc":"2.0","method":"isPrime","params":["7"],"id":1}`, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid params: 'number' must be a numeric JSON value"},"id":1}`},
+		{`{"jsonrpc":"2.0","method":"isPrime","id":1}`, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid params: required field 'number' is missing"},"id":1}`},
+		{`{"jsonrpc":"2.0","method":"isPrime","params":[7,8],"id":1}`, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid params: isPrime takes 1 param, got 2"},"id":1}`},
+		{`{"jsonrpc":"2.0","method":"isPrime","params":7,"id":1}`, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid params: 'params' must be an object or an array"},"id":1}`},
+		{`{"jsonrpc":"2.0","method":"nextPrime","params":[7.5],"id":1}`, `{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid params: 'number' must be an integer"},"id":1}`},
+
+		// Batches answer every request that isn't a notification, in order
+		{
+			`[{"jsonrpc":"2.0","method":"isPrime","params":[7],"id":1},{"jsonrpc":"2.0","method":"isPrime","params":[7]},1,{"jsonrpc":"2.0","method":"nextPrime","params":[7],"id":2}]`,
+			`[{"jsonrpc":"2.0","result":true,"id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request: request must be a JSON object"},"id":null},{"jsonrpc":"2.0","result":11,"id":2}]`,
+		},
+		{`[{"jsonrpc":"2.0","method":"isPrime","params":[7]},{"jsonrpc":"2.0","method":"isPrime","params":[8]}]`, ``},
+		{`[1,2,3,4,5]`, `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request: batch holds more than 4 requests"},"id":null}`},
+	}
+	limits := DefaultLimits()
+	limits.MaxBatch = 4
+	for _, row := range table {
+		response, err := HandleRPC(context.Background(), []byte(row.in), limits, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", row.in, err)
+			continue
+		}
+		if response == nil {
+			if row.out != "" {
+				t.Errorf("%s: got no response, want %s", row.in, row.out)
+			}
+			continue
+		}
+		var b bytes.Buffer
+		if err := SendResponse(&b, response); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != row.out+"\n" {
+			t.Errorf("%s: got %s, want %s", row.in, got, row.out)
+		}
+	}
+}
+
+// TestRPCBatchCost checks that every request of a batch is charged to the line rate limit, and that malformed requests
+// are counted like in the default mode
+func TestRPCBatchCost(t *testing.T) {
+	lines := limit.NewTokenBucket(0, 3, time.Now())
+	batch := []byte(`[{"jsonrpc":"2.0","method":"isPrime","params":[7],"id":1},{"jsonrpc":"2.0","method":"isPrim","id":2}]`)
+
+	// The handler takes a token for the line, and HandleRPC one for the second request
+	lines.Allow()
+	malformed := malformedRequests.Value()
+	if _, err := HandleRPC(context.Background(), batch, DefaultLimits(), lines); err != nil {
+		t.Fatalf("first batch: unexpected error: %v", err)
+	}
+	if got := malformedRequests.Value() - malformed; got != 1 {
+		t.Errorf("first batch: counted %d malformed requests, want 1", got)
+	}
+
+	lines.Allow()
+	if _, err := HandleRPC(context.Background(), batch, DefaultLimits(), lines); err != errRateLimited {
+		t.Errorf("second batch: got error %v, want %v", err, errRateLimited)
+	}
+
+	HandleRPC(context.Background(), []byte("not json"), DefaultLimits(), nil)
+	if got := malformedRequests.Value() - malformed; got != 2 {
+		t.Errorf("parse error: counted %d malformed requests, want 2", got)
+	}
+}
+
+// TestHandleJSONRPC checks that errors don't close the connection in JSON-RPC mode
+func TestHandleJSONRPC(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go Handle(context.Background(), &Server{JSONRPC: true}, server)
+
+	r := bufio.NewReader(client)
+	for _, line := range []string{
+		"not json\n",
+		`{"jsonrpc":"2.0","method":"isPrim","params":[7],"id":1}` + "\n",
+		`{"jsonrpc":"2.0","method":"isPrime","params":[7],"id":2}` + "\n",
+	} {
+		if _, err := client.Write([]byte(line)); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		if _, err := r.ReadBytes('\n'); err != nil {
+			t.Fatalf("connection closed after %q: %v", line, err)
+		}
+	}
+}
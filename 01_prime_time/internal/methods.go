@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"context"
+	"math/big"
+	"slices"
+	"strconv"
+)
+
+var (
+	one = big.NewInt(1)
+	two = big.NewInt(2)
+)
+
+// Respond computes the response to a request, whose fields have been checked by ParseRequest. It returns the error of
+// ctx if it's done before the answer is found
+func Respond(ctx context.Context, request Request) (any, error) {
+	switch request.Method {
+	case MethodFactorize:
+		var factors []*big.Int
+		for _, factor := range Factorize(uint64(request.Number.Int64())) {
+			factors = append(factors, new(big.Int).SetUint64(factor))
+		}
+		// An empty list rather than null for 1, which has no prime factors
+		if factors == nil {
+			factors = []*big.Int{}
+		}
+		return FactorsResponse{Method: request.Method, Factors: factors}, nil
+	case MethodNextPrime:
+		number, err := NextPrime(ctx, request.Number)
+		return NumberResponse{Method: request.Method, Number: number}, err
+	case MethodPrevPrime:
+		number, err := PrevPrime(ctx, request.Number)
+		return NumberResponse{Method: request.Method, Number: number}, err
+	case MethodPrimesInRange:
+		primes, err := PrimesInRange(ctx, request.From, request.To)
+		return PrimesResponse{Method: request.Method, Primes: primes}, err
+	default:
+		isPrime := request.IsPrime()
+		numbersEvaluated.With(strconv.FormatBool(isPrime)).Inc()
+		return Response{Method: MethodIsPrime, Prime: isPrime}, nil
+	}
+}
+
+// NextPrime returns the smallest prime larger than n
+func NextPrime(ctx context.Context, n *big.Int) (*big.Int, error) {
+	if n.Cmp(two) < 0 {
+		return big.NewInt(2), nil
+	}
+	var prime *big.Int
+	err := walkPrimes(ctx, new(big.Int).Add(n, one), 1, nil, func(p *big.Int) bool {
+		prime = p
+		return false
+	})
+	return prime, err
+}
+
+// PrevPrime returns the largest prime smaller than n, or nil if there is none
+func PrevPrime(ctx context.Context, n *big.Int) (*big.Int, error) {
+	if n.Cmp(two) <= 0 {
+		return nil, nil
+	}
+	// The walk ends at 2 at the latest
+	var prime *big.Int
+	err := walkPrimes(ctx, new(big.Int).Sub(n, one), -1, nil, func(p *big.Int) bool {
+		prime = p
+		return false
+	})
+	return prime, err
+}
+
+// PrimesInRange returns the primes between from and to, both included
+func PrimesInRange(ctx context.Context, from, to *big.Int) ([]*big.Int, error) {
+	primes := []*big.Int{}
+	if to.Cmp(two) < 0 {
+		return primes, nil
+	}
+	start := from
+	if start.Cmp(two) < 0 {
+		start = two
+	}
+	err := walkPrimes(ctx, start, 1, to, func(p *big.Int) bool {
+		primes = append(primes, p)
+		return true
+	})
+	return primes, err
+}
+
+// walkPrimes calls found with every prime from start, which must be at least 2, going up if step is 1 and down if it's
+// -1. It stops after last if it's not nil, when found returns false, or when ctx is done. The remainders of the
+// candidate modulo smallPrimes are kept up to date as it moves, so that the candidates with a small factor are skipped
+// without running IsPrimeBig
+func walkPrimes(ctx context.Context, start *big.Int, step int64, last *big.Int, found func(*big.Int) bool) error {
+	candidate := new(big.Int).Set(start)
+	remainders := make([]uint64, len(smallPrimes))
+	var r, p big.Int
+	delta := big.NewInt(step)
+	for i, prime := range smallPrimes {
+		remainders[i] = r.Mod(candidate, p.SetUint64(prime)).Uint64()
+	}
+	for last == nil || candidate.Cmp(last)*int(step) <= 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// IsPrime does its own trial division, and the sieve would reject the small primes themselves
+		if (candidate.IsInt64() || !slices.Contains(remainders, 0)) && IsPrimeBig(candidate) {
+			if !found(new(big.Int).Set(candidate)) {
+				return nil
+			}
+		}
+		candidate.Add(candidate, delta)
+		for i, prime := range smallPrimes {
+			// Adding prime keeps the sum positive when stepping down
+			remainders[i] = uint64(int64(remainders[i]+prime)+step) % prime
+		}
+	}
+	return nil
+}
+
+// Factorize returns the prime factors of n > 0 in increasing order, repeated by their multiplicity. Small factors are
+// found by trial division, the others with Pollard's rho
+func Factorize(n uint64) []uint64 {
+	var factors []uint64
+	for _, p := range smallPrimes {
+		for n%p == 0 {
+			factors = append(factors, p)
+			n /= p
+		}
+	}
+	factors = splitFactors(n, factors)
+	slices.Sort(factors)
+	return factors
+}
+
+// splitFactors appends the prime factors of n to factors, where n has no small prime factor
+func splitFactors(n uint64, factors []uint64) []uint64 {
+	if n == 1 {
+		return factors
+	}
+	if IsPrime(int64(n)) {
+		return append(factors, n)
+	}
+	d := pollardRho(n)
+	return splitFactors(n/d, splitFactors(d, factors))
+}
+
+// pollardRho returns a non-trivial factor of n, which must be an odd composite below 2^63. It uses Floyd's cycle
+// detection on x -> x^2 + c, and tries the next c when the cycle doesn't reveal a factor
+func pollardRho(n uint64) uint64 {
+	for c := uint64(1); ; c++ {
+		// n < 2^63, so adding c can't overflow
+		f := func(x uint64) uint64 { return (mulMod(x, x, n) + c) % n }
+		x, y, d := uint64(2), uint64(2), uint64(1)
+		for d == 1 {
+			x = f(x)
+			y = f(f(y))
+			d = gcd(max(x, y)-min(x, y), n)
+		}
+		if d != n {
+			return d
+		}
+	}
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
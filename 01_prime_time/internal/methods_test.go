@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+)
+
+func TestFactorize(t *testing.T) {
+	cases := []struct {
+		in   uint64
+		want []uint64
+	}{
+		{1, nil},
+		{2, []uint64{2}},
+		{360, []uint64{2, 2, 2, 3, 3, 5}},
+		{97, []uint64{97}},
+		{101 * 101, []uint64{101, 101}},
+		{561, []uint64{3, 11, 17}},
+		{2147483647 * 2147483629, []uint64{2147483629, 2147483647}},
+		{2147483647 * 2147483647, []uint64{2147483647, 2147483647}},
+		{1000003 * 1000033 * 1000037, []uint64{1000003, 1000033, 1000037}},
+		{3825123056546413051, []uint64{149491, 747451, 34233211}},
+		{9223372036854775807, []uint64{7, 7, 73, 127, 337, 92737, 649657}},
+		{9223372036854775783, []uint64{9223372036854775783}},
+		{1 << 62, slices.Repeat([]uint64{2}, 62)},
+	}
+	for _, c := range cases {
+		if got := Factorize(c.in); !slices.Equal(got, c.want) {
+			t.Errorf("Factorize(%d) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestNeighbourPrimes(t *testing.T) {
+	cases := []struct {
+		in         int64
+		next, prev int64 // prev is 0 if there is no smaller prime
+	}{
+		{-10, 2, 0},
+		{0, 2, 0},
+		{2, 3, 0},
+		{3, 5, 2},
+		{7, 11, 5},
+		{8, 11, 7},
+		{1000000, 1000003, 999983},
+		{9223372036854775783, 0, 9223372036854775643},
+	}
+	for _, c := range cases {
+		n := big.NewInt(c.in)
+		if c.next != 0 {
+			if got, _ := NextPrime(context.Background(), n); got.Cmp(big.NewInt(c.next)) != 0 {
+				t.Errorf("NextPrime(%d) = %v, want %d", c.in, got, c.next)
+			}
+		}
+		got, _ := PrevPrime(context.Background(), n)
+		if c.prev == 0 && got != nil || c.prev != 0 && (got == nil || got.Cmp(big.NewInt(c.prev)) != 0) {
+			t.Errorf("PrevPrime(%d) = %v, want %d", c.in, got, c.prev)
+		}
+	}
+
+	// The next prime after the largest int64 prime doesn't fit in an int64
+	want, _ := new(big.Int).SetString("9223372036854775837", 10)
+	if got, _ := NextPrime(context.Background(), big.NewInt(9223372036854775783)); got.Cmp(want) != 0 {
+		t.Errorf("NextPrime(9223372036854775783) = %v, want %v", got, want)
+	}
+}
+
+func TestPrimesInRange(t *testing.T) {
+	// The sieve must agree with IsPrimeBig on every candidate, on both sides of 2^64
+	for _, from := range []*big.Int{big.NewInt(-10), new(big.Int).Lsh(one, 64)} {
+		to := new(big.Int).Add(from, big.NewInt(2000))
+		got, err := PrimesInRange(context.Background(), from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []*big.Int
+		for n := new(big.Int).Set(from); n.Cmp(to) <= 0; n.Add(n, one) {
+			if IsPrimeBig(n) {
+				want = append(want, new(big.Int).Set(n))
+			}
+		}
+		if !slices.EqualFunc(got, want, func(a, b *big.Int) bool { return a.Cmp(b) == 0 }) {
+			t.Errorf("PrimesInRange(%v, %v) = %v, want %v", from, to, got, want)
+		}
+	}
+}
+
+func TestSearchCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n := new(big.Int).Lsh(one, 100)
+	if _, err := NextPrime(ctx, n); !errors.Is(err, context.Canceled) {
+		t.Errorf("NextPrime: got %v, want %v", err, context.Canceled)
+	}
+	if _, err := PrevPrime(ctx, n); !errors.Is(err, context.Canceled) {
+		t.Errorf("PrevPrime: got %v, want %v", err, context.Canceled)
+	}
+	if _, err := PrimesInRange(ctx, n, new(big.Int).Add(n, two)); !errors.Is(err, context.Canceled) {
+		t.Errorf("PrimesInRange: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRespond(t *testing.T) {
+	table := []struct {
+		in, out string
+	}{
+		{`{"method":"isPrime","number":7}`, `{"method":"isPrime","prime":true}`},
+		{`{"method":"isPrime","number":7.5}`, `{"method":"isPrime","prime":false}`},
+		{`{"method":"factorize","number":360}`, `{"method":"factorize","factors":[2,2,2,3,3,5]}`},
+		{`{"method":"factorize","number":1}`, `{"method":"factorize","factors":[]}`},
+		{`{"method":"nextPrime","number":1e20}`, `{"method":"nextPrime","number":100000000000000000039}`},
+		{`{"method":"prevPrime","number":14}`, `{"method":"prevPrime","number":13}`},
+		{`{"method":"prevPrime","number":2}`, `{"method":"prevPrime","number":null}`},
+		{`{"method":"primesInRange","from":-5,"to":20}`, `{"method":"primesInRange","primes":[2,3,5,7,11,13,17,19]}`},
+		{`{"method":"primesInRange","from":24,"to":28}`, `{"method":"primesInRange","primes":[]}`},
+	}
+	for _, row := range table {
+		request, err := ParseRequest([]byte(row.in), DefaultLimits())
+		if err != nil {
+			t.Errorf("ParseRequest(%q) produced unexpected error: %v", row.in, err)
+			continue
+		}
+		response, err := Respond(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if err := SendResponse(&b, response); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != row.out+"\n" {
+			t.Errorf("%s: got %q, want %q", row.in, got, row.out)
+		}
+	}
+}
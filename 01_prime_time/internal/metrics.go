@@ -0,0 +1,10 @@
+package internal
+
+import "github.com/ananthvk/protohackers-go/internal/metrics"
+
+var (
+	requestsHandled   = metrics.NewCounterVec("prime_time_requests_total", "Number of well-formed requests handled, by method", "method")
+	numbersEvaluated  = metrics.NewCounterVec("prime_time_numbers_evaluated_total", "Number of numbers checked for primality, by result", "prime")
+	malformedRequests = metrics.NewCounter("prime_time_malformed_requests_total", "Number of malformed requests received")
+	rateLimited       = metrics.NewCounter("prime_time_rate_limited_total", "Number of clients disconnected for going over the line rate limit")
+)
@@ -1,28 +1,90 @@
 package internal
 
-import "math"
+import (
+	"math/big"
+	"math/bits"
+)
 
-// IsPrime checks if the given integer is a prime number. Returns true if it's a prime, false otherwise
+// millerRabinRounds is the number of random bases tried by ProbablyPrime for numbers that don't fit in an int64, on
+// top of its Baillie-PSW test. No composite is known to pass Baillie-PSW alone
+const millerRabinRounds = 20
+
+// smallPrimes are used to filter out most composites before running Miller-Rabin. The first twelve of them are also
+// the Miller-Rabin witnesses, which are enough to decide every n < 3.3 * 10^24, so every int64
+var smallPrimes = []uint64{2, 3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71, 73, 79, 83, 89, 97}
+
+// millerRabinWitnesses is the number of smallPrimes used as witnesses
+const millerRabinWitnesses = 12
+
+// IsPrime checks if the given integer is a prime number. Returns true if it's a prime, false otherwise. It uses a
+// deterministic Miller-Rabin test, so the answer is exact and takes microseconds for every int64
 func IsPrime(n int64) bool {
 	if n <= 1 {
 		return false
 	}
-	if n == 2 || n == 3 {
-		return true
+	u := uint64(n)
+	for _, p := range smallPrimes {
+		if u%p == 0 {
+			return u == p
+		}
 	}
-	// Use property that all primes are of the form 6k +- 1 (except 2 & 3)
-	rem := n % 6
-	if rem == 0 || rem == 2 || rem == 3 || rem == 4 {
-		return false
+	// Every factor of a composite below 101^2 is a small prime
+	if u < 101*101 {
+		return true
 	}
 
-	m := int64(math.Sqrt(float64(n)))
-
-	// Only iterate over odd numbers, cutting down the number of iterations in half
-	for i := int64(3); i <= m; i += 2 {
-		if n%i == 0 {
+	// Write n - 1 as d * 2^s with d odd
+	d := u - 1
+	s := bits.TrailingZeros64(d)
+	d >>= s
+	for _, a := range smallPrimes[:millerRabinWitnesses] {
+		if !passesMillerRabin(u, a, d, s) {
 			return false
 		}
 	}
 	return true
 }
+
+// passesMillerRabin returns false if a is a witness that n is composite, where n - 1 = d * 2^s and d is odd
+func passesMillerRabin(n, a, d uint64, s int) bool {
+	x := powMod(a, d, n)
+	if x == 1 || x == n-1 {
+		return true
+	}
+	for range s - 1 {
+		x = mulMod(x, x, n)
+		if x == n-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// mulMod returns a * b mod m. The product is computed on 128 bits, so it can't overflow
+func mulMod(a, b, m uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return bits.Rem64(hi, lo, m)
+}
+
+// powMod returns base^exp mod m, by repeated squaring
+func powMod(base, exp, m uint64) uint64 {
+	result := uint64(1)
+	base %= m
+	for exp > 0 {
+		if exp&1 == 1 {
+			result = mulMod(result, base, m)
+		}
+		base = mulMod(base, base, m)
+		exp >>= 1
+	}
+	return result
+}
+
+// IsPrimeBig checks if an integer of any size is a prime number. Numbers that fit in an int64 are checked exactly by
+// IsPrime, larger ones with a probabilistic test
+func IsPrimeBig(n *big.Int) bool {
+	if n.IsInt64() {
+		return IsPrime(n.Int64())
+	}
+	return n.Sign() > 0 && n.ProbablyPrime(millerRabinRounds)
+}
@@ -1,6 +1,11 @@
 package internal
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"math/rand/v2"
+	"testing"
+)
 
 func TestIsPrime(t *testing.T) {
 	cases := []struct {
@@ -28,6 +33,47 @@ func TestIsPrime(t *testing.T) {
 		{997525855, false},
 		{33900388113763, false},
 		{33900388113767, false},
+
+		// Carmichael numbers, which pass the Fermat test for every coprime base
+		{561, false},
+		{1105, false},
+		{1729, false},
+		{2465, false},
+		{2821, false},
+		{6601, false},
+		{8911, false},
+		{41041, false},
+		{825265, false},
+		{321197185, false},
+		{5394826801, false},
+		{232250619601, false},
+		{9746347772161, false},
+
+		// Strong pseudoprimes, the smallest ones to the first 1, 2, ... 9 prime bases
+		{2047, false},
+		{1373653, false},
+		{25326001, false},
+		{3215031751, false},
+		{2152302898747, false},
+		{3474749660383, false},
+		{341550071728321, false},
+		{3825123056546413051, false},
+		// Strong pseudoprimes to bases 2, 3, 5, 7 and 11 that aren't caught by trial division
+		{4759123141, false},
+		{1122004669633, false},
+
+		// Squares and products of large primes
+		{2147483647 * 2147483647, false},
+		{2147483647 * 2147483629, false},
+
+		// Large primes, which took seconds with trial division
+		{2305843009213693951, true},
+		{999999999999999989, true},
+		{1000000000000000003, true},
+		{4611686018427387847, true},
+		{9223372036854775643, true},
+		{9223372036854775783, true},
+		{9223372036854775807, false},
 	}
 	for _, c := range cases {
 		got := IsPrime(c.in)
@@ -36,3 +82,41 @@ func TestIsPrime(t *testing.T) {
 		}
 	}
 }
+
+// TestIsPrimeMatchesProbablyPrime compares IsPrime with ProbablyPrime, which is exact below 2^64, on random numbers
+func TestIsPrimeMatchesProbablyPrime(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for range 100000 {
+		// Odd numbers of every size, which are much more likely to be prime
+		n := r.Int64N(math.MaxInt64>>r.IntN(62)) | 1
+		if got, want := IsPrime(n), big.NewInt(n).ProbablyPrime(0); got != want {
+			t.Fatalf("IsPrime(%d) = %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestIsPrimeBig(t *testing.T) {
+	cases := []struct {
+		in  string
+		out bool
+	}{
+		{"-170141183460469231731687303715884105727", false},
+		{"0", false},
+		{"2", true},
+		{"997525853", true},
+		{"33900388113767", false},
+		// 2^127 - 1 and 2^127 + 1
+		{"170141183460469231731687303715884105727", true},
+		{"170141183460469231731687303715884105729", false},
+		{"123456789012345678901234567890", false},
+		// The product of 2^61 - 1 and 2^89 - 1, both prime
+		{"1427247692705959880439315947500961989719490561", false},
+	}
+	for _, c := range cases {
+		n, _ := new(big.Int).SetString(c.in, 10)
+		got := IsPrimeBig(n)
+		if got != c.out {
+			t.Errorf("IsPrimeBig(%s) = %v, want %v", c.in, got, c.out)
+		}
+	}
+}
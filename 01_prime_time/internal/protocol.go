@@ -4,24 +4,109 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
+	"strconv"
+	"strings"
 )
 
+const (
+	// DefaultMaxDigits is the largest number of digits accepted in the integer part of a number
+	DefaultMaxDigits = 1000
+	// DefaultMaxRange is the largest number of integers checked by a primesInRange request
+	DefaultMaxRange = 100000
+	// DefaultMaxBatch is the largest number of requests in a JSON-RPC batch
+	DefaultMaxBatch = 100
+	// MaxSearchBits is the largest bit length of the numbers of nextPrime, prevPrime and primesInRange. Primes are
+	// about as far apart as the number of digits, and each candidate takes longer to check as the number grows
+	MaxSearchBits = 128
+	// rangeBits is the bit length up to which a primesInRange request may check MaxRange integers. Ranges of larger
+	// numbers are shorter in proportion
+	rangeBits = 64
+)
+
+// The methods of the service. isPrime is the only one of the challenge, the others are extensions
+const (
+	MethodIsPrime       = "isPrime"
+	MethodFactorize     = "factorize"
+	MethodNextPrime     = "nextPrime"
+	MethodPrevPrime     = "prevPrime"
+	MethodPrimesInRange = "primesInRange"
+)
+
+// Limits bounds the work done for a single request
+type Limits struct {
+	// MaxDigits is the largest number of digits accepted in the integer part of a number
+	MaxDigits int
+	// MaxRange is the largest number of integers checked by a primesInRange request whose bounds fit in 64 bits.
+	// Ranges of larger numbers are shorter in proportion to their bit length
+	MaxRange int
+	// MaxBatch is the largest number of requests in a JSON-RPC batch
+	MaxBatch int
+}
+
+// DefaultLimits returns the limits used when none are configured
+func DefaultLimits() Limits {
+	return Limits{MaxDigits: DefaultMaxDigits, MaxRange: DefaultMaxRange, MaxBatch: DefaultMaxBatch}
+}
+
 type Request struct {
-	Number int64
+	// Method is one of the Method constants
+	Method string
+	// Number is the integer part of the number, for every method except primesInRange
+	Number *big.Int
+	// Fraction is true if the number has a non-zero fractional part, in which case it's not prime. Only isPrime
+	// accepts such numbers
+	Fraction bool
+	// From and To are the bounds of a primesInRange request, both included
+	From, To *big.Int
+}
+
+// IsPrime returns true if the number of the request is prime
+func (r Request) IsPrime() bool {
+	return !r.Fraction && IsPrimeBig(r.Number)
 }
+
+// Response answers isPrime
 type Response struct {
 	Method string `json:"method"`
 	Prime  bool   `json:"prime"`
 }
 
-func ParseRequest(line []byte) (Request, error) {
+// FactorsResponse answers factorize, with the prime factors in increasing order, repeated by their multiplicity
+type FactorsResponse struct {
+	Method  string     `json:"method"`
+	Factors []*big.Int `json:"factors"`
+}
+
+// NumberResponse answers nextPrime and prevPrime. Number is null if there is no such prime
+type NumberResponse struct {
+	Method string   `json:"method"`
+	Number *big.Int `json:"number"`
+}
+
+// PrimesResponse answers primesInRange, with the primes in increasing order
+type PrimesResponse struct {
+	Method string     `json:"method"`
+	Primes []*big.Int `json:"primes"`
+}
+
+// fields holds the number fields of a request, which are checked by the method
+type fields struct {
+	Number json.RawMessage `json:"number"`
+	From   json.RawMessage `json:"from"`
+	To     json.RawMessage `json:"to"`
+}
+
+// ParseRequest parses a single request, and checks the fields required by its method
+func ParseRequest(line []byte, limits Limits) (Request, error) {
 	decoder := json.NewDecoder(bytes.NewReader(line))
 	decoder.UseNumber()
 
 	primeRequest := struct {
-		Method *string         `json:"method"`
-		Number json.RawMessage `json:"number"`
+		Method *string `json:"method"`
+		fields
 	}{}
 
 	err := decoder.Decode(&primeRequest)
@@ -35,43 +120,149 @@ func ParseRequest(line []byte) (Request, error) {
 		return Request{}, errors.New("request contains extra data after JSON object")
 	}
 
-	// Check if fields are missing
 	if primeRequest.Method == nil {
 		return Request{}, errors.New("required field 'method' is missing")
 	}
-	if primeRequest.Number == nil {
-		return Request{}, errors.New("required field 'number' is missing")
+	if !isMethod(*primeRequest.Method) {
+		return Request{}, fmt.Errorf("unknown method %q", *primeRequest.Method)
+	}
+	return parseFields(*primeRequest.Method, primeRequest.fields, limits)
+}
+
+// isMethod returns true if method is one of the Method constants
+func isMethod(method string) bool {
+	switch method {
+	case MethodIsPrime, MethodFactorize, MethodNextPrime, MethodPrevPrime, MethodPrimesInRange:
+		return true
+	}
+	return false
+}
+
+// parseFields checks the fields required by method, which must be known
+func parseFields(method string, f fields, limits Limits) (Request, error) {
+	var err error
+	request := Request{Method: method}
+	switch method {
+	case MethodIsPrime:
+		request.Number, request.Fraction, err = parseField("number", f.Number, limits.MaxDigits)
+		return request, err
+
+	case MethodFactorize, MethodNextPrime, MethodPrevPrime:
+		request.Number, err = parseIntegerField("number", f.Number, limits.MaxDigits)
+		if err != nil {
+			return Request{}, err
+		}
+		// Pollard's rho is only fast enough for numbers that fit in 64 bits
+		if method == MethodFactorize && (request.Number.Sign() <= 0 || !request.Number.IsInt64()) {
+			return Request{}, errors.New("'number' must be a positive integer below 2^63 to be factorized")
+		}
+		if request.Number.BitLen() > MaxSearchBits {
+			return Request{}, fmt.Errorf("'number' must be below 2^%d in magnitude", MaxSearchBits)
+		}
+		return request, nil
+
+	default:
+		if request.From, err = parseIntegerField("from", f.From, limits.MaxDigits); err != nil {
+			return Request{}, err
+		}
+		if request.To, err = parseIntegerField("to", f.To, limits.MaxDigits); err != nil {
+			return Request{}, err
+		}
+		bits := max(request.From.BitLen(), request.To.BitLen())
+		if bits > MaxSearchBits {
+			return Request{}, fmt.Errorf("'from' and 'to' must be below 2^%d in magnitude", MaxSearchBits)
+		}
+		size := new(big.Int).Sub(request.To, request.From)
+		if size.Sign() < 0 {
+			return Request{}, errors.New("'from' must not be larger than 'to'")
+		}
+		// The cost of the range is its size times the bit length of its numbers
+		maxRange := int64(limits.MaxRange) * rangeBits / int64(max(bits, rangeBits))
+		if size.Cmp(big.NewInt(maxRange)) >= 0 {
+			return Request{}, fmt.Errorf("range contains more than %d integers", maxRange)
+		}
+		return request, nil
 	}
+}
 
-	// Check if method is 'isPrime'
-	if *primeRequest.Method != "isPrime" {
-		return Request{}, errors.New("method is not 'isPrime'")
+// parseField parses the number in the field called name, and returns its integer part and whether it has a fractional
+// part
+func parseField(name string, raw json.RawMessage, maxDigits int) (*big.Int, bool, error) {
+	if raw == nil {
+		return nil, false, fmt.Errorf("required field '%s' is missing", name)
 	}
 
-	// Edge case, json.Number accepts numeric values represented as strings]
+	// Edge case, json.Number accepts numeric values represented as strings (and null)
 	// but the specification disallows it
-	if len(primeRequest.Number) == 0 || primeRequest.Number[0] == '"' {
-		return Request{}, errors.New("'number' must be a numeric JSON value")
+	if len(raw) == 0 || raw[0] == '"' || string(raw) == "null" {
+		return nil, false, fmt.Errorf("'%s' must be a numeric JSON value", name)
 	}
 
 	var num json.Number
-	if err := json.Unmarshal(primeRequest.Number, &num); err != nil {
-		return Request{}, errors.New("invalid number")
+	if err := json.Unmarshal(raw, &num); err != nil {
+		return nil, false, errors.New("invalid number")
 	}
+	return parseNumber(num.String(), maxDigits)
+}
 
-	i, err := num.Int64()
+// parseIntegerField parses the field called name, which must be an integer
+func parseIntegerField(name string, raw json.RawMessage, maxDigits int) (*big.Int, error) {
+	i, fraction, err := parseField(name, raw, maxDigits)
 	if err != nil {
-		// If it's not an integer, try parsing it as a float
-		f, err := num.Float64()
-		i = int64(f)
+		return nil, err
+	}
+	if fraction {
+		return nil, fmt.Errorf("'%s' must be an integer", name)
+	}
+	return i, nil
+}
 
-		// If both of them failed, return an error
-		if err != nil {
-			return Request{}, errors.New("invalid number")
-		}
+// parseNumber returns the integer part of a JSON number, and whether its fractional part is not zero. It works on the
+// digits of the number rather than going through a float, so that no precision is lost. The number of digits is
+// checked before the integer is built, so that a large exponent can't use up memory
+func parseNumber(num string, maxDigits int) (*big.Int, bool, error) {
+	sign := ""
+	if strings.HasPrefix(num, "-") {
+		sign, num = "-", num[1:]
+	}
+	exponent := 0
+	if index := strings.IndexAny(num, "eE"); index >= 0 {
+		// An exponent out of range is clamped by Atoi, and the number is then too large or a fraction of 1. Beyond
+		// the digit limit the exact exponent doesn't matter, clamping it further keeps the arithmetic below from
+		// overflowing
+		e, _ := strconv.Atoi(num[index+1:])
+		bound := maxDigits + len(num)
+		exponent, num = max(-bound, min(e, bound)), num[:index]
 	}
-	return Request{Number: i}, nil
+	integer, fraction, _ := strings.Cut(num, ".")
+	digits := strings.TrimLeft(integer+fraction, "0")
+	// Position of the decimal point in digits, which is also the number of digits of the integer part
+	point := len(digits) - len(fraction) + exponent
+	if digits == "" {
+		return new(big.Int), false, nil
+	}
+	if point <= 0 {
+		// digits has no leading zero, so the fractional part is not zero
+		return new(big.Int), true, nil
+	}
+	if point > maxDigits {
+		return nil, false, fmt.Errorf("number has more than %d digits", maxDigits)
+	}
+	hasFraction := false
+	if point <= len(digits) {
+		hasFraction = strings.TrimRight(digits[point:], "0") != ""
+		digits = digits[:point]
+	} else {
+		digits += strings.Repeat("0", point-len(digits))
+	}
+	i, ok := new(big.Int).SetString(sign+digits, 10)
+	if !ok {
+		return nil, false, errors.New("invalid number")
+	}
+	return i, hasFraction, nil
 }
-func SendResponse(w io.Writer, response Response) error {
+
+// SendResponse writes one of the responses as a single line
+func SendResponse(w io.Writer, response any) error {
 	return json.NewEncoder(w).Encode(response)
 }
@@ -1,9 +1,27 @@
 package internal
 
 import (
+	"math/big"
+	"strings"
 	"testing"
 )
 
+// number returns the request for a number given in decimal
+func number(s string) Request {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid number " + s)
+	}
+	return Request{Number: n}
+}
+
+// fraction returns the request for a number that has a non-zero fractional part, whose integer part is given in decimal
+func fraction(s string) Request {
+	r := number(s)
+	r.Fraction = true
+	return r
+}
+
 func TestParseRequest(t *testing.T) {
 	table := []struct {
 		in      string
@@ -42,23 +60,38 @@ func TestParseRequest(t *testing.T) {
 		{`{"method": "isPrime", "number": "a string"}`, Request{}, true},
 		{`{"method": "isPrime", "number": "32"}`, Request{}, true},
 		{`{"method": "isPrime", "number": "32.15"}`, Request{}, true},
+		{`{"method": "isPrime", "number": null}`, Request{}, true},
 
 		// Test valid inputs
-		{`{"method": "isPrime", "number": 0}`, Request{0}, false},
-		{`{"method": "isPrime", "number": -1}`, Request{-1}, false},
-		{`{"method": "isPrime", "number": 2}`, Request{2}, false},
-		{`{"method": "isPrime", "number": 123456}`, Request{123456}, false},
-		{`{"method": "isPrime", "number": 3.1415}`, Request{3}, false},
-		{`{"method": "isPrime", "number": 3.999}`, Request{3}, false},
-		{`{"method": "isPrime", "number": 8.9999999}`, Request{8}, false},
-		{`{"method": "isPrime", "number": 0.01}`, Request{0}, false},
-		{`{"method": "isPrime", "number": -0.01}`, Request{0}, false},
+		{`{"method": "isPrime", "number": 0}`, number("0"), false},
+		{`{"method": "isPrime", "number": -1}`, number("-1"), false},
+		{`{"method": "isPrime", "number": 2}`, number("2"), false},
+		{`{"method": "isPrime", "number": 123456}`, number("123456"), false},
+		{`{"method": "isPrime", "number": 3.1415}`, fraction("3"), false},
+		{`{"method": "isPrime", "number": 3.999}`, fraction("3"), false},
+		{`{"method": "isPrime", "number": 8.9999999}`, fraction("8"), false},
+		{`{"method": "isPrime", "number": 0.01}`, fraction("0"), false},
+		{`{"method": "isPrime", "number": -0.01}`, fraction("0"), false},
 
 		// Test extraneous fields
-		{`{"method": "isPrime", "number": 23, "other_input":[1,2,3,4]}`, Request{23}, false},
+		{`{"method": "isPrime", "number": 23, "other_input":[1,2,3,4]}`, number("23"), false},
+
+		// Test numbers that don't fit in an int64
+		{`{"method": "isPrime", "number": 123456789012345678901234567890}`, number("123456789012345678901234567890"), false},
+		{`{"method": "isPrime", "number": -123456789012345678901234567890}`, number("-123456789012345678901234567890"), false},
+		{`{"method": "isPrime", "number": 123456789012345678901234567890.75}`, fraction("123456789012345678901234567890"), false},
+		{`{"method": "isPrime", "number": 1.5e30}`, number("1500000000000000000000000000000"), false},
+		{`{"method": "isPrime", "number": 12E-1}`, fraction("1"), false},
+		{`{"method": "isPrime", "number": 0e5000}`, number("0"), false},
+		{`{"method": "isPrime", "number": 1e-99999999999999999999}`, fraction("0"), false},
+
+		// Test numbers over the digit limit
+		{`{"method": "isPrime", "number": 1e1000}`, Request{}, true},
+		{`{"method": "isPrime", "number": 1e99999999999999999999}`, Request{}, true},
+		{`{"method": "isPrime", "number": 1` + strings.Repeat("0", 1000) + `}`, Request{}, true},
 	}
 	for _, row := range table {
-		got, err := ParseRequest([]byte(row.in))
+		got, err := ParseRequest([]byte(row.in), DefaultLimits())
 		if row.wantErr {
 			if err == nil {
 				t.Errorf("ParseRequest(%q) expected error", row.in)
@@ -69,8 +102,127 @@ func TestParseRequest(t *testing.T) {
 			t.Errorf("ParseRequest(%q) produced unexpected error: %v", row.in, err)
 			continue
 		}
-		if got != row.want {
+		if got.Number.Cmp(row.want.Number) != 0 || got.Fraction != row.want.Fraction {
 			t.Errorf("ParseRequest(%q) : got %v want %v", row.in, got, row.want)
 		}
 	}
 }
+
+func TestParseMethods(t *testing.T) {
+	limits := Limits{MaxDigits: 100, MaxRange: 1000}
+	table := []struct {
+		in      string
+		wantErr bool
+	}{
+		{`{"method": "factorize", "number": 360}`, false},
+		{`{"method": "factorize", "number": 1}`, false},
+		{`{"method": "factorize", "number": 9223372036854775807}`, false},
+		{`{"method": "nextPrime", "number": -5}`, false},
+		{`{"method": "prevPrime", "number": 1e38}`, false},
+		{`{"method": "primesInRange", "from": 10, "to": 20}`, false},
+		{`{"method": "primesInRange", "from": 10, "to": 10}`, false},
+		{`{"method": "primesInRange", "from": 1e30, "to": 1.000000000000000000000000000001e30}`, false},
+		// The other fields are ignored, like any extraneous field
+		{`{"method": "primesInRange", "from": 1, "to": 2, "number": 3.5}`, false},
+
+		// Only isPrime accepts non-integers
+		{`{"method": "factorize", "number": 7.5}`, true},
+		{`{"method": "nextPrime", "number": 7.5}`, true},
+		{`{"method": "primesInRange", "from": 1.5, "to": 10}`, true},
+
+		// Numbers that can't be factorized
+		{`{"method": "factorize", "number": 0}`, true},
+		{`{"method": "factorize", "number": -12}`, true},
+		{`{"method": "factorize", "number": 9223372036854775808}`, true},
+
+		// Numbers too large to search for primes
+		{`{"method": "nextPrime", "number": 1e39}`, true},
+		{`{"method": "prevPrime", "number": -1e39}`, true},
+		{`{"method": "primesInRange", "from": 1, "to": 1e39}`, true},
+
+		// Missing and invalid fields
+		{`{"method": "factorize"}`, true},
+		{`{"method": "prevPrime", "number": "7"}`, true},
+		{`{"method": "primesInRange", "from": 10}`, true},
+		{`{"method": "primesInRange", "to": 10}`, true},
+		{`{"method": "primesInRange", "from": 1e101, "to": 1e101}`, true},
+
+		// Ranges that are reversed or too large
+		{`{"method": "primesInRange", "from": 20, "to": 10}`, true},
+		{`{"method": "primesInRange", "from": 0, "to": 999}`, false},
+		{`{"method": "primesInRange", "from": 0, "to": 1000}`, true},
+		// Ranges of 127-bit numbers may hold 1000 * 64 / 127 = 503 integers
+		{`{"method": "primesInRange", "from": 100000000000000000000000000000000000000, "to": 100000000000000000000000000000000000400}`, false},
+		{`{"method": "primesInRange", "from": 100000000000000000000000000000000000000, "to": 100000000000000000000000000000000000600}`, true},
+
+		// Unknown methods
+		{`{"method": "isComposite", "number": 7}`, true},
+		{`{"method": "factorise", "number": 7}`, true},
+	}
+	for _, row := range table {
+		_, err := ParseRequest([]byte(row.in), limits)
+		if row.wantErr && err == nil {
+			t.Errorf("ParseRequest(%q) expected error", row.in)
+		}
+		if !row.wantErr && err != nil {
+			t.Errorf("ParseRequest(%q) produced unexpected error: %v", row.in, err)
+		}
+	}
+}
+
+// TestNumberSemantics checks the answer to numbers whose JSON text is unusual, which must be classified exactly
+func TestNumberSemantics(t *testing.T) {
+	table := []struct {
+		number string
+		prime  bool
+	}{
+		// Non-integers are never prime, however close they are to a prime
+		{"3.999", false},
+		{"7.5", false},
+		{"7.0000000000000000000000000001", false},
+		{"6.9999999999999999999999999999", false},
+		{"7e-1", false},
+		{"0.7e1", true},
+		{"70e-1", true},
+		{"71e-1", false},
+		{"1e-400", false},
+
+		// Integer valued forms are the integer
+		{"7", true},
+		{"7.0", true},
+		{"7.000e0", true},
+		{"7e0", true},
+		{"7E+0", true},
+		{"700e-2", true},
+		{"0.0000007e7", true},
+		{"-7.0", false},
+
+		// Zero in every form
+		{"0", false},
+		{"-0", false},
+		{"-0.0", false},
+		{"0e400", false},
+		{"0.000e-99999999999999999999", false},
+
+		// Beyond the range of a float64, and of an int64
+		{"1e400", false},
+		{"1e308", false},
+		{"9223372036854775783", true},
+		{"9223372036854775783.0", true},
+		{"9223372036854775783.5", false},
+		{"170141183460469231731687303715884105727", true},
+		{"1.70141183460469231731687303715884105727e38", true},
+		{"1.701411834604692317316873037158841057275e38", false},
+	}
+	for _, row := range table {
+		line := `{"method": "isPrime", "number": ` + row.number + `}`
+		request, err := ParseRequest([]byte(line), DefaultLimits())
+		if err != nil {
+			t.Errorf("ParseRequest(%q) produced unexpected error: %v", line, err)
+			continue
+		}
+		if got := request.IsPrime(); got != row.prime {
+			t.Errorf("%s: got prime=%v, want %v", row.number, got, row.prime)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Package service exposes the prime time server as a runner.Service, so that it can be started by both the
+// standalone server and the combined protohackers binary.
+package service
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/01_prime_time/internal"
+	"github.com/ananthvk/protohackers-go/internal/limit"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+	"github.com/ananthvk/protohackers-go/internal/sniff"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+)
+
+// Name is the name of the service, used in logs
+const Name = "prime"
+
+// Config holds the settings of the service
+type Config struct {
+	Address string
+	// TLSAddress serves TLS on a separate address, see runner.Service.TLSAddress
+	TLSAddress string
+	// LineRate limits the requests of each connection. Clients that go over it get a malformed response, and are
+	// disconnected
+	LineRate limit.Rate
+	// MaxDigits is the largest number of digits accepted in a number. Zero uses the default
+	MaxDigits int
+	// MaxRange is the largest number of integers checked by a primesInRange request of 64-bit numbers. Zero uses the
+	// default
+	MaxRange int
+	// MaxBatch is the largest number of requests in a JSON-RPC batch. Zero uses the default
+	MaxBatch int
+	// JSONRPC serves JSON-RPC 2.0 instead of the protocol of the challenge
+	JSONRPC bool
+}
+
+// DefaultConfig returns a config with the default value of every tunable
+func DefaultConfig() Config {
+	return Config{MaxDigits: internal.DefaultMaxDigits, MaxRange: internal.DefaultMaxRange, MaxBatch: internal.DefaultMaxBatch}
+}
+
+// RegisterFlags binds the tunables of the service to command line flags, whose names start with prefix
+func (c *Config) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	c.LineRate.RegisterFlags(fs, prefix+"line-", "requests")
+	fs.IntVar(&c.MaxDigits, prefix+"max-digits", c.MaxDigits, "largest number of digits accepted in a number, larger numbers get a malformed response")
+	fs.BoolVar(&c.JSONRPC, prefix+"jsonrpc", c.JSONRPC, "serve JSON-RPC 2.0 instead of the protocol of the challenge, errors are answered without disconnecting")
+	fs.IntVar(&c.MaxBatch, prefix+"max-batch", c.MaxBatch, "largest number of requests in a JSON-RPC batch, each of which counts towards the line rate")
+	fs.IntVar(&c.MaxRange, prefix+"max-range", c.MaxRange, "largest number of integers checked by a primesInRange request, fewer for numbers above 2^64")
+}
+
+// Validate checks the tunables of the service
+func (c *Config) Validate() error {
+	if c.MaxDigits < 0 || c.MaxRange < 0 || c.MaxBatch < 0 {
+		return errors.New("prime: max digits, max range and max batch must not be negative")
+	}
+	if err := c.LineRate.Validate(); err != nil {
+		return fmt.Errorf("prime: %w", err)
+	}
+	return nil
+}
+
+// New creates the service
+func New(cfg Config) *runner.Service {
+	primeServer := &internal.Server{
+		LineRate:  cfg.LineRate,
+		MaxDigits: cfg.MaxDigits,
+		MaxRange:  cfg.MaxRange,
+		MaxBatch:  cfg.MaxBatch,
+		JSONRPC:   cfg.JSONRPC,
+	}
+	return &runner.Service{
+		Name:       Name,
+		Network:    "tcp",
+		Address:    cfg.Address,
+		TLSAddress: cfg.TLSAddress,
+		Timeouts:   timeout.Policy{Idle: time.Minute, Read: 10 * time.Second, Write: 10 * time.Second},
+		Handler: func(ctx context.Context, conn net.Conn) {
+			internal.Handle(ctx, primeServer, conn)
+		},
+	}
+}
+
+// Detect recognizes prime time clients for protocol sniffing: every request is a JSON object
+func Detect(prefix []byte) sniff.Verdict {
+	if prefix[0] == '{' {
+		return sniff.Match
+	}
+	return sniff.NoMatch
+}
@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/ananthvk/protohackers-go/internal/script"
+)
+
+// messages are the messages of the protocol, as named in the scripts of testdata/e2e
+var messages = script.Messages{
+	"insert": func(f *script.Fields) []byte {
+		return encodeMessage('I', f.Int("timestamp", 32), f.Int("price", 32))
+	},
+	"query": func(f *script.Fields) []byte {
+		return encodeMessage('Q', f.Int("mintime", 32), f.Int("maxtime", 32))
+	},
+	"mean": func(f *script.Fields) []byte {
+		return binary.BigEndian.AppendUint32(nil, uint32(f.Int("value", 32)))
+	},
+}
+
+func encodeMessage(messageType byte, field1, field2 int64) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{messageType}, uint32(field1))
+	return binary.BigEndian.AppendUint32(b, uint32(field2))
+}
+
+// TestEndToEnd runs the conversations of testdata/e2e, see the script package
+func TestEndToEnd(t *testing.T) {
+	script.RunFiles(t, "testdata/e2e/*.script", func(h *script.Harness) {
+		h.Start(script.Server{Stream: Handle, Messages: messages})
+	})
+}
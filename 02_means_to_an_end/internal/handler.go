@@ -1,18 +1,21 @@
 package internal
 
 import (
+	"context"
 	"io"
 	"log/slog"
 	"net"
+
+	"github.com/ananthvk/protohackers-go/internal/timeout"
 )
 
 // Handle handles a single client connection. This should be run in a separate gorutine so that requests can be handled
 // concurrently.
-func Handle(connection net.Conn) {
+func Handle(ctx context.Context, connection net.Conn) {
 	numRequests := int64(0)
-	slog.Info("client connected", "remote_address", connection.RemoteAddr().String())
+	slog.InfoContext(ctx, "client connected", "remote_address", connection.RemoteAddr().String())
 	defer func() {
-		slog.Info("client disconnected", "address", connection.RemoteAddr().String(), "num_requests", numRequests)
+		slog.InfoContext(ctx, "client disconnected", "address", connection.RemoteAddr().String(), "num_requests", numRequests)
 	}()
 	defer connection.Close()
 
@@ -25,6 +28,7 @@ func Handle(connection net.Conn) {
 			return
 		}
 		numRequests++
+		timeout.EndMessage(connection)
 		message, err := ParseMessage(buffer)
 		if err != nil {
 			return
@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/chaos"
+)
+
+func TestHandleFragmented(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	// Every read of the handler returns a single byte, and responses are written a byte at a time
+	go Handle(context.Background(), chaos.NewConn(server, chaos.Config{Seed: 2, Fragment: 1, Coalesce: 0.5}, 1))
+
+	message := func(kind byte, a, b int32) []byte {
+		m := []byte{kind}
+		m = binary.BigEndian.AppendUint32(m, uint32(a))
+		return binary.BigEndian.AppendUint32(m, uint32(b))
+	}
+	var requests []byte
+	requests = append(requests, message('I', 12345, 101)...)
+	requests = append(requests, message('I', 12346, 102)...)
+	requests = append(requests, message('I', 12347, 100)...)
+	requests = append(requests, message('I', 40960, 5)...)
+	requests = append(requests, message('Q', 12288, 16384)...)
+	client.SetDeadline(time.Now().Add(time.Second * 5))
+	go client.Write(requests)
+
+	var response [4]byte
+	if _, err := io.ReadFull(client, response[:]); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if got := int32(binary.BigEndian.Uint32(response[:])); got != 101 {
+		t.Errorf("got mean %d, want 101", got)
+	}
+}
@@ -0,0 +1,14 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/ananthvk/protohackers-go/internal/record/recordtest"
+)
+
+// TestReplay replays the sessions recorded in testdata/replay, see the replay command
+func TestReplay(t *testing.T) {
+	recordtest.TestDir(t, func() func(context.Context, net.Conn) { return Handle }, "testdata/replay")
+}
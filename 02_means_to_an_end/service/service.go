@@ -0,0 +1,54 @@
+// Package service exposes the means to an end server as a runner.Service, so that it can be started by both the
+// standalone server and the combined protohackers binary.
+package service
+
+import (
+	"time"
+
+	"github.com/ananthvk/protohackers-go/02_means_to_an_end/internal"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+	"github.com/ananthvk/protohackers-go/internal/sniff"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+)
+
+// Name is the name of the service, used in logs
+const Name = "means"
+
+// Config holds the settings of the service
+type Config struct {
+	Address string
+	// TLSAddress serves TLS on a separate address, see runner.Service.TLSAddress
+	TLSAddress string
+}
+
+// New creates the service
+func New(cfg Config) *runner.Service {
+	return &runner.Service{
+		Name:       Name,
+		Network:    "tcp",
+		Address:    cfg.Address,
+		TLSAddress: cfg.TLSAddress,
+		Timeouts:   timeout.Policy{Idle: time.Minute, Read: 10 * time.Second, Write: 10 * time.Second},
+		Handler:    internal.Handle,
+	}
+}
+
+// Detect recognizes means to an end clients for protocol sniffing: every message is a 9 byte frame that starts with
+// 'I' or 'Q'. A single byte is not enough, since text such as "Is this on?" starts the same way, so Detect waits for a
+// whole frame. The type byte of every frame received so far is checked, including the one of a frame that is not
+// complete yet, which rejects most lines of text. Nothing else in a frame can be checked, since the integers may take
+// any value (a query whose mintime comes after maxtime is valid, and answered with 0)
+func Detect(prefix []byte) sniff.Verdict {
+	for frame := prefix; len(frame) > 0; frame = frame[min(len(frame), frameSize):] {
+		if frame[0] != 'I' && frame[0] != 'Q' {
+			return sniff.NoMatch
+		}
+	}
+	if len(prefix) < frameSize {
+		return sniff.NeedMore
+	}
+	return sniff.Match
+}
+
+// frameSize is the size of every message of the protocol
+const frameSize = 9
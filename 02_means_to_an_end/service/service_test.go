@@ -0,0 +1,35 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/ananthvk/protohackers-go/internal/sniff"
+)
+
+func TestDetect(t *testing.T) {
+	table := []struct {
+		in   string
+		want sniff.Verdict
+	}{
+		{"I", sniff.NeedMore},
+		{"I\x00\x00\x30\x39\x00\x00", sniff.NeedMore},
+		{"I\x00\x00\x30\x39\x00\x00\x00\x65", sniff.Match},
+		{"Q\x00\x00\x03\xe8\x00\x01\x86\xa0", sniff.Match},
+		{"I\x00\x00\x30\x39\x00\x00\x00\x65Q\x00", sniff.Match},
+		// Negative mintime before a positive maxtime
+		{"Q\xff\xff\xff\xff\x00\x00\x00\x01", sniff.Match},
+		// mintime after maxtime is answered with 0
+		{"Q\x00\x00\x00\x02\x00\x00\x00\x01", sniff.Match},
+		{"I\x00\x00\x30\x39\x00\x00\x00\x65X", sniff.NoMatch},
+		// Lines of text that start like a frame
+		{"Is this on?\n", sniff.NoMatch},
+		// Too short to tell, the connection is routed by the silence timeout if nothing else comes
+		{"Quit\n", sniff.NeedMore},
+		{"hello", sniff.NoMatch},
+	}
+	for _, row := range table {
+		if got := Detect([]byte(row.in)); got != row.want {
+			t.Errorf("Detect(%q) = %v, want %v", row.in, got, row.want)
+		}
+	}
+}
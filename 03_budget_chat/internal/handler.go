@@ -2,12 +2,15 @@ package internal
 
 import (
 	"bufio"
+	"context"
 	"log/slog"
 	"net"
 	"strings"
-)
+	"time"
 
-const outgoingChannelSize = 10
+	"github.com/ananthvk/protohackers-go/internal/session"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+)
 
 // WriteLineAndFlush writes the given string along with a newline ('\n'), then flushes the stream.
 func WriteLineAndFlush(writer *bufio.Writer, message string) error {
@@ -22,21 +25,21 @@ func WriteLineAndFlush(writer *bufio.Writer, message string) error {
 
 // Handle handles a single client connection. This should be run in a separate gorutine so that requests can be handled
 // concurrently.
-func Handle(chatServer *ChatServer, connection net.Conn) {
+func Handle(ctx context.Context, chatServer *ChatServer, connection net.Conn) {
 	isJoined := false
-	slog.Info("client connected", "remote_address", connection.RemoteAddr().String())
+	slog.InfoContext(ctx, "client connected", "remote_address", connection.RemoteAddr().String())
 	client := &ClientConnection{
 		conn:     connection,
 		reader:   bufio.NewReader(connection),
 		writer:   bufio.NewWriter(connection),
-		outgoing: make(chan string, outgoingChannelSize),
+		outgoing: make(chan string, chatServer.OutgoingQueueSize),
 	}
 	defer func() {
 		if isJoined {
 			chatServer.BroadcastExcept(client.getKey(), formatNotification(client.username, "left the room"))
 		}
 		chatServer.RemoveUser(connection.RemoteAddr().String())
-		slog.Info("client disconnected", "address", connection.RemoteAddr().String())
+		slog.InfoContext(ctx, "client disconnected", "address", connection.RemoteAddr().String())
 	}()
 	defer connection.Close()
 
@@ -44,16 +47,21 @@ func Handle(chatServer *ChatServer, connection net.Conn) {
 	if err := WriteLineAndFlush(client.writer, formatGreeting()); err != nil {
 		return
 	}
-	// Get the username
+	// Get the username, the client has to reply to the greeting within the read timeout
+	timeout.BeginMessage(connection)
 	line, err := client.reader.ReadString('\n')
 	if err != nil {
 		return
 	}
+	timeout.EndMessage(connection)
 	client.username = strings.TrimSuffix(line, "\n")
 	if !validateName(client.username) {
 		WriteLineAndFlush(client.writer, formatNotification("!system", "invalid username"))
 		return
 	}
+	session.Annotate(ctx, "username", client.username)
+	// Joined users may only read the chat, and never send anything
+	timeout.SetIdleTimeout(connection, 0)
 
 	// Send presence notification to this client
 	// Note: We do not need to filter the returned list since the current user is not yet added to the map
@@ -77,11 +85,22 @@ func Handle(chatServer *ChatServer, connection net.Conn) {
 	// Broadcast a join notification to connected users
 	chatServer.BroadcastExcept(client.getKey(), formatNotification(client.username, "joined the room"))
 
+	lines := chatServer.LineRate.NewBucket(time.Now())
 	for {
 		line, err := client.reader.ReadString('\n')
 		if err != nil {
 			return
 		}
+		timeout.EndMessage(connection)
+		// Reading stops while the client is held back, so that a flood of messages fills its TCP window instead of the
+		// queues of the other clients
+		delay, err := lines.Wait(ctx)
+		if err != nil {
+			return
+		}
+		if delay > 0 {
+			messagesThrottled.Inc()
+		}
 		line = strings.TrimSuffix(line, "\n")
 		chatServer.BroadcastExcept(client.getKey(), formatBroadcast(client.username, line))
 	}
@@ -0,0 +1,10 @@
+package internal
+
+import "github.com/ananthvk/protohackers-go/internal/metrics"
+
+var (
+	messagesQueued  = metrics.NewCounter("budget_chat_messages_queued_total", "Number of messages queued for delivery to a client")
+	messagesDropped = metrics.NewCounter("budget_chat_messages_dropped_total", "Number of messages dropped because the outgoing queue of a client was full")
+	// messagesThrottled counts the messages that were held back by the line rate limit, they are still delivered
+	messagesThrottled = metrics.NewCounter("budget_chat_messages_throttled_total", "Number of messages delayed by the line rate limit")
+)
@@ -0,0 +1,17 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/ananthvk/protohackers-go/internal/record/recordtest"
+)
+
+// TestReplay replays the sessions recorded in testdata/replay, see the replay command
+func TestReplay(t *testing.T) {
+	recordtest.TestDir(t, func() func(context.Context, net.Conn) {
+		chatServer := NewChatServer()
+		return func(ctx context.Context, conn net.Conn) { Handle(ctx, chatServer, conn) }
+	}, "testdata/replay")
+}
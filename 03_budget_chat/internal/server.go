@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"net"
 	"sync"
+
+	"github.com/ananthvk/protohackers-go/internal/limit"
 )
 
 // ClientConnection is a connection from a single client. It has the net.Conn object, along with the buffered readers & writers for
@@ -22,14 +24,25 @@ func (c *ClientConnection) getKey() string {
 
 // ChatServer represents the global state of the chat application. It holds a mutex, and a map of clients
 type ChatServer struct {
+	// OutgoingQueueSize is the number of messages queued for each client. Messages to a client with a full queue are
+	// dropped. It must be set before the server is used.
+	OutgoingQueueSize int
+	// LineRate limits the messages of each client. Clients that go over it are slowed down, their messages are
+	// broadcast once the rate allows it. It must be set before the server is used.
+	LineRate limit.Rate
+
 	mu sync.RWMutex
 	// clients is a map of connection address to the connection object
 	clients map[string]*ClientConnection
 }
 
+// DefaultOutgoingQueueSize is the default value of ChatServer.OutgoingQueueSize
+const DefaultOutgoingQueueSize = 10
+
 func NewChatServer() *ChatServer {
 	return &ChatServer{
-		clients: map[string]*ClientConnection{},
+		OutgoingQueueSize: DefaultOutgoingQueueSize,
+		clients:           map[string]*ClientConnection{},
 	}
 }
 
@@ -75,8 +88,10 @@ func (c *ChatServer) BroadcastExcept(excludeKey, message string) {
 	for _, client := range recipients {
 		select {
 		case client.outgoing <- message:
+			messagesQueued.Inc()
 		default:
 			// Skip slow client
+			messagesDropped.Inc()
 		}
 	}
 }
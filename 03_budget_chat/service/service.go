@@ -0,0 +1,73 @@
+// Package service exposes the budget chat server as a runner.Service, so that it can be started by both the
+// standalone server and the combined protohackers binary.
+package service
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/03_budget_chat/internal"
+	"github.com/ananthvk/protohackers-go/internal/limit"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+)
+
+// Name is the name of the service, used in logs
+const Name = "chat"
+
+// Config holds the settings of the service
+type Config struct {
+	Address string
+	// TLSAddress serves TLS on a separate address, see runner.Service.TLSAddress
+	TLSAddress string
+	// OutgoingQueueSize is the number of messages queued for each client. Zero uses the default
+	OutgoingQueueSize int
+	// LineRate limits the messages of each client. Clients that go over it are slowed down
+	LineRate limit.Rate
+}
+
+// DefaultConfig returns a config with the default value of every tunable
+func DefaultConfig() Config {
+	return Config{OutgoingQueueSize: internal.DefaultOutgoingQueueSize}
+}
+
+// RegisterFlags binds the tunables of the service to command line flags, whose names start with prefix
+func (c *Config) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	fs.IntVar(&c.OutgoingQueueSize, prefix+"outgoing-queue-size", c.OutgoingQueueSize, "number of messages queued for each chat client, messages are dropped when it's full")
+	c.LineRate.RegisterFlags(fs, prefix+"line-", "chat messages")
+}
+
+// Validate checks the tunables of the service
+func (c *Config) Validate() error {
+	if c.OutgoingQueueSize < 0 {
+		return errors.New("chat: outgoing queue size must not be negative")
+	}
+	if err := c.LineRate.Validate(); err != nil {
+		return fmt.Errorf("chat: %w", err)
+	}
+	return nil
+}
+
+// New creates the service, along with the chat room shared by all its connections
+func New(cfg Config) *runner.Service {
+	chatServer := internal.NewChatServer()
+	if cfg.OutgoingQueueSize > 0 {
+		chatServer.OutgoingQueueSize = cfg.OutgoingQueueSize
+	}
+	chatServer.LineRate = cfg.LineRate
+	return &runner.Service{
+		Name:       Name,
+		Network:    "tcp",
+		Address:    cfg.Address,
+		TLSAddress: cfg.TLSAddress,
+		// Clients have to send their name promptly. The idle timeout is cleared once they joined, so that they may lurk
+		Timeouts: timeout.Policy{Idle: 30 * time.Minute, Read: 30 * time.Second, Write: 10 * time.Second},
+		Handler: func(ctx context.Context, conn net.Conn) {
+			internal.Handle(ctx, chatServer, conn)
+		},
+	}
+}
@@ -2,12 +2,15 @@ package tests
 
 import (
 	"bufio"
+	"context"
 	"net"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/ananthvk/protohackers-go/03_budget_chat/internal"
+	"github.com/ananthvk/protohackers-go/internal/clock"
+	"github.com/ananthvk/protohackers-go/internal/vnet"
 )
 
 // This tests the following:
@@ -16,18 +19,44 @@ import (
 // 3) The client "joins" the room
 // 4) The server sends a presence notification (lists the users in the room, except the new user)
 
+// readTimeout bounds each read on the wall clock, so that a server that never answers fails the test
+const readTimeout = 5 * time.Second
+
+// readLine reads a line sent to the client. The deadlines of the virtual network follow its clock, which doesn't move
+// in these tests, so the client is closed instead if nothing arrives within readTimeout
+func readLine(t *testing.T, client net.Conn, reader *bufio.Reader) (string, error) {
+	t.Helper()
+	timer := time.AfterFunc(readTimeout, func() { client.Close() })
+	line, err := reader.ReadString('\n')
+	if !timer.Stop() {
+		t.Fatalf("no line received within %v, got %q", readTimeout, line)
+	}
+	return line, err
+}
+
 func TestJoinSingleClient(t *testing.T) {
-	server, client := net.Pipe()
+	// The network only moves when the test says so, every read below returns as soon as the server has answered
+	network := vnet.New(clock.NewVirtual())
+	listener, err := network.Listen("10.0.0.1:7")
+	if err != nil {
+		t.Fatal(err)
+	}
 	chatServer := internal.NewChatServer()
-	go func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go vnet.Serve(ctx, listener, func(ctx context.Context, conn net.Conn) {
 		// Server
-		internal.Handle(chatServer, server)
-	}()
+		internal.Handle(ctx, chatServer, conn)
+	})
 
+	client, err := network.Dial("10.0.0.1:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
 	reader := bufio.NewReader(client)
-	writer := bufio.NewWriter(client)
 
-	line, err := readLineWithTimeout(reader, time.Second*1)
+	line, err := readLine(t, client, reader)
 	if err != nil {
 		t.Errorf("unexpected error while receiving greeting message: %v", err)
 		return
@@ -36,11 +65,11 @@ func TestJoinSingleClient(t *testing.T) {
 		t.Errorf("want %q in greeting message, got %q", "Welcome to budgetchat", line)
 		return
 	}
-	if err := writeStringWithTimeout(writer, "bob\n", time.Second*1); err != nil {
+	if _, err := client.Write([]byte("bob\n")); err != nil {
 		t.Errorf("unexpected error while sending username: %v", err)
 		return
 	}
-	line, err = readLineWithTimeout(reader, time.Second*1)
+	line, err = readLine(t, client, reader)
 	if err != nil {
 		t.Errorf("unexpected error while receiving presence notification: %v", err)
 		return
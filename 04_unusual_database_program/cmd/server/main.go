@@ -1,45 +1,28 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
-	"log/slog"
-	"net"
 
-	"github.com/ananthvk/protohackers-go/04_unusual_database_program/internal"
+	"github.com/ananthvk/protohackers-go/04_unusual_database_program/service"
+	"github.com/ananthvk/protohackers-go/internal/runner"
 )
 
-const maxPacketSize = 1000
-const appVersion = "1.0.1"
-
 func main() {
 	portPtr := flag.Uint("port", 8000, "specify the port on which to listen")
 	hostPtr := flag.String("host", "0.0.0.0", "specify the bind address")
-	flag.Parse()
-	address := fmt.Sprintf("%s:%d", *hostPtr, *portPtr)
-
-	ctx := context.Background()
-	listenerConfig := net.ListenConfig{}
-	listener, err := listenerConfig.ListenPacket(ctx, "udp", address)
-	if err != nil {
-		slog.Error("listen failed", "error", err)
-		return
-	}
-	store := internal.NewKVStore(appVersion)
-	slog.Info("server listening", "address", listener.LocalAddr().String())
-	defer listener.Close()
-	buffer := make([]byte, maxPacketSize)
-	for {
-		n, fromAddr, err := listener.ReadFrom(buffer)
-		result := store.ExecuteQuery(string(buffer[:n]))
-		if result.HasValue {
-			if _, err := listener.WriteTo([]byte(result.Value), fromAddr); err != nil {
-				slog.Error("write error", "error", err)
-			}
-		}
-		if err != nil {
-			slog.Error("read error", "error", err)
-		}
+	addressPtr := flag.String("address", "", "listen on this address instead of -host and -port, such as unix:/path/to/socket or systemd:name for a socket passed by systemd")
+	opts := runner.DefaultOptions()
+	opts.RegisterPacketFlags(flag.CommandLine, false)
+	cfg := service.DefaultConfig()
+	cfg.RegisterFlags(flag.CommandLine, "")
+	runner.ParseFlags(opts.Validate, cfg.Validate)
+	address := *addressPtr
+	if address == "" {
+		address = fmt.Sprintf("%s:%d", *hostPtr, *portPtr)
 	}
+
+	cfg.Address = address
+
+	runner.Main(opts, service.New(cfg))
 }
@@ -30,13 +30,16 @@ func (k *KVStore) ExecuteQuery(query string) QueryResult {
 	if found {
 		// It's an insert / update
 		if before == "version" {
+			queries.With("version").Inc()
 			return QueryResult{HasValue: false}
 		}
+		queries.With("insert").Inc()
 		k.mp[before] = after
 		return QueryResult{HasValue: false}
 
 	} else {
 		// It's a retrieval
+		queries.With("retrieve").Inc()
 		result := fmt.Sprintf("%s=%s", before, k.mp[before])
 		return QueryResult{Value: result, HasValue: true}
 	}
@@ -0,0 +1,10 @@
+package internal
+
+import "github.com/ananthvk/protohackers-go/internal/metrics"
+
+// queries is labelled by the kind of query, which is one of "insert", "retrieve" or "version" (rejected attempts to
+// modify the version key)
+var queries = metrics.NewCounterVec("kv_queries_total", "Number of queries executed, by kind", "kind")
+
+// queriesDenied is labelled by the kind of query, "insert" or "retrieve"
+var queriesDenied = metrics.NewCounterVec("kv_queries_denied_total", "Number of queries ignored because of the access lists, by kind", "kind")
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/ananthvk/protohackers-go/internal/acl"
+)
+
+const maxPacketSize = 1000
+
+// Access restricts the addresses that may run each kind of query. The zero value permits every query
+type Access struct {
+	Inserts    acl.List
+	Retrievals acl.List
+}
+
+// permits returns true if the query may be run by a client with the address, along with the kind of the query
+func (a Access) permits(query string, addr net.Addr) (kind string, ok bool) {
+	if strings.Contains(query, "=") {
+		return "insert", a.Inserts.Permits(addr)
+	}
+	return "retrieve", a.Retrievals.Permits(addr)
+}
+
+// Serve reads queries from the packet connection, and executes them against the store. Responses to retrievals are
+// sent back to the address the query came from, unless access denies the query, which is then ignored. It returns when
+// the context is cancelled or the connection is closed.
+func Serve(ctx context.Context, store *KVStore, access Access, conn net.PacketConn) {
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, fromAddr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if temporary(err) {
+				slog.WarnContext(ctx, "read error", "error", err)
+				continue
+			}
+			slog.ErrorContext(ctx, "read error, stopping", "error", err)
+			return
+		}
+		query := string(buffer[:n])
+		if kind, ok := access.permits(query, fromAddr); !ok {
+			queriesDenied.With(kind).Inc()
+			slog.DebugContext(ctx, "query denied", "kind", kind, "remote_address", fromAddr.String())
+			continue
+		}
+		result := store.ExecuteQuery(query)
+		if result.HasValue {
+			if _, err := conn.WriteTo([]byte(result.Value), fromAddr); err != nil {
+				slog.ErrorContext(ctx, "write error", "error", err)
+			}
+		}
+	}
+}
+
+// temporary returns true if a read error doesn't prevent the next read from succeeding: a refused connection is an ICMP
+// error caused by an earlier reply, for a client that has gone away
+func temporary(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
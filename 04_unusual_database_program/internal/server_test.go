@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/acl"
+)
+
+func TestServeAccess(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer conn.Close()
+	// Only the office network may insert, anyone may retrieve
+	access := Access{Inserts: acl.List{Allow: acl.Prefixes{netip.MustParsePrefix("10.0.0.0/8")}}}
+	go Serve(ctx, NewKVStore("1.0.0-test"), access, conn)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("foo=bar"))
+	client.Write([]byte("foo"))
+	client.SetReadDeadline(time.Now().Add(time.Second * 2))
+	buffer := make([]byte, 64)
+	n, err := client.Read(buffer)
+	if err != nil || string(buffer[:n]) != "foo=" {
+		t.Errorf("got %q, %v, want %q as the insert is denied", buffer[:n], err, "foo=")
+	}
+}
+
+// failingConn is a packet connection whose reads fail with the next of its errors
+type failingConn struct {
+	net.PacketConn
+	errs  []error
+	reads int
+}
+
+func (c *failingConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.reads++
+	err := c.errs[0]
+	if len(c.errs) > 1 {
+		c.errs = c.errs[1:]
+	}
+	return 0, nil, err
+}
+
+func TestServeReadErrors(t *testing.T) {
+	conn := &failingConn{errs: []error{syscall.ECONNREFUSED, errors.New("broken")}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Serve(context.Background(), NewKVStore("1.0.0-test"), Access{}, conn)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("Serve did not return after a read error that is not temporary")
+	}
+	if conn.reads != 2 {
+		t.Errorf("got %d reads, want 2 as the refused connection is skipped", conn.reads)
+	}
+}
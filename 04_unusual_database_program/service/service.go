@@ -0,0 +1,65 @@
+// Package service exposes the unusual database program server as a runner.Service, so that it can be started by both the
+// standalone server and the combined protohackers binary.
+package service
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net"
+
+	"github.com/ananthvk/protohackers-go/04_unusual_database_program/internal"
+	"github.com/ananthvk/protohackers-go/internal/acl"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+)
+
+// Name is the name of the service, used in logs
+const Name = "kv"
+
+// DefaultVersion is the value of the read-only "version" key
+const DefaultVersion = "1.0.1"
+
+// Config holds the settings of the service
+type Config struct {
+	Address string
+	// Version is the value of the read-only "version" key
+	Version string
+	// Inserts and Retrievals restrict the addresses that may run each kind of query, in addition to the access lists of
+	// the service
+	Inserts    acl.List
+	Retrievals acl.List
+}
+
+// DefaultConfig returns a config with the default value of every tunable
+func DefaultConfig() Config {
+	return Config{Version: DefaultVersion}
+}
+
+// RegisterFlags binds the tunables of the service to command line flags, whose names start with prefix
+func (c *Config) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	fs.StringVar(&c.Version, prefix+"version", c.Version, "value of the read-only version key")
+	c.Inserts.RegisterFlags(fs, prefix+"insert-", "inserts")
+	c.Retrievals.RegisterFlags(fs, prefix+"retrieve-", "retrievals")
+}
+
+// Validate checks the tunables of the service
+func (c *Config) Validate() error {
+	if c.Version == "" {
+		return errors.New("kv: version must not be empty")
+	}
+	return nil
+}
+
+// New creates the service, along with the key value store
+func New(cfg Config) *runner.Service {
+	store := internal.NewKVStore(cfg.Version)
+	access := internal.Access{Inserts: cfg.Inserts, Retrievals: cfg.Retrievals}
+	return &runner.Service{
+		Name:    Name,
+		Network: "udp",
+		Address: cfg.Address,
+		PacketHandler: func(ctx context.Context, conn net.PacketConn) {
+			internal.Serve(ctx, store, access, conn)
+		},
+	}
+}
@@ -1,39 +1,41 @@
 package main
 
 import (
-	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log/slog"
-	"net"
 
-	"github.com/ananthvk/protohackers-go/05_mob_in_the_middle/internal"
+	"github.com/ananthvk/protohackers-go/05_mob_in_the_middle/service"
+	"github.com/ananthvk/protohackers-go/internal/runner"
 )
 
 func main() {
 	portPtr := flag.Uint("port", 8000, "specify the port on which to listen")
 	hostPtr := flag.String("host", "0.0.0.0", "specify the bind address")
-	upstreamPortPtr := flag.Uint("upstream-port", 16963, "specify the upstream host port")
-	upstreamHostPtr := flag.String("upstream-host", "chat.protohackers.com", "specify the upstream host address")
-	flag.Parse()
-	address := fmt.Sprintf("%s:%d", *hostPtr, *portPtr)
-	upstreamAddress := fmt.Sprintf("%s:%d", *upstreamHostPtr, *upstreamPortPtr)
-
-	ctx := context.Background()
-	listenerConfig := net.ListenConfig{}
-	listener, err := listenerConfig.Listen(ctx, "tcp", address)
-	if err != nil {
-		slog.Error("listen failed", "error", err)
-		return
-	}
-	slog.Info("server listening", "address", listener.Addr().String())
-	defer listener.Close()
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			slog.Warn("accept failed", "error", err)
-			continue
+	addressPtr := flag.String("address", "", "listen on this address instead of -host and -port, such as unix:/path/to/socket or systemd:name for a socket passed by systemd")
+	tlsPortPtr := flag.Uint("tls-port", 0, "also serve TLS on this port of -host, while -port serves plain text (requires -tls-cert, can't be combined with -address)")
+	opts := runner.DefaultOptions()
+	opts.RegisterFlags(flag.CommandLine)
+	cfg := service.DefaultConfig()
+	cfg.RegisterFlags(flag.CommandLine, "")
+	validateTLSPort := func() error {
+		if *tlsPortPtr != 0 && *addressPtr != "" {
+			return errors.New("-tls-port listens on -host, and can't be combined with -address")
 		}
-		go internal.Handle(upstreamAddress, conn)
+		return nil
+	}
+	runner.ParseFlags(opts.Validate, validateTLSPort, cfg.Validate)
+	address := *addressPtr
+	if address == "" {
+		address = fmt.Sprintf("%s:%d", *hostPtr, *portPtr)
 	}
+	tlsAddress := ""
+	if *tlsPortPtr != 0 {
+		tlsAddress = fmt.Sprintf("%s:%d", *hostPtr, *tlsPortPtr)
+	}
+
+	cfg.Address = address
+	cfg.TLSAddress = tlsAddress
+
+	runner.Main(opts, service.New(cfg))
 }
@@ -8,7 +8,16 @@ func isAlphaNumeric(c rune) bool {
 	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
 }
 
+// ReplaceBoguscoin replaces every boguscoin address in the input with the target address
 func ReplaceBoguscoin(input string, target string) string {
+	result, _ := replaceBoguscoinCount(input, target)
+	return result
+}
+
+// replaceBoguscoinCount replaces every boguscoin address in the input with the target address, and returns the
+// result along with the number of addresses that were replaced
+func replaceBoguscoinCount(input string, target string) (string, int) {
+	replaced := 0
 	// Two pointer solution to handle replacement, since splitting by
 	// space does not work (when newline / other whitespace characters are present)
 	left := 0
@@ -21,6 +30,7 @@ func ReplaceBoguscoin(input string, target string) string {
 			if length >= 26 && length <= 35 && runes[left] == '7' && unicode.IsSpace(ch) {
 				// Found a boguscoin
 				result = append(result, targetRunes...)
+				replaced++
 			} else {
 				result = append(result, runes[left:right]...)
 			}
@@ -35,9 +45,10 @@ func ReplaceBoguscoin(input string, target string) string {
 		if length >= 26 && length <= 35 && runes[left] == '7' {
 			// Found a boguscoin
 			result = append(result, targetRunes...)
+			replaced++
 		} else {
 			result = append(result, runes[left:]...)
 		}
 	}
-	return string(result)
+	return string(result), replaced
 }
@@ -1,26 +1,50 @@
 package internal
 
 import (
+	"context"
+	"crypto/tls"
 	"log/slog"
 	"net"
+
+	"github.com/ananthvk/protohackers-go/internal/limit"
 )
 
 const targetBogusCoin = "7YWHMfk9JZe0LM0g1ZauHuiSxhI"
 
 func InterceptMessage(b []byte) []byte {
-	return []byte(ReplaceBoguscoin(string(b), targetBogusCoin))
+	result, replaced := replaceBoguscoinCount(string(b), targetBogusCoin)
+	boguscoinRewrites.Add(uint64(replaced))
+	return []byte(result)
+}
+
+// Upstream is the chat server that clients are proxied to
+type Upstream struct {
+	Address string
+	// TLS is used to connect to the upstream server. Plain text is used if it's nil
+	TLS *tls.Config
+}
+
+// Dial opens a new connection to the upstream server
+func (u Upstream) Dial(ctx context.Context) (net.Conn, error) {
+	if u.TLS != nil {
+		dialer := tls.Dialer{Config: u.TLS}
+		return dialer.DialContext(ctx, "tcp", u.Address)
+	}
+	dialer := net.Dialer{}
+	return dialer.DialContext(ctx, "tcp", u.Address)
 }
 
 // Handle handles a single client connection. This should be run in a separate gorutine so that requests can be handled
-// concurrently.
-func Handle(upstreamAddress string, connection net.Conn) {
-	slog.Info("client connected", "remote_address", connection.RemoteAddr().String())
-	upstreamConn, err := net.Dial("tcp", upstreamAddress)
+// concurrently. lineRate limits the lines the client sends to the upstream server.
+func Handle(ctx context.Context, upstream Upstream, lineRate limit.Rate, connection net.Conn) {
+	slog.InfoContext(ctx, "client connected", "remote_address", connection.RemoteAddr().String())
+	upstreamConn, err := upstream.Dial(ctx)
 	if err != nil {
-		slog.Error("proxy session creation failed", "error", err)
+		slog.ErrorContext(ctx, "proxy session creation failed", "error", err)
 		return
 	}
 	session := NewProxySession(connection, upstreamConn)
 	session.SetOnLineReceivedHandler(InterceptMessage)
-	session.Start()
+	session.SetClientLineRate(lineRate)
+	session.Start(ctx)
 }
@@ -0,0 +1,8 @@
+package internal
+
+import "github.com/ananthvk/protohackers-go/internal/metrics"
+
+var (
+	boguscoinRewrites = metrics.NewCounter("mob_boguscoin_rewrites_total", "Number of boguscoin addresses rewritten")
+	linesThrottled    = metrics.NewCounter("mob_lines_throttled_total", "Number of client lines delayed by the line rate limit")
+)
@@ -2,9 +2,14 @@ package internal
 
 import (
 	"bufio"
+	"context"
 	"log/slog"
 	"net"
 	"sync"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/limit"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
 )
 
 type ProxySession struct {
@@ -18,6 +23,9 @@ type ProxySession struct {
 	upstreamRW *bufio.ReadWriter
 
 	onLineReceivedHandler func([]byte) []byte
+
+	// clientLines limits the lines forwarded from the client to the upstream server, nil if there is no limit
+	clientLines *limit.TokenBucket
 }
 
 // NewProxySession creates a new proxy session. It creates  creates the buffered reader/writers.
@@ -43,14 +51,29 @@ func (p *ProxySession) SetOnLineReceivedHandler(callback func([]byte) []byte) {
 	p.onLineReceivedHandler = callback
 }
 
+// SetClientLineRate limits the lines sent by the client. The client is slowed down when it goes over the limit, since
+// the chat protocol has no way to tell it
+func (p *ProxySession) SetClientLineRate(rate limit.Rate) {
+	p.clientLines = rate.NewBucket(time.Now())
+}
+
 // proxy reads from the 'from' ReaderWriter and writes to 'to' ReaderWriter, and flushes 'to' inside an infinite loop.
-// In case of any error, it terminates and returns the error
-func (p *ProxySession) proxy(from *bufio.ReadWriter, to *bufio.ReadWriter) error {
-	for {
+// fromConn is the connection underlying 'from', and lines limits the lines forwarded (nil for no limit). In case of any
+// error, it terminates and returns the error
+func (p *ProxySession) proxy(ctx context.Context, fromConn net.Conn, from *bufio.ReadWriter, to *bufio.ReadWriter, lines *limit.TokenBucket) error {
+	for first := true; ; first = false {
 		line, err := from.ReadBytes('\n')
 		if err != nil {
 			return err
 		}
+		timeout.EndMessage(fromConn)
+		delay, err := lines.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		if delay > 0 {
+			linesThrottled.Inc()
+		}
 		_, err = to.Write(p.onLineReceivedHandler(line))
 		if err != nil {
 			return err
@@ -59,15 +82,21 @@ func (p *ProxySession) proxy(from *bufio.ReadWriter, to *bufio.ReadWriter) error
 		if err != nil {
 			return err
 		}
+		if first {
+			// The first line of the client is its name, after which it may only read the chat for a long time. The
+			// upstream server disconnects clients with an invalid name, which closes this connection too. This is a
+			// no-op for the upstream connection, which has no timeouts
+			timeout.SetIdleTimeout(fromConn, 0)
+		}
 	}
 }
 
 // Start starts the proxy, and proxies data between the server and client. This method handles cleanup of both
 // connection and the upstream connection. It blocks until either client-proxy or proxy-upstream connection is closed
-func (p *ProxySession) Start() {
-	slog.Info("start proxy", "client", p.conn.RemoteAddr().String(), "upstream", p.upstreamConn.RemoteAddr())
+func (p *ProxySession) Start(ctx context.Context) {
+	slog.InfoContext(ctx, "start proxy", "client", p.conn.RemoteAddr().String(), "upstream", p.upstreamConn.RemoteAddr())
 	defer func() {
-		slog.Info("stop proxy", "client", p.conn.RemoteAddr().String(), "upstream", p.upstreamConn.RemoteAddr())
+		slog.InfoContext(ctx, "stop proxy", "client", p.conn.RemoteAddr().String(), "upstream", p.upstreamConn.RemoteAddr())
 		p.conn.Close()
 		p.upstreamConn.Close()
 	}()
@@ -76,14 +105,14 @@ func (p *ProxySession) Start() {
 
 	// Handle client->upstream communication
 	wg.Go(func() {
-		p.proxy(p.clientRW, p.upstreamRW)
+		p.proxy(ctx, p.conn, p.clientRW, p.upstreamRW, p.clientLines)
 		p.conn.Close()
 		p.upstreamConn.Close()
 	})
 
 	// Handle upstream->client communication
 	wg.Go(func() {
-		p.proxy(p.upstreamRW, p.clientRW)
+		p.proxy(ctx, p.upstreamConn, p.upstreamRW, p.clientRW, nil)
 		p.conn.Close()
 		p.upstreamConn.Close()
 	})
@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/chaos"
+)
+
+func TestProxyFragmented(t *testing.T) {
+	client, clientSide := net.Pipe()
+	upstream, upstreamSide := net.Pipe()
+	defer client.Close()
+	defer upstream.Close()
+	// Both sides of the proxy see lines arrive in fragments, and send them in fragments
+	cfg := chaos.Config{Seed: 5, Fragment: 0.8, Coalesce: 0.2, CoalesceDelay: time.Millisecond}
+	session := NewProxySession(chaos.NewConn(clientSide, cfg, 1), chaos.NewConn(upstreamSide, cfg, 2))
+	session.SetOnLineReceivedHandler(InterceptMessage)
+	go session.Start(context.Background())
+
+	client.SetDeadline(time.Now().Add(time.Second * 5))
+	upstream.SetDeadline(time.Now().Add(time.Second * 5))
+	clientReader, upstreamReader := bufio.NewReader(client), bufio.NewReader(upstream)
+
+	go client.Write([]byte("Hi alice, please send payment to 7YWHMfk9JZe0LM0g1ZauHuiSxhI\nthanks\n"))
+	for _, want := range []string{"Hi alice, please send payment to " + targetBogusCoin + "\n", "thanks\n"} {
+		if got, err := upstreamReader.ReadString('\n'); err != nil || got != want {
+			t.Errorf("upstream got %q, %v, want %q", got, err, want)
+		}
+	}
+
+	go upstream.Write([]byte("* bob has entered the room\n[bob] send to 7iKDZEwPZSqIvDnHvVN2r0hUWXD5rHX\n"))
+	for _, want := range []string{"* bob has entered the room\n", "[bob] send to " + targetBogusCoin + "\n"} {
+		if got, err := clientReader.ReadString('\n'); err != nil || got != want {
+			t.Errorf("client got %q, %v, want %q", got, err, want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Package service exposes the mob in the middle server as a runner.Service, so that it can be started by both the
+// standalone server and the combined protohackers binary.
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/05_mob_in_the_middle/internal"
+	"github.com/ananthvk/protohackers-go/internal/limit"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+	"github.com/ananthvk/protohackers-go/internal/tlsutil"
+)
+
+// Name is the name of the service, used in logs
+const Name = "mob"
+
+// DefaultUpstreamAddress is the address of the official budget chat server
+const DefaultUpstreamAddress = "chat.protohackers.com:16963"
+
+// Config holds the settings of the service
+type Config struct {
+	Address string
+	// TLSAddress serves TLS on a separate address, see runner.Service.TLSAddress
+	TLSAddress      string
+	UpstreamAddress string
+	// UpstreamTLSEnabled connects to the upstream server over TLS. It's implied by UpstreamCAFile
+	UpstreamTLSEnabled bool
+	// UpstreamCAFile is a PEM bundle of CAs trusted for the upstream server, instead of the system roots
+	UpstreamCAFile string
+	// UpstreamTLS is used to connect to the upstream server, which is plain text if it's nil. Validate sets it from
+	// UpstreamTLSEnabled and UpstreamCAFile
+	UpstreamTLS *tls.Config
+	// LineRate limits the lines each client sends upstream. Clients that go over it are slowed down
+	LineRate limit.Rate
+}
+
+// DefaultConfig returns a config with the default value of every tunable
+func DefaultConfig() Config {
+	return Config{UpstreamAddress: DefaultUpstreamAddress}
+}
+
+// RegisterFlags binds the tunables of the service to command line flags, whose names start with prefix
+func (c *Config) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	fs.StringVar(&c.UpstreamAddress, prefix+"upstream", c.UpstreamAddress, "address of the upstream chat server, as host:port")
+	fs.BoolVar(&c.UpstreamTLSEnabled, prefix+"upstream-tls", c.UpstreamTLSEnabled, "connect to the upstream chat server over TLS")
+	fs.StringVar(&c.UpstreamCAFile, prefix+"upstream-ca", c.UpstreamCAFile, "PEM bundle of CAs trusted for the upstream chat server, instead of the system roots (implies -"+prefix+"upstream-tls)")
+	c.LineRate.RegisterFlags(fs, prefix+"line-", "client lines")
+}
+
+// Validate checks the tunables of the service, and loads the upstream TLS config
+func (c *Config) Validate() error {
+	if c.UpstreamAddress == "" {
+		return errors.New("mob: upstream address must not be empty")
+	}
+	if err := c.LineRate.Validate(); err != nil {
+		return fmt.Errorf("mob: %w", err)
+	}
+	upstreamTLS, err := UpstreamTLSConfig(c.UpstreamTLSEnabled, c.UpstreamCAFile)
+	if err != nil {
+		return fmt.Errorf("mob: upstream TLS: %w", err)
+	}
+	c.UpstreamTLS = upstreamTLS
+	return nil
+}
+
+// UpstreamTLSConfig returns the TLS config used to connect to the upstream server, or nil if TLS is disabled. If caFile
+// is not empty, TLS is enabled and the upstream certificate is verified against the PEM bundle in it.
+func UpstreamTLSConfig(enabled bool, caFile string) (*tls.Config, error) {
+	if !enabled && caFile == "" {
+		return nil, nil
+	}
+	return tlsutil.ClientConfig(caFile)
+}
+
+// New creates the service
+func New(cfg Config) *runner.Service {
+	upstream := internal.Upstream{Address: cfg.UpstreamAddress, TLS: cfg.UpstreamTLS}
+	return &runner.Service{
+		Name:       Name,
+		Network:    "tcp",
+		Address:    cfg.Address,
+		TLSAddress: cfg.TLSAddress,
+		// The idle timeout is cleared once the client sent its name, so that it may lurk. The upstream connection is not
+		// subject to the timeouts
+		Timeouts: timeout.Policy{Idle: 30 * time.Minute, Read: 30 * time.Second, Write: 10 * time.Second},
+		Handler: func(ctx context.Context, conn net.Conn) {
+			internal.Handle(ctx, upstream, cfg.LineRate, conn)
+		},
+	}
+}
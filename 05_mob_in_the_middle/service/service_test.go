@@ -0,0 +1,39 @@
+package service
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFlags(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+	tests := []struct {
+		name    string
+		args    []string
+		wantTLS bool
+		wantErr bool
+	}{
+		{"defaults", nil, false, false},
+		{"tls", []string{"-mob-upstream", "localhost:9003", "-mob-upstream-tls"}, true, false},
+		{"missing ca", []string{"-mob-upstream-ca", missing}, false, true},
+		{"empty upstream", []string{"-mob-upstream", ""}, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := flag.NewFlagSet(test.name, flag.ContinueOnError)
+			cfg := DefaultConfig()
+			cfg.RegisterFlags(fs, Name+"-")
+			if err := fs.Parse(test.args); err != nil {
+				t.Fatal(err)
+			}
+			err := cfg.Validate()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if (cfg.UpstreamTLS != nil) != test.wantTLS {
+				t.Errorf("got upstream TLS %v, want %v", cfg.UpstreamTLS != nil, test.wantTLS)
+			}
+		})
+	}
+}
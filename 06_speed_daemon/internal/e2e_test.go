@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/ananthvk/protohackers-go/internal/script"
+)
+
+// messages are the messages of the protocol, as named in the scripts of testdata/e2e. They are encoded from the
+// statement rather than with the server's own encoders, so that the scripts check the wire format too
+var messages = script.Messages{
+	"error": func(f *script.Fields) []byte {
+		return appendString([]byte{0x10}, f.String("msg"))
+	},
+	"plate": func(f *script.Fields) []byte {
+		b := appendString([]byte{0x20}, f.String("plate"))
+		return binary.BigEndian.AppendUint32(b, uint32(f.Uint("timestamp", 32)))
+	},
+	"ticket": func(f *script.Fields) []byte {
+		b := appendString([]byte{0x21}, f.String("plate"))
+		b = binary.BigEndian.AppendUint16(b, uint16(f.Uint("road", 16)))
+		b = binary.BigEndian.AppendUint16(b, uint16(f.Uint("mile1", 16)))
+		b = binary.BigEndian.AppendUint32(b, uint32(f.Uint("timestamp1", 32)))
+		b = binary.BigEndian.AppendUint16(b, uint16(f.Uint("mile2", 16)))
+		b = binary.BigEndian.AppendUint32(b, uint32(f.Uint("timestamp2", 32)))
+		return binary.BigEndian.AppendUint16(b, uint16(f.Uint("speed", 16)))
+	},
+	"wantheartbeat": func(f *script.Fields) []byte {
+		return binary.BigEndian.AppendUint32([]byte{0x40}, uint32(f.Uint("interval", 32)))
+	},
+	"heartbeat": func(f *script.Fields) []byte {
+		return []byte{0x41}
+	},
+	"iamcamera": func(f *script.Fields) []byte {
+		b := binary.BigEndian.AppendUint16([]byte{0x80}, uint16(f.Uint("road", 16)))
+		b = binary.BigEndian.AppendUint16(b, uint16(f.Uint("mile", 16)))
+		return binary.BigEndian.AppendUint16(b, uint16(f.Uint("limit", 16)))
+	},
+	"iamdispatcher": func(f *script.Fields) []byte {
+		roads := f.Uints("roads", 16)
+		b := []byte{0x81, byte(len(roads))}
+		for _, road := range roads {
+			b = binary.BigEndian.AppendUint16(b, uint16(road))
+		}
+		return b
+	},
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(append(b, byte(len(s))), s...)
+}
+
+// TestEndToEnd runs the conversations of testdata/e2e, see the script package. Every script gets its own server
+func TestEndToEnd(t *testing.T) {
+	script.RunFiles(t, "testdata/e2e/*.script", func(h *script.Harness) {
+		speedServer := NewSpeedServer()
+		speedServer.Clock = h.Clock
+		h.Start(script.Server{
+			Stream:   func(ctx context.Context, conn net.Conn) { Handle(ctx, speedServer, conn) },
+			Messages: messages,
+		})
+	})
+}
@@ -1,45 +1,53 @@
 package internal
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 	"net"
 	"time"
-)
 
-const queueSize = 100
+	"github.com/ananthvk/protohackers-go/internal/clock"
+	"github.com/ananthvk/protohackers-go/internal/session"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+)
 
 // Handle handles a single client connection. This should be run in a separate gorutine so that requests can be handled
 // concurrently.
-func Handle(speedServer *SpeedServer, connection net.Conn) {
+func Handle(ctx context.Context, speedServer *SpeedServer, connection net.Conn) {
 	client := connection.RemoteAddr().String()
-	slog.Info("client connected", "address", client)
+	slog.InfoContext(ctx, "client connected", "address", client)
 	isCamera := false
 	isDispatcher := false
 	isHeartbeatInitialized := false
 	var cameraDetails IAmCameraMessage
 	connState := &ConnState{
 		conn:             connection,
-		outgoing:         make(chan Ticket, queueSize),
+		outgoing:         make(chan Ticket, speedServer.QueueSize),
 		heartbeat:        0,
 		kill:             make(chan struct{}),
+		done:             make(chan struct{}),
 		heartbeatControl: make(chan time.Duration),
+		clock:            clock.Or(speedServer.Clock),
 	}
 
 	defer func() {
-		slog.Info("client disconnecting", "address", client)
-		connState.kill <- struct{}{}
+		slog.InfoContext(ctx, "client disconnecting", "address", client)
+		// Closing the channel stops the writer loop, without blocking if the loop has already exited. The loop must be
+		// gone before RemoveConn closes the outgoing channel, and before the connection is closed
 		close(connState.kill)
+		<-connState.done
 		if isDispatcher {
 			speedServer.dispatchers.RemoveConn(connState)
 		}
 		connection.Close()
-		slog.Info("client disconnected", "address", client)
+		slog.InfoContext(ctx, "client disconnected", "address", client)
 	}()
 
 	// Start writer loop
-	go StartWriteLoop(connState)
+	go StartWriteLoop(ctx, connState)
 
+	messages := speedServer.MessageRate.NewBucket(connState.clock.Now())
 	for {
 		message, err := ReadMessage(connection)
 		if err != nil {
@@ -48,50 +56,91 @@ func Handle(speedServer *SpeedServer, connection net.Conn) {
 			}
 			return
 		}
+		timeout.EndMessage(connection)
+		if !messages.AllowAt(connState.clock.Now()) {
+			rateLimited.Inc()
+			slog.InfoContext(ctx, "client error", "reason", "message rate limit exceeded", "client", client)
+			WriteError(connection, "too many messages")
+			return
+		}
 		switch v := message.(type) {
 		case WantHeartbeatMessage:
-			slog.Info("client initialized heartbeat", "message", v, "client", client)
+			slog.InfoContext(ctx, "client initialized heartbeat", "message", v, "client", client)
 			if isHeartbeatInitialized {
-				slog.Info("client error", "reason", "client already set heartbeat", "client", client)
+				slog.InfoContext(ctx, "client error", "reason", "client already set heartbeat", "client", client)
 				WriteError(connection, "client has already set a heartbeat interval on this connection")
 				return
 			}
 			isHeartbeatInitialized = true
 			interval := time.Duration(v.interval) * time.Second / 10.0
 			if v.interval != 0 {
-				connState.heartbeatControl <- interval
+				select {
+				case connState.heartbeatControl <- interval:
+				case <-connState.done:
+					// The writer loop failed to write to the client, which is gone
+					return
+				}
+				// The client is kept alive by the heartbeats, so it may stay silent
+				timeout.SetIdleTimeout(connection, 0)
 			}
-			slog.Info("initialized heartbeat", "interval", interval, "client", client)
+			session.Annotate(ctx, "heartbeat", interval.String())
+			slog.InfoContext(ctx, "initialized heartbeat", "interval", interval, "client", client)
 		case IAmCameraMessage:
 			if isCamera || isDispatcher {
-				slog.Info("client error", "reason", "client has already identified as dispatcher/camera", "client", client)
+				slog.InfoContext(ctx, "client error", "reason", "client has already identified as dispatcher/camera", "client", client)
 				WriteError(connection, "client has already identified as a dispatcher/camera")
 				return
 			}
+			if !speedServer.Cameras.Permits(connection.RemoteAddr()) {
+				identificationsDenied.With("camera").Inc()
+				slog.InfoContext(ctx, "client error", "reason", "address not allowed to identify as camera", "client", client)
+				WriteError(connection, "not allowed to identify as a camera")
+				return
+			}
 			isCamera = true
 			cameraDetails = v
+			// Cameras only speak when a car passes, which may be rare on quiet roads
+			timeout.SetIdleTimeout(connection, 0)
 			speedServer.store.SetLimit(Road(cameraDetails.road), cameraDetails.limit)
-			slog.Info("client identification", "type", "camera", "client", client)
+			session.Annotate(ctx, "role", "camera")
+			session.Annotate(ctx, "road", cameraDetails.road)
+			session.Annotate(ctx, "mile", cameraDetails.mile)
+			session.Annotate(ctx, "limit", cameraDetails.limit)
+			slog.InfoContext(ctx, "client identification", "type", "camera", "client", client)
 		case IAmDispatcherMessage:
 			if isCamera || isDispatcher {
-				slog.Info("client error", "reason", "client has already identified as dispatcher/camera", "client", client)
+				slog.InfoContext(ctx, "client error", "reason", "client has already identified as dispatcher/camera", "client", client)
 				WriteError(connection, "client has already identified as a dispatcher/camera")
 				return
 			}
+			if !speedServer.Dispatchers.Permits(connection.RemoteAddr()) {
+				identificationsDenied.With("dispatcher").Inc()
+				slog.InfoContext(ctx, "client error", "reason", "address not allowed to identify as dispatcher", "client", client)
+				WriteError(connection, "not allowed to identify as a dispatcher")
+				return
+			}
 			speedServer.dispatchers.AddConn(connState, v.roads)
 			isDispatcher = true
-			slog.Info("client identification", "type", "dispatcher", "client", client)
+			// Dispatchers never send anything after identifying themselves, they wait for tickets
+			timeout.SetIdleTimeout(connection, 0)
+			session.Annotate(ctx, "role", "dispatcher")
+			session.Annotate(ctx, "roads", v.roads)
+			slog.InfoContext(ctx, "client identification", "type", "dispatcher", "client", client)
 			// Check if there are any pending tickets that need to be sent
 			for _, road := range v.roads {
 				pending := speedServer.store.GetPending(road)
-				slog.Info("dispatching pending tickets", "road", road, "count", len(pending), "client", client)
+				slog.InfoContext(ctx, "dispatching pending tickets", "road", road, "count", len(pending), "client", client)
 				for _, p := range pending {
-					connState.outgoing <- p
+					select {
+					case connState.outgoing <- p:
+					case <-connState.done:
+						return
+					}
 				}
 			}
 		case PlateMessage:
 			if !isCamera {
-				slog.Info("client error", "reason", "only camera can send plate message", "client", client)
+				slog.InfoContext(ctx, "client error", "reason", "only camera can send plate message", "client", client)
 				WriteError(connection, "only camera can send plate message")
 				return
 			}
@@ -102,12 +151,12 @@ func Handle(speedServer *SpeedServer, connection net.Conn) {
 				mile:      cameraDetails.mile,
 			})
 			if ticket != nil {
-				slog.Info("ticket generated", "ticket", ticket, "client", client)
+				slog.InfoContext(ctx, "ticket generated", "ticket", ticket, "client", client)
 				dipatcherAddr := speedServer.dispatchers.DispatchTicket(*ticket)
 				if dipatcherAddr != "" {
-					slog.Info("ticket sent to dispatcher", "ticket", ticket, "client", client, "dispatcher_client", dipatcherAddr)
+					slog.InfoContext(ctx, "ticket sent to dispatcher", "ticket", ticket, "client", client, "dispatcher_client", dipatcherAddr)
 				} else {
-					slog.Info("ticket marked pending", "ticket", ticket, "client", client)
+					slog.InfoContext(ctx, "ticket marked pending", "ticket", ticket, "client", client)
 					speedServer.store.AddPending(*ticket)
 				}
 			}
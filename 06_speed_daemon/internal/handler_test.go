@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/acl"
+	"github.com/ananthvk/protohackers-go/internal/clock"
+	"github.com/ananthvk/protohackers-go/internal/vnet"
+)
+
+func TestRoleAccess(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	speedServer := NewSpeedServer()
+	speedServer.Cameras = acl.List{Allow: acl.Prefixes{netip.MustParsePrefix("10.0.0.0/8")}}
+	speedServer.Dispatchers = acl.List{Allow: acl.Prefixes{netip.MustParsePrefix("127.0.0.0/8")}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go Handle(context.Background(), speedServer, conn)
+		}
+	}()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(time.Second * 2))
+		return conn
+	}
+
+	// Cameras may only connect from 10.0.0.0/8
+	camera := dial()
+	defer camera.Close()
+	camera.Write([]byte{0x80, 0x00, 0x42, 0x00, 0x64, 0x00, 0x3c})
+	// Server messages can't be parsed by ReadMessage, the error (0x10) is followed by the length of its text
+	response, err := io.ReadAll(camera)
+	if err != nil || len(response) < 2 || response[0] != 0x10 || int(response[1]) != len(response)-2 {
+		t.Errorf("got %x, %v, want an error followed by the end of the connection", response, err)
+	}
+
+	// The dispatcher is allowed, and keeps receiving heartbeats
+	dispatcher := dial()
+	defer dispatcher.Close()
+	dispatcher.Write([]byte{0x81, 0x01, 0x00, 0x42, 0x40, 0x00, 0x00, 0x00, 0x01})
+	heartbeat := make([]byte, 1)
+	if _, err := io.ReadFull(dispatcher, heartbeat); err != nil || heartbeat[0] != 0x41 {
+		t.Errorf("got %x, %v, want a heartbeat", heartbeat, err)
+	}
+}
+
+func TestHeartbeatVirtualClock(t *testing.T) {
+	virtual := clock.NewVirtual()
+	network := vnet.New(virtual)
+	listener, err := network.Listen("10.0.0.1:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	speedServer := NewSpeedServer()
+	speedServer.Clock = virtual
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go vnet.Serve(ctx, listener, func(ctx context.Context, conn net.Conn) {
+		Handle(ctx, speedServer, conn)
+	})
+
+	client, err := network.Dial("10.0.0.1:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// WantHeartbeat every 2.5 seconds
+	client.Write([]byte{0x40, 0x00, 0x00, 0x00, 0x19})
+	virtual.WaitTimers(1)
+
+	heartbeat := make([]byte, 1)
+	for i := 1; i <= 3; i++ {
+		virtual.Advance(time.Millisecond * 2500)
+		if _, err := io.ReadFull(client, heartbeat); err != nil || heartbeat[0] != 0x41 {
+			t.Fatalf("heartbeat %d: got %x, %v", i, heartbeat, err)
+		}
+	}
+	if got, want := virtual.Now(), clock.Epoch.Add(time.Millisecond*7500); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
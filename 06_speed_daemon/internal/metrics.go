@@ -0,0 +1,12 @@
+package internal
+
+import "github.com/ananthvk/protohackers-go/internal/metrics"
+
+var (
+	ticketsIssued     = metrics.NewCounter("speed_daemon_tickets_issued_total", "Number of tickets generated")
+	ticketsPending    = metrics.NewGauge("speed_daemon_tickets_pending", "Number of tickets waiting for a dispatcher")
+	ticketsDispatched = metrics.NewCounter("speed_daemon_tickets_dispatched_total", "Number of tickets written to a dispatcher")
+	rateLimited       = metrics.NewCounter("speed_daemon_rate_limited_total", "Number of clients disconnected for going over the message rate limit")
+	// identificationsDenied is labelled by the role the client tried to take, "camera" or "dispatcher"
+	identificationsDenied = metrics.NewCounterVec("speed_daemon_identifications_denied_total", "Number of clients that identified as a role they are not allowed to take", "role")
+)
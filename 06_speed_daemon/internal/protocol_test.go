@@ -2,8 +2,12 @@ package internal
 
 import (
 	"bytes"
+	"net"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/chaos"
 )
 
 func TestReadPlate(t *testing.T) {
@@ -280,3 +284,33 @@ func TestWriteHeartbeat(t *testing.T) {
 		t.Errorf("want %x, got %x", want, got)
 	}
 }
+
+func TestReadMessageFragmented(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := chaos.NewConn(server, chaos.Config{Seed: 6, Fragment: 0.7, Coalesce: 0.3, CoalesceDelay: time.Millisecond}, 1)
+	defer conn.Close()
+	// Several messages written at once, which the reader sees a byte at a time or coalesced
+	go client.Write([]byte{
+		0x80, 0x00, 0x42, 0x00, 0x64, 0x00, 0x3c, // IAmCamera{road: 66, mile: 100, limit: 60}
+		0x20, 0x04, 0x55, 0x4e, 0x31, 0x58, 0x00, 0x00, 0x03, 0xe8, // Plate{plate: "UN1X", timestamp: 1000}
+		0x81, 0x02, 0x00, 0x42, 0x01, 0x70, // IAmDispatcher{roads: [66, 368]}
+		0x40, 0x00, 0x00, 0x00, 0x0a, // WantHeartbeat{interval: 10}
+	})
+	want := []Message{
+		IAmCameraMessage{road: 66, mile: 100, limit: 60},
+		PlateMessage{plate: "UN1X", timestamp: 1000},
+		IAmDispatcherMessage{roads: []uint16{66, 368}},
+		WantHeartbeatMessage{interval: 10},
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	for _, w := range want {
+		got, err := ReadMessage(conn)
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("want %+v, got %+v", w, got)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/ananthvk/protohackers-go/internal/record/recordtest"
+)
+
+// TestReplay replays the sessions recorded in testdata/replay, see the replay command
+func TestReplay(t *testing.T) {
+	recordtest.TestDir(t, func() func(context.Context, net.Conn) {
+		speedServer := NewSpeedServer()
+		return func(ctx context.Context, conn net.Conn) { Handle(ctx, speedServer, conn) }
+	}, "testdata/replay")
+}
+
+// TestReplayTicket replays two cameras and then a dispatcher against the same server, so that the dispatcher receives
+// the ticket that was pending
+func TestReplayTicket(t *testing.T) {
+	paths, err := filepath.Glob("testdata/replay/ticket/*.jsonl")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no recordings found: %v", err)
+	}
+	speedServer := NewSpeedServer()
+	handler := func(ctx context.Context, conn net.Conn) { Handle(ctx, speedServer, conn) }
+	for _, path := range paths {
+		recordtest.TestFile(t, handler, path)
+	}
+}
@@ -4,17 +4,35 @@ import (
 	"net"
 	"sync"
 	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/acl"
+	"github.com/ananthvk/protohackers-go/internal/clock"
+	"github.com/ananthvk/protohackers-go/internal/limit"
 )
 
 type ConnState struct {
 	conn             net.Conn
 	outgoing         chan Ticket        // nil until dispatcher identifies itself
 	heartbeat        time.Duration      // 0 until WantHeartbeat message
-	kill             chan struct{}      // When a message is sent on this channel, the writer loop + connection is closed
+	kill             chan struct{}      // When this channel is closed, the writer loop is stopped
+	done             chan struct{}      // Closed by the writer loop when it exits
 	heartbeatControl chan time.Duration // Send a time.Duration on this channel to enable heartbeats
+	clock            clock.Clock        // Runs the heartbeat ticker
 }
 
 type SpeedServer struct {
+	// QueueSize is the number of tickets queued for each dispatcher. It must be set before the server is used.
+	QueueSize int
+	// Cameras and Dispatchers restrict the addresses that may identify as each role. They must be set before the server
+	// is used.
+	Cameras     acl.List
+	Dispatchers acl.List
+	// Clock runs the heartbeats and the message rate limit. nil uses the system clock
+	Clock clock.Clock
+	// MessageRate limits the messages of each client. Clients that go over it get an error, and are disconnected. It
+	// must be set before the server is used.
+	MessageRate limit.Rate
+
 	store       *Store
 	dispatchers *DispatcherHub
 }
@@ -75,9 +93,13 @@ func (d *DispatcherHub) DispatchTicket(message Ticket) string {
 	return ""
 }
 
+// DefaultQueueSize is the default value of SpeedServer.QueueSize
+const DefaultQueueSize = 100
+
 func NewSpeedServer() *SpeedServer {
 	return &SpeedServer{
-		store: NewStore(),
+		QueueSize: DefaultQueueSize,
+		store:     NewStore(),
 		dispatchers: &DispatcherHub{
 			roadDispatchers: map[uint16]map[*ConnState]struct{}{},
 			dispatcherRoads: map[*ConnState]map[uint16]struct{}{},
@@ -74,6 +74,9 @@ func (s *Store) AddObservation(observation PlateObservation) *Ticket {
 	// Add the observation to the map
 	plateMap[observation.road] = append(plateMap[observation.road], observation)
 
+	if ticket != nil {
+		ticketsIssued.Inc()
+	}
 	return ticket
 }
 
@@ -168,6 +171,7 @@ func (s *Store) AddPending(ticket Ticket) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.pending[ticket.road] = append(s.pending[ticket.road], ticket)
+	ticketsPending.Inc()
 }
 
 // GetPending returns the pending tickets that have yet to be sent for a particular road. After the pending tickets are
@@ -177,6 +181,7 @@ func (s *Store) GetPending(road uint16) []Ticket {
 	defer s.mu.Unlock()
 	pending := s.pending[Road(road)]
 	s.pending[Road(road)] = nil
+	ticketsPending.Add(-int64(len(pending)))
 	return pending
 }
 
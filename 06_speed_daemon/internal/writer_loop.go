@@ -1,36 +1,45 @@
 package internal
 
 import (
+	"context"
 	"log/slog"
 	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/clock"
 )
 
-func StartWriteLoop(connState *ConnState) {
-	slog.Info("started writer loop", "client", connState.conn.RemoteAddr().String())
+// StartWriteLoop writes the tickets and heartbeats of a client, until connState.kill is closed or a write fails. It
+// closes connState.done when it exits
+func StartWriteLoop(ctx context.Context, connState *ConnState) {
+	defer close(connState.done)
+	slog.InfoContext(ctx, "started writer loop", "client", connState.conn.RemoteAddr().String())
 	client := connState.conn.RemoteAddr().String()
-	var heartbeatTicker *time.Ticker
+	var heartbeatTicker clock.Ticker
 	if connState.heartbeat != 0 {
 		// Set the ticker
-		slog.Info("setting initial heartbeat interval", "interval", connState.heartbeat, "client", client)
-		heartbeatTicker = time.NewTicker(connState.heartbeat)
+		slog.InfoContext(ctx, "setting initial heartbeat interval", "interval", connState.heartbeat, "client", client)
+		heartbeatTicker = connState.clock.NewTicker(connState.heartbeat)
 	}
 	for {
 		var hbC <-chan time.Time
 		if heartbeatTicker != nil {
-			hbC = heartbeatTicker.C
+			hbC = heartbeatTicker.C()
 		}
 		select {
 		case hbDuration := <-connState.heartbeatControl:
-			slog.Info("received heartbeat control message", "new_interval", hbDuration, "client", client)
+			slog.InfoContext(ctx, "received heartbeat control message", "new_interval", hbDuration, "client", client)
 			if heartbeatTicker != nil {
 				heartbeatTicker.Stop()
 				heartbeatTicker = nil
 			}
 			if hbDuration != 0 {
-				heartbeatTicker = time.NewTicker(hbDuration)
+				heartbeatTicker = connState.clock.NewTicker(hbDuration)
+			}
+		case ticket, ok := <-connState.outgoing:
+			if !ok {
+				return
 			}
-		case ticket := <-connState.outgoing:
-			slog.Info("dispatching ticket", "ticket", ticket, "client", client)
+			slog.InfoContext(ctx, "dispatching ticket", "ticket", ticket, "client", client)
 			err := WriteTicket(connState.conn, TicketMessage{
 				plate:      string(ticket.plate),
 				road:       uint16(ticket.road),
@@ -41,16 +50,17 @@ func StartWriteLoop(connState *ConnState) {
 				speed:      ticket.speed,
 			})
 			if err != nil {
-				slog.Error("dispatch ticket failed", "error", err, "client", client)
+				slog.ErrorContext(ctx, "dispatch ticket failed", "error", err, "client", client)
 				return
 			}
+			ticketsDispatched.Inc()
 		case <-connState.kill:
-			slog.Info("killed writer loop", "client", client)
+			slog.InfoContext(ctx, "killed writer loop", "client", client)
 			return
 		case <-hbC:
 			err := WriteHeartbeat(connState.conn)
 			if err != nil {
-				slog.Error("heartbeat send failed", "error", err, "client", client)
+				slog.ErrorContext(ctx, "heartbeat send failed", "error", err, "client", client)
 				return
 			}
 		}
@@ -0,0 +1,93 @@
+// Package service exposes the speed daemon server as a runner.Service, so that it can be started by both the
+// standalone server and the combined protohackers binary.
+package service
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/06_speed_daemon/internal"
+	"github.com/ananthvk/protohackers-go/internal/acl"
+	"github.com/ananthvk/protohackers-go/internal/limit"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+	"github.com/ananthvk/protohackers-go/internal/sniff"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+)
+
+// Name is the name of the service, used in logs
+const Name = "speed"
+
+// Config holds the settings of the service
+type Config struct {
+	Address string
+	// TLSAddress serves TLS on a separate address, see runner.Service.TLSAddress
+	TLSAddress string
+	// QueueSize is the number of tickets queued for each dispatcher. Zero uses the default
+	QueueSize int
+	// Cameras and Dispatchers restrict the addresses that may identify as each role, in addition to the access lists of
+	// the service
+	Cameras     acl.List
+	Dispatchers acl.List
+	// MessageRate limits the messages of each client. Clients that go over it get an error, and are disconnected
+	MessageRate limit.Rate
+}
+
+// DefaultConfig returns a config with the default value of every tunable
+func DefaultConfig() Config {
+	return Config{QueueSize: internal.DefaultQueueSize}
+}
+
+// RegisterFlags binds the tunables of the service to command line flags, whose names start with prefix
+func (c *Config) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	fs.IntVar(&c.QueueSize, prefix+"queue-size", c.QueueSize, "number of tickets queued for each dispatcher")
+	c.Cameras.RegisterFlags(fs, prefix+"camera-", "cameras")
+	c.Dispatchers.RegisterFlags(fs, prefix+"dispatcher-", "dispatchers")
+	c.MessageRate.RegisterFlags(fs, prefix+"message-", "messages")
+}
+
+// Validate checks the tunables of the service
+func (c *Config) Validate() error {
+	if c.QueueSize < 0 {
+		return errors.New("speed: queue size must not be negative")
+	}
+	if err := c.MessageRate.Validate(); err != nil {
+		return fmt.Errorf("speed: %w", err)
+	}
+	return nil
+}
+
+// New creates the service, along with the observation store and dispatchers shared by all its connections
+func New(cfg Config) *runner.Service {
+	speedServer := internal.NewSpeedServer()
+	if cfg.QueueSize > 0 {
+		speedServer.QueueSize = cfg.QueueSize
+	}
+	speedServer.Cameras, speedServer.Dispatchers = cfg.Cameras, cfg.Dispatchers
+	speedServer.MessageRate = cfg.MessageRate
+	return &runner.Service{
+		Name:       Name,
+		Network:    "tcp",
+		Address:    cfg.Address,
+		TLSAddress: cfg.TLSAddress,
+		// The idle timeout only applies until the client identifies itself or asks for heartbeats, see Handle
+		Timeouts: timeout.Policy{Idle: time.Minute, Read: 10 * time.Second, Write: 10 * time.Second},
+		Handler: func(ctx context.Context, conn net.Conn) {
+			internal.Handle(ctx, speedServer, conn)
+		},
+	}
+}
+
+// Detect recognizes speed daemon clients for protocol sniffing, from the type of the first message. Clients start by
+// identifying themselves or asking for heartbeats, but a camera may also send a plate before identifying itself (which
+// is answered with an error)
+func Detect(prefix []byte) sniff.Verdict {
+	switch prefix[0] {
+	case 0x20, 0x40, 0x80, 0x81:
+		return sniff.Match
+	}
+	return sniff.NoMatch
+}
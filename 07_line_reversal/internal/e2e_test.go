@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ananthvk/protohackers-go/07_line_reversal/internal/lrcp"
+	"github.com/ananthvk/protohackers-go/internal/script"
+	"github.com/ananthvk/protohackers-go/internal/vnet"
+)
+
+// messages are the LRCP messages, as named in the scripts of testdata/e2e
+var messages = script.Messages{
+	"connect": func(f *script.Fields) []byte {
+		return fmt.Appendf(nil, "/connect/%d/", f.Uint("session", 31))
+	},
+	"data": func(f *script.Fields) []byte {
+		escaped := strings.NewReplacer(`\`, `\\`, `/`, `\/`).Replace(f.String("data"))
+		return fmt.Appendf(nil, "/data/%d/%d/%s/", f.Uint("session", 31), f.Uint("pos", 31), escaped)
+	},
+	"ack": func(f *script.Fields) []byte {
+		return fmt.Appendf(nil, "/ack/%d/%d/", f.Uint("session", 31), f.Uint("length", 31))
+	},
+	"close": func(f *script.Fields) []byte {
+		return fmt.Appendf(nil, "/close/%d/", f.Uint("session", 31))
+	},
+}
+
+// TestEndToEnd runs the conversations of testdata/e2e, see the script package. The timers of LRCP run on the clock of
+// the script
+func TestEndToEnd(t *testing.T) {
+	script.RunFiles(t, "testdata/e2e/*.script", func(h *script.Harness) {
+		h.Start(script.Server{
+			Packet: func(ctx context.Context, conn net.PacketConn) {
+				listener := lrcp.NewListener(conn, slog.New(slog.DiscardHandler), lrcp.Options{Clock: h.Clock})
+				vnet.Serve(ctx, listener, Handle)
+			},
+			Messages: messages,
+		})
+	})
+}
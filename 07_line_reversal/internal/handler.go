@@ -2,9 +2,12 @@ package internal
 
 import (
 	"bufio"
+	"context"
 	"log/slog"
 	"net"
 	"strings"
+
+	"github.com/ananthvk/protohackers-go/internal/timeout"
 )
 
 func reverseString(s string) string {
@@ -17,10 +20,10 @@ func reverseString(s string) string {
 
 // Handle handles a single client connection. This should be run in a separate gorutine so that requests can be handled
 // concurrently.
-func Handle(connection net.Conn) {
-	slog.Info("client connected", "remote_address", connection.RemoteAddr().String())
+func Handle(ctx context.Context, connection net.Conn) {
+	slog.InfoContext(ctx, "client connected", "remote_address", connection.RemoteAddr().String())
 	defer func() {
-		slog.Info("client disconnected", "address", connection.RemoteAddr().String())
+		slog.InfoContext(ctx, "client disconnected", "address", connection.RemoteAddr().String())
 	}()
 	defer connection.Close()
 
@@ -30,23 +33,24 @@ func Handle(connection net.Conn) {
 	for {
 		line, err := r.ReadString('\n')
 		if err != nil {
-			slog.Error("read error", "error", err)
+			slog.ErrorContext(ctx, "read error", "error", err)
 			return
 		}
-		slog.Info("got line", "line", line)
+		slog.InfoContext(ctx, "got line", "line", line)
+		timeout.EndMessage(connection)
 
 		line = strings.TrimSuffix(line, "\n")
 
 		line = reverseString(line) + "\n"
-		slog.Info("reversed", "line", line)
+		slog.InfoContext(ctx, "reversed", "line", line)
 		_, err = w.WriteString(line)
 		if err != nil {
-			slog.Error("write error", "error", err)
+			slog.ErrorContext(ctx, "write error", "error", err)
 			return
 		}
 		err = w.Flush()
 		if err != nil {
-			slog.Error("flush error", "error", err)
+			slog.ErrorContext(ctx, "flush error", "error", err)
 			return
 		}
 	}
@@ -1,10 +1,14 @@
 package lrcp
 
 import (
+	"context"
 	"log/slog"
 	"net"
+	"os"
 	"sync"
 	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/logging"
 )
 
 const maxDataSize = 900 // Max size of data in a segment (in bytes)
@@ -19,10 +23,11 @@ type LRCPConn struct {
 	// connection.
 	totalBytesReceived int64
 
-	mu          sync.Mutex
-	recvBuffer  []byte
-	waitingRead chan struct{}
-	closed      bool
+	mu           sync.Mutex
+	recvBuffer   []byte
+	waitingRead  chan struct{} // Buffered with capacity 1, so that a wake up is not lost if Read() is not yet waiting
+	closed       bool
+	readDeadline time.Time
 
 	sendMu          sync.Mutex
 	sendBuffer      []byte
@@ -31,7 +36,14 @@ type LRCPConn struct {
 
 	unacked []segment
 
-	outbound chan outboundMessage // The same channel the listener uses
+	outbound chan<- outboundMessage // The same channel the listener uses
+	done     <-chan struct{}        // Closed when the listener is closed
+
+	id     uint64 // Connection ID, shared with the handler through ConnID
+	logger *slog.Logger
+
+	release func() // Removes the session from the listener, called once when the connection is closed
+	opts    Options
 }
 
 type segment struct {
@@ -40,6 +52,37 @@ type segment struct {
 	payload []byte
 }
 
+// Samplers of the records logged for every packet, see logging.Sampler
+var (
+	packetLogs     logging.Sampler
+	segmentLogs    logging.Sampler
+	ackLogs        logging.Sampler
+	dropLogs       logging.Sampler
+	retransmitLogs logging.Sampler
+)
+
+// debugSampled returns true if a debug record of a packet should be logged. The level is checked first, so that the
+// sampler only counts records that could be logged
+func (lConn *LRCPConn) debugSampled(sampler *logging.Sampler) bool {
+	return lConn.logger.Enabled(context.Background(), slog.LevelDebug) && sampler.Allow()
+}
+
+// ConnID returns the ID that tags the log lines of the connection
+func (lConn *LRCPConn) ConnID() uint64 {
+	return lConn.id
+}
+
+// Describe reports the session ID and the number of segments waiting for an ack, see session.Describer
+func (lConn *LRCPConn) Describe() map[string]any {
+	lConn.sendMu.Lock()
+	defer lConn.sendMu.Unlock()
+	return map[string]any{
+		"session":  lConn.sessionId,
+		"unacked":  len(lConn.unacked),
+		"sent_pos": lConn.nextPos,
+	}
+}
+
 func (lConn *LRCPConn) Read(b []byte) (int, error) {
 	for {
 		lConn.mu.Lock()
@@ -55,9 +98,23 @@ func (lConn *LRCPConn) Read(b []byte) (int, error) {
 			lConn.mu.Unlock()
 			return n, nil
 		}
+		deadline := lConn.readDeadline
+		if !deadline.IsZero() && !lConn.opts.Clock.Now().Before(deadline) {
+			lConn.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
 		ch := lConn.waitingRead
 		lConn.mu.Unlock()
-		<-ch
+		if deadline.IsZero() {
+			<-ch
+			continue
+		}
+		timer := lConn.opts.Clock.NewTimer(deadline.Sub(lConn.opts.Clock.Now()))
+		select {
+		case <-ch:
+		case <-timer.C():
+		}
+		timer.Stop()
 	}
 }
 
@@ -68,13 +125,26 @@ func (lConn *LRCPConn) Write(b []byte) (n int, err error) {
 func (lConn *LRCPConn) Close() error {
 	lConn.mu.Lock()
 	defer lConn.mu.Unlock()
+	if !lConn.closed && lConn.release != nil {
+		lConn.release()
+	}
 	lConn.closed = true
-	// Wake up any Read() that is blocked
+	lConn.wakeReader()
+	return nil
+}
+
+func (lConn *LRCPConn) isClosed() bool {
+	lConn.mu.Lock()
+	defer lConn.mu.Unlock()
+	return lConn.closed
+}
+
+// wakeReader wakes up any Read() that is blocked. The caller must hold mu
+func (lConn *LRCPConn) wakeReader() {
 	select {
 	case lConn.waitingRead <- struct{}{}:
 	default:
 	}
-	return nil
 }
 
 func (lConn *LRCPConn) LocalAddr() net.Addr {
@@ -83,62 +153,78 @@ func (lConn *LRCPConn) LocalAddr() net.Addr {
 func (lConn *LRCPConn) RemoteAddr() net.Addr {
 	return lConn.remoteAddr
 }
+
+// SetDeadline only sets the read deadline, since writes never block
 func (lConn *LRCPConn) SetDeadline(t time.Time) error {
-	return nil
+	return lConn.SetReadDeadline(t)
 }
 func (lConn *LRCPConn) SetReadDeadline(t time.Time) error {
+	lConn.mu.Lock()
+	defer lConn.mu.Unlock()
+	lConn.readDeadline = t
+	// Wake up a blocked Read() so that it picks up the new deadline
+	lConn.wakeReader()
 	return nil
 }
 func (lConn *LRCPConn) SetWriteDeadline(t time.Time) error {
 	return nil
 }
 
+// send queues a message on the listener's outbound channel. The message is dropped if the listener has been closed
+func (lConn *LRCPConn) send(msg outboundMessage) {
+	select {
+	case lConn.outbound <- msg:
+	case <-lConn.done:
+	}
+}
+
 func (lConn *LRCPConn) appendBytes(b []byte) {
 	lConn.mu.Lock()
 	defer lConn.mu.Unlock()
 	lConn.recvBuffer = append(lConn.recvBuffer, b...)
 	// Non blocking, wake up any Read() that is blocked
-	select {
-	case lConn.waitingRead <- struct{}{}:
-	default:
-	}
+	lConn.wakeReader()
 }
 
 func (lConn *LRCPConn) handleConnect() {
-	slog.Info("got connect request", "from", lConn.remoteAddr)
-	lConn.outbound <- outboundMessage{
-		addr: lConn.remoteAddr,
-		buffer: SerializeMessage(message{
-			kind:      Ack,
-			sessionId: lConn.sessionId,
-			length:    0,
-		}),
-	}
+	lConn.logger.Info("got connect request", "from", lConn.remoteAddr)
+	lConn.sendAckFor(0)
 
 	// Start a goroutine that handles unacked segments
 	go func() {
-		slog.Info("starting unack goroutine")
-		ticker := time.NewTicker(retransmissionTimeout)
+		lConn.logger.Info("starting unack goroutine")
+		ticker := lConn.opts.Clock.NewTicker(lConn.opts.RetransmissionTimeout)
 		defer ticker.Stop()
 
-		for range ticker.C {
+		for {
+			select {
+			case <-lConn.done:
+				return
+			case <-ticker.C():
+			}
+			if lConn.isClosed() {
+				return
+			}
 			lConn.sendMu.Lock()
 
 			// Check if connection should be closed due to timeout
 			// Also check if unacked has some elements
-			if len(lConn.unacked) > 0 && time.Now().After(lConn.lastSendAckTime.Add(sessionExpiryTimeout)) {
-				slog.Info("client timeout", "addr", lConn.remoteAddr)
+			if len(lConn.unacked) > 0 && lConn.opts.Clock.Now().After(lConn.lastSendAckTime.Add(lConn.opts.SessionExpiryTimeout)) {
+				lConn.logger.Info("client timeout", "addr", lConn.remoteAddr)
 				lConn.sendMu.Unlock()
 				lConn.Close()
 				return
 			}
 
 			for _, seg := range lConn.unacked {
-				slog.Info("retransmit data", "addr", lConn.remoteAddr, "pos", seg.pos, "buffer", seg.payload)
-				lConn.outbound <- outboundMessage{
+				if lConn.debugSampled(&retransmitLogs) {
+					lConn.logger.Debug("retransmit data", "addr", lConn.remoteAddr, "pos", seg.pos, "length", len(seg.data))
+				}
+				retransmissions.Inc()
+				lConn.send(outboundMessage{
 					addr:   lConn.remoteAddr,
 					buffer: seg.payload,
-				}
+				})
 			}
 			lConn.sendMu.Unlock()
 		}
@@ -151,14 +237,7 @@ func (lConn *LRCPConn) handleData(pos int64, data []byte) {
 	if pos <= lConn.totalBytesReceived {
 		// The packet does not contain any new data
 		if (pos + int64(len(data))) <= lConn.totalBytesReceived {
-			lConn.outbound <- outboundMessage{
-				addr: lConn.remoteAddr,
-				buffer: SerializeMessage(message{
-					kind:      Ack,
-					sessionId: lConn.sessionId,
-					length:    lConn.totalBytesReceived,
-				}),
-			}
+			lConn.sendAckFor(lConn.totalBytesReceived)
 			return
 		}
 
@@ -170,25 +249,11 @@ func (lConn *LRCPConn) handleData(pos int64, data []byte) {
 		// Send the new bytes received to Read()
 		lConn.appendBytes(newBytes)
 		lConn.totalBytesReceived = newEnd
-		lConn.outbound <- outboundMessage{
-			addr: lConn.remoteAddr,
-			buffer: SerializeMessage(message{
-				kind:      Ack,
-				sessionId: lConn.sessionId,
-				length:    newEnd,
-			}),
-		}
+		lConn.sendAckFor(newEnd)
 		return
 	}
 	// 2) We have not yet received all data after totalBytesReceived
-	lConn.outbound <- outboundMessage{
-		addr: lConn.remoteAddr,
-		buffer: SerializeMessage(message{
-			kind:      Ack,
-			sessionId: lConn.sessionId,
-			length:    lConn.totalBytesReceived,
-		}),
-	}
+	lConn.sendAckFor(lConn.totalBytesReceived)
 }
 func safeSlice(buf []byte, n int) []byte {
 	if n > len(buf) {
@@ -206,7 +271,9 @@ func (lConn *LRCPConn) handleWrite(b []byte) (n int, err error) {
 	// Create segments
 	for len(lConn.sendBuffer) > 0 {
 		chunk := safeSlice(lConn.sendBuffer, maxDataSize)
-		slog.Info("create segment", "chunk", chunk, "pos", lConn.nextPos)
+		if lConn.debugSampled(&segmentLogs) {
+			lConn.logger.Debug("create segment", "chunk", chunk, "pos", lConn.nextPos)
+		}
 		pos := lConn.nextPos
 		lConn.nextPos += int64(len(chunk))
 		chunkCpy := make([]byte, len(chunk))
@@ -225,10 +292,10 @@ func (lConn *LRCPConn) handleWrite(b []byte) (n int, err error) {
 		// Queue for checking retransmission
 		lConn.unacked = append(lConn.unacked, seg)
 
-		lConn.outbound <- outboundMessage{
+		lConn.send(outboundMessage{
 			addr:   lConn.remoteAddr,
 			buffer: seg.payload,
-		}
+		})
 
 		// Drop this chunk from send buffer
 		lConn.sendBuffer = lConn.sendBuffer[len(chunk):]
@@ -239,18 +306,21 @@ func (lConn *LRCPConn) handleWrite(b []byte) (n int, err error) {
 func (lConn *LRCPConn) handleAck(length int64) {
 	lConn.sendMu.Lock()
 	defer lConn.sendMu.Unlock()
-	slog.Info("received ack", "length", length)
-	lConn.lastSendAckTime = time.Now()
+	if lConn.debugSampled(&ackLogs) {
+		lConn.logger.Debug("received ack", "length", length)
+	}
+	acksReceived.Inc()
+	lConn.lastSendAckTime = lConn.opts.Clock.Now()
 
 	if length > lConn.nextPos {
 		// Misbehaving client
-		lConn.outbound <- outboundMessage{
+		lConn.send(outboundMessage{
 			addr: lConn.remoteAddr,
 			buffer: SerializeMessage(message{
 				kind:      Close,
 				sessionId: lConn.sessionId,
 			}),
-		}
+		})
 		lConn.Close()
 	}
 
@@ -261,7 +331,9 @@ func (lConn *LRCPConn) handleAck(length int64) {
 		if end <= length {
 			// This segment has been acknowledged, drop it
 			lConn.unacked = lConn.unacked[1:]
-			slog.Info("dropping segment", "end", end, "length", length)
+			if lConn.debugSampled(&dropLogs) {
+				lConn.logger.Debug("dropping segment", "end", end, "length", length)
+			}
 		} else {
 			break
 		}
@@ -271,12 +343,18 @@ func (lConn *LRCPConn) handleAck(length int64) {
 func (lConn *LRCPConn) sendAck() {
 	lConn.sendMu.Lock()
 	defer lConn.sendMu.Unlock()
-	lConn.outbound <- outboundMessage{
+	lConn.sendAckFor(lConn.totalBytesReceived)
+}
+
+// sendAckFor acknowledges that all data upto length has been received
+func (lConn *LRCPConn) sendAckFor(length int64) {
+	acksSent.Inc()
+	lConn.send(outboundMessage{
 		addr: lConn.remoteAddr,
 		buffer: SerializeMessage(message{
 			kind:      Ack,
-			pos:       lConn.totalBytesReceived,
 			sessionId: lConn.sessionId,
+			length:    length,
 		}),
-	}
+	})
 }
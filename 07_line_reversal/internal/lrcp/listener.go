@@ -7,15 +7,41 @@ import (
 	"net"
 	"sync"
 	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/clock"
+	"github.com/ananthvk/protohackers-go/internal/logging"
 )
 
 const (
-	maxPacketSize         = 1000
-	retransmissionTimeout = time.Second * 3
-	sessionExpiryTimeout  = time.Second * 60
-	outboundQueueSize     = 32
+	maxPacketSize     = 1000
+	outboundQueueSize = 32
+
+	DefaultRetransmissionTimeout = time.Second * 3
+	DefaultSessionExpiryTimeout  = time.Second * 60
 )
 
+// Options holds the timers of the protocol. Zero values are replaced by the defaults
+type Options struct {
+	// RetransmissionTimeout is how often unacknowledged data is sent again
+	RetransmissionTimeout time.Duration
+	// SessionExpiryTimeout is how long a session is kept once the peer stops acknowledging data
+	SessionExpiryTimeout time.Duration
+	// Clock runs the timers. nil uses the system clock
+	Clock clock.Clock
+}
+
+// withDefaults returns a copy of the options, with zero values replaced by the defaults
+func (o Options) withDefaults() Options {
+	if o.RetransmissionTimeout == 0 {
+		o.RetransmissionTimeout = DefaultRetransmissionTimeout
+	}
+	if o.SessionExpiryTimeout == 0 {
+		o.SessionExpiryTimeout = DefaultSessionExpiryTimeout
+	}
+	o.Clock = clock.Or(o.Clock)
+	return o
+}
+
 type outboundMessage struct {
 	buffer []byte
 	addr   net.Addr
@@ -28,23 +54,33 @@ type LRCPListener struct {
 	sessionMu     sync.Mutex
 	newConnection chan *LRCPConn
 	outbound      chan outboundMessage
+	done          chan struct{} // Closed when the listener is closed
+	closeOnce     sync.Once
+	logger        *slog.Logger
+	opts          Options
 }
 
 type ListenConfig struct {
+	Options Options
 }
 
 func (listener *LRCPListener) Accept() (net.Conn, error) {
-	conn, ok := <-listener.newConnection
-	if !ok {
+	select {
+	case conn := <-listener.newConnection:
+		return conn, nil
+	case <-listener.done:
 		return nil, net.ErrClosed
 	}
-	return conn, nil
 }
 
+// Close closes the underlying packet connection. Channels shared with the sessions are never closed, instead the done
+// channel is closed so that blocked senders give up, which makes it safe to call Close while sessions are active
 func (listener *LRCPListener) Close() error {
-	err := listener.conn.Close()
-	close(listener.newConnection)
-	close(listener.outbound)
+	err := net.ErrClosed
+	listener.closeOnce.Do(func() {
+		close(listener.done)
+		err = listener.conn.Close()
+	})
 	return err
 }
 
@@ -61,12 +97,21 @@ func (lc *ListenConfig) Listen(ctx context.Context, network, address string) (ne
 	if err != nil {
 		return nil, err
 	}
+	return NewListener(udpConn, slog.Default(), lc.Options), nil
+}
+
+// NewListener creates a LRCP listener that runs on top of an existing packet connection. The listener takes ownership of
+// the connection, and closes it when the listener is closed. The logger is used by the listener and all its sessions.
+func NewListener(udpConn net.PacketConn, logger *slog.Logger, opts Options) *LRCPListener {
 	listener := &LRCPListener{
-		addr:          &LRCPAddress{address: address},
+		addr:          &LRCPAddress{address: udpConn.LocalAddr().String()},
 		conn:          udpConn,
 		sessions:      map[int64]*LRCPConn{},
 		newConnection: make(chan *LRCPConn),
 		outbound:      make(chan outboundMessage, outboundQueueSize),
+		done:          make(chan struct{}),
+		logger:        logger,
+		opts:          opts.withDefaults(),
 	}
 
 	// Start the reader loop
@@ -78,7 +123,9 @@ func (lc *ListenConfig) Listen(ctx context.Context, network, address string) (ne
 				listener.handleMessage(fromAddr, buffer[:n])
 			}
 			if err != nil {
-				slog.Error("read error", "error", err)
+				if !errors.Is(err, net.ErrClosed) {
+					listener.logger.Error("read error", "error", err)
+				}
 				return
 			}
 		}
@@ -86,14 +133,15 @@ func (lc *ListenConfig) Listen(ctx context.Context, network, address string) (ne
 	// Start the writer loop
 	go func() {
 		for {
-			msg, ok := <-listener.outbound
-			if !ok {
+			select {
+			case msg := <-listener.outbound:
+				udpConn.WriteTo(msg.buffer, msg.addr)
+			case <-listener.done:
 				return
 			}
-			udpConn.WriteTo(msg.buffer, msg.addr)
 		}
 	}()
-	return listener, nil
+	return listener
 }
 
 func (l *LRCPListener) getSession(sessionId int64) (*LRCPConn, bool) {
@@ -106,28 +154,40 @@ func (l *LRCPListener) getSession(sessionId int64) (*LRCPConn, bool) {
 func (l *LRCPListener) setSession(sessionId int64, conn *LRCPConn) {
 	l.sessionMu.Lock()
 	defer l.sessionMu.Unlock()
+	if _, ok := l.sessions[sessionId]; !ok {
+		sessionsActive.Inc()
+		sessionsTotal.Inc()
+	}
 	l.sessions[sessionId] = conn
 }
 
 func (l *LRCPListener) deleteSession(sessionId int64) {
 	l.sessionMu.Lock()
 	defer l.sessionMu.Unlock()
+	if _, ok := l.sessions[sessionId]; ok {
+		sessionsActive.Dec()
+	}
 	delete(l.sessions, sessionId)
 }
 
 func (listener *LRCPListener) sendClose(sessionId int64, addr net.Addr) {
-	listener.outbound <- outboundMessage{
+	select {
+	case listener.outbound <- outboundMessage{
 		addr: addr,
 		buffer: SerializeMessage(message{
 			kind:      Close,
 			sessionId: sessionId,
 		}),
+	}:
+	case <-listener.done:
 	}
 }
 
 func (listener *LRCPListener) handleMessage(fromAddr net.Addr, buffer []byte) {
 	msg, err := ParseMessage(buffer)
-	slog.Info("UDP", "from", fromAddr, "buffer", string(buffer), "err", err)
+	if listener.logger.Enabled(context.Background(), slog.LevelDebug) && packetLogs.Allow() {
+		listener.logger.Debug("UDP", "from", fromAddr, "buffer", string(buffer), "err", err)
+	}
 	if err != nil {
 		return
 	}
@@ -141,18 +201,28 @@ func (listener *LRCPListener) handleMessage(fromAddr net.Addr, buffer []byte) {
 			return
 		}
 
+		id := logging.NewConnID()
 		conn = &LRCPConn{
+			id:              id,
 			remoteAddr:      fromAddr,
 			localAddr:       listener.conn.LocalAddr(),
 			sessionId:       msg.sessionId,
-			waitingRead:     make(chan struct{}),
+			waitingRead:     make(chan struct{}, 1),
 			outbound:        listener.outbound,
-			lastSendAckTime: time.Now(),
+			done:            listener.done,
+			logger:          listener.logger.With("conn_id", id, "session", msg.sessionId),
+			opts:            listener.opts,
+			lastSendAckTime: listener.opts.Clock.Now(),
 		}
+		sessionId := msg.sessionId
+		conn.release = func() { listener.deleteSession(sessionId) }
 		listener.setSession(msg.sessionId, conn)
 
 		conn.handleConnect()
-		listener.newConnection <- conn
+		select {
+		case listener.newConnection <- conn:
+		case <-listener.done:
+		}
 		return
 
 	case Data:
@@ -174,15 +244,15 @@ func (listener *LRCPListener) handleMessage(fromAddr net.Addr, buffer []byte) {
 		return
 
 	case Close:
-		_, ok := listener.getSession(msg.sessionId)
+		conn, ok := listener.getSession(msg.sessionId)
 		if !ok {
 			listener.sendClose(msg.sessionId, fromAddr)
 			return
 		}
 
-		// conn.handleClose()
+		// Closing the connection removes the session, and wakes up any reader
 		listener.sendClose(msg.sessionId, fromAddr)
-		listener.deleteSession(msg.sessionId)
+		conn.Close()
 		return
 	}
 }
@@ -0,0 +1,209 @@
+package lrcp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/chaos"
+	"github.com/ananthvk/protohackers-go/internal/clock"
+	"github.com/ananthvk/protohackers-go/internal/vnet"
+)
+
+// testClient is a minimal lrcp client that sends a single payload, and retransmits until it is acknowledged
+type testClient struct {
+	t         *testing.T
+	conn      net.PacketConn
+	server    net.Addr
+	sessionId int64
+	received  []byte
+}
+
+func (c *testClient) send(m message) {
+	if _, err := c.conn.WriteTo(SerializeMessage(m), c.server); err != nil {
+		c.t.Fatalf("write failed: %v", err)
+	}
+}
+
+// receive returns the next message of the session, or false if none arrived before the retransmission interval. Data
+// is acknowledged, and appended to received when it is at the expected position
+func (c *testClient) receive() (message, bool) {
+	var buffer [maxPacketSize]byte
+	c.conn.SetReadDeadline(time.Now().Add(time.Millisecond * 30))
+	n, _, err := c.conn.ReadFrom(buffer[:])
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return message{}, false
+		}
+		c.t.Fatalf("read failed: %v", err)
+	}
+	m, err := ParseMessage(buffer[:n])
+	if err != nil || m.sessionId != c.sessionId {
+		return message{}, false
+	}
+	if m.kind == Data {
+		if m.pos == int64(len(c.received)) {
+			c.received = append(c.received, m.data...)
+		}
+		c.send(message{kind: Ack, sessionId: c.sessionId, length: int64(len(c.received))})
+	}
+	return m, true
+}
+
+// until sends the message until the server acknowledges the given length
+func (c *testClient) until(m message, length int64) {
+	deadline := time.Now().Add(time.Second * 10)
+	for time.Now().Before(deadline) {
+		c.send(m)
+		for {
+			reply, ok := c.receive()
+			if !ok {
+				break
+			}
+			if reply.kind == Ack && reply.length == length {
+				return
+			}
+		}
+	}
+	c.t.Fatalf("%s was never acknowledged", m.kind)
+}
+
+func TestListenerLossyNetwork(t *testing.T) {
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server drops, duplicates and reorders datagrams in both directions, the client network is reliable
+	lossy := chaos.NewPacketConn(udpConn, chaos.Config{Seed: 7, Drop: 0.3, Duplicate: 0.2, Reorder: 0.2})
+	listener := NewListener(lossy, slog.New(slog.DiscardHandler), Options{RetransmissionTimeout: time.Millisecond * 20})
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, err := bufio.NewReader(conn).ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return
+		}
+		reversed := bytes.TrimSuffix(line, []byte("\n"))
+		slices.Reverse(reversed)
+		conn.Write(append(reversed, '\n'))
+	}()
+
+	clientConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	client := &testClient{t: t, conn: clientConn, server: udpConn.LocalAddr(), sessionId: 12345}
+
+	client.until(message{kind: Connect, sessionId: client.sessionId}, 0)
+	payload := []byte("hello, lossy/world\\\n")
+	client.until(message{kind: Data, sessionId: client.sessionId, pos: 0, data: payload}, int64(len(payload)))
+
+	want := "\\dlrow/yssol ,olleh\n"
+	deadline := time.Now().Add(time.Second * 10)
+	for string(client.received) != want && time.Now().Before(deadline) {
+		client.receive()
+	}
+	if got := string(client.received); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestListenerRetransmitsOnVirtualClock(t *testing.T) {
+	virtual := clock.NewVirtual()
+	network := vnet.New(virtual)
+	serverConn, err := network.ListenPacket("10.0.0.1:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConn, err := network.ListenPacket("10.0.0.2:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	// The first data packet sent by the server is lost
+	dropped := make(chan struct{})
+	network.SetDrop(func(from, to net.Addr, data []byte) bool {
+		m, err := ParseMessage(data)
+		if err != nil || m.kind != Data || from.String() != serverConn.LocalAddr().String() {
+			return false
+		}
+		select {
+		case <-dropped:
+			return false
+		default:
+			close(dropped)
+			return true
+		}
+	})
+	listener := NewListener(serverConn, slog.New(slog.DiscardHandler), Options{Clock: virtual})
+	defer listener.Close()
+
+	// The session stays open until the test ends, since closed sessions are not retransmitted
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, err := bufio.NewReader(conn).ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		conn.Write(line)
+		<-finished
+	}()
+
+	send := func(m message) {
+		if _, err := clientConn.WriteTo(SerializeMessage(m), serverConn.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive := func() message {
+		var buffer [maxPacketSize]byte
+		n, _, err := clientConn.ReadFrom(buffer[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := ParseMessage(buffer[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	send(message{kind: Connect, sessionId: 1})
+	if m := receive(); m.kind != Ack || m.length != 0 {
+		t.Fatalf("got %+v, want ack 0", m)
+	}
+	send(message{kind: Data, sessionId: 1, pos: 0, data: []byte("hello\n")})
+	if m := receive(); m.kind != Ack || m.length != 6 {
+		t.Fatalf("got %+v, want ack 6", m)
+	}
+
+	// Nothing is sent again until the retransmission timeout passes on the clock
+	<-dropped
+	virtual.WaitTimers(1) // The retransmission ticker of the session
+	virtual.Advance(DefaultRetransmissionTimeout)
+	m := receive()
+	if m.kind != Data || m.pos != 0 || string(m.data) != "hello\n" {
+		t.Fatalf("got %+v, want the retransmitted data", m)
+	}
+	if got, want := virtual.Now(), clock.Epoch.Add(DefaultRetransmissionTimeout); !got.Equal(want) {
+		t.Errorf("retransmitted at %v, want %v", got, want)
+	}
+}
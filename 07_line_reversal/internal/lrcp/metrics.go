@@ -0,0 +1,11 @@
+package lrcp
+
+import "github.com/ananthvk/protohackers-go/internal/metrics"
+
+var (
+	sessionsActive  = metrics.NewGauge("lrcp_sessions_active", "Number of open LRCP sessions")
+	sessionsTotal   = metrics.NewCounter("lrcp_sessions_total", "Number of LRCP sessions created")
+	retransmissions = metrics.NewCounter("lrcp_retransmissions_total", "Number of data messages retransmitted")
+	acksReceived    = metrics.NewCounter("lrcp_acks_received_total", "Number of ack messages received")
+	acksSent        = metrics.NewCounter("lrcp_acks_sent_total", "Number of ack messages sent")
+)
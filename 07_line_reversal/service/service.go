@@ -0,0 +1,63 @@
+// Package service exposes the line reversal server as a runner.Service, so that it can be started by both the
+// standalone server and the combined protohackers binary.
+package service
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net"
+
+	"github.com/ananthvk/protohackers-go/07_line_reversal/internal"
+	"github.com/ananthvk/protohackers-go/07_line_reversal/internal/lrcp"
+	"github.com/ananthvk/protohackers-go/internal/logging"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+)
+
+// Name is the name of the service, used in logs
+const Name = "lrcp"
+
+// Config holds the settings of the service
+type Config struct {
+	Address string
+	// Protocol holds the LRCP timers. Zero values use the defaults
+	Protocol lrcp.Options
+}
+
+// DefaultConfig returns a config with the default value of every tunable
+func DefaultConfig() Config {
+	return Config{Protocol: lrcp.Options{
+		RetransmissionTimeout: lrcp.DefaultRetransmissionTimeout,
+		SessionExpiryTimeout:  lrcp.DefaultSessionExpiryTimeout,
+	}}
+}
+
+// RegisterFlags binds the tunables of the service to command line flags, whose names start with prefix
+func (c *Config) RegisterFlags(fs *flag.FlagSet, prefix string) {
+	fs.DurationVar(&c.Protocol.RetransmissionTimeout, prefix+"retransmission-timeout", c.Protocol.RetransmissionTimeout, "interval at which unacknowledged LRCP data is sent again")
+	fs.DurationVar(&c.Protocol.SessionExpiryTimeout, prefix+"session-expiry-timeout", c.Protocol.SessionExpiryTimeout, "time after which an LRCP session whose peer stopped acknowledging data is closed")
+}
+
+// Validate checks the tunables of the service
+func (c *Config) Validate() error {
+	if c.Protocol.RetransmissionTimeout < 0 || c.Protocol.SessionExpiryTimeout < 0 {
+		return errors.New("lrcp: timeouts must not be negative")
+	}
+	if c.Protocol.RetransmissionTimeout > 0 && c.Protocol.SessionExpiryTimeout > 0 && c.Protocol.SessionExpiryTimeout < c.Protocol.RetransmissionTimeout {
+		return errors.New("lrcp: session expiry timeout must not be shorter than the retransmission timeout")
+	}
+	return nil
+}
+
+// New creates the service. LRCP runs on top of UDP, so the service listens on a UDP port.
+func New(cfg Config) *runner.Service {
+	return &runner.Service{
+		Name:    Name,
+		Network: "udp",
+		Address: cfg.Address,
+		StreamListener: func(ctx context.Context, conn net.PacketConn) net.Listener {
+			return lrcp.NewListener(conn, logging.Logger(ctx), cfg.Protocol)
+		},
+		Handler: internal.Handle,
+	}
+}
@@ -0,0 +1,74 @@
+// Command loadgen runs many concurrent clients of a challenge against a server, and reports the throughput, latency
+// percentiles, errors and protocol violations of the run:
+//
+//	loadgen -workload prime -address localhost:8001 -clients 100 -duration 30s
+//	loadgen -workload lrcp -address localhost:8007 -loss 0.2
+//	loadgen -list
+//
+// The exit status is 1 if the server violated its protocol during the run.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ananthvk/protohackers-go/internal/loadgen"
+)
+
+func main() {
+	workloadPtr := flag.String("workload", "", "workload to run, see -list")
+	addressPtr := flag.String("address", "", "host:port of the server")
+	tlsPtr := flag.Bool("tls", false, "connect to tcp servers over TLS, without verifying their certificate")
+	clientsPtr := flag.Int("clients", loadgen.DefaultClients, "number of concurrent clients")
+	durationPtr := flag.Duration("duration", loadgen.DefaultDuration, "length of the run")
+	timeoutPtr := flag.Duration("timeout", loadgen.DefaultTimeout, "time to wait for an answer of the server")
+	intervalPtr := flag.Duration("interval", 0, "pause between the operations of a client, 0 for the default of the workload")
+	sizePtr := flag.Int("size", 0, "size of the messages of the smoke and lrcp workloads, 0 for the default")
+	lossPtr := flag.Float64("loss", 0, "probability that a udp datagram is lost, in each direction")
+	seedPtr := flag.Uint64("seed", 0, "seed of the data sent by the clients")
+	listPtr := flag.Bool("list", false, "list the workloads and exit")
+	flag.Parse()
+
+	if *listPtr {
+		for _, d := range loadgen.Workloads {
+			fmt.Printf("%-6s %s\n", d.Name, d.Description)
+		}
+		return
+	}
+	def, ok := loadgen.Find(*workloadPtr)
+	if !ok || *addressPtr == "" {
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown workload %q, see -list\n", *workloadPtr)
+		} else {
+			fmt.Fprintln(os.Stderr, "-address is required")
+		}
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *lossPtr < 0 || *lossPtr >= 1 {
+		fmt.Fprintln(os.Stderr, "-loss must be in [0, 1)")
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report := loadgen.Run(ctx, def, loadgen.Options{
+		Address:  *addressPtr,
+		TLS:      *tlsPtr,
+		Clients:  *clientsPtr,
+		Duration: *durationPtr,
+		Timeout:  *timeoutPtr,
+		Interval: *intervalPtr,
+		Size:     *sizePtr,
+		Loss:     *lossPtr,
+		Seed:     *seedPtr,
+	})
+	report.Write(os.Stdout)
+	if report.Violations > 0 {
+		os.Exit(1)
+	}
+}
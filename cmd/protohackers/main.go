@@ -0,0 +1,171 @@
+// Command protohackers runs several challenge servers in a single process. Each challenge is enabled by passing the
+// address it should listen on, for example:
+//
+//	protohackers -smoke :8000 -prime :8001 -speed :8006 -lrcp 8007
+//
+// -sniff :8080 serves the prime, means, speed, chat and smoke challenges on a single address, and picks the challenge of
+// each connection from the first bytes sent by the client.
+//
+// TCP challenges can also serve TLS on a second address, for example -smoke-tls :9000 together with -tls-cert and
+// -tls-key. All services share the same signal handling, and are shut down together.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+
+	smoke "github.com/ananthvk/protohackers-go/00_smoke_test/service"
+	prime "github.com/ananthvk/protohackers-go/01_prime_time/service"
+	means "github.com/ananthvk/protohackers-go/02_means_to_an_end/service"
+	chat "github.com/ananthvk/protohackers-go/03_budget_chat/service"
+	kv "github.com/ananthvk/protohackers-go/04_unusual_database_program/service"
+	mob "github.com/ananthvk/protohackers-go/05_mob_in_the_middle/service"
+	speed "github.com/ananthvk/protohackers-go/06_speed_daemon/service"
+	lrcp "github.com/ananthvk/protohackers-go/07_line_reversal/service"
+	"github.com/ananthvk/protohackers-go/internal/acl"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+	"github.com/ananthvk/protohackers-go/internal/sniff"
+)
+
+// challenge is a single service that can be enabled from the command line
+type challenge struct {
+	name        string
+	description string
+	tcp         bool
+	address     *string
+	tlsAddress  *string // nil for challenges that don't run over tcp
+	access      acl.List
+	build       func(address, tlsAddress string) *runner.Service
+}
+
+// normalizeAddress allows the address to be given as just a port number
+func normalizeAddress(address string) string {
+	if _, err := strconv.ParseUint(address, 10, 16); err == nil {
+		return ":" + address
+	}
+	return address
+}
+
+func main() {
+	sniffPtr := flag.String(sniffName, "", "address (or port) to serve the prime, means, speed, chat and smoke challenges on, detecting the protocol of each connection")
+	sniffTLSPtr := flag.String(sniffName+"-tls", "", "address (or port) to serve protocol detection over TLS on, requires -tls-cert")
+	var sniffAccess acl.List
+	sniffAccess.RegisterFlags(flag.CommandLine, sniffName+"-", "clients of "+sniffName)
+	sniffSilencePtr := flag.Duration(sniffName+"-silence-timeout", sniff.DefaultSilenceTimeout, "connections that send nothing for this long are served by the chat challenge, whose server speaks first")
+	allPtr := flag.Bool("all", false, "enable every challenge, on port 8000 + challenge number unless an address is given")
+	opts := runner.DefaultOptions()
+	opts.RegisterFlags(flag.CommandLine)
+	// The tunables of each challenge are prefixed by its name, such as -speed-queue-size
+	primeCfg := prime.DefaultConfig()
+	primeCfg.RegisterFlags(flag.CommandLine, prime.Name+"-")
+	chatCfg := chat.DefaultConfig()
+	chatCfg.RegisterFlags(flag.CommandLine, chat.Name+"-")
+	kvCfg := kv.DefaultConfig()
+	kvCfg.RegisterFlags(flag.CommandLine, kv.Name+"-")
+	mobCfg := mob.DefaultConfig()
+	mobCfg.RegisterFlags(flag.CommandLine, mob.Name+"-")
+	speedCfg := speed.DefaultConfig()
+	speedCfg.RegisterFlags(flag.CommandLine, speed.Name+"-")
+	lrcpCfg := lrcp.DefaultConfig()
+	lrcpCfg.RegisterFlags(flag.CommandLine, lrcp.Name+"-")
+
+	challenges := []challenge{
+		{name: smoke.Name, description: "00 smoke test (tcp)", tcp: true, build: func(address, tlsAddress string) *runner.Service {
+			return smoke.New(smoke.Config{Address: address, TLSAddress: tlsAddress})
+		}},
+		{name: prime.Name, description: "01 prime time (tcp)", tcp: true, build: func(address, tlsAddress string) *runner.Service {
+			primeCfg.Address, primeCfg.TLSAddress = address, tlsAddress
+			return prime.New(primeCfg)
+		}},
+		{name: means.Name, description: "02 means to an end (tcp)", tcp: true, build: func(address, tlsAddress string) *runner.Service {
+			return means.New(means.Config{Address: address, TLSAddress: tlsAddress})
+		}},
+		{name: chat.Name, description: "03 budget chat (tcp)", tcp: true, build: func(address, tlsAddress string) *runner.Service {
+			chatCfg.Address, chatCfg.TLSAddress = address, tlsAddress
+			return chat.New(chatCfg)
+		}},
+		{name: kv.Name, description: "04 unusual database program (udp)", build: func(address, tlsAddress string) *runner.Service {
+			kvCfg.Address = address
+			return kv.New(kvCfg)
+		}},
+		{name: mob.Name, description: "05 mob in the middle (tcp)", tcp: true, build: func(address, tlsAddress string) *runner.Service {
+			mobCfg.Address, mobCfg.TLSAddress = address, tlsAddress
+			return mob.New(mobCfg)
+		}},
+		{name: speed.Name, description: "06 speed daemon (tcp)", tcp: true, build: func(address, tlsAddress string) *runner.Service {
+			speedCfg.Address, speedCfg.TLSAddress = address, tlsAddress
+			return speed.New(speedCfg)
+		}},
+		{name: lrcp.Name, description: "07 line reversal (lrcp over udp)", build: func(address, tlsAddress string) *runner.Service {
+			lrcpCfg.Address = address
+			return lrcp.New(lrcpCfg)
+		}},
+	}
+	for i := range challenges {
+		c := &challenges[i]
+		c.address = flag.String(c.name, "", fmt.Sprintf("address (or port) to serve %s on", c.description))
+		if c.tcp {
+			c.tlsAddress = flag.String(c.name+"-tls", "", fmt.Sprintf("address (or port) to serve %s over TLS on, requires -tls-cert", c.description))
+		}
+		c.access.RegisterFlags(flag.CommandLine, c.name+"-", "clients of "+c.name)
+		// The access lists of the challenge also apply to connections routed to it by -sniff
+		build := c.build
+		c.build = func(address, tlsAddress string) *runner.Service {
+			s := build(address, tlsAddress)
+			s.Access = c.access
+			return s
+		}
+	}
+	validateSniff := func() error {
+		if *sniffSilencePtr <= 0 {
+			return errors.New("sniff: silence timeout must be positive")
+		}
+		return nil
+	}
+	runner.ParseFlags(opts.Validate, validateSniff, primeCfg.Validate, chatCfg.Validate, kvCfg.Validate, mobCfg.Validate, speedCfg.Validate, lrcpCfg.Validate)
+
+	var services []*runner.Service
+	built := map[string]*runner.Service{}
+	for i, c := range challenges {
+		address := *c.address
+		if address == "" && *allPtr {
+			address = strconv.Itoa(8000 + i)
+		}
+		tlsAddress := ""
+		if c.tlsAddress != nil && *c.tlsAddress != "" {
+			tlsAddress = normalizeAddress(*c.tlsAddress)
+		}
+		if address == "" {
+			continue
+		}
+		s := c.build(normalizeAddress(address), tlsAddress)
+		built[c.name] = s
+		services = append(services, s)
+	}
+	if *sniffPtr != "" {
+		// Challenges that are not served on their own address are only reachable through protocol detection
+		service := func(name string) *runner.Service {
+			if s, ok := built[name]; ok {
+				return s
+			}
+			i := slices.IndexFunc(challenges, func(c challenge) bool { return c.name == name })
+			return challenges[i].build("", "")
+		}
+		sniffTLSAddress := ""
+		if *sniffTLSPtr != "" {
+			sniffTLSAddress = normalizeAddress(*sniffTLSPtr)
+		}
+		services = append(services, newSniffService(normalizeAddress(*sniffPtr), sniffTLSAddress, sniffAccess, *sniffSilencePtr, opts.Timeouts, service))
+	}
+	if len(services) == 0 {
+		slog.Error("no challenges enabled, pass an address for at least one challenge, -sniff or -all")
+		flag.Usage()
+		os.Exit(runner.ExitError)
+	}
+	runner.Main(opts, services...)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"time"
+
+	smoke "github.com/ananthvk/protohackers-go/00_smoke_test/service"
+	prime "github.com/ananthvk/protohackers-go/01_prime_time/service"
+	means "github.com/ananthvk/protohackers-go/02_means_to_an_end/service"
+	chat "github.com/ananthvk/protohackers-go/03_budget_chat/service"
+	speed "github.com/ananthvk/protohackers-go/06_speed_daemon/service"
+	"github.com/ananthvk/protohackers-go/internal/acl"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+	"github.com/ananthvk/protohackers-go/internal/sniff"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+)
+
+// sniffName is the name of the service that detects the protocol of each connection
+const sniffName = "sniff"
+
+// route serves the connections of a sniffed protocol with the handler of the service
+func route(s *runner.Service, match sniff.Matcher) sniff.Route {
+	return sniff.Route{Name: s.Name, Match: match, Handler: s.Handler, Timeouts: s.Timeouts, Access: s.Access}
+}
+
+// newSniffService creates a service that serves prime, means and speed clients on the same address, recognized from
+// their first bytes. Clients that stay silent are greeted by chat, and anything else is echoed by smoke. service returns
+// the service of a challenge by name, so that connections share the state (such as the chat room) of the challenge
+// when it is also served on its own address.
+func newSniffService(address, tlsAddress string, access acl.List, silenceTimeout time.Duration, timeouts timeout.Policy, service func(name string) *runner.Service) *runner.Service {
+	fallback := route(service(smoke.Name), nil)
+	silent := route(service(chat.Name), nil)
+	router := &sniff.Router{
+		Routes: []sniff.Route{
+			route(service(prime.Name), prime.Detect),
+			route(service(means.Name), means.Detect),
+			route(service(speed.Name), speed.Detect),
+		},
+		Fallback:       &fallback,
+		Silent:         &silent,
+		SilenceTimeout: silenceTimeout,
+		Timeouts:       timeouts,
+	}
+	return &runner.Service{
+		Name:       sniffName,
+		Network:    "tcp",
+		Address:    address,
+		TLSAddress: tlsAddress,
+		// Reads are bounded by the silence timeout until the protocol is known, and then by the timeouts of the protocol
+		Timeouts: timeout.Policy{Write: 10 * time.Second},
+		Access:   access,
+		Handler:  router.Handle,
+	}
+}
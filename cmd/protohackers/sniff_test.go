@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	smoke "github.com/ananthvk/protohackers-go/00_smoke_test/service"
+	prime "github.com/ananthvk/protohackers-go/01_prime_time/service"
+	means "github.com/ananthvk/protohackers-go/02_means_to_an_end/service"
+	chat "github.com/ananthvk/protohackers-go/03_budget_chat/service"
+	speed "github.com/ananthvk/protohackers-go/06_speed_daemon/service"
+	"github.com/ananthvk/protohackers-go/internal/acl"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+	"github.com/ananthvk/protohackers-go/internal/sniff"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+)
+
+// TestSniffMeans checks that means to an end clients reach their service, including with a query whose mintime comes
+// after maxtime, which is valid
+func TestSniffMeans(t *testing.T) {
+	services := map[string]*runner.Service{
+		smoke.Name: smoke.New(smoke.Config{}),
+		prime.Name: prime.New(prime.DefaultConfig()),
+		means.Name: means.New(means.Config{}),
+		chat.Name:  chat.New(chat.DefaultConfig()),
+		speed.Name: speed.New(speed.DefaultConfig()),
+	}
+	s := newSniffService("", "", acl.List{}, sniff.DefaultSilenceTimeout, timeout.Policy{}, func(name string) *runner.Service {
+		return services[name]
+	})
+
+	tests := []struct {
+		name    string
+		request string
+		want    string
+	}{
+		{"query", "I\x00\x00\x30\x39\x00\x00\x00\x65Q\x00\x00\x30\x00\x00\x00\x31\x00", "\x00\x00\x00\x65"},
+		{"inverted query", "Q\x00\x00\x00\x02\x00\x00\x00\x01", "\x00\x00\x00\x00"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() {
+				defer server.Close()
+				s.Handler(context.Background(), server)
+			}()
+			client.SetDeadline(time.Now().Add(time.Second * 2))
+			go client.Write([]byte(test.request))
+			got := make([]byte, len(test.want))
+			if _, err := io.ReadFull(client, got); err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Command replay plays the client side of recordings made with -record-dir, and compares what the server sends back
+// with what it sent when the recording was made:
+//
+//	replay -address localhost:8001 recordings/prime-*.jsonl
+//	replay recordings/chat-20250101T120000-123.jsonl
+//
+// Without -address, each recording is played against a fresh in-process instance of the service it was recorded from
+// (or of -service). With -shared, all recordings are played in order against the same instance, so that sessions that
+// depend on each other, such as speed cameras followed by a dispatcher, can be replayed. The exit status is 1 if any
+// output differs.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	smoke "github.com/ananthvk/protohackers-go/00_smoke_test/service"
+	prime "github.com/ananthvk/protohackers-go/01_prime_time/service"
+	means "github.com/ananthvk/protohackers-go/02_means_to_an_end/service"
+	chat "github.com/ananthvk/protohackers-go/03_budget_chat/service"
+	speed "github.com/ananthvk/protohackers-go/06_speed_daemon/service"
+	lrcp "github.com/ananthvk/protohackers-go/07_line_reversal/service"
+	"github.com/ananthvk/protohackers-go/internal/logging"
+	"github.com/ananthvk/protohackers-go/internal/record"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+)
+
+// services builds the handlers that can be replayed in-process
+var services = map[string]func() runner.Handler{
+	smoke.Name: func() runner.Handler { return smoke.New(smoke.Config{}).Handler },
+	prime.Name: func() runner.Handler { return prime.New(prime.Config{}).Handler },
+	means.Name: func() runner.Handler { return means.New(means.Config{}).Handler },
+	chat.Name:  func() runner.Handler { return chat.New(chat.Config{}).Handler },
+	speed.Name: func() runner.Handler { return speed.New(speed.Config{}).Handler },
+	lrcp.Name:  func() runner.Handler { return lrcp.New(lrcp.Config{}).Handler },
+}
+
+func main() {
+	addressPtr := flag.String("address", "", "replay against the tcp server at this address, instead of in-process")
+	tlsPtr := flag.Bool("tls", false, "connect to -address over TLS, without verifying its certificate")
+	servicePtr := flag.String("service", "", "in-process service to replay against, instead of the one named in the recording")
+	realtimePtr := flag.Bool("realtime", false, "send data at the recorded times, instead of as fast as the server answers")
+	waitPtr := flag.Duration("wait", record.DefaultWait, "time to wait for output the server still owes")
+	sharedPtr := flag.Bool("shared", false, "replay every recording against the same in-process instance of a service")
+	// The logs of in-process services are only interesting when something goes wrong
+	logConfig := logging.DefaultConfig()
+	logConfig.Level = slog.LevelWarn
+	logConfig.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] recording...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	logging.Setup(os.Stderr, logConfig)
+	instances := map[string]runner.Handler{}
+	if !*sharedPtr {
+		instances = nil
+	}
+
+	opts := record.ReplayOptions{Realtime: *realtimePtr, Wait: *waitPtr}
+	failed := 0
+	for _, path := range flag.Args() {
+		recording, err := record.ReadFile(path)
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		var output []byte
+		if *addressPtr != "" {
+			output, err = replayRemote(*addressPtr, *tlsPtr, recording, opts)
+		} else {
+			output, err = replayLocal(*servicePtr, instances, recording, opts)
+		}
+		if err == nil {
+			if diff := record.Diff(recording.Output(), output); diff != "" {
+				err = fmt.Errorf("%s", diff)
+			}
+		}
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", path)
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d recordings failed\n", failed, flag.NArg())
+		os.Exit(1)
+	}
+}
+
+func replayRemote(address string, useTLS bool, recording *record.Recording, opts record.ReplayOptions) ([]byte, error) {
+	dialer := &net.Dialer{Timeout: time.Second * 10}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return record.Replay(context.Background(), conn, recording, opts)
+}
+
+// replayLocal replays the recording in-process. If instances is not nil, the instance of the service is reused across
+// calls.
+func replayLocal(name string, instances map[string]runner.Handler, recording *record.Recording, opts record.ReplayOptions) ([]byte, error) {
+	if name == "" {
+		name = recording.Header.Service
+	}
+	build, ok := services[name]
+	if !ok {
+		names := slices.Sorted(maps.Keys(services))
+		return nil, fmt.Errorf("service %q can't be replayed in-process, use -address or one of: %s", name, strings.Join(names, ", "))
+	}
+	handler := instances[name]
+	if handler == nil {
+		handler = build()
+		if instances != nil {
+			instances[name] = handler
+		}
+	}
+	return record.ReplayHandler(context.Background(), handler, recording, opts)
+}
@@ -0,0 +1,150 @@
+// Package acl decides which clients may reach a service, from lists of allowed and denied networks. A client is denied
+// if its IP belongs to a denied network, or if networks are allowed and its IP belongs to none of them. Clients of unix
+// sockets have no IP, so they only get through lists that allow every network.
+package acl
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Reason is the reason reported for connections and packets denied by a List
+const Reason = "acl"
+
+// Prefixes is a list of networks that can be used as a flag.Value. Plain IP addresses are accepted as single host
+// networks.
+type Prefixes []netip.Prefix
+
+func (p *Prefixes) String() string {
+	if p == nil {
+		return ""
+	}
+	parts := make([]string, len(*p))
+	for i, prefix := range *p {
+		parts[i] = prefix.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set parses a comma separated list of networks, which replaces the current list
+func (p *Prefixes) Set(value string) error {
+	var prefixes Prefixes
+	for part := range strings.SplitSeq(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		prefix, err := ParsePrefix(part)
+		if err != nil {
+			return err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	*p = prefixes
+	return nil
+}
+
+// Contains returns true if the IP of the address belongs to one of the networks. Addresses without an IP never do
+func (p Prefixes) Contains(addr net.Addr) bool {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return false
+	}
+	for _, prefix := range p {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP returns the IP of an ip:port address. The zone of link-local addresses is dropped, since a prefix never
+// contains a zoned address
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	if addr == nil {
+		return netip.Addr{}, false
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addrPort.Addr().Unmap().WithZone(""), true
+}
+
+// ParsePrefix parses a network in CIDR notation, or a single IP address
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid network %q", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// List holds the allowed and denied networks of a service, or of a role within a service. The zero value permits
+// every client
+type List struct {
+	// Allow restricts clients to these networks. Every network is allowed if it's empty
+	Allow Prefixes
+	// Deny rejects clients from these networks, even if they are allowed
+	Deny Prefixes
+}
+
+// RegisterFlags binds the list to the flags prefix+"allow" and prefix+"deny". who describes the clients the list
+// applies to in the usage text, such as "cameras"
+func (l *List) RegisterFlags(fs *flag.FlagSet, prefix, who string) {
+	fs.Var(&l.Allow, prefix+"allow", fmt.Sprintf("comma separated networks (CIDR) or IPs that %s must come from (any if empty)", who))
+	fs.Var(&l.Deny, prefix+"deny", fmt.Sprintf("comma separated networks (CIDR) or IPs that %s must not come from", who))
+}
+
+// IsZero returns true if the list permits every client
+func (l List) IsZero() bool {
+	return len(l.Allow) == 0 && len(l.Deny) == 0
+}
+
+// Permits returns true if a client with the address may reach the service
+func (l List) Permits(addr net.Addr) bool {
+	if l.Deny.Contains(addr) {
+		return false
+	}
+	return len(l.Allow) == 0 || l.Allow.Contains(addr)
+}
+
+// PacketConn drops incoming packets from addresses that are not permitted. Dropped packets are reported to onDeny, and
+// never returned from ReadFrom.
+type PacketConn struct {
+	net.PacketConn
+	permits func(addr net.Addr) bool
+	onDeny  func(addr net.Addr)
+}
+
+func NewPacketConn(conn net.PacketConn, permits func(addr net.Addr) bool, onDeny func(addr net.Addr)) *PacketConn {
+	return &PacketConn{PacketConn: conn, permits: permits, onDeny: onDeny}
+}
+
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || c.permits(addr) {
+			return n, addr, err
+		}
+		if c.onDeny != nil {
+			c.onDeny(addr)
+		}
+	}
+}
+
+// Unwrap returns the wrapped connection
+func (c *PacketConn) Unwrap() net.PacketConn {
+	return c.PacketConn
+}
@@ -0,0 +1,88 @@
+package acl
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestPrefixes(t *testing.T) {
+	var p Prefixes
+	if err := p.Set("10.0.0.0/8, 127.0.0.1,::1"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if got := p.String(); got != "10.0.0.0/8,127.0.0.1/32,::1/128" {
+		t.Errorf("got %q", got)
+	}
+	if err := p.Set("not-an-ip"); err == nil {
+		t.Errorf("expected an error for an invalid network")
+	}
+}
+
+func TestPermits(t *testing.T) {
+	prefixes := func(s string) Prefixes {
+		var p Prefixes
+		if err := p.Set(s); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	office := List{Allow: prefixes("10.0.0.0/8,2001:db8::/32"), Deny: prefixes("10.0.66.0/24")}
+	tests := []struct {
+		list List
+		addr net.Addr
+		want bool
+	}{
+		{List{}, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 80}, true},
+		{List{}, &net.UnixAddr{Name: "@", Net: "unix"}, true},
+		{office, &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 80}, true},
+		{office, &net.UDPAddr{IP: net.ParseIP("::ffff:10.1.2.3"), Port: 80}, true},
+		{office, &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 80}, true},
+		{office, &net.TCPAddr{IP: net.ParseIP("10.0.66.7"), Port: 80}, false},
+		{office, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 80}, false},
+		{office, &net.UnixAddr{Name: "@", Net: "unix"}, false},
+		{List{Deny: prefixes("192.0.2.0/24")}, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 80}, false},
+		{List{Deny: prefixes("192.0.2.0/24")}, &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 80}, true},
+		// Link-local clients carry the zone of their interface
+		{List{Deny: prefixes("fe80::/10")}, &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 80, Zone: "eth0"}, false},
+		{List{Allow: prefixes("fe80::1")}, &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 80, Zone: "eth0"}, true},
+	}
+	for _, test := range tests {
+		if got := test.list.Permits(test.addr); got != test.want {
+			t.Errorf("%+v.Permits(%v) = %v, want %v", test.list, test.addr, got, test.want)
+		}
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied := 0
+	list := List{Deny: Prefixes{netip.MustParsePrefix("127.0.0.2/32")}}
+	conn := NewPacketConn(server, list.Permits, func(addr net.Addr) { denied++ })
+	defer conn.Close()
+
+	send := func(from, data string) {
+		client, err := net.ListenPacket("udp", from+":0")
+		if err != nil {
+			t.Skipf("can't bind %s: %v", from, err)
+		}
+		defer client.Close()
+		client.WriteTo([]byte(data), server.LocalAddr())
+	}
+	send("127.0.0.2", "denied")
+	send("127.0.0.1", "allowed")
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	buffer := make([]byte, 16)
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil || string(buffer[:n]) != "allowed" {
+		t.Fatalf("got %q, %v", buffer[:n], err)
+	}
+	if denied != 1 {
+		t.Errorf("got %d denied packets, want 1", denied)
+	}
+}
@@ -0,0 +1,113 @@
+// Package admin serves an HTTP API to inspect and close the live sessions of every service:
+//
+//	GET  /sessions             every live session, optionally filtered with ?service=name
+//	GET  /sessions/{id}        a single session
+//	POST /sessions/{id}/close  closes the connection of the session
+//
+// The API has no authentication, so it must only be bound to a trusted address.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/session"
+	"github.com/ananthvk/protohackers-go/internal/socket"
+)
+
+// Handler returns the HTTP handler of the API
+func Handler(sessions *session.Registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", func(w http.ResponseWriter, r *http.Request) {
+		service := r.URL.Query().Get("service")
+		snapshots := []session.Snapshot{}
+		for _, snapshot := range sessions.List() {
+			if service == "" || snapshot.Service == service {
+				snapshots = append(snapshots, snapshot)
+			}
+		}
+		writeJSON(w, http.StatusOK, snapshots)
+	})
+	mux.HandleFunc("GET /sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		s, ok := lookup(w, r, sessions)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Snapshot())
+	})
+	mux.HandleFunc("POST /sessions/{id}/close", func(w http.ResponseWriter, r *http.Request) {
+		s, ok := lookup(w, r, sessions)
+		if !ok {
+			return
+		}
+		slog.Info("closing session from the admin endpoint", "service", s.Service, "conn_id", s.ID, "remote_address", s.Remote)
+		s.Close()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// lookup finds the session named by the id in the path, and writes an error response if there is none
+func lookup(w http.ResponseWriter, r *http.Request, sessions *session.Registry) (*session.Session, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid session id"})
+		return nil, false
+	}
+	s, ok := sessions.Get(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return nil, false
+	}
+	return s, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// Server serves the admin API
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen binds the admin server to the address
+func Listen(ctx context.Context, address string, sessions *session.Registry) (*Server, error) {
+	listener, err := socket.Listen(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		listener: listener,
+		server:   &http.Server{Handler: Handler(sessions), ReadHeaderTimeout: time.Second * 10},
+	}, nil
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve serves HTTP requests until the context is cancelled
+func (s *Server) Serve(ctx context.Context) {
+	slog.Info("admin listening", "address", s.listener.Addr().String())
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.server.Shutdown(shutdownCtx)
+	}()
+	if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("admin server failed", "error", err)
+	}
+}
@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/session"
+)
+
+// described is a connection that reports details about itself
+type described struct {
+	net.Conn
+}
+
+func (described) Describe() map[string]any {
+	return map[string]any{"unacked": 2}
+}
+
+func TestSessions(t *testing.T) {
+	registry := session.NewRegistry()
+	chatClient, chatServer := net.Pipe()
+	defer chatClient.Close()
+	chat := session.New(1, "chat", chatServer, func() (uint64, uint64) { return 10, 20 })
+	chat.Set("username", "alice")
+	registry.Add(chat)
+	_, lrcpServer := net.Pipe()
+	registry.Add(session.New(2, "lrcp", described{lrcpServer}, nil))
+
+	server := httptest.NewServer(Handler(registry))
+	defer server.Close()
+
+	get := func(path string, want int, value any) {
+		t.Helper()
+		response, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != want {
+			t.Fatalf("GET %s: got status %d, want %d", path, response.StatusCode, want)
+		}
+		if value != nil {
+			if err := json.NewDecoder(response.Body).Decode(value); err != nil {
+				t.Fatalf("GET %s: %v", path, err)
+			}
+		}
+	}
+
+	var all []session.Snapshot
+	get("/sessions", http.StatusOK, &all)
+	if len(all) != 2 || all[0].ID != 1 || all[1].ID != 2 {
+		t.Fatalf("got %+v", all)
+	}
+	if all[0].Attributes["username"] != "alice" || all[0].BytesReceived != 10 || all[0].BytesSent != 20 {
+		t.Errorf("got %+v", all[0])
+	}
+	if all[1].Attributes["unacked"] != float64(2) {
+		t.Errorf("the Describer of the connection was not used: %+v", all[1])
+	}
+
+	var filtered []session.Snapshot
+	get("/sessions?service=lrcp", http.StatusOK, &filtered)
+	if len(filtered) != 1 || filtered[0].Service != "lrcp" {
+		t.Errorf("got %+v", filtered)
+	}
+	get("/sessions/3", http.StatusNotFound, nil)
+	get("/sessions/abc", http.StatusBadRequest, nil)
+
+	response, err := http.Post(server.URL+"/sessions/1/close", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNoContent {
+		t.Errorf("got status %d, want %d", response.StatusCode, http.StatusNoContent)
+	}
+	chatClient.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := chatClient.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want %v after closing the session", err, io.EOF)
+	}
+}
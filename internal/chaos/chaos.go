@@ -0,0 +1,183 @@
+// Package chaos wraps connections to inject the faults of real networks: fragmented and coalesced TCP segments,
+// latency, stalls, resets, and dropped, duplicated or reordered datagrams. Faults follow a schedule drawn from a seeded
+// random source, so a failure found with a seed can be reproduced with the same seed.
+//
+// A Config is written as a comma separated list of key=value pairs, for example
+//
+//	seed=42,fragment=0.5,latency=20ms,drop=0.1
+//
+// Probabilities are between 0 and 1, and apply to every read and write (or every datagram).
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default durations of the faults that need one
+const (
+	DefaultStallDuration = time.Second
+	DefaultCoalesceDelay = time.Millisecond * 10
+)
+
+// Config describes the faults to inject. The zero value injects nothing
+type Config struct {
+	// Seed of the schedule. Every connection draws its faults from its own source, derived from the seed and the order
+	// in which connections were accepted
+	Seed uint64
+
+	// Fragment is the probability that a write is sent one byte at a time, or that a read returns a single byte
+	Fragment float64
+	// Coalesce is the probability that a read waits CoalesceDelay first, so that several segments arrive together
+	Coalesce      float64
+	CoalesceDelay time.Duration
+	// Latency is the upper bound of a random delay added before every write, and every datagram sent
+	Latency time.Duration
+	// Stall is the probability that a read or write blocks for StallDuration
+	Stall         float64
+	StallDuration time.Duration
+	// Reset is the probability that a read or write resets the connection instead
+	Reset float64
+
+	// Drop and Duplicate are the probabilities that a datagram is lost or delivered twice, in both directions. Reorder
+	// is the probability that a received datagram is delivered after the one that follows it
+	Drop      float64
+	Duplicate float64
+	Reorder   float64
+}
+
+// Enabled returns true if any fault is configured
+func (c Config) Enabled() bool {
+	return c.Fragment > 0 || c.Coalesce > 0 || c.Latency > 0 || c.Stall > 0 || c.Reset > 0 ||
+		c.Drop > 0 || c.Duplicate > 0 || c.Reorder > 0
+}
+
+// withDefaults returns a copy of the config, with zero durations replaced by the defaults
+func (c Config) withDefaults() Config {
+	if c.StallDuration == 0 {
+		c.StallDuration = DefaultStallDuration
+	}
+	if c.CoalesceDelay == 0 {
+		c.CoalesceDelay = DefaultCoalesceDelay
+	}
+	return c
+}
+
+// Parse parses a config written as key=value pairs, see the package documentation
+func Parse(spec string) (Config, error) {
+	var c Config
+	for part := range strings.SplitSeq(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return Config{}, fmt.Errorf("chaos: %q is not a key=value pair", part)
+		}
+		if err := c.set(key, value); err != nil {
+			return Config{}, fmt.Errorf("chaos: %s: %w", key, err)
+		}
+	}
+	return c, nil
+}
+
+func (c *Config) set(key, value string) error {
+	probability := func(p *float64) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		if f < 0 || f > 1 {
+			return errors.New("probability must be between 0 and 1")
+		}
+		*p = f
+		return nil
+	}
+	duration := func(d *time.Duration) error {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		if parsed < 0 {
+			return errors.New("duration must not be negative")
+		}
+		*d = parsed
+		return nil
+	}
+	switch key {
+	case "seed":
+		seed, err := strconv.ParseUint(value, 10, 64)
+		c.Seed = seed
+		return err
+	case "fragment":
+		return probability(&c.Fragment)
+	case "coalesce":
+		return probability(&c.Coalesce)
+	case "coalesce-delay":
+		return duration(&c.CoalesceDelay)
+	case "latency":
+		return duration(&c.Latency)
+	case "stall":
+		return probability(&c.Stall)
+	case "stall-duration":
+		return duration(&c.StallDuration)
+	case "reset":
+		return probability(&c.Reset)
+	case "drop":
+		return probability(&c.Drop)
+	case "duplicate":
+		return probability(&c.Duplicate)
+	case "reorder":
+		return probability(&c.Reorder)
+	}
+	return errors.New("unknown fault")
+}
+
+// String formats the config so that Parse returns it again. Faults that are not configured are left out
+func (c *Config) String() string {
+	if c == nil {
+		return ""
+	}
+	var parts []string
+	add := func(key string, value string) {
+		parts = append(parts, key+"="+value)
+	}
+	probability := func(key string, p float64) {
+		if p > 0 {
+			add(key, strconv.FormatFloat(p, 'g', -1, 64))
+		}
+	}
+	duration := func(key string, d time.Duration) {
+		if d > 0 {
+			add(key, d.String())
+		}
+	}
+	if c.Seed != 0 {
+		add("seed", strconv.FormatUint(c.Seed, 10))
+	}
+	probability("fragment", c.Fragment)
+	probability("coalesce", c.Coalesce)
+	duration("coalesce-delay", c.CoalesceDelay)
+	duration("latency", c.Latency)
+	probability("stall", c.Stall)
+	duration("stall-duration", c.StallDuration)
+	probability("reset", c.Reset)
+	probability("drop", c.Drop)
+	probability("duplicate", c.Duplicate)
+	probability("reorder", c.Reorder)
+	return strings.Join(parts, ",")
+}
+
+// Set parses the value with Parse, so that a Config can be used as a flag.Value
+func (c *Config) Set(value string) error {
+	parsed, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
@@ -0,0 +1,167 @@
+package chaos
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse("seed=7, fragment=0.5,latency=20ms,drop=1")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := Config{Seed: 7, Fragment: 0.5, Latency: time.Millisecond * 20, Drop: 1}
+	if cfg != want {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+	if again, err := Parse(cfg.String()); err != nil || again != cfg {
+		t.Errorf("String does not round trip: %q gives %+v, %v", cfg.String(), again, err)
+	}
+	if !cfg.Enabled() || (Config{Seed: 1}).Enabled() {
+		t.Errorf("Enabled is wrong")
+	}
+
+	for _, spec := range []string{"fragment", "fragment=2", "drop=-0.1", "latency=-1s", "latency=soon", "jitter=1", "seed=-1"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+// readSizes returns the size of every read needed to receive n bytes from conn
+func readSizes(t *testing.T, conn net.Conn, n int) []int {
+	t.Helper()
+	var sizes []int
+	buffer := make([]byte, 64)
+	for received := 0; received < n; {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		read, err := conn.Read(buffer)
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		sizes = append(sizes, read)
+		received += read
+	}
+	return sizes
+}
+
+func TestFragment(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := NewConn(server, Config{Fragment: 1}, 1)
+	defer conn.Close()
+
+	// Writes are sent one byte at a time
+	go conn.Write([]byte("hello"))
+	if sizes := readSizes(t, client, 5); !slices.Equal(sizes, []int{1, 1, 1, 1, 1}) {
+		t.Errorf("got reads of %v bytes, want 5 single bytes", sizes)
+	}
+	// Reads return a single byte, and nothing is lost
+	go client.Write([]byte("world"))
+	var received bytes.Buffer
+	for range 5 {
+		buffer := make([]byte, 64)
+		n, err := conn.Read(buffer)
+		if err != nil || n != 1 {
+			t.Fatalf("got %d bytes, %v, want a single byte", n, err)
+		}
+		received.Write(buffer[:n])
+	}
+	if received.String() != "world" {
+		t.Errorf("got %q, want %q", received.String(), "world")
+	}
+}
+
+func TestSeededSchedule(t *testing.T) {
+	// The same seed and stream fragment the same writes
+	fragments := func(seed, stream uint64) []int {
+		client, server := net.Pipe()
+		defer client.Close()
+		conn := NewConn(server, Config{Seed: seed, Fragment: 0.5}, stream)
+		defer conn.Close()
+		go func() {
+			for range 8 {
+				conn.Write([]byte("ab"))
+			}
+		}()
+		return readSizes(t, client, 16)
+	}
+	first := fragments(1, 1)
+	if !slices.Equal(first, fragments(1, 1)) {
+		t.Errorf("the same seed gave different schedules")
+	}
+	if slices.Equal(first, fragments(1, 2)) && slices.Equal(first, fragments(2, 1)) {
+		t.Errorf("different streams and seeds gave the same schedule: %v", first)
+	}
+}
+
+func TestReset(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := NewConn(server, Config{Reset: 1}, 1)
+	if _, err := conn.Write([]byte("x")); !errors.Is(err, ErrReset) {
+		t.Errorf("got %v, want %v", err, ErrReset)
+	}
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, ErrReset) {
+		t.Errorf("got %v, want %v after a reset", err, ErrReset)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want the peer to see the connection closed", err)
+	}
+}
+
+// receive returns the datagrams received by conn until nothing arrives for a while
+func receive(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+	var received []string
+	buffer := make([]byte, 64)
+	for {
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return received
+		}
+		received = append(received, string(buffer[:n]))
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want []string
+	}{
+		{"none", Config{}, []string{"a", "b", "c"}},
+		{"drop", Config{Drop: 1}, nil},
+		{"duplicate", Config{Duplicate: 1}, []string{"a", "a", "b", "b", "c", "c"}},
+		// Every other datagram is held back, and delivered after the next one
+		{"reorder", Config{Reorder: 1}, []string{"b", "a", "c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn := NewPacketConn(udp, test.cfg)
+			defer conn.Close()
+			sender, err := net.Dial("udp", udp.LocalAddr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sender.Close()
+			for _, data := range []string{"a", "b", "c"} {
+				sender.Write([]byte(data))
+			}
+			if got := receive(t, conn); !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package chaos
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReset is returned by reads and writes of a connection that was reset by an injected fault
+var ErrReset = errors.New("chaos: connection reset")
+
+// schedule draws the faults of one direction of a connection
+type schedule struct {
+	cfg  Config
+	rand *rand.Rand
+}
+
+func newSchedule(cfg Config, stream, direction uint64) *schedule {
+	return &schedule{cfg: cfg, rand: rand.New(rand.NewPCG(cfg.Seed, stream<<1|direction))}
+}
+
+// hit returns true with probability p
+func (s *schedule) hit(p float64) bool {
+	return p > 0 && s.rand.Float64() < p
+}
+
+// latency returns a random delay up to the configured latency
+func (s *schedule) latency() time.Duration {
+	if s.cfg.Latency <= 0 {
+		return 0
+	}
+	return time.Duration(s.rand.Int64N(int64(s.cfg.Latency) + 1))
+}
+
+// Conn injects faults into a stream connection. Reads and writes each follow their own schedule, so that the faults
+// seen by one direction don't depend on the timing of the other.
+type Conn struct {
+	net.Conn
+
+	readMu   sync.Mutex
+	reads    *schedule
+	buffered []byte // data read from the connection but not returned yet, because of fragmentation
+
+	writeMu sync.Mutex
+	writes  *schedule
+
+	reset atomic.Bool
+}
+
+// NewConn wraps conn. stream selects the schedule derived from the seed, connections with different streams get
+// different faults.
+func NewConn(conn net.Conn, cfg Config, stream uint64) *Conn {
+	cfg = cfg.withDefaults()
+	return &Conn{Conn: conn, reads: newSchedule(cfg, stream, 0), writes: newSchedule(cfg, stream, 1)}
+}
+
+// resetConn closes the connection, with an RST instead of a FIN for tcp connections
+func (c *Conn) resetConn() error {
+	c.reset.Store(true)
+	if tcp, ok := c.Conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	c.Conn.Close()
+	return ErrReset
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.reset.Load() {
+		return 0, ErrReset
+	}
+	if len(c.buffered) > 0 {
+		return c.deliver(b), nil
+	}
+	s := c.reads
+	if s.hit(s.cfg.Reset) {
+		return 0, c.resetConn()
+	}
+	if s.hit(s.cfg.Stall) {
+		time.Sleep(s.cfg.StallDuration)
+	}
+	if s.hit(s.cfg.Coalesce) {
+		time.Sleep(s.cfg.CoalesceDelay)
+	}
+	if !s.hit(s.cfg.Fragment) || len(b) <= 1 {
+		return c.Conn.Read(b)
+	}
+	buffer := make([]byte, len(b))
+	n, err := c.Conn.Read(buffer)
+	c.buffered = buffer[:n]
+	if n == 0 {
+		return 0, err
+	}
+	// The error, if any, is returned by the next read once the buffered data is gone
+	return c.deliver(b), nil
+}
+
+// deliver returns a single byte of the buffered data
+func (c *Conn) deliver(b []byte) int {
+	b[0] = c.buffered[0]
+	c.buffered = c.buffered[1:]
+	return 1
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.reset.Load() {
+		return 0, ErrReset
+	}
+	s := c.writes
+	if s.hit(s.cfg.Reset) {
+		return 0, c.resetConn()
+	}
+	if s.hit(s.cfg.Stall) {
+		time.Sleep(s.cfg.StallDuration)
+	}
+	if d := s.latency(); d > 0 {
+		time.Sleep(d)
+	}
+	if !s.hit(s.cfg.Fragment) {
+		return c.Conn.Write(b)
+	}
+	for i := range b {
+		if _, err := c.Conn.Write(b[i : i+1]); err != nil {
+			return i, err
+		}
+	}
+	return len(b), nil
+}
+
+// Unwrap returns the wrapped connection
+func (c *Conn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// Listener wraps every accepted connection in a *Conn. Each connection gets the next stream of the seed
+type Listener struct {
+	net.Listener
+	cfg     Config
+	streams atomic.Uint64
+}
+
+// NewListener wraps the listener
+func NewListener(listener net.Listener, cfg Config) *Listener {
+	return &Listener{Listener: listener, cfg: cfg}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, l.cfg, l.streams.Add(1)), nil
+}
+
+type datagram struct {
+	data []byte
+	addr net.Addr
+}
+
+// PacketConn injects faults into a packet connection: datagrams are dropped, duplicated, reordered and delayed in both
+// directions
+type PacketConn struct {
+	net.PacketConn
+
+	readMu  sync.Mutex
+	reads   *schedule
+	pending []datagram // delivered before reading from the connection again
+	held    *datagram  // a reordered datagram, delivered after the next one
+
+	writeMu sync.Mutex
+	writes  *schedule
+}
+
+// NewPacketConn wraps conn
+func NewPacketConn(conn net.PacketConn, cfg Config) *PacketConn {
+	cfg = cfg.withDefaults()
+	return &PacketConn{PacketConn: conn, reads: newSchedule(cfg, 0, 0), writes: newSchedule(cfg, 0, 1)}
+}
+
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	s := c.reads
+	for {
+		if len(c.pending) > 0 {
+			d := c.pending[0]
+			c.pending = c.pending[1:]
+			return copy(b, d.data), d.addr, nil
+		}
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil {
+			if c.held != nil {
+				// Deliver the held datagram before the error, the next read will see the error again
+				d := *c.held
+				c.held = nil
+				return copy(b, d.data), d.addr, nil
+			}
+			return n, addr, err
+		}
+		if s.hit(s.cfg.Drop) {
+			continue
+		}
+		d := datagram{data: append([]byte(nil), b[:n]...), addr: addr}
+		if c.held == nil && s.hit(s.cfg.Reorder) {
+			c.held = &d
+			continue
+		}
+		if s.hit(s.cfg.Duplicate) {
+			c.pending = append(c.pending, d)
+		}
+		if c.held != nil {
+			c.pending = append(c.pending, *c.held)
+			c.held = nil
+		}
+		return n, addr, nil
+	}
+}
+
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	s := c.writes
+	if d := s.latency(); d > 0 {
+		time.Sleep(d)
+	}
+	if s.hit(s.cfg.Drop) {
+		return len(b), nil
+	}
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err == nil && s.hit(s.cfg.Duplicate) {
+		c.PacketConn.WriteTo(b, addr)
+	}
+	return n, err
+}
+
+// Unwrap returns the wrapped connection
+func (c *PacketConn) Unwrap() net.PacketConn {
+	return c.PacketConn
+}
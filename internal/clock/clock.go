@@ -0,0 +1,209 @@
+// Package clock lets the timers of the protocols run on a virtual clock in tests. Code that waits on timers takes a
+// Clock, which is Real in production. Tests pass a *Virtual instead, and move time forward with Advance, so that
+// retransmissions and heartbeats happen on demand rather than after waiting in wall-clock time.
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer delivers the time once on C, see time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker delivers the time on C every period, see time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// Real is the clock of the system
+var Real Clock = realClock{}
+
+// Or returns c, or Real if c is nil, so that a nil Clock in an options struct means the system clock
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// Epoch is the time a Virtual clock created by NewVirtual starts at
+var Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Virtual is a clock that only moves when Advance is called. Like the channels of the time package, the channels of its
+// timers have a buffer of one, and ticks are dropped while the previous one has not been received.
+type Virtual struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*virtualTimer // Active timers
+	changed chan struct{}   // Closed and replaced every time a timer is added
+}
+
+// NewVirtual creates a virtual clock set to Epoch
+func NewVirtual() *Virtual {
+	return &Virtual{now: Epoch, changed: make(chan struct{})}
+}
+
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+func (v *Virtual) NewTimer(d time.Duration) Timer {
+	return v.add(d, 0)
+}
+
+func (v *Virtual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return virtualTicker{v.add(d, d)}
+}
+
+func (v *Virtual) add(d, period time.Duration) *virtualTimer {
+	t := &virtualTimer{clock: v, c: make(chan time.Time, 1), period: period}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if period == 0 && d <= 0 {
+		// Expired already, like time.NewTimer
+		t.c <- v.now
+		return t
+	}
+	v.schedule(t, d)
+	return t
+}
+
+// schedule makes the timer fire after d. The caller must hold the lock
+func (v *Virtual) schedule(t *virtualTimer, d time.Duration) {
+	t.when = v.now.Add(d)
+	if !slices.Contains(v.timers, t) {
+		v.timers = append(v.timers, t)
+	}
+	close(v.changed)
+	v.changed = make(chan struct{})
+}
+
+// remove stops the timer, and returns true if it was active. The caller must hold the lock
+func (v *Virtual) remove(t *virtualTimer) bool {
+	i := slices.Index(v.timers, t)
+	if i < 0 {
+		return false
+	}
+	v.timers = slices.Delete(v.timers, i, i+1)
+	return true
+}
+
+// Advance moves the clock forward by d, firing every timer that expires on the way, in order
+func (v *Virtual) Advance(d time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	target := v.now.Add(d)
+	for {
+		var next *virtualTimer
+		for _, t := range v.timers {
+			if !t.when.After(target) && (next == nil || t.when.Before(next.when)) {
+				next = t
+			}
+		}
+		if next == nil {
+			v.now = target
+			return
+		}
+		v.now = next.when
+		select {
+		case next.c <- v.now:
+		default:
+		}
+		if next.period > 0 {
+			next.when = next.when.Add(next.period)
+		} else {
+			v.remove(next)
+		}
+	}
+}
+
+// Timers returns the number of active timers and tickers
+func (v *Virtual) Timers() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.timers)
+}
+
+// WaitTimers blocks until at least n timers and tickers are active. Tests call it before Advance, so that the
+// goroutine that creates a timer has done so before time moves on
+func (v *Virtual) WaitTimers(n int) {
+	for {
+		v.mu.Lock()
+		active, changed := len(v.timers), v.changed
+		v.mu.Unlock()
+		if active >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+type virtualTimer struct {
+	clock  *Virtual
+	c      chan time.Time
+	when   time.Time
+	period time.Duration // Zero for timers
+}
+
+func (t *virtualTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *virtualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+type virtualTicker struct {
+	*virtualTimer
+}
+
+func (t virtualTicker) Stop() {
+	t.virtualTimer.Stop()
+}
+
+func (t virtualTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("clock: non-positive interval for Reset")
+	}
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.period = d
+	t.clock.schedule(t.virtualTimer, d)
+}
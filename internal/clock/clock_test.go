@@ -0,0 +1,98 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtualTimers(t *testing.T) {
+	v := NewVirtual()
+	late := v.NewTimer(time.Second * 2)
+	early := v.NewTimer(time.Second)
+	if v.Timers() != 2 {
+		t.Fatalf("got %d timers, want 2", v.Timers())
+	}
+
+	v.Advance(time.Millisecond * 999)
+	select {
+	case <-early.C():
+		t.Fatal("timer fired before its time")
+	default:
+	}
+	v.Advance(time.Millisecond)
+	if got := <-early.C(); !got.Equal(Epoch.Add(time.Second)) {
+		t.Errorf("timer fired at %v, want %v", got, Epoch.Add(time.Second))
+	}
+	if early.Stop() {
+		t.Error("Stop returned true for a timer that has fired")
+	}
+	if !late.Stop() {
+		t.Error("Stop returned false for an active timer")
+	}
+	v.Advance(time.Second * 5)
+	select {
+	case <-late.C():
+		t.Error("stopped timer fired")
+	default:
+	}
+	if got, want := v.Now(), Epoch.Add(time.Second*6); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	expired := v.NewTimer(0)
+	select {
+	case <-expired.C():
+	default:
+		t.Error("timer of zero duration did not fire immediately")
+	}
+}
+
+func TestVirtualTicker(t *testing.T) {
+	v := NewVirtual()
+	ticker := v.NewTicker(time.Second)
+	for i := 1; i <= 3; i++ {
+		v.Advance(time.Second)
+		if got, want := <-ticker.C(), Epoch.Add(time.Second*time.Duration(i)); !got.Equal(want) {
+			t.Errorf("tick %d at %v, want %v", i, got, want)
+		}
+	}
+
+	// Ticks are dropped while the previous one has not been received
+	v.Advance(time.Second * 3)
+	if got, want := <-ticker.C(), Epoch.Add(time.Second*4); !got.Equal(want) {
+		t.Errorf("got tick at %v, want %v", got, want)
+	}
+	select {
+	case <-ticker.C():
+		t.Error("got more than one pending tick")
+	default:
+	}
+
+	ticker.Reset(time.Second * 10)
+	v.Advance(time.Second * 9)
+	select {
+	case <-ticker.C():
+		t.Error("ticker fired before the new interval")
+	default:
+	}
+	v.Advance(time.Second)
+	<-ticker.C()
+
+	ticker.Stop()
+	if v.Timers() != 0 {
+		t.Errorf("got %d timers after Stop, want 0", v.Timers())
+	}
+}
+
+func TestWaitTimers(t *testing.T) {
+	v := NewVirtual()
+	fired := make(chan time.Time)
+	go func() {
+		fired <- <-v.NewTimer(time.Minute).C()
+	}()
+	v.WaitTimers(1)
+	v.Advance(time.Minute)
+	if got := <-fired; !got.Equal(Epoch.Add(time.Minute)) {
+		t.Errorf("got %v, want %v", got, Epoch.Add(time.Minute))
+	}
+}
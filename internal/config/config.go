@@ -0,0 +1,200 @@
+// Package config fills command line flags from a JSON config file and PROTOHACKERS_* environment variables, so that
+// every setting that can be given as a flag can also be given in the other two ways.
+//
+// The keys of the config file are flag names. Nested objects are flattened by joining the keys with "-", so
+// {"speed": {"queue-size": 200}} sets -speed-queue-size. The environment variable of a flag is its name in upper case,
+// with "-" replaced by "_", and prefixed by EnvPrefix: PROTOHACKERS_SPEED_QUEUE_SIZE.
+//
+// Flags given on the command line take precedence over environment variables, which take precedence over the config
+// file.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of the environment variables that set flags
+const EnvPrefix = "PROTOHACKERS_"
+
+// EnvName returns the name of the environment variable that sets the flag
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// ReadFile reads a JSON config file, and returns the value of every flag in it
+func ReadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// Decode parses the contents of a config file. Values may be strings, numbers, booleans or arrays of those, which
+// are joined with commas.
+func Decode(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var root map[string]any
+	if err := decoder.Decode(&root); err != nil {
+		return nil, fmt.Errorf("invalid config file: %w", err)
+	}
+	values := map[string]string{}
+	if err := flatten("", root, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func flatten(prefix string, object map[string]any, values map[string]string) error {
+	for key, value := range object {
+		name := key
+		if prefix != "" {
+			name = prefix + "-" + key
+		}
+		if nested, ok := value.(map[string]any); ok {
+			if err := flatten(name, nested, values); err != nil {
+				return err
+			}
+			continue
+		}
+		s, err := scalar(value)
+		if err != nil {
+			return fmt.Errorf("config key %q: %w", name, err)
+		}
+		if _, ok := values[name]; ok {
+			return fmt.Errorf("config key %q is set more than once", name)
+		}
+		values[name] = s
+	}
+	return nil
+}
+
+// scalar converts a JSON value to the string form accepted by flag.Value.Set
+func scalar(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			s, err := scalar(item)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), nil
+	case nil:
+		return "", errors.New("null is not a valid value")
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// FromEnv returns the value of every flag set by a PROTOHACKERS_* variable in environ. A variable with the prefix
+// that does not match any flag is an error, since it's most likely a typo.
+func FromEnv(fs *flag.FlagSet, environ []string) (map[string]string, error) {
+	names := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		names[EnvName(f.Name)] = f.Name
+	})
+	values := map[string]string{}
+	for _, entry := range environ {
+		key, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(key, EnvPrefix) {
+			continue
+		}
+		name, ok := names[key]
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s does not match any setting", key)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// Apply sets the flags that were not given on the command line, first from the config file at path (if not empty),
+// and then from the environment. fs must already have been parsed.
+func Apply(fs *flag.FlagSet, path string, environ []string) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	set := func(values map[string]string, source string) error {
+		// Sorted, so that the first error is always the same
+		for _, name := range slices.Sorted(maps.Keys(values)) {
+			if explicit[name] {
+				continue
+			}
+			if fs.Lookup(name) == nil {
+				return fmt.Errorf("%s: unknown setting %q", source, name)
+			}
+			if err := fs.Set(name, values[name]); err != nil {
+				return fmt.Errorf("%s: invalid value %q for %q: %w", source, values[name], name, err)
+			}
+		}
+		return nil
+	}
+
+	if path != "" {
+		values, err := ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := set(values, path); err != nil {
+			return err
+		}
+	}
+	values, err := FromEnv(fs, environ)
+	if err != nil {
+		return err
+	}
+	return set(values, "environment")
+}
+
+// Print writes the effective value of every flag as a JSON config file, which can be loaded again with Apply. Flags
+// named in skip are left out.
+func Print(w io.Writer, fs *flag.FlagSet, skip ...string) error {
+	values := map[string]any{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(skip, f.Name) {
+			return
+		}
+		values[f.Name] = effective(f)
+	})
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(values)
+}
+
+// effective returns the value of the flag, typed so that it's encoded naturally in JSON
+func effective(f *flag.Flag) any {
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return f.Value.String()
+	}
+	switch v := getter.Get().(type) {
+	case bool, int, int64, uint, uint64, float64:
+		return v
+	case time.Duration:
+		return v.String()
+	}
+	return f.Value.String()
+}
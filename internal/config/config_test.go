@@ -0,0 +1,137 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type settings struct {
+	port      uint
+	host      string
+	queueSize int
+	timeout   time.Duration
+	verbose   bool
+}
+
+func newFlagSet(s *settings) *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.UintVar(&s.port, "port", 8000, "")
+	fs.StringVar(&s.host, "host", "0.0.0.0", "")
+	fs.IntVar(&s.queueSize, "speed-queue-size", 100, "")
+	fs.DurationVar(&s.timeout, "drain-timeout", time.Second*10, "")
+	fs.BoolVar(&s.verbose, "verbose", false, "")
+	return fs
+}
+
+func writeFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDecode(t *testing.T) {
+	values, err := Decode([]byte(`{"port": 9000, "speed": {"queue-size": 5}, "list": ["a", "b"], "verbose": true, "ratio": 0.5}`))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	want := map[string]string{"port": "9000", "speed-queue-size": "5", "list": "a,b", "verbose": "true", "ratio": "0.5"}
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("%s: got %q, want %q", key, values[key], value)
+		}
+	}
+	if len(values) != len(want) {
+		t.Errorf("got %d values, want %d", len(values), len(want))
+	}
+
+	invalid := []string{
+		`{"port": null}`,
+		`{"speed-queue-size": 1, "speed": {"queue-size": 2}}`,
+		`[1, 2]`,
+		`{"port": 9000`,
+	}
+	for _, input := range invalid {
+		if _, err := Decode([]byte(input)); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	var s settings
+	fs := newFlagSet(&s)
+	if err := fs.Parse([]string{"-port", "1234"}); err != nil {
+		t.Fatal(err)
+	}
+	path := writeFile(t, `{"port": 9000, "host": "127.0.0.1", "speed": {"queue-size": 5}, "drain-timeout": "1s"}`)
+	environ := []string{"PROTOHACKERS_HOST=10.0.0.1", "HOME=/root", "PROTOHACKERS_VERBOSE=true"}
+	if err := Apply(fs, path, environ); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	// The command line wins over the file, and the environment wins over the file
+	if s.port != 1234 || s.host != "10.0.0.1" || s.queueSize != 5 || s.timeout != time.Second || !s.verbose {
+		t.Errorf("got %+v", s)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		environ []string
+		err     string
+	}{
+		{"unknown key", `{"queue-size": 5}`, nil, "unknown setting"},
+		{"invalid value", `{"port": -1}`, nil, "invalid value"},
+		{"invalid duration", `{"drain-timeout": "soon"}`, nil, "invalid value"},
+		{"unknown variable", `{}`, []string{"PROTOHACKERS_PROT=1"}, "PROTOHACKERS_PROT"},
+		{"invalid variable", `{}`, []string{"PROTOHACKERS_SPEED_QUEUE_SIZE=many"}, "invalid value"},
+	}
+	for _, test := range tests {
+		var s settings
+		fs := newFlagSet(&s)
+		fs.Parse(nil)
+		err := Apply(fs, writeFile(t, test.file), test.environ)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got %v, want an error containing %q", test.name, err, test.err)
+		}
+	}
+	var s settings
+	if err := Apply(newFlagSet(&s), filepath.Join(t.TempDir(), "missing.json"), nil); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestPrintRoundTrip(t *testing.T) {
+	var s settings
+	fs := newFlagSet(&s)
+	fs.Parse([]string{"-port", "1234", "-drain-timeout", "3s", "-verbose"})
+	var output bytes.Buffer
+	if err := Print(&output, fs, "host"); err != nil {
+		t.Fatalf("Print failed: %v", err)
+	}
+	if strings.Contains(output.String(), "host") {
+		t.Errorf("skipped flag was printed: %s", output.String())
+	}
+
+	// The printed config loads back to the same settings
+	var loaded settings
+	loadedFs := newFlagSet(&loaded)
+	loadedFs.Parse(nil)
+	if err := Apply(loadedFs, writeFile(t, output.String()), nil); err != nil {
+		t.Fatalf("Apply failed: %v\n%s", err, output.String())
+	}
+	if loaded != s {
+		t.Errorf("got %+v, want %+v", loaded, s)
+	}
+}
@@ -0,0 +1,154 @@
+// Package limit implements the connection caps and rate limits applied by the runner to every service.
+package limit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket allows events at an average rate, with bursts of up to burst events. It is safe for concurrent use. A nil
+// bucket allows every event
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a bucket that starts full
+func NewTokenBucket(rate float64, burst int, now time.Time) *TokenBucket {
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// refill adds the tokens accumulated since the last call. The caller must hold the lock
+func (b *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+// AllowAt takes a token from the bucket, and returns false if there were none left
+func (b *TokenBucket) AllowAt(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *TokenBucket) Allow() bool {
+	return b.AllowAt(time.Now())
+}
+
+// ReserveAt takes n tokens from the bucket, even if it doesn't hold them yet, and returns how long to wait until the
+// tokens are available. Events that wait for their reservation keep the average rate, whatever their size
+func (b *TokenBucket) ReserveAt(n int, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// unreserve gives back n tokens taken by ReserveAt, for an event that is not going to happen
+func (b *TokenBucket) unreserve(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+float64(n))
+}
+
+// Wait takes a token from the bucket, waiting until one is available or ctx is done. It returns how long it waited
+func (b *TokenBucket) Wait(ctx context.Context) (time.Duration, error) {
+	delay := b.ReserveAt(1, time.Now())
+	if delay <= 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// Burst returns the number of tokens the bucket holds when it's full
+func (b *TokenBucket) Burst() int {
+	return int(b.burst)
+}
+
+// isFull returns true if the bucket has refilled completely, which means it can be dropped without changing behaviour
+func (b *TokenBucket) isFull(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// sweepInterval is how often idle buckets are removed from a KeyedBuckets
+const sweepInterval = time.Minute
+
+// KeyedBuckets holds a token bucket for every key (usually a source IP). Buckets that have been idle long enough to
+// refill are removed periodically, so that memory use is bounded by the number of recently active keys.
+type KeyedBuckets struct {
+	rate  float64
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*TokenBucket
+	lastSweep time.Time
+}
+
+func NewKeyedBuckets(rate float64, burst int) *KeyedBuckets {
+	return &KeyedBuckets{rate: rate, burst: burst, buckets: map[string]*TokenBucket{}}
+}
+
+// AllowAt takes a token from the bucket for the key
+func (k *KeyedBuckets) AllowAt(key string, now time.Time) bool {
+	k.mu.Lock()
+	if now.Sub(k.lastSweep) >= sweepInterval {
+		for key, bucket := range k.buckets {
+			if bucket.isFull(now) {
+				delete(k.buckets, key)
+			}
+		}
+		k.lastSweep = now
+	}
+	bucket, ok := k.buckets[key]
+	if !ok {
+		bucket = NewTokenBucket(k.rate, k.burst, now)
+		k.buckets[key] = bucket
+	}
+	k.mu.Unlock()
+	return bucket.AllowAt(now)
+}
+
+func (k *KeyedBuckets) Allow(key string) bool {
+	return k.AllowAt(key, time.Now())
+}
+
+// Len returns the number of buckets currently being tracked
+func (k *KeyedBuckets) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.buckets)
+}
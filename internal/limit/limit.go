@@ -0,0 +1,190 @@
+package limit
+
+import (
+	"errors"
+	"flag"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Config holds the limits applied to a single service. A zero value means that the limit is disabled.
+type Config struct {
+	// MaxConns is the maximum number of connections handled at the same time
+	MaxConns int
+	// MaxConnsPerIP is the maximum number of connections from a single source IP handled at the same time
+	MaxConnsPerIP int
+	// ConnRate is the number of new connections per second accepted from a single source IP
+	ConnRate  float64
+	ConnBurst int
+	// PacketRate is the number of packets per second accepted from a single source IP by udp services
+	PacketRate  float64
+	PacketBurst int
+	// ByteRate is the number of bytes per second read from each connection, and written to it. Connections that go over
+	// it are slowed down
+	ByteRate  float64
+	ByteBurst int
+	// ServiceByteRate is the number of bytes per second read from all the connections of a service together, and
+	// written to them
+	ServiceByteRate  float64
+	ServiceByteBurst int
+}
+
+// RegisterFlags binds the limits to command line flags
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	c.RegisterConnFlags(fs)
+	c.RegisterPacketFlags(fs)
+}
+
+// RegisterConnFlags binds the limits of stream connections to command line flags: the connection counts and rates, and
+// the byte rates
+func (c *Config) RegisterConnFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.MaxConns, "max-conns", c.MaxConns, "maximum number of concurrent connections per service (0 for unlimited)")
+	fs.IntVar(&c.MaxConnsPerIP, "max-conns-per-ip", c.MaxConnsPerIP, "maximum number of concurrent connections from a single IP (0 for unlimited)")
+	fs.Float64Var(&c.ConnRate, "conn-rate", c.ConnRate, "new connections per second allowed from a single IP (0 for unlimited)")
+	fs.IntVar(&c.ConnBurst, "conn-burst", c.ConnBurst, "burst size of the connection rate limit (defaults to the rate)")
+	fs.Float64Var(&c.ByteRate, "byte-rate", c.ByteRate, "bytes per second read from and written to each connection, in each direction (0 for unlimited)")
+	fs.IntVar(&c.ByteBurst, "byte-burst", c.ByteBurst, "burst size of the per connection byte rate limit (defaults to the rate)")
+	fs.Float64Var(&c.ServiceByteRate, "service-byte-rate", c.ServiceByteRate, "bytes per second read from and written to all the connections of a service, in each direction (0 for unlimited)")
+	fs.IntVar(&c.ServiceByteBurst, "service-byte-burst", c.ServiceByteBurst, "burst size of the per service byte rate limit (defaults to the rate)")
+}
+
+// RegisterPacketFlags binds the limits of udp packets to command line flags
+func (c *Config) RegisterPacketFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&c.PacketRate, "packet-rate", c.PacketRate, "udp packets per second allowed from a single IP (0 for unlimited)")
+	fs.IntVar(&c.PacketBurst, "packet-burst", c.PacketBurst, "burst size of the packet rate limit (defaults to the rate)")
+}
+
+// Validate checks that none of the limits are negative
+func (c Config) Validate() error {
+	if c.MaxConns < 0 || c.MaxConnsPerIP < 0 || c.ConnRate < 0 || c.ConnBurst < 0 || c.PacketRate < 0 || c.PacketBurst < 0 ||
+		c.ByteRate < 0 || c.ByteBurst < 0 || c.ServiceByteRate < 0 || c.ServiceByteBurst < 0 {
+		return errors.New("limits must not be negative")
+	}
+	return nil
+}
+
+// burstFor returns the burst to use for a rate limit, when it's not set explicitly
+func burstFor(rate float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	return max(1, int(math.Ceil(rate)))
+}
+
+// Reasons for rejecting a connection
+const (
+	ReasonMaxConns      = "max_conns"
+	ReasonMaxConnsPerIP = "max_conns_per_ip"
+	ReasonRate          = "rate"
+)
+
+// Host returns the IP address part of the address, used as the key of per IP limits
+func Host(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Limiter keeps track of the connections of a service, and decides whether new connections are allowed
+type Limiter struct {
+	cfg Config
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+
+	connRate   *KeyedBuckets
+	packetRate *KeyedBuckets
+	// Shared by the connections of the service, nil unless ServiceByteRate is set
+	serviceReads, serviceWrites *TokenBucket
+}
+
+func New(cfg Config) *Limiter {
+	l := &Limiter{cfg: cfg, perIP: map[string]int{}}
+	if cfg.ConnRate > 0 {
+		l.connRate = NewKeyedBuckets(cfg.ConnRate, burstFor(cfg.ConnRate, cfg.ConnBurst))
+	}
+	if cfg.PacketRate > 0 {
+		l.packetRate = NewKeyedBuckets(cfg.PacketRate, burstFor(cfg.PacketRate, cfg.PacketBurst))
+	}
+	if cfg.ServiceByteRate > 0 {
+		burst := burstFor(cfg.ServiceByteRate, cfg.ServiceByteBurst)
+		l.serviceReads = NewTokenBucket(cfg.ServiceByteRate, burst, time.Now())
+		l.serviceWrites = NewTokenBucket(cfg.ServiceByteRate, burst, time.Now())
+	}
+	return l
+}
+
+// Admit checks whether a new connection from the address is allowed. If it is, the returned release function must be
+// called once the connection is closed. Otherwise, release is nil and reason is one of the Reason* constants.
+func (l *Limiter) Admit(addr net.Addr) (release func(), reason string) {
+	host := Host(addr)
+	if l.connRate != nil && !l.connRate.Allow(host) {
+		return nil, ReasonRate
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg.MaxConns > 0 && l.total >= l.cfg.MaxConns {
+		return nil, ReasonMaxConns
+	}
+	if l.cfg.MaxConnsPerIP > 0 && l.perIP[host] >= l.cfg.MaxConnsPerIP {
+		return nil, ReasonMaxConnsPerIP
+	}
+	l.total++
+	l.perIP[host]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.total--
+			l.perIP[host]--
+			if l.perIP[host] == 0 {
+				delete(l.perIP, host)
+			}
+		})
+	}, ""
+}
+
+// AllowPacket returns false if a packet from the address exceeds the packet rate limit
+func (l *Limiter) AllowPacket(addr net.Addr) bool {
+	return l.packetRate == nil || l.packetRate.Allow(Host(addr))
+}
+
+// HasPacketLimit returns true if packets need to be checked with AllowPacket
+func (l *Limiter) HasPacketLimit() bool {
+	return l.packetRate != nil
+}
+
+// PacketConn drops incoming packets that exceed the packet rate limit of the limiter. Dropped packets are reported
+// to onDrop, and never returned from ReadFrom.
+type PacketConn struct {
+	net.PacketConn
+	limiter *Limiter
+	onDrop  func(addr net.Addr)
+}
+
+func NewPacketConn(conn net.PacketConn, limiter *Limiter, onDrop func(addr net.Addr)) *PacketConn {
+	return &PacketConn{PacketConn: conn, limiter: limiter, onDrop: onDrop}
+}
+
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || c.limiter.AllowPacket(addr) {
+			return n, addr, err
+		}
+		if c.onDrop != nil {
+			c.onDrop(addr)
+		}
+	}
+}
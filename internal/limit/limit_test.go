@@ -0,0 +1,297 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(1000, 0)
+	bucket := NewTokenBucket(2, 3, start)
+
+	// The bucket starts full
+	for i := range 3 {
+		if !bucket.AllowAt(start) {
+			t.Fatalf("event %d rejected, want allowed", i)
+		}
+	}
+	if bucket.AllowAt(start) {
+		t.Fatalf("event allowed with an empty bucket")
+	}
+
+	// 2 tokens per second, so one token is available after half a second
+	if !bucket.AllowAt(start.Add(time.Millisecond * 500)) {
+		t.Errorf("event rejected after refill")
+	}
+	if bucket.AllowAt(start.Add(time.Millisecond * 500)) {
+		t.Errorf("event allowed, but only one token should have been added")
+	}
+
+	// The bucket never holds more than burst tokens
+	later := start.Add(time.Hour)
+	allowed := 0
+	for bucket.AllowAt(later) {
+		allowed++
+	}
+	if allowed != 3 {
+		t.Errorf("got %d events after a long idle period, want 3", allowed)
+	}
+}
+
+func TestKeyedBucketsSweep(t *testing.T) {
+	start := time.Unix(1000, 0)
+	buckets := NewKeyedBuckets(1, 1)
+	buckets.AllowAt("a", start)
+	buckets.AllowAt("b", start)
+	if !buckets.AllowAt("c", start) || buckets.AllowAt("c", start) {
+		t.Errorf("keys should have independent buckets")
+	}
+	if buckets.Len() != 3 {
+		t.Fatalf("got %d buckets, want 3", buckets.Len())
+	}
+	// After the sweep interval, every bucket has refilled and is dropped, except the one that was just used
+	buckets.AllowAt("a", start.Add(sweepInterval*2))
+	if buckets.Len() != 1 {
+		t.Errorf("got %d buckets after sweep, want 1", buckets.Len())
+	}
+}
+
+func addr(s string) net.Addr {
+	a, err := net.ResolveTCPAddr("tcp", s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func TestLimiterAdmit(t *testing.T) {
+	l := New(Config{MaxConns: 3, MaxConnsPerIP: 2})
+
+	release1, _ := l.Admit(addr("10.0.0.1:1000"))
+	release2, _ := l.Admit(addr("10.0.0.1:1001"))
+	if release1 == nil || release2 == nil {
+		t.Fatalf("connections under the limit were rejected")
+	}
+	if release, reason := l.Admit(addr("10.0.0.1:1002")); release != nil || reason != ReasonMaxConnsPerIP {
+		t.Errorf("got reason %q, want %q", reason, ReasonMaxConnsPerIP)
+	}
+	release3, _ := l.Admit(addr("10.0.0.2:1000"))
+	if release3 == nil {
+		t.Fatalf("connection from another IP was rejected")
+	}
+	if release, reason := l.Admit(addr("10.0.0.3:1000")); release != nil || reason != ReasonMaxConns {
+		t.Errorf("got reason %q, want %q", reason, ReasonMaxConns)
+	}
+
+	// Releasing twice must only free one slot
+	release1()
+	release1()
+	if release, _ := l.Admit(addr("10.0.0.1:1003")); release == nil {
+		t.Errorf("connection rejected after a slot was released")
+	}
+	if release, reason := l.Admit(addr("10.0.0.4:1000")); release != nil || reason != ReasonMaxConns {
+		t.Errorf("got reason %q, want %q", reason, ReasonMaxConns)
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	l := New(Config{ConnRate: 1, ConnBurst: 2})
+	for range 2 {
+		if release, _ := l.Admit(addr("10.0.0.1:1000")); release == nil {
+			t.Fatalf("connection within burst rejected")
+		}
+	}
+	if release, reason := l.Admit(addr("10.0.0.1:1000")); release != nil || reason != ReasonRate {
+		t.Errorf("got reason %q, want %q", reason, ReasonRate)
+	}
+	if release, _ := l.Admit(addr("10.0.0.2:1000")); release == nil {
+		t.Errorf("rate limit of one IP affected another")
+	}
+}
+
+func TestHost(t *testing.T) {
+	tests := []struct {
+		in   net.Addr
+		want string
+	}{
+		{addr("10.0.0.1:80"), "10.0.0.1"},
+		{addr("[::1]:80"), "::1"},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		if got := Host(test.in); got != test.want {
+			t.Errorf("Host(%v) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestReserve(t *testing.T) {
+	start := time.Unix(1000, 0)
+	bucket := NewTokenBucket(100, 10, start)
+	if d := bucket.ReserveAt(10, start); d != 0 {
+		t.Errorf("got delay %v for a full bucket, want 0", d)
+	}
+	// The bucket is empty, 50 tokens take half a second at 100 tokens per second
+	if d := bucket.ReserveAt(50, start); d != time.Millisecond*500 {
+		t.Errorf("got delay %v, want 500ms", d)
+	}
+	// Reservations queue up behind the earlier ones
+	if d := bucket.ReserveAt(10, start.Add(time.Millisecond*100)); d != time.Millisecond*500 {
+		t.Errorf("got delay %v, want 500ms", d)
+	}
+
+	var disabled *TokenBucket
+	if d := disabled.ReserveAt(1000, start); d != 0 || !disabled.AllowAt(start) {
+		t.Errorf("a nil bucket must allow everything")
+	}
+}
+
+func TestRate(t *testing.T) {
+	if (Rate{}).NewBucket(time.Now()) != nil {
+		t.Errorf("the zero rate must not create a bucket")
+	}
+	start := time.Unix(1000, 0)
+	bucket := Rate{PerSecond: 2}.NewBucket(start)
+	if !bucket.AllowAt(start) || !bucket.AllowAt(start) || bucket.AllowAt(start) {
+		t.Errorf("the burst must default to the rate")
+	}
+	if err := (Rate{PerSecond: -1}).Validate(); err == nil {
+		t.Errorf("negative rate accepted")
+	}
+}
+
+func TestConnShaping(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	var throttled time.Duration
+	conn := NewConn(context.Background(), server, New(Config{ByteRate: 1000, ByteBurst: 100}), 0, func(d time.Duration) { throttled += d })
+
+	// The first 100 bytes are sent at once, the next 200 take 200ms at 1000 bytes per second
+	start := time.Now()
+	if n, err := conn.Write(make([]byte, 300)); n != 300 || err != nil {
+		t.Fatalf("got %d, %v, want 300 bytes written", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*150 || elapsed > time.Second*2 {
+		t.Errorf("writing took %v, want about 200ms", elapsed)
+	}
+	if throttled < time.Millisecond*150 {
+		t.Errorf("got %v of throttling, want about 200ms", throttled)
+	}
+
+	// Closing the connection interrupts a write that is waiting
+	result := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 1000))
+		result <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	conn.Close()
+	select {
+	case err := <-result:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("got %v, want %v", err, net.ErrClosed)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("write still waiting after Close")
+	}
+}
+
+func TestConnServiceRate(t *testing.T) {
+	limiter := New(Config{ServiceByteRate: 1000, ServiceByteBurst: 100})
+	if !limiter.HasByteLimit() {
+		t.Fatalf("service byte rate not enabled")
+	}
+	// Reads are split to fit in the bucket, and the two connections share it
+	var conns []*Conn
+	for range 2 {
+		server, client := net.Pipe()
+		defer client.Close()
+		go client.Write(make([]byte, 150))
+		conns = append(conns, NewConn(context.Background(), server, limiter, 0, nil))
+	}
+	start := time.Now()
+	buffer := make([]byte, 1000)
+	total := 0
+	for _, conn := range conns {
+		for read := 0; read < 150; {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n > 100 {
+				t.Fatalf("got a read of %d bytes, larger than the burst", n)
+			}
+			read += n
+			total += n
+		}
+	}
+	// 300 bytes with a burst of 100 take 200ms at 1000 bytes per second
+	if elapsed := time.Since(start); elapsed < time.Millisecond*150 {
+		t.Errorf("reading %d bytes took %v, want about 200ms", total, elapsed)
+	}
+}
+
+func TestConnMaxDelay(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	limiter := New(Config{ServiceByteRate: 100, ServiceByteBurst: 100})
+	conn := NewConn(context.Background(), server, limiter, time.Millisecond*500, nil)
+
+	// The second write would wait a second, longer than the largest delay, and its tokens are given back
+	if _, err := conn.Write(make([]byte, 100)); err != nil {
+		t.Fatalf("first write failed: %v", err)
+	}
+	if _, err := conn.Write(make([]byte, 100)); !errors.Is(err, ErrThrottled) {
+		t.Fatalf("got %v, want %v", err, ErrThrottled)
+	}
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 10)); err != nil {
+		t.Fatalf("small write failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*400 {
+		t.Errorf("small write waited %v, the failed write was still charged", elapsed)
+	}
+}
+
+func TestConnWaitStops(t *testing.T) {
+	for _, name := range []string{"inner close", "context"} {
+		t.Run(name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			go io.Copy(io.Discard, client)
+			inner := timeout.New(server, timeout.Policy{})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			conn := NewConn(ctx, inner, New(Config{ByteRate: 100, ByteBurst: 100}), time.Minute, nil)
+			conn.Write(make([]byte, 100))
+
+			result := make(chan error, 1)
+			go func() {
+				_, err := conn.Write(make([]byte, 100))
+				result <- err
+			}()
+			time.Sleep(time.Millisecond * 50)
+			if name == "context" {
+				cancel()
+			} else {
+				inner.Close()
+			}
+			select {
+			case err := <-result:
+				if err == nil {
+					t.Errorf("write succeeded, want an error")
+				}
+			case <-time.After(time.Second * 2):
+				t.Fatalf("write still waiting")
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package limit
+
+import (
+	"errors"
+	"flag"
+	"time"
+)
+
+// Rate limits the messages of a single connection, such as the lines of a line based protocol. What happens to clients
+// that go over it is up to the protocol. The zero value disables the limit
+type Rate struct {
+	// PerSecond is the average number of messages allowed per second
+	PerSecond float64
+	// Burst is the number of messages allowed at once. It defaults to PerSecond
+	Burst int
+}
+
+// RegisterFlags binds the rate to the flags prefix+"rate" and prefix+"burst". messages names what is limited, for the
+// help text
+func (r *Rate) RegisterFlags(fs *flag.FlagSet, prefix, messages string) {
+	fs.Float64Var(&r.PerSecond, prefix+"rate", r.PerSecond, messages+" per second allowed on each connection (0 for unlimited)")
+	fs.IntVar(&r.Burst, prefix+"burst", r.Burst, "burst size of the "+messages+" rate limit (defaults to the rate)")
+}
+
+// Validate checks that the rate is not negative
+func (r Rate) Validate() error {
+	if r.PerSecond < 0 || r.Burst < 0 {
+		return errors.New("rate limits must not be negative")
+	}
+	return nil
+}
+
+// Enabled returns true if the rate limits anything
+func (r Rate) Enabled() bool {
+	return r.PerSecond > 0
+}
+
+// NewBucket returns the bucket of a connection, which starts full. It returns nil if the limit is disabled, which
+// allows every message
+func (r Rate) NewBucket(now time.Time) *TokenBucket {
+	if !r.Enabled() {
+		return nil
+	}
+	return NewTokenBucket(r.PerSecond, burstFor(r.PerSecond, r.Burst), now)
+}
@@ -0,0 +1,150 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/netutil"
+)
+
+// HasByteLimit returns true if connections need to be wrapped with NewConn
+func (l *Limiter) HasByteLimit() bool {
+	return l.cfg.ByteRate > 0 || l.serviceReads != nil
+}
+
+// DefaultMaxDelay bounds the wait of a single read or write when no other bound is given to NewConn
+const DefaultMaxDelay = 10 * time.Second
+
+// ErrThrottled is returned by writes that would have to wait longer than the largest delay of the connection
+var ErrThrottled = errors.New("limit: write throttled for too long")
+
+// Conn slows down the reads and writes of a connection to the byte rates of the limiter. Reads wait once the data has
+// been read, so that a client that sends too fast is held back by TCP flow control. Writes wait before the data is
+// written. The time spent waiting is reported to onThrottle.
+//
+// A wait never takes longer than the largest delay of the connection, so that many connections sharing the bucket of
+// the service can't push each other back indefinitely: writes that would wait longer fail with ErrThrottled, and reads
+// wait for the largest delay. Waits also stop when the connection (or the connection it wraps) is closed, or when the
+// context is done
+type Conn struct {
+	net.Conn
+	// reads and writes hold the bucket of the connection, followed by the bucket of the service. Either may be nil
+	reads, writes [2]*TokenBucket
+	// chunk is the largest read or write, so that a single call never needs more than a full bucket
+	chunk      int
+	maxDelay   time.Duration
+	onThrottle func(time.Duration)
+
+	ctx       context.Context
+	closed    chan struct{}
+	closeOnce sync.Once
+	// inner is closed when a wrapped connection is closed, nil if none of them tells
+	inner <-chan struct{}
+}
+
+// closeNotifier is implemented by connections that tell when they are closed, such as timeout.Conn
+type closeNotifier interface {
+	Done() <-chan struct{}
+}
+
+// NewConn wraps conn. maxDelay bounds the wait of a single read or write, zero uses DefaultMaxDelay. onThrottle may be
+// nil
+func NewConn(ctx context.Context, conn net.Conn, limiter *Limiter, maxDelay time.Duration, onThrottle func(time.Duration)) *Conn {
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+	c := &Conn{Conn: conn, maxDelay: maxDelay, onThrottle: onThrottle, ctx: ctx, closed: make(chan struct{})}
+	if notifier, ok := netutil.Find[closeNotifier](conn); ok {
+		c.inner = notifier.Done()
+	}
+	c.reads[1], c.writes[1] = limiter.serviceReads, limiter.serviceWrites
+	cfg := limiter.cfg
+	if cfg.ByteRate > 0 {
+		burst := burstFor(cfg.ByteRate, cfg.ByteBurst)
+		c.reads[0] = NewTokenBucket(cfg.ByteRate, burst, time.Now())
+		c.writes[0] = NewTokenBucket(cfg.ByteRate, burst, time.Now())
+	}
+	for _, bucket := range c.reads {
+		if bucket != nil && (c.chunk == 0 || bucket.Burst() < c.chunk) {
+			c.chunk = bucket.Burst()
+		}
+	}
+	return c
+}
+
+// wait takes n tokens from each bucket, and waits until all of them are available. A wait longer than maxDelay gives
+// the tokens back: it fails with ErrThrottled if strict is set, and waits for maxDelay otherwise. It returns
+// net.ErrClosed if the connection was closed in the meantime, and the error of the context if it's done
+func (c *Conn) wait(buckets [2]*TokenBucket, n int, strict bool) error {
+	now := time.Now()
+	delay := max(buckets[0].ReserveAt(n, now), buckets[1].ReserveAt(n, now))
+	if delay <= 0 {
+		return nil
+	}
+	if delay > c.maxDelay {
+		buckets[0].unreserve(n)
+		buckets[1].unreserve(n)
+		if strict {
+			return ErrThrottled
+		}
+		delay = c.maxDelay
+	}
+	if c.onThrottle != nil {
+		c.onThrottle(delay)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.closed:
+		return net.ErrClosed
+	case <-c.inner:
+		return net.ErrClosed
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(b) > c.chunk {
+		b = b[:c.chunk]
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if waitErr := c.wait(c.reads, n, false); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		chunk := b[written:min(len(b), written+c.chunk)]
+		if err := c.wait(c.writes, len(chunk), true); err != nil {
+			return written, err
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close closes the connection, and interrupts the reads and writes that are waiting
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// Unwrap returns the wrapped connection
+func (c *Conn) Unwrap() net.Conn {
+	return c.Conn
+}
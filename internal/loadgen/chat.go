@@ -0,0 +1,108 @@
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultChatInterval = time.Millisecond * 100
+	messagesPerSession  = 100
+	// chatMessagePrefix starts the messages of the chatters, followed by the time they were sent at
+	chatMessagePrefix = "loadgen "
+)
+
+// chat joins the room, and sends a message every interval. Every chatter measures how long the messages of the
+// others took to reach it
+type chat struct{}
+
+func newChat(Options) Workload {
+	return chat{}
+}
+
+func (chat) Session(ctx context.Context, c *Client) error {
+	conn, err := c.Dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	name := fmt.Sprintf("load%dx%d", c.ID, c.Session)
+
+	start := time.Now()
+	conn.SetReadDeadline(c.Deadline())
+	if _, err := r.ReadString('\n'); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n", name); err != nil {
+		return err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	c.Stats.Observe("join", time.Since(start))
+	if !strings.HasPrefix(line, "* ") {
+		c.Stats.Violation("chat: got %q after joining, want the list of users", line)
+		return nil
+	}
+	// Chatters may stay silent for long, the room talks to them
+	conn.SetReadDeadline(time.Time{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			received(c, name, strings.TrimSuffix(line, "\n"))
+		}
+	}()
+	for range messagesPerSession {
+		if !c.Pause(ctx, defaultChatInterval) {
+			break
+		}
+		conn.SetWriteDeadline(c.Deadline())
+		if _, err := fmt.Fprintf(conn, "%s%d\n", chatMessagePrefix, time.Now().UnixNano()); err != nil {
+			return err
+		}
+		c.Stats.Count("send")
+	}
+	conn.Close()
+	<-done
+	return nil
+}
+
+// received checks a line received by a chatter, and records the latency of the messages of the other chatters
+func received(c *Client, name, line string) {
+	if strings.HasPrefix(line, "* ") {
+		c.Stats.Count("presence")
+		return
+	}
+	sender, message, ok := strings.Cut(strings.TrimPrefix(line, "["), "] ")
+	if !strings.HasPrefix(line, "[") || !ok {
+		c.Stats.Violation("chat: unexpected line %q", line)
+		return
+	}
+	if sender == name {
+		c.Stats.Violation("chat: %s received its own message", name)
+		return
+	}
+	sent, ok := strings.CutPrefix(message, chatMessagePrefix)
+	if !ok {
+		// Sent by a chatter that is not part of the run
+		return
+	}
+	nanos, err := strconv.ParseInt(sent, 10, 64)
+	if err != nil {
+		c.Stats.Violation("chat: message %q was altered", message)
+		return
+	}
+	c.Stats.Observe("message", time.Since(time.Unix(0, nanos)))
+}
@@ -0,0 +1,58 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+)
+
+const (
+	defaultEchoSize = 4096
+	// echoesPerSession is the number of chunks sent on a connection before it's replaced by a new one
+	echoesPerSession = 100
+)
+
+// echo sends chunks of random data, and checks that the smoke test server sends them back
+type echo struct {
+	size int
+}
+
+func newEcho(opts Options) Workload {
+	if opts.Size <= 0 {
+		opts.Size = defaultEchoSize
+	}
+	return &echo{size: opts.Size}
+}
+
+func (w *echo) Session(ctx context.Context, c *Client) error {
+	conn, err := c.Dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	sent := make([]byte, w.size)
+	received := make([]byte, w.size)
+	for range echoesPerSession {
+		for i := range sent {
+			sent[i] = byte(c.Rand.Uint32())
+		}
+		start := time.Now()
+		conn.SetDeadline(c.Deadline())
+		if _, err := conn.Write(sent); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, received); err != nil {
+			return err
+		}
+		c.Stats.Observe("echo", time.Since(start))
+		if !bytes.Equal(sent, received) {
+			c.Stats.Violation("smoke: the %d bytes sent back differ from the bytes sent", w.size)
+			return nil
+		}
+		if !c.Pause(ctx, 0) {
+			return nil
+		}
+	}
+	return nil
+}
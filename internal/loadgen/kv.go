@@ -0,0 +1,87 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	kvOperationsPerSession = 100
+	kvKeysPerClient        = 16
+	maxDatagramSize        = 1000
+)
+
+// kv inserts keys of its own, and retrieves them. Clients use distinct keys, so that the values they retrieve are the
+// ones they inserted last
+type kv struct {
+	loss bool
+}
+
+func newKV(opts Options) Workload {
+	return &kv{loss: opts.Loss > 0}
+}
+
+func (w *kv) Session(ctx context.Context, c *Client) error {
+	conn, server, err := c.ListenPacket(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	values := map[string]string{}
+	buffer := make([]byte, maxDatagramSize)
+	for range kvOperationsPerSession {
+		if ctx.Err() != nil {
+			return nil
+		}
+		key := fmt.Sprintf("load%d-%d", c.ID, c.Rand.IntN(kvKeysPerClient))
+		if _, ok := values[key]; !ok || c.Rand.IntN(2) == 0 {
+			value := fmt.Sprintf("v%d", c.Rand.Uint32())
+			if _, err := conn.WriteTo([]byte(key+"="+value), server); err != nil {
+				return err
+			}
+			values[key] = value
+			c.Stats.Count("insert")
+			continue
+		}
+		start := time.Now()
+		if _, err := conn.WriteTo([]byte(key), server); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(c.Deadline())
+		// Answers to earlier retrievals that timed out may still arrive, they are skipped
+		for {
+			n, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				if !isTimeout(err) {
+					return err
+				}
+				c.Stats.Error("timeout")
+				break
+			}
+			gotKey, gotValue, ok := strings.Cut(string(buffer[:n]), "=")
+			if !ok {
+				c.Stats.Violation("kv: got %q, want key=value", buffer[:n])
+				break
+			}
+			if gotKey != key {
+				continue
+			}
+			c.Stats.Observe("retrieve", time.Since(start))
+			if gotValue != values[key] {
+				// The insert itself may have been lost
+				if w.loss {
+					c.Stats.Error("stale value")
+				} else {
+					c.Stats.Violation("kv: got %s=%s, want %s", key, gotValue, values[key])
+				}
+			}
+			break
+		}
+		if !c.Pause(ctx, 0) {
+			return nil
+		}
+	}
+	return nil
+}
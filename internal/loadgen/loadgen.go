@@ -0,0 +1,290 @@
+// Package loadgen drives many concurrent clients against a server, to find out how much load one instance sustains.
+// Every challenge has a workload that plays its clients, and checks the answers of the server. A run reports the
+// throughput and latency of each operation, the errors of the clients, and the answers that violate the protocol.
+package loadgen
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/chaos"
+)
+
+// Defaults of Options
+const (
+	DefaultClients  = 10
+	DefaultDuration = time.Second * 10
+	DefaultTimeout  = time.Second * 5
+)
+
+// maxErrorKinds bounds the number of distinct errors in a report, the others are counted as "other"
+const maxErrorKinds = 20
+
+// Options configure a run
+type Options struct {
+	// Address is the host:port of the server
+	Address string
+	// TLS connects to stream servers over TLS, without verifying their certificate
+	TLS bool
+	// Clients is the number of concurrent clients
+	Clients int
+	// Duration is the length of the run
+	Duration time.Duration
+	// Timeout bounds the wait for an answer of the server
+	Timeout time.Duration
+	// Interval is the pause between the operations of a client. Zero sends as fast as the server answers, unless the
+	// workload has a default of its own
+	Interval time.Duration
+	// Size is the size of the messages of workloads that send arbitrary data. Zero uses the default of the workload
+	Size int
+	// Loss is the probability that a datagram sent or received by a udp client is lost
+	Loss float64
+	// Seed makes the data sent by the clients reproducible
+	Seed uint64
+}
+
+func (o Options) withDefaults() Options {
+	if o.Clients <= 0 {
+		o.Clients = DefaultClients
+	}
+	if o.Duration <= 0 {
+		o.Duration = DefaultDuration
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	return o
+}
+
+// Workload plays the clients of a protocol
+type Workload interface {
+	// Session runs one session of a client, usually over a connection of its own. It's called again once it returns,
+	// until the run is over. It returns early when ctx is done.
+	Session(ctx context.Context, c *Client) error
+}
+
+// Definition describes a workload
+type Definition struct {
+	// Name is the name of the service the workload targets
+	Name string
+	// Description says what the clients do
+	Description string
+	// New creates the workload of a run
+	New func(opts Options) Workload
+}
+
+// Workloads are the available workloads, one per challenge
+var Workloads = []Definition{
+	{Name: "smoke", Description: "echo throughput: clients send chunks of random data and check that they come back", New: newEcho},
+	{Name: "prime", Description: "isPrime requests for random numbers, and malformed requests that must close the connection", New: newPrime},
+	{Name: "means", Description: "insert-heavy sessions: 1000 inserts, then queries whose means are checked", New: newMeans},
+	{Name: "chat", Description: "chatters that join the room and send a message every interval (100ms by default)", New: newChat},
+	{Name: "kv", Description: "udp mix of inserts and retrievals of the inserted keys", New: newKV},
+	{Name: "speed", Description: "camera pairs reporting cars on their road, and dispatchers that receive the tickets", New: newSpeed},
+	{Name: "lrcp", Description: "LRCP sessions that send lines and check that they come back reversed, over lossy udp", New: newLRCP},
+}
+
+// Find returns the workload with the given name
+func Find(name string) (Definition, bool) {
+	for _, d := range Workloads {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Definition{}, false
+}
+
+// Run runs the workload with opts.Clients concurrent clients, until opts.Duration has passed or ctx is done
+func Run(ctx context.Context, def Definition, opts Options) *Report {
+	opts = opts.withDefaults()
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+	stats := newStats()
+	workload := def.New(opts)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for id := range opts.Clients {
+		wg.Go(func() {
+			c := &Client{ID: id, Rand: rand.New(rand.NewPCG(opts.Seed, uint64(id))), Stats: stats, opts: opts}
+			for ctx.Err() == nil {
+				err := workload.Session(ctx, c)
+				c.Session++
+				if err != nil && ctx.Err() == nil {
+					stats.Error(errorKind(stats, err))
+					// Don't spin against a server that refuses every connection
+					c.Sleep(ctx, time.Millisecond*100)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	return stats.report(def.Name, opts.Clients, time.Since(start))
+}
+
+// errorKind groups errors, so that the report counts them by cause
+func errorKind(s *Stats, err error) string {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "connection reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection closed by server"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.errors[err.Error()]; !ok && len(s.errors) >= maxErrorKinds {
+		return "other"
+	}
+	return err.Error()
+}
+
+// Client is one of the concurrent clients of a run
+type Client struct {
+	// ID numbers the clients of a run from 0
+	ID int
+	// Session counts the sessions of the client that have ended
+	Session int
+	// Rand is the random source of the client, seeded from Options.Seed and ID
+	Rand *rand.Rand
+	// Stats records the operations of every client
+	Stats *Stats
+
+	opts Options
+}
+
+// Options returns the options of the run
+func (c *Client) Options() Options {
+	return c.opts
+}
+
+// Deadline returns the time by which the server must answer an operation started now
+func (c *Client) Deadline() time.Time {
+	return time.Now().Add(c.opts.Timeout)
+}
+
+// Dial connects to the server over tcp, or TLS. The connection is closed when ctx is done
+func (c *Client) Dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.opts.Timeout}
+	var conn net.Conn
+	var err error
+	if c.opts.TLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{InsecureSkipVerify: true}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", c.opts.Address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.opts.Address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	return &countingConn{Conn: conn, stats: c.Stats, stop: context.AfterFunc(ctx, func() { conn.Close() })}, nil
+}
+
+// ListenPacket opens a udp socket to talk to the server, whose address is returned too. Datagrams are lost with
+// probability Options.Loss in both directions. The socket is closed when ctx is done
+func (c *Client) ListenPacket(ctx context.Context) (net.PacketConn, net.Addr, error) {
+	server, err := net.ResolveUDPAddr("udp", c.opts.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+	var listenConfig net.ListenConfig
+	conn, err := listenConfig.ListenPacket(ctx, "udp", ":0")
+	if err != nil {
+		return nil, nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	var packetConn net.PacketConn = conn
+	if c.opts.Loss > 0 {
+		packetConn = chaos.NewPacketConn(conn, chaos.Config{Seed: c.opts.Seed + uint64(c.ID), Drop: c.opts.Loss})
+	}
+	return &countingPacketConn{PacketConn: packetConn, stats: c.Stats, stop: stop}, server, nil
+}
+
+// Pause waits for Options.Interval, or for fallback if it's zero. It returns false if ctx is done
+func (c *Client) Pause(ctx context.Context, fallback time.Duration) bool {
+	d := c.opts.Interval
+	if d == 0 {
+		d = fallback
+	}
+	return c.Sleep(ctx, d)
+}
+
+// Sleep waits for d, and returns false if ctx is done before
+func (c *Client) Sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// countingConn counts the bytes sent and received
+type countingConn struct {
+	net.Conn
+	stats *Stats
+	stop  func() bool
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.addReceived(n)
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.addSent(n)
+	return n, err
+}
+
+func (c *countingConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// countingPacketConn counts the bytes sent and received
+type countingPacketConn struct {
+	net.PacketConn
+	stats *Stats
+	stop  func() bool
+}
+
+func (c *countingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	c.stats.addReceived(n)
+	return n, addr, err
+}
+
+func (c *countingPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	c.stats.addSent(n)
+	return n, err
+}
+
+func (c *countingPacketConn) Close() error {
+	c.stop()
+	return c.PacketConn.Close()
+}
+
+// isTimeout returns true if the error is a timeout of a deadline
+func isTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,128 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	smokeservice "github.com/ananthvk/protohackers-go/00_smoke_test/service"
+	primeservice "github.com/ananthvk/protohackers-go/01_prime_time/service"
+	meansservice "github.com/ananthvk/protohackers-go/02_means_to_an_end/service"
+	chatservice "github.com/ananthvk/protohackers-go/03_budget_chat/service"
+	kvservice "github.com/ananthvk/protohackers-go/04_unusual_database_program/service"
+	speedservice "github.com/ananthvk/protohackers-go/06_speed_daemon/service"
+	lrcpservice "github.com/ananthvk/protohackers-go/07_line_reversal/service"
+	"github.com/ananthvk/protohackers-go/internal/runner"
+)
+
+// start serves the service on a random local port until the test ends, and returns its address
+func start(t *testing.T, service *runner.Service) string {
+	t.Helper()
+	service.Address = "127.0.0.1:0"
+	ctx, cancel := context.WithCancel(context.Background())
+	group, err := runner.Listen(ctx, runner.Options{DrainTimeout: time.Millisecond * 200}, service)
+	if err != nil {
+		cancel()
+		t.Fatalf("listen failed: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		group.Serve(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return service.Addr().String()
+}
+
+func TestWorkloads(t *testing.T) {
+	tests := []struct {
+		service   *runner.Service
+		loss      float64
+		operation string
+	}{
+		{smokeservice.New(smokeservice.Config{}), 0, "echo"},
+		{primeservice.New(primeservice.Config{}), 0, "isPrime"},
+		{meansservice.New(meansservice.Config{}), 0, "query"},
+		{chatservice.New(chatservice.Config{}), 0, "message"},
+		{kvservice.New(kvservice.DefaultConfig()), 0, "retrieve"},
+		{speedservice.New(speedservice.Config{}), 0, "ticket"},
+		{lrcpservice.New(lrcpservice.Config{}), 0.1, "line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.service.Name, func(t *testing.T) {
+			def, ok := Find(tt.service.Name)
+			if !ok {
+				t.Fatalf("no workload for %s", tt.service.Name)
+			}
+			address := start(t, tt.service)
+			report := Run(context.Background(), def, Options{
+				Address:  address,
+				Clients:  4,
+				Duration: time.Millisecond * 500,
+				Timeout:  time.Second,
+				Interval: time.Millisecond * 10,
+				Loss:     tt.loss,
+				Seed:     1,
+			})
+			if report.Violations != 0 {
+				t.Errorf("got %d violations: %v", report.Violations, report.Examples)
+			}
+			var count int
+			for _, op := range report.Operations {
+				if op.Name == tt.operation {
+					count = op.Count
+				}
+			}
+			if count == 0 {
+				var b bytes.Buffer
+				report.Write(&b)
+				t.Errorf("no %s operation completed:\n%s", tt.operation, b.String())
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := []time.Duration{5, 1, 4, 2, 3, 10, 9, 8, 7, 6}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0.5, 5},
+		{0.9, 9},
+		{0.99, 10},
+		{1, 10},
+	}
+	for _, tt := range tests {
+		s := newStats()
+		for _, l := range latencies {
+			s.Observe("op", l)
+		}
+		report := s.report("test", 1, time.Second)
+		op := report.Operations[0]
+		got := map[float64]time.Duration{0.5: op.P50, 0.9: op.P90, 0.99: op.P99, 1: op.Max}[tt.p]
+		if got != tt.want {
+			t.Errorf("percentile %v = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestReportWrite(t *testing.T) {
+	s := newStats()
+	s.Observe("query", time.Millisecond)
+	s.Count("insert")
+	s.Error("timeout")
+	s.Violation("means: got mean %d, want %d", 1, 2)
+	var b bytes.Buffer
+	s.report("means", 2, time.Second).Write(&b)
+	for _, want := range []string{"query", "insert", "timeout", "protocol violations: 1", "means: got mean 1, want 2"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLineSize = 40
+	linesPerSession = 20
+	// lrcpRetransmission is how often the client sends unacknowledged data again
+	lrcpRetransmission = time.Millisecond * 300
+	// maxChunk bounds the unescaped data sent in one message, so that it fits in a datagram even if every byte is escaped
+	maxChunk = 400
+)
+
+// lrcp opens LRCP sessions with the line reversal server, sends lines one at a time, and checks that each comes back
+// reversed. With Options.Loss, datagrams are lost in both directions and the retransmissions of both sides are
+// exercised.
+type lrcp struct {
+	size int
+}
+
+func newLRCP(opts Options) Workload {
+	if opts.Size <= 0 {
+		opts.Size = defaultLineSize
+	}
+	return &lrcp{size: opts.Size}
+}
+
+// lrcpSession is the client side of an LRCP session
+type lrcpSession struct {
+	c      *Client
+	conn   net.PacketConn
+	server net.Addr
+	id     int64
+
+	sent     []byte // Every byte sent, acknowledged or not
+	acked    int
+	received []byte // Every byte received in order
+	lines    int    // Number of lines read from received
+	lastSend time.Time
+}
+
+func (w *lrcp) Session(ctx context.Context, c *Client) error {
+	conn, server, err := c.ListenPacket(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	s := &lrcpSession{c: c, conn: conn, server: server, id: c.Rand.Int64N(1 << 31)}
+
+	start := time.Now()
+	if err := s.connect(); err != nil {
+		return err
+	}
+	c.Stats.Observe("connect", time.Since(start))
+	for range linesPerSession {
+		line := randomLine(c, w.size)
+		start := time.Now()
+		s.sent = append(s.sent, line...)
+		got, err := s.nextLine()
+		if err != nil {
+			return err
+		}
+		c.Stats.Observe("line", time.Since(start))
+		if want := reverse(string(line[:len(line)-1])); got != want {
+			c.Stats.Violation("lrcp: got %q, want %q", got, want)
+			return nil
+		}
+		if !c.Pause(ctx, 0) {
+			break
+		}
+	}
+	s.send(fmt.Sprintf("/close/%d/", s.id))
+	return nil
+}
+
+// connect sends connect messages until the server acknowledges the session
+func (s *lrcpSession) connect() error {
+	deadline := s.c.Deadline()
+	for time.Now().Before(deadline) {
+		s.send(fmt.Sprintf("/connect/%d/", s.id))
+		fields, err := s.receive(time.Now().Add(lrcpRetransmission))
+		if err != nil {
+			return err
+		}
+		if len(fields) == 3 && fields[0] == "ack" && fields[2] == "0" {
+			return nil
+		}
+	}
+	return errTimeout("connect")
+}
+
+// nextLine sends the unacknowledged data until the next line is received
+func (s *lrcpSession) nextLine() (string, error) {
+	deadline := s.c.Deadline()
+	for time.Now().Before(deadline) {
+		if line, ok := s.readLine(); ok {
+			return line, nil
+		}
+		if s.acked < len(s.sent) && time.Since(s.lastSend) >= lrcpRetransmission {
+			end := min(len(s.sent), s.acked+maxChunk)
+			s.send(fmt.Sprintf("/data/%d/%d/%s/", s.id, s.acked, escape(s.sent[s.acked:end])))
+		}
+		fields, err := s.receive(time.Now().Add(lrcpRetransmission))
+		if err != nil {
+			return "", err
+		}
+		if err := s.handle(fields); err != nil {
+			return "", err
+		}
+	}
+	return "", errTimeout("line")
+}
+
+// handle processes a message of the server
+func (s *lrcpSession) handle(fields []string) error {
+	switch {
+	case fields == nil:
+		return nil
+	case fields[0] == "ack" && len(fields) == 3:
+		length, err := strconv.Atoi(fields[2])
+		if err != nil || length > len(s.sent) {
+			s.c.Stats.Violation("lrcp: acknowledgement of %s bytes, only %d were sent", fields[2], len(s.sent))
+			return nil
+		}
+		if length > s.acked {
+			s.acked = length
+			// The rest of the data is sent right away
+			s.lastSend = time.Time{}
+		}
+	case fields[0] == "data" && len(fields) == 4:
+		pos, err := strconv.Atoi(fields[2])
+		if err != nil {
+			s.c.Stats.Violation("lrcp: invalid data position %q", fields[2])
+			return nil
+		}
+		if pos == len(s.received) {
+			s.received = append(s.received, fields[3]...)
+		}
+		s.send(fmt.Sprintf("/ack/%d/%d/", s.id, len(s.received)))
+	case fields[0] == "close":
+		return fmt.Errorf("session closed by the server")
+	}
+	return nil
+}
+
+// readLine returns the next complete line of received
+func (s *lrcpSession) readLine() (string, bool) {
+	lines := bytes.Split(s.received, []byte("\n"))
+	if len(lines) <= s.lines+1 {
+		return "", false
+	}
+	s.lines++
+	return string(lines[s.lines-1]), true
+}
+
+func (s *lrcpSession) send(message string) {
+	s.lastSend = time.Now()
+	s.conn.WriteTo([]byte(message), s.server)
+}
+
+// receive returns the fields of the next message of the session, or nil if none arrived before the deadline
+func (s *lrcpSession) receive(deadline time.Time) ([]string, error) {
+	var buffer [maxDatagramSize]byte
+	s.conn.SetReadDeadline(deadline)
+	for {
+		n, _, err := s.conn.ReadFrom(buffer[:])
+		if err != nil {
+			if isTimeout(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		fields, ok := parseLRCP(buffer[:n])
+		if !ok {
+			s.c.Stats.Violation("lrcp: invalid message %q", buffer[:n])
+			continue
+		}
+		if fields[1] == strconv.FormatInt(s.id, 10) {
+			return fields, nil
+		}
+	}
+}
+
+// parseLRCP splits a message into its fields, unescaping them
+func parseLRCP(b []byte) ([]string, bool) {
+	if len(b) < 2 || b[0] != '/' || b[len(b)-1] != '/' {
+		return nil, false
+	}
+	var fields []string
+	var field []byte
+	for i := 1; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+			if i == len(b)-1 {
+				return nil, false
+			}
+			field = append(field, b[i])
+		case '/':
+			fields = append(fields, string(field))
+			field = nil
+		default:
+			field = append(field, b[i])
+		}
+	}
+	return fields, len(fields) >= 2
+}
+
+func escape(b []byte) string {
+	return strings.NewReplacer(`\`, `\\`, `/`, `\/`).Replace(string(b))
+}
+
+func reverse(s string) string {
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b)
+}
+
+// randomLine returns a line of printable ASCII, slashes and backslashes included, ending with a newline
+func randomLine(c *Client, size int) []byte {
+	line := make([]byte, size)
+	for i := range line {
+		line[i] = byte(' ' + c.Rand.IntN('~'-' '+1))
+	}
+	return append(line, '\n')
+}
+
+type timeoutError string
+
+func (e timeoutError) Error() string   { return string(e) + " timed out" }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+func errTimeout(operation string) error {
+	return timeoutError(operation)
+}
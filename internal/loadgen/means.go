@@ -0,0 +1,93 @@
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+const (
+	insertsPerSession = 1000
+	queriesPerSession = 10
+)
+
+// means inserts many prices in a session, and checks the mean of a few ranges
+type means struct{}
+
+func newMeans(Options) Workload {
+	return means{}
+}
+
+type price struct {
+	timestamp, value int32
+}
+
+func (means) Session(ctx context.Context, c *Client) error {
+	conn, err := c.Dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	w := bufio.NewWriter(conn)
+	prices := make([]price, 0, insertsPerSession)
+	// Timestamps must be unique within a session
+	timestamp := int32(c.Rand.IntN(1000))
+	for range insertsPerSession {
+		timestamp += 1 + int32(c.Rand.IntN(100))
+		p := price{timestamp: timestamp, value: int32(c.Rand.IntN(20001) - 10000)}
+		prices = append(prices, p)
+		conn.SetWriteDeadline(c.Deadline())
+		if _, err := w.Write(meansMessage('I', p.timestamp, p.value)); err != nil {
+			return err
+		}
+		c.Stats.Count("insert")
+	}
+	var answer [4]byte
+	for range queriesPerSession {
+		if ctx.Err() != nil {
+			return nil
+		}
+		minTime := int32(c.Rand.IntN(int(timestamp)))
+		maxTime := minTime + int32(c.Rand.IntN(int(timestamp-minTime)+1))
+		start := time.Now()
+		conn.SetDeadline(c.Deadline())
+		w.Write(meansMessage('Q', minTime, maxTime))
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, answer[:]); err != nil {
+			return err
+		}
+		c.Stats.Observe("query", time.Since(start))
+		got, want := int64(int32(binary.BigEndian.Uint32(answer[:]))), mean(prices, minTime, maxTime)
+		// Means that are not integers may be rounded either way
+		if got < want-1 || got > want+1 {
+			c.Stats.Violation("means: got mean %d for [%d, %d], want %d", got, minTime, maxTime, want)
+		}
+		if !c.Pause(ctx, 0) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func meansMessage(kind byte, field1, field2 int32) []byte {
+	b := binary.BigEndian.AppendUint32([]byte{kind}, uint32(field1))
+	return binary.BigEndian.AppendUint32(b, uint32(field2))
+}
+
+func mean(prices []price, minTime, maxTime int32) int64 {
+	var sum, count int64
+	for _, p := range prices {
+		if minTime <= p.timestamp && p.timestamp <= maxTime {
+			sum += int64(p.value)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / count
+}
@@ -0,0 +1,132 @@
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// primeRequestsPerSession is the number of requests sent on a connection, unless a malformed one closes it before
+	primeRequestsPerSession = 100
+	// malformedRatio is the share of malformed requests
+	malformedRatio = 0.05
+)
+
+// malformedRequests are requests that the server must answer with a malformed response, before closing the connection
+var malformedRequests = []string{
+	"not json",
+	`{"method":"isPrime"}`,
+	`{"number":7}`,
+	`{"method":"isPrim","number":7}`,
+	`{"method":"isPrime","number":"7"}`,
+	`["isPrime",7]`,
+	`{"method":"isPrime","number":7`,
+}
+
+// prime sends isPrime requests for random numbers, and checks the answers
+type prime struct{}
+
+func newPrime(Options) Workload {
+	return prime{}
+}
+
+func (prime) Session(ctx context.Context, c *Client) error {
+	conn, err := c.Dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for range primeRequestsPerSession {
+		if c.Rand.Float64() < malformedRatio {
+			return malformed(c, conn, r)
+		}
+		number, want := randomNumber(c)
+		start := time.Now()
+		conn.SetDeadline(c.Deadline())
+		if _, err := fmt.Fprintf(conn, "{\"method\":\"isPrime\",\"number\":%s}\n", number); err != nil {
+			return err
+		}
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		c.Stats.Observe("isPrime", time.Since(start))
+		var response struct {
+			Method string `json:"method"`
+			Prime  *bool  `json:"prime"`
+		}
+		if err := json.Unmarshal(line, &response); err != nil || response.Method != "isPrime" || response.Prime == nil {
+			c.Stats.Violation("prime: malformed response %q to %s", line, number)
+			return nil
+		}
+		if *response.Prime != want {
+			c.Stats.Violation("prime: got prime=%t for %s, want %t", *response.Prime, number, want)
+		}
+		if !c.Pause(ctx, 0) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// malformed sends a malformed request, which must be answered with a malformed response and the end of the connection
+func malformed(c *Client, conn net.Conn, r *bufio.Reader) error {
+	request := malformedRequests[c.Rand.IntN(len(malformedRequests))]
+	start := time.Now()
+	conn.SetDeadline(c.Deadline())
+	if _, err := fmt.Fprintf(conn, "%s\n", request); err != nil {
+		return err
+	}
+	line, err := r.ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	var response struct {
+		Method string `json:"method"`
+		Prime  *bool  `json:"prime"`
+	}
+	if json.Unmarshal(line, &response) == nil && response.Method == "isPrime" && response.Prime != nil {
+		c.Stats.Violation("prime: well-formed response %q to malformed request %q", line, request)
+		return nil
+	}
+	if err == nil {
+		// Only more data or a timeout mean that the server kept the connection open, other errors come from the run
+		// ending
+		_, err := r.ReadByte()
+		var netErr net.Error
+		switch {
+		case errors.Is(err, io.EOF):
+		case err == nil || errors.As(err, &netErr) && netErr.Timeout():
+			c.Stats.Violation("prime: connection still open after malformed request %q", request)
+			return nil
+		default:
+			return err
+		}
+	}
+	c.Stats.Observe("malformed", time.Since(start))
+	return nil
+}
+
+// randomNumber returns a number in JSON, and whether it's prime. Most numbers are small, so that primes are frequent
+func randomNumber(c *Client) (string, bool) {
+	var n int64
+	switch p := c.Rand.Float64(); {
+	case p < 0.5:
+		n = c.Rand.Int64N(10000)
+	case p < 0.9:
+		n = c.Rand.Int64N(1 << 53)
+	default:
+		n = -c.Rand.Int64N(10000)
+	}
+	// ProbablyPrime is exact below 2^64
+	return strconv.FormatInt(n, 10), n > 1 && big.NewInt(n).ProbablyPrime(0)
+}
@@ -0,0 +1,173 @@
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// speedRoads bounds the number of roads, so that a single IAmDispatcher message covers all of them
+	speedRoads      = 250
+	firstSpeedRoad  = 1000
+	speedLimit      = 60
+	cameraDistance  = 10 // miles between the two cameras of a road
+	carsPerSession  = 100
+	dispatcherRatio = 10 // One client in dispatcherRatio is a dispatcher
+	// heartbeatInterval is the heartbeat interval requested by dispatchers, in deciseconds
+	heartbeatInterval = 10
+)
+
+// speed runs a fleet of cameras and dispatchers. Every camera client reports cars with a pair of cameras on its own
+// road, and some cars are speeding. Dispatchers cover every road, and measure the time between the second
+// observation of a speeding car and its ticket
+type speed struct {
+	roads int
+
+	mu      sync.Mutex
+	pending map[string]time.Time // Time at which each speeding car was reported
+}
+
+func newSpeed(opts Options) Workload {
+	return &speed{roads: min(max(opts.Clients-opts.Clients/dispatcherRatio, 1), speedRoads), pending: map[string]time.Time{}}
+}
+
+func (w *speed) Session(ctx context.Context, c *Client) error {
+	if c.ID%dispatcherRatio == 0 {
+		return w.dispatcher(ctx, c)
+	}
+	return w.cameras(ctx, c)
+}
+
+func (w *speed) cameras(ctx context.Context, c *Client) error {
+	road := uint16(firstSpeedRoad + c.ID%w.roads)
+	var cameras [2]*bufio.Writer
+	for i := range cameras {
+		conn, err := c.Dial(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		cameras[i] = bufio.NewWriter(conn)
+		b := binary.BigEndian.AppendUint16([]byte{0x80}, road)
+		b = binary.BigEndian.AppendUint16(b, uint16(i*cameraDistance))
+		cameras[i].Write(binary.BigEndian.AppendUint16(b, speedLimit))
+	}
+	for car := range carsPerSession {
+		// Speeds close to the limit may or may not be ticketed, they are avoided
+		mph := 30 + c.Rand.IntN(30)
+		if c.Rand.IntN(2) == 0 {
+			mph = speedLimit + 1 + c.Rand.IntN(60)
+		}
+		plate := fmt.Sprintf("L%dS%dC%d", c.ID, c.Session, car)
+		// Every car drives on its own day, tickets are limited to one per car and day
+		timestamp1 := uint32(car * 86400)
+		timestamp2 := timestamp1 + uint32(cameraDistance*3600/mph)
+		for i, timestamp := range []uint32{timestamp1, timestamp2} {
+			if i == 1 && mph > speedLimit {
+				// Before the observation is sent, since the ticket may arrive right after
+				w.mu.Lock()
+				w.pending[plate] = time.Now()
+				w.mu.Unlock()
+			}
+			b := append([]byte{0x20, byte(len(plate))}, plate...)
+			cameras[i].Write(binary.BigEndian.AppendUint32(b, timestamp))
+			if err := cameras[i].Flush(); err != nil {
+				return err
+			}
+			c.Stats.Count("plate")
+		}
+		if !c.Pause(ctx, 0) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// dispatcher stays connected until the run is over, and checks the tickets it receives
+func (w *speed) dispatcher(ctx context.Context, c *Client) error {
+	conn, err := c.Dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	b := []byte{0x81, byte(w.roads)}
+	for road := range w.roads {
+		b = binary.BigEndian.AppendUint16(b, uint16(firstSpeedRoad+road))
+	}
+	b = binary.BigEndian.AppendUint32(append(b, 0x40), heartbeatInterval)
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	for ctx.Err() == nil {
+		// Heartbeats arrive every second, a longer silence is a missed heartbeat
+		conn.SetReadDeadline(c.Deadline())
+		kind, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case 0x41:
+			c.Stats.Count("heartbeat")
+		case 0x21:
+			if err := w.ticket(c, r); err != nil {
+				return err
+			}
+		case 0x10:
+			message, _ := readString(r)
+			c.Stats.Violation("speed: dispatcher got error %q", message)
+			return nil
+		default:
+			c.Stats.Violation("speed: dispatcher got unknown message type 0x%02x", kind)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (w *speed) ticket(c *Client, r *bufio.Reader) error {
+	plate, err := readString(r)
+	if err != nil {
+		return err
+	}
+	var fields struct {
+		Road, Mile1 uint16
+		Timestamp1  uint32
+		Mile2       uint16
+		Timestamp2  uint32
+		Speed       uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &fields); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	reported, ok := w.pending[plate]
+	delete(w.pending, plate)
+	w.mu.Unlock()
+	if !ok {
+		c.Stats.Violation("speed: unexpected ticket for %s", plate)
+		return nil
+	}
+	c.Stats.Observe("ticket", time.Since(reported))
+	if fields.Speed < speedLimit*100 || fields.Road < firstSpeedRoad || int(fields.Road) >= firstSpeedRoad+w.roads {
+		c.Stats.Violation("speed: invalid ticket %s %+v", plate, fields)
+	}
+	return nil
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	length, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
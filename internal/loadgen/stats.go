@@ -0,0 +1,217 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// maxViolationExamples is the number of protocol violations kept to be shown in the report
+const maxViolationExamples = 5
+
+// Stats collects the operations, errors and violations of every client of a run
+type Stats struct {
+	mu         sync.Mutex
+	operations map[string]*operation
+	errors     map[string]int
+	violations int
+	examples   []string
+	sent       int64
+	received   int64
+}
+
+type operation struct {
+	count     int
+	latencies []time.Duration // Empty for operations that get no answer
+}
+
+func newStats() *Stats {
+	return &Stats{operations: map[string]*operation{}, errors: map[string]int{}}
+}
+
+func (s *Stats) op(name string) *operation {
+	o := s.operations[name]
+	if o == nil {
+		o = &operation{}
+		s.operations[name] = o
+	}
+	return o
+}
+
+// Count records an operation that gets no answer, such as an insert
+func (s *Stats) Count(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.op(name).count++
+}
+
+// Observe records an operation, and the time it took to be answered
+func (s *Stats) Observe(name string, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.op(name)
+	o.count++
+	o.latencies = append(o.latencies, latency)
+}
+
+// Error records a failure of the client or of the network, such as a refused connection or a timeout
+func (s *Stats) Error(kind string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors[kind]++
+}
+
+// Violation records an answer of the server that breaks the protocol
+func (s *Stats) Violation(format string, args ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.violations++
+	if len(s.examples) < maxViolationExamples {
+		s.examples = append(s.examples, fmt.Sprintf(format, args...))
+	}
+}
+
+func (s *Stats) addSent(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent += int64(n)
+}
+
+func (s *Stats) addReceived(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received += int64(n)
+}
+
+// Report is the summary of a run
+type Report struct {
+	Workload   string
+	Clients    int
+	Elapsed    time.Duration
+	Operations []OperationReport
+	Errors     map[string]int
+	Violations int
+	// Examples are the first protocol violations
+	Examples      []string
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// OperationReport summarizes the operations of one kind
+type OperationReport struct {
+	Name  string
+	Count int
+	// Rate is the number of operations per second
+	Rate float64
+	// Latency percentiles, zero for operations that get no answer
+	P50, P90, P99, Max time.Duration
+}
+
+func (s *Stats) report(workload string, clients int, elapsed time.Duration) *Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &Report{
+		Workload:      workload,
+		Clients:       clients,
+		Elapsed:       elapsed,
+		Errors:        maps.Clone(s.errors),
+		Violations:    s.violations,
+		Examples:      slices.Clone(s.examples),
+		BytesSent:     s.sent,
+		BytesReceived: s.received,
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.operations)) {
+		o := s.operations[name]
+		op := OperationReport{Name: name, Count: o.count, Rate: float64(o.count) / elapsed.Seconds()}
+		if len(o.latencies) > 0 {
+			latencies := slices.Clone(o.latencies)
+			slices.Sort(latencies)
+			op.P50 = percentile(latencies, 0.50)
+			op.P90 = percentile(latencies, 0.90)
+			op.P99 = percentile(latencies, 0.99)
+			op.Max = latencies[len(latencies)-1]
+		}
+		r.Operations = append(r.Operations, op)
+	}
+	return r
+}
+
+// percentile returns the p-th percentile of sorted latencies, with the nearest rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
+
+// TotalErrors returns the total number of errors
+func (r *Report) TotalErrors() int {
+	total := 0
+	for _, n := range r.Errors {
+		total += n
+	}
+	return total
+}
+
+// Write prints the report as a table
+func (r *Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "workload %s, %d clients, %v\n\n", r.Workload, r.Clients, r.Elapsed.Round(time.Millisecond))
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "operation\tcount\trate/s\tp50\tp90\tp99\tmax\t")
+	for _, op := range r.Operations {
+		if op.Max == 0 {
+			fmt.Fprintf(table, "%s\t%d\t%.1f\t-\t-\t-\t-\t\n", op.Name, op.Count, op.Rate)
+			continue
+		}
+		fmt.Fprintf(table, "%s\t%d\t%.1f\t%v\t%v\t%v\t%v\t\n", op.Name, op.Count, op.Rate,
+			roundLatency(op.P50), roundLatency(op.P90), roundLatency(op.P99), roundLatency(op.Max))
+	}
+	table.Flush()
+	seconds := r.Elapsed.Seconds()
+	fmt.Fprintf(w, "\nsent %s (%s/s), received %s (%s/s)\n", formatBytes(float64(r.BytesSent)), formatBytes(float64(r.BytesSent)/seconds),
+		formatBytes(float64(r.BytesReceived)), formatBytes(float64(r.BytesReceived)/seconds))
+
+	kinds := make([]string, 0, len(r.Errors))
+	for kind, n := range r.Errors {
+		kinds = append(kinds, fmt.Sprintf("%s: %d", kind, n))
+	}
+	sort.Strings(kinds)
+	fmt.Fprintf(w, "errors: %d", r.TotalErrors())
+	if len(kinds) > 0 {
+		fmt.Fprintf(w, " (%s)", strings.Join(kinds, ", "))
+	}
+	fmt.Fprintf(w, "\nprotocol violations: %d\n", r.Violations)
+	for _, example := range r.Examples {
+		fmt.Fprintf(w, "  %s\n", example)
+	}
+}
+
+// roundLatency keeps three significant digits or so
+func roundLatency(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(time.Microsecond * 10)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
+
+func formatBytes(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	suffixes := "KMGT"
+	i := -1
+	for n >= unit && i < len(suffixes)-1 {
+		n /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", n, suffixes[i])
+}
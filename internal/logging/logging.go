@@ -0,0 +1,161 @@
+// Package logging provides a slog handler that adds attributes stored in a context to every record, so that log lines
+// from a handler can be attributed to the service (and connection) they belong to.
+package logging
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"net"
+	"sync/atomic"
+
+	"github.com/ananthvk/protohackers-go/internal/netutil"
+)
+
+type attrsKey struct{}
+
+// WithAttrs returns a copy of the context carrying the given attributes, in addition to the ones already present. The
+// arguments are interpreted the same way as the arguments to slog.Logger.Info
+func WithAttrs(ctx context.Context, args ...any) context.Context {
+	attrs := attrsFromContext(ctx)
+	record := slog.Record{}
+	record.Add(args...)
+	merged := make([]slog.Attr, 0, len(attrs)+record.NumAttrs())
+	merged = append(merged, attrs...)
+	record.Attrs(func(a slog.Attr) bool {
+		merged = append(merged, a)
+		return true
+	})
+	return context.WithValue(ctx, attrsKey{}, merged)
+}
+
+func attrsFromContext(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// Logger returns the default logger with the attributes of the context attached. It is useful for long lived objects
+// that log without a context.
+func Logger(ctx context.Context) *slog.Logger {
+	attrs := attrsFromContext(ctx)
+	args := make([]any, len(attrs))
+	for i, a := range attrs {
+		args[i] = a
+	}
+	return slog.Default().With(args...)
+}
+
+// ContextHandler wraps another handler, and adds the attributes stored in the context by WithAttrs to each record
+type ContextHandler struct {
+	slog.Handler
+}
+
+// NewHandler returns a ContextHandler that wraps h
+func NewHandler(h slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: h}
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs := attrsFromContext(ctx); len(attrs) > 0 {
+		record = record.Clone()
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// Log formats accepted by Config.Format
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config selects what is logged, and how
+type Config struct {
+	// Level is the minimum level of the records that are logged
+	Level slog.Level
+	// Format is either FormatText or FormatJSON. An empty format is the same as FormatText
+	Format string
+	// SampleRate keeps one out of every SampleRate records on hot paths, see Sampler. 0 and 1 keep every record
+	SampleRate uint
+}
+
+// DefaultConfig returns the config used when no flags are given
+func DefaultConfig() Config {
+	return Config{Level: slog.LevelInfo, Format: FormatText, SampleRate: 1}
+}
+
+// RegisterFlags binds the config to command line flags
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.TextVar(&c.Level, "log-level", c.Level, "minimum level of the logs: debug, info, warn or error")
+	fs.StringVar(&c.Format, "log-format", c.Format, "format of the logs: text or json")
+	fs.UintVar(&c.SampleRate, "log-sample", c.SampleRate, "log one out of every N records on hot paths, such as each lrcp packet")
+}
+
+// Validate checks that the format is known
+func (c Config) Validate() error {
+	switch c.Format {
+	case "", FormatText, FormatJSON:
+		return nil
+	}
+	return errors.New("log format must be text or json")
+}
+
+// Setup installs a logger writing to w in the format of the config as the default logger
+func Setup(w io.Writer, cfg Config) {
+	options := &slog.HandlerOptions{Level: cfg.Level}
+	var h slog.Handler
+	if cfg.Format == FormatJSON {
+		h = slog.NewJSONHandler(w, options)
+	} else {
+		h = slog.NewTextHandler(w, options)
+	}
+	sampleRate.Store(uint64(cfg.SampleRate))
+	slog.SetDefault(slog.New(NewHandler(h)))
+}
+
+// sampleRate is set by Setup, and shared by every Sampler
+var sampleRate atomic.Uint64
+
+// Sampler thins out the records of a hot path, such as a log line for every packet. Allow lets the first call through,
+// and then one out of every -log-sample calls. The zero value is ready to use.
+type Sampler struct {
+	count atomic.Uint64
+}
+
+// Allow returns true if the record should be logged
+func (s *Sampler) Allow() bool {
+	rate := sampleRate.Load()
+	if rate <= 1 {
+		return true
+	}
+	return (s.count.Add(1)-1)%rate == 0
+}
+
+var lastConnID atomic.Uint64
+
+// NewConnID returns a connection ID that is unique within the process
+func NewConnID() uint64 {
+	return lastConnID.Add(1)
+}
+
+// ConnID returns the ID of the connection if it (or a connection it wraps) has a ConnID method, and a new ID otherwise.
+// Connections that log on their own, such as lrcp sessions, use it to share their ID with the handler.
+func ConnID(conn net.Conn) uint64 {
+	if c, ok := netutil.Find[interface{ ConnID() uint64 }](conn); ok {
+		return c.ConnID()
+	}
+	return NewConnID()
+}
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestContextAttrs(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewTextHandler(&buffer, nil)))
+
+	ctx := WithAttrs(context.Background(), "service", "prime")
+	ctx = WithAttrs(ctx, "conn", 7)
+	logger.InfoContext(ctx, "hello", "key", "value")
+	logger.Info("no context")
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), buffer.String())
+	}
+	for _, want := range []string{"key=value", "service=prime", "conn=7"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("want %q in %q", want, lines[0])
+		}
+	}
+	if strings.Contains(lines[1], "service=") {
+		t.Errorf("attributes leaked into a record without context: %q", lines[1])
+	}
+}
+
+func TestWithAttrsDoesNotModifyParent(t *testing.T) {
+	parent := WithAttrs(context.Background(), "a", 1)
+	WithAttrs(parent, "b", 2)
+	if got := len(attrsFromContext(parent)); got != 1 {
+		t.Errorf("parent context has %d attributes, want 1", got)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	defer sampleRate.Store(0)
+
+	var buffer bytes.Buffer
+	Setup(&buffer, Config{Level: slog.LevelWarn, Format: FormatJSON})
+	slog.Info("dropped")
+	slog.WarnContext(WithAttrs(context.Background(), "conn_id", 3), "kept")
+
+	output := strings.TrimSpace(buffer.String())
+	if strings.Contains(output, "dropped") {
+		t.Errorf("record below the level was logged: %s", output)
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(output), &record); err != nil {
+		t.Fatalf("output is not a JSON record: %v: %s", err, output)
+	}
+	if record["msg"] != "kept" || record["conn_id"] != float64(3) {
+		t.Errorf("got %v", record)
+	}
+
+	if err := (Config{Format: "xml"}).Validate(); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestSampler(t *testing.T) {
+	defer sampleRate.Store(0)
+
+	var sampler Sampler
+	sampleRate.Store(3)
+	var allowed []bool
+	for range 7 {
+		allowed = append(allowed, sampler.Allow())
+	}
+	want := []bool{true, false, false, true, false, false, true}
+	if !slices.Equal(allowed, want) {
+		t.Errorf("got %v, want %v", allowed, want)
+	}
+
+	sampleRate.Store(1)
+	for range 3 {
+		if !sampler.Allow() {
+			t.Fatalf("a sample rate of 1 should keep every record")
+		}
+	}
+}
+
+type idConn struct {
+	net.Conn
+	id uint64
+}
+
+func (c *idConn) ConnID() uint64 { return c.id }
+
+type wrapper struct {
+	net.Conn
+}
+
+func (w *wrapper) Unwrap() net.Conn { return w.Conn }
+
+func TestConnID(t *testing.T) {
+	if got := ConnID(&wrapper{Conn: &idConn{id: 42}}); got != 42 {
+		t.Errorf("got %d, want the ID of the wrapped connection", got)
+	}
+	// TLS connections expose the connection they wrap through NetConn
+	if got := ConnID(&wrapper{Conn: tls.Server(&idConn{id: 43}, &tls.Config{})}); got != 43 {
+		t.Errorf("got %d, want the ID of the connection under TLS", got)
+	}
+	first, second := ConnID(&wrapper{}), ConnID(&wrapper{})
+	if first == 0 || first == second {
+		t.Errorf("got IDs %d and %d, want distinct non zero IDs", first, second)
+	}
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/socket"
+)
+
+// Handler returns a HTTP handler that writes the metrics of the registry
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.WriteText(w); err != nil {
+			slog.Warn("write metrics failed", "error", err)
+		}
+	})
+}
+
+// Server exposes the default registry on /metrics
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen binds the metrics server to the address
+func Listen(ctx context.Context, address string) (*Server, error) {
+	listener, err := socket.Listen(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler(Default))
+	return &Server{
+		listener: listener,
+		server:   &http.Server{Handler: mux, ReadHeaderTimeout: time.Second * 10},
+	}, nil
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve serves HTTP requests until the context is cancelled
+func (s *Server) Serve(ctx context.Context) {
+	slog.Info("metrics listening", "address", s.listener.Addr().String())
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.server.Shutdown(shutdownCtx)
+	}()
+	if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("metrics server failed", "error", err)
+	}
+}
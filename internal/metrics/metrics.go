@@ -0,0 +1,259 @@
+// Package metrics implements counters and gauges that are exposed over HTTP in the Prometheus text format. Metrics are
+// usually declared as package level variables, and registered with the default registry when they are created.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a value that only goes up
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	value atomic.Int64
+}
+
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+func (g *Gauge) Add(n int64) {
+	g.value.Add(n)
+}
+
+func (g *Gauge) Set(n int64) {
+	g.value.Store(n)
+}
+
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+// sample is a single line in the exposition format
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+// family is a named metric, with zero or more labelled children
+type family struct {
+	name       string
+	help       string
+	kind       string // "counter" or "gauge"
+	labelNames []string
+
+	mu       sync.Mutex
+	children map[string]any // joined label values -> *Counter / *Gauge
+	values   map[string][]string
+	newChild func() any
+	collect  func() []sample // Set for function backed metrics
+}
+
+func (f *family) child(labelValues []string) any {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", f.name, len(labelValues), len(f.labelNames)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.children[key]
+	if !ok {
+		c = f.newChild()
+		f.children[key] = c
+		f.values[key] = slices.Clone(labelValues)
+	}
+	return c
+}
+
+func (f *family) samples() []sample {
+	if f.collect != nil {
+		return f.collect()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	samples := make([]sample, 0, len(f.children))
+	for key, c := range f.children {
+		s := sample{labelValues: f.values[key]}
+		switch v := c.(type) {
+		case *Counter:
+			s.value = float64(v.Value())
+		case *Gauge:
+			s.value = float64(v.Value())
+		}
+		samples = append(samples, s)
+	}
+	slices.SortFunc(samples, func(a, b sample) int {
+		return slices.Compare(a.labelValues, b.labelValues)
+	})
+	return samples
+}
+
+// CounterVec is a set of counters that share a name, and are distinguished by label values
+type CounterVec struct {
+	f *family
+}
+
+// With returns the counter for the given label values, creating it if needed. The values must be given in the same
+// order as the label names.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return v.f.child(labelValues).(*Counter)
+}
+
+// GaugeVec is a set of gauges that share a name, and are distinguished by label values
+type GaugeVec struct {
+	f *family
+}
+
+// With returns the gauge for the given label values, creating it if needed
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return v.f.child(labelValues).(*Gauge)
+}
+
+// Registry holds a set of metrics that are written out together
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// Default is the registry used by the package level constructors
+var Default = NewRegistry()
+
+func (r *Registry) register(f *family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[f.name]; ok {
+		panic("metric registered twice: " + f.name)
+	}
+	f.children = map[string]any{}
+	f.values = map[string][]string{}
+	r.families[f.name] = f
+}
+
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	f := &family{name: name, help: help, kind: "counter", labelNames: labelNames, newChild: func() any { return &Counter{} }}
+	r.register(f)
+	return &CounterVec{f: f}
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	f := &family{name: name, help: help, kind: "gauge", labelNames: labelNames, newChild: func() any { return &Gauge{} }}
+	r.register(f)
+	return &GaugeVec{f: f}
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+// NewGaugeFunc registers a gauge whose value is computed by calling fn every time the metrics are written
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&family{name: name, help: help, kind: "gauge", collect: func() []sample {
+		return []sample{{value: fn()}}
+	}})
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labelNames...)
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labelNames...)
+}
+
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounter(name, help)
+}
+
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGauge(name, help)
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.NewGaugeFunc(name, help, fn)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteText writes all the metrics in the Prometheus text exposition format, sorted by name
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(families, func(a, b *family) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	var sb strings.Builder
+	for _, f := range families {
+		fmt.Fprintf(&sb, "# HELP %s %s\n", f.name, helpEscaper.Replace(f.help))
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", f.name, f.kind)
+		for _, s := range f.samples() {
+			sb.WriteString(f.name)
+			if len(f.labelNames) > 0 {
+				sb.WriteByte('{')
+				for i, name := range f.labelNames {
+					if i > 0 {
+						sb.WriteByte(',')
+					}
+					fmt.Fprintf(&sb, "%s=\"%s\"", name, labelValueEscaper.Replace(s.labelValues[i]))
+				}
+				sb.WriteByte('}')
+			}
+			sb.WriteByte(' ')
+			sb.WriteString(formatValue(s.value))
+			sb.WriteByte('\n')
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Number of requests", "service", "kind")
+	active := r.NewGauge("test_active", "Active connections")
+	r.NewGaugeFunc("test_func", "Function gauge", func() float64 { return 1.5 })
+
+	requests.With("prime", "valid").Add(3)
+	requests.With("chat", "in\"valid\n").Inc()
+	active.Inc()
+	active.Inc()
+	active.Dec()
+
+	var sb strings.Builder
+	if err := r.WriteText(&sb); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `# HELP test_active Active connections
+# TYPE test_active gauge
+test_active 1
+# HELP test_func Function gauge
+# TYPE test_func gauge
+test_func 1.5
+# HELP test_requests_total Number of requests
+# TYPE test_requests_total counter
+test_requests_total{service="chat",kind="in\"valid\n"} 1
+test_requests_total{service="prime",kind="valid"} 3
+`
+	if sb.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+	}
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("dup", "")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic on duplicate registration")
+		}
+	}()
+	r.NewGauge("dup", "")
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	r := NewRegistry()
+	v := r.NewCounterVec("labels", "", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic on wrong number of label values")
+		}
+	}()
+	v.With("only-one")
+}
@@ -0,0 +1,25 @@
+// Package netutil holds helpers shared by the packages that wrap connections.
+package netutil
+
+import "net"
+
+// Find looks for a connection of type T in the chain of wrapped connections, starting with conn itself. It follows
+// Unwrap, which is implemented by the wrappers of this module, and NetConn, which is implemented by *tls.Conn. T may be
+// an interface, in which case the first connection implementing it is returned
+func Find[T any](conn net.Conn) (T, bool) {
+	for conn != nil {
+		if c, ok := conn.(T); ok {
+			return c, true
+		}
+		switch wrapper := conn.(type) {
+		case interface{ Unwrap() net.Conn }:
+			conn = wrapper.Unwrap()
+		case interface{ NetConn() net.Conn }:
+			conn = wrapper.NetConn()
+		default:
+			conn = nil
+		}
+	}
+	var zero T
+	return zero, false
+}
@@ -0,0 +1,48 @@
+package netutil
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+type wrapper struct {
+	net.Conn
+}
+
+func (w *wrapper) Unwrap() net.Conn {
+	return w.Conn
+}
+
+type marker struct {
+	net.Conn
+}
+
+func (m *marker) Unwrap() net.Conn {
+	return m.Conn
+}
+
+func TestFind(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	m := &marker{Conn: server}
+
+	if got, ok := Find[*marker](&wrapper{Conn: &wrapper{Conn: m}}); !ok || got != m {
+		t.Errorf("Find did not return the wrapped *marker")
+	}
+	// *tls.Conn is followed through NetConn
+	if got, ok := Find[*marker](&wrapper{Conn: tls.Server(m, &tls.Config{})}); !ok || got != m {
+		t.Errorf("Find did not return the *marker wrapped by a *tls.Conn")
+	}
+	// Interfaces match the outermost connection implementing them
+	outer := &wrapper{Conn: m}
+	if got, ok := Find[interface{ Unwrap() net.Conn }](outer); !ok || got != outer {
+		t.Errorf("Find did not return the outermost match")
+	}
+	if _, ok := Find[*marker](&wrapper{Conn: server}); ok {
+		t.Errorf("Find returned a *marker that is not in the chain")
+	}
+	if _, ok := Find[*marker](nil); ok {
+		t.Errorf("Find returned a *marker for a nil connection")
+	}
+}
@@ -0,0 +1,249 @@
+package pcap
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// Fallback addresses, for connections whose addresses are not IP addresses, such as unix sockets
+var (
+	fallbackServer = netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	fallbackClient = netip.AddrFrom4([4]byte{127, 0, 0, 2})
+)
+
+// firstEphemeralPort starts the range of the ports given to clients without an IP address
+const firstEphemeralPort = 49152
+
+// Interface captures the connections of a service
+type Interface struct {
+	w    *Writer
+	id   uint32
+	name string
+	// flows numbers the connections without an IP address, so that each gets a port of its own
+	flows atomic.Uint32
+}
+
+// Name returns the name of the interface
+func (i *Interface) Name() string {
+	return i.name
+}
+
+func (i *Interface) packet(build func(ipID uint16) []byte) {
+	i.w.packet(i.id, build)
+}
+
+// fallbackServer returns the address of the server when it isn't an IP address. Every interface gets its own port, so
+// that the services of a file can be told apart
+func (i *Interface) fallbackServer() netip.AddrPort {
+	return netip.AddrPortFrom(fallbackServer, uint16(i.id+1))
+}
+
+// Conn captures everything that is read from and written to the wrapped stream connection, as a TCP flow from the
+// remote address of the connection to its local address
+type Conn struct {
+	net.Conn
+	iface          *Interface
+	client, server netip.AddrPort
+
+	mu sync.Mutex
+	// Next sequence numbers of each side
+	clientSeq, serverSeq uint32
+	eofOnce, closeOnce   sync.Once
+}
+
+// WrapConn starts a TCP flow for conn, and captures its handshake
+func (i *Interface) WrapConn(conn net.Conn) *Conn {
+	client, ok := addrPort(conn.RemoteAddr())
+	if !ok {
+		client = netip.AddrPortFrom(fallbackClient, uint16(firstEphemeralPort+i.flows.Add(1)%(1<<16-firstEphemeralPort)))
+	}
+	server, ok := addrPort(conn.LocalAddr())
+	if !ok {
+		server = i.fallbackServer()
+	}
+	client, server = sameFamily(client, server)
+	c := &Conn{Conn: conn, iface: i, client: client, server: server, clientSeq: rand.Uint32(), serverSeq: rand.Uint32()}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.segment(true, flagSYN, nil)
+	c.segment(false, flagSYN|flagACK, nil)
+	c.segment(true, flagACK, nil)
+	return c
+}
+
+// segment captures a segment of one side, split if the payload doesn't fit in a packet. The lock must be held
+func (c *Conn) segment(fromClient bool, flags byte, payload []byte) {
+	for {
+		chunk := payload[:min(len(payload), maxSegmentSize)]
+		payload = payload[len(chunk):]
+		src, dst, seq, ack := c.client, c.server, &c.clientSeq, c.serverSeq
+		if !fromClient {
+			src, dst, seq, ack = c.server, c.client, &c.serverSeq, c.clientSeq
+		}
+		if flags == flagSYN {
+			// There is nothing to acknowledge before the other side has sent its SYN
+			ack = 0
+		}
+		s := *seq
+		c.iface.packet(func(ipID uint16) []byte {
+			return tcpSegment(src, dst, ipID, s, ack, flags, chunk)
+		})
+		*seq += uint32(len(chunk))
+		if flags&(flagSYN|flagFIN) != 0 {
+			*seq++
+		}
+		if len(payload) == 0 {
+			return
+		}
+	}
+}
+
+// data captures data sent by one side, and its acknowledgement by the other
+func (c *Conn) data(fromClient bool, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.segment(fromClient, flagPSH|flagACK, payload)
+	c.segment(!fromClient, flagACK, nil)
+}
+
+// fin captures the end of the data of one side, and its acknowledgement by the other
+func (c *Conn) fin(fromClient bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.segment(fromClient, flagFIN|flagACK, nil)
+	c.segment(!fromClient, flagACK, nil)
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.data(true, b[:n])
+	}
+	if errors.Is(err, io.EOF) {
+		c.eofOnce.Do(func() { c.fin(true) })
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.data(false, b[:n])
+	}
+	return n, err
+}
+
+// Close closes the connection, and captures a FIN from the server
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { c.fin(false) })
+	return err
+}
+
+// Unwrap returns the wrapped connection
+func (c *Conn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// PacketConn captures every datagram read from and written to the wrapped packet connection, as UDP packets between
+// its local address and the address of the peer
+type PacketConn struct {
+	net.PacketConn
+	iface *Interface
+	local netip.AddrPort
+}
+
+// WrapPacketConn captures the datagrams of conn
+func (i *Interface) WrapPacketConn(conn net.PacketConn) *PacketConn {
+	local, ok := addrPort(conn.LocalAddr())
+	if !ok {
+		local = i.fallbackServer()
+	}
+	return &PacketConn{PacketConn: conn, iface: i, local: local}
+}
+
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil {
+		c.datagram(addr, true, b[:n])
+	}
+	return n, addr, err
+}
+
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err == nil {
+		c.datagram(addr, false, b[:n])
+	}
+	return n, err
+}
+
+func (c *PacketConn) datagram(addr net.Addr, fromPeer bool, payload []byte) {
+	peer, ok := addrPort(addr)
+	if !ok {
+		peer = netip.AddrPortFrom(fallbackClient, fallbackPort(addr))
+	}
+	peer, local := sameFamily(peer, c.local)
+	src, dst := peer, local
+	if !fromPeer {
+		src, dst = local, peer
+	}
+	c.iface.packet(func(ipID uint16) []byte {
+		return udpDatagram(src, dst, ipID, payload)
+	})
+}
+
+// Unwrap returns the wrapped connection
+func (c *PacketConn) Unwrap() net.PacketConn {
+	return c.PacketConn
+}
+
+// addrPort returns the IP address and port of addr, if it has them
+func addrPort(addr net.Addr) (netip.AddrPort, bool) {
+	var ap netip.AddrPort
+	switch a := addr.(type) {
+	case nil:
+		return ap, false
+	case *net.TCPAddr:
+		ap = a.AddrPort()
+	case *net.UDPAddr:
+		ap = a.AddrPort()
+	default:
+		var err error
+		if ap, err = netip.ParseAddrPort(addr.String()); err != nil {
+			return ap, false
+		}
+	}
+	if !ap.Addr().IsValid() {
+		return ap, false
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap().WithZone(""), ap.Port()), true
+}
+
+// sameFamily turns IPv4 addresses into IPv4-mapped IPv6 addresses when the other address is IPv6, since the headers of
+// a packet hold addresses of a single family
+func sameFamily(a, b netip.AddrPort) (netip.AddrPort, netip.AddrPort) {
+	if a.Addr().Is4() == b.Addr().Is4() {
+		return a, b
+	}
+	return toIPv6(a), toIPv6(b)
+}
+
+func toIPv6(ap netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(netip.AddrFrom16(ap.Addr().As16()), ap.Port())
+}
+
+// fallbackPort gives a stable port to a peer without an IP address, so that the datagrams of each peer form a flow
+func fallbackPort(addr net.Addr) uint16 {
+	h := fnv.New32a()
+	if addr != nil {
+		h.Write([]byte(addr.String()))
+	}
+	return uint16(firstEphemeralPort + h.Sum32()%(1<<16-firstEphemeralPort))
+}
@@ -0,0 +1,119 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// IP protocol numbers
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+)
+
+// TCP flags
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagPSH = 0x08
+	flagACK = 0x10
+)
+
+const (
+	ipv4HeaderSize = 20
+	ipv6HeaderSize = 40
+	tcpHeaderSize  = 20
+	udpHeaderSize  = 8
+	// maxPacketSize is the largest IPv4 packet. IPv6 packets are kept under it as well, so that every packet fits in
+	// the 16 bit length fields of both versions
+	maxPacketSize = 65535
+	// maxSegmentSize is the largest payload of a TCP segment, longer reads and writes are split into several segments
+	maxSegmentSize = maxPacketSize - ipv6HeaderSize - tcpHeaderSize
+	// maxDatagramSize is the largest payload of a UDP packet, longer datagrams are truncated
+	maxDatagramSize = maxPacketSize - ipv6HeaderSize - udpHeaderSize
+)
+
+// tcpSegment builds an IP packet holding a TCP segment
+func tcpSegment(src, dst netip.AddrPort, ipID uint16, seq, ack uint32, flags byte, payload []byte) []byte {
+	segment := binary.BigEndian.AppendUint16(make([]byte, 0, tcpHeaderSize+len(payload)), src.Port())
+	segment = binary.BigEndian.AppendUint16(segment, dst.Port())
+	segment = binary.BigEndian.AppendUint32(segment, seq)
+	segment = binary.BigEndian.AppendUint32(segment, ack)
+	segment = append(segment, tcpHeaderSize/4<<4, flags)
+	segment = binary.BigEndian.AppendUint16(segment, 0xFFFF) // Window
+	segment = binary.BigEndian.AppendUint16(segment, 0)      // Checksum, filled below
+	segment = binary.BigEndian.AppendUint16(segment, 0)      // Urgent pointer
+	segment = append(segment, payload...)
+	binary.BigEndian.PutUint16(segment[16:], transportChecksum(src.Addr(), dst.Addr(), protocolTCP, segment))
+	return ipPacket(src.Addr(), dst.Addr(), ipID, protocolTCP, segment)
+}
+
+// udpDatagram builds an IP packet holding a UDP datagram
+func udpDatagram(src, dst netip.AddrPort, ipID uint16, payload []byte) []byte {
+	payload = payload[:min(len(payload), maxDatagramSize)]
+	datagram := binary.BigEndian.AppendUint16(make([]byte, 0, udpHeaderSize+len(payload)), src.Port())
+	datagram = binary.BigEndian.AppendUint16(datagram, dst.Port())
+	datagram = binary.BigEndian.AppendUint16(datagram, uint16(udpHeaderSize+len(payload)))
+	datagram = binary.BigEndian.AppendUint16(datagram, 0) // Checksum, filled below
+	datagram = append(datagram, payload...)
+	checksum := transportChecksum(src.Addr(), dst.Addr(), protocolUDP, datagram)
+	if checksum == 0 {
+		// Zero means that there is no checksum
+		checksum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(datagram[6:], checksum)
+	return ipPacket(src.Addr(), dst.Addr(), ipID, protocolUDP, datagram)
+}
+
+// ipPacket prepends an IPv4 header if both addresses are IPv4, and an IPv6 header otherwise
+func ipPacket(src, dst netip.Addr, ipID uint16, protocol byte, payload []byte) []byte {
+	if src.Is4() && dst.Is4() {
+		header := []byte{0x45, 0}
+		header = binary.BigEndian.AppendUint16(header, uint16(ipv4HeaderSize+len(payload)))
+		header = binary.BigEndian.AppendUint16(header, ipID)
+		header = binary.BigEndian.AppendUint16(header, 0x4000) // Don't fragment
+		header = append(header, 64, protocol, 0, 0)            // TTL, protocol and checksum, filled below
+		header = append(header, src.AsSlice()...)
+		header = append(header, dst.AsSlice()...)
+		binary.BigEndian.PutUint16(header[10:], ^uint16(sum(0, header)))
+		return append(header, payload...)
+	}
+	header := binary.BigEndian.AppendUint32(nil, 6<<28)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	header = append(header, protocol, 64) // Next header and hop limit
+	src16, dst16 := src.As16(), dst.As16()
+	header = append(header, src16[:]...)
+	header = append(header, dst16[:]...)
+	return append(header, payload...)
+}
+
+// transportChecksum returns the checksum of a TCP segment or UDP datagram, which covers a pseudo header made of the
+// fields of the IP header
+func transportChecksum(src, dst netip.Addr, protocol byte, segment []byte) uint16 {
+	var pseudo []byte
+	if src.Is4() && dst.Is4() {
+		pseudo = append(src.AsSlice(), dst.AsSlice()...)
+		pseudo = append(pseudo, 0, protocol)
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(segment)))
+	} else {
+		src16, dst16 := src.As16(), dst.As16()
+		pseudo = append(src16[:], dst16[:]...)
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(segment)))
+		pseudo = append(pseudo, 0, 0, 0, protocol)
+	}
+	return ^uint16(sum(sum(0, pseudo), segment))
+}
+
+// sum adds b to the one's complement sum s
+func sum(s uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	for s > 0xFFFF {
+		s = s&0xFFFF + s>>16
+	}
+	return s
+}
@@ -0,0 +1,169 @@
+// Package pcap writes the traffic of connections to a pcapng file, so that it can be inspected in Wireshark or tcpdump.
+//
+// Packets are captured at the net.Conn and net.PacketConn layer, without raw sockets: the payloads are the bytes that
+// the server read and wrote, and the IP, TCP and UDP headers around them are synthetic. Every stream connection is
+// captured as its own TCP flow, with a handshake, sequence numbers that follow the data in both directions, and FIN
+// segments when either side closes. Datagrams are captured as UDP packets. Every service is a separate interface of
+// the file, named after the service.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Block types of the pcapng format
+const (
+	blockSectionHeader  = 0x0A0D0D0A
+	blockInterface      = 0x00000001
+	blockEnhancedPacket = 0x00000006
+)
+
+const (
+	byteOrderMagic = 0x1A2B3C4D
+	// linkTypeRaw is LINKTYPE_RAW, packets start with an IPv4 or IPv6 header
+	linkTypeRaw = 101
+	// optionName is if_name, and optionTimestampResolution is if_tsresol
+	optionName                = 2
+	optionTimestampResolution = 9
+	// nanoseconds is the value of if_tsresol for timestamps in nanoseconds
+	nanoseconds = 9
+)
+
+// Writer appends packets to a pcapng file. It is safe for concurrent use
+type Writer struct {
+	// OnError is called once when writing to the file fails. Packets are dropped from then on. It may be nil
+	OnError func(error)
+
+	mu         sync.Mutex
+	w          *bufio.Writer
+	closer     io.Closer // nil unless the Writer owns the file
+	interfaces uint32
+	ipID       uint16
+	err        error
+	closed     bool
+}
+
+// Create creates the file at path, and returns a Writer that captures to it
+func Create(path string) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.closer = file
+	return w, nil
+}
+
+// NewWriter writes the section header to w, and returns a Writer that appends interfaces and packets after it
+func NewWriter(w io.Writer) (*Writer, error) {
+	writer := &Writer{w: bufio.NewWriter(w)}
+	body := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1) // Major version
+	body = binary.LittleEndian.AppendUint16(body, 0) // Minor version
+	// The length of the section is unknown until the file is complete
+	body = binary.LittleEndian.AppendUint64(body, 0xFFFFFFFFFFFFFFFF)
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if err := writer.writeBlock(blockSectionHeader, body); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Interface adds an interface to the file, on which the traffic of a service is captured
+func (w *Writer) Interface(name string) (*Interface, error) {
+	body := binary.LittleEndian.AppendUint16(nil, linkTypeRaw)
+	body = binary.LittleEndian.AppendUint16(body, 0) // Reserved
+	body = binary.LittleEndian.AppendUint32(body, 0) // No snapshot length
+	body = appendOption(body, optionName, []byte(name))
+	body = appendOption(body, optionTimestampResolution, []byte{nanoseconds})
+	body = appendOption(body, 0, nil) // End of options
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.writeBlock(blockInterface, body); err != nil {
+		return nil, err
+	}
+	id := w.interfaces
+	w.interfaces++
+	return &Interface{w: w, id: id, name: name}, nil
+}
+
+// Close flushes the file, and closes it if it was opened by Create. Packets captured after Close are dropped
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	err := w.w.Flush()
+	if w.closer != nil {
+		if closeErr := w.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// packet writes an enhanced packet block. build is called with the lock held, so that the IP identification field
+// follows the order of the packets in the file
+func (w *Writer) packet(id uint32, build func(ipID uint16) []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.err != nil {
+		return
+	}
+	w.ipID++
+	data := build(w.ipID)
+	timestamp := uint64(time.Now().UnixNano())
+	body := binary.LittleEndian.AppendUint32(nil, id)
+	body = binary.LittleEndian.AppendUint32(body, uint32(timestamp>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(timestamp))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = append(body, data...)
+	body = pad(body)
+	if err := w.writeBlock(blockEnhancedPacket, body); err != nil && w.OnError != nil {
+		w.OnError(err)
+	}
+}
+
+// writeBlock writes a block, and flushes it so that the file can be opened while the capture is running. The lock must
+// be held. Once a write fails, the error is returned by every later call
+func (w *Writer) writeBlock(kind uint32, body []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	// The total length is repeated after the body, so that the file can be read backwards
+	length := uint32(len(body) + 12)
+	block := binary.LittleEndian.AppendUint32(make([]byte, 0, length), kind)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	if _, err := w.w.Write(block); err != nil {
+		w.err = err
+		return err
+	}
+	w.err = w.w.Flush()
+	return w.err
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	return pad(append(b, value...))
+}
+
+// pad pads b to a multiple of 4 bytes, as required for the fields of blocks
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
@@ -0,0 +1,307 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+// block is a block read back from a capture
+type block struct {
+	kind uint32
+	body []byte
+}
+
+func readBlocks(t *testing.T, b []byte) []block {
+	t.Helper()
+	var blocks []block
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block: %x", b)
+		}
+		kind, length := binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint32(b[4:])
+		if length%4 != 0 || int(length) > len(b) || binary.LittleEndian.Uint32(b[length-4:]) != length {
+			t.Fatalf("invalid length %d of block 0x%x", length, kind)
+		}
+		blocks = append(blocks, block{kind: kind, body: b[8 : length-4]})
+		b = b[length:]
+	}
+	return blocks
+}
+
+// packet is a captured packet, decoded
+type packet struct {
+	iface    uint32
+	src, dst netip.AddrPort
+	protocol byte
+	seq, ack uint32
+	flags    byte
+	payload  []byte
+}
+
+func decode(t *testing.T, b block) packet {
+	t.Helper()
+	if b.kind != blockEnhancedPacket {
+		t.Fatalf("got block 0x%x, want an enhanced packet block", b.kind)
+	}
+	length := binary.LittleEndian.Uint32(b.body[12:])
+	data := b.body[20 : 20+length]
+	p := packet{iface: binary.LittleEndian.Uint32(b.body)}
+	var src, dst netip.Addr
+	var segment []byte
+	switch data[0] >> 4 {
+	case 4:
+		if sum(0, data[:ipv4HeaderSize]) != 0xFFFF {
+			t.Errorf("invalid IPv4 header checksum")
+		}
+		if int(binary.BigEndian.Uint16(data[2:])) != len(data) {
+			t.Errorf("IPv4 total length %d, packet of %d bytes", binary.BigEndian.Uint16(data[2:]), len(data))
+		}
+		p.protocol = data[9]
+		src, dst = netip.AddrFrom4([4]byte(data[12:16])), netip.AddrFrom4([4]byte(data[16:20]))
+		segment = data[ipv4HeaderSize:]
+	case 6:
+		p.protocol = data[6]
+		src, dst = netip.AddrFrom16([16]byte(data[8:24])), netip.AddrFrom16([16]byte(data[24:40]))
+		segment = data[ipv6HeaderSize:]
+		if int(binary.BigEndian.Uint16(data[4:])) != len(segment) {
+			t.Errorf("IPv6 payload length %d, payload of %d bytes", binary.BigEndian.Uint16(data[4:]), len(segment))
+		}
+	default:
+		t.Fatalf("invalid IP version in %x", data)
+	}
+	if transportChecksum(src, dst, p.protocol, segment) != 0 {
+		t.Errorf("invalid checksum of %x", segment)
+	}
+	p.src = netip.AddrPortFrom(src, binary.BigEndian.Uint16(segment))
+	p.dst = netip.AddrPortFrom(dst, binary.BigEndian.Uint16(segment[2:]))
+	if p.protocol == protocolTCP {
+		p.seq, p.ack = binary.BigEndian.Uint32(segment[4:]), binary.BigEndian.Uint32(segment[8:])
+		p.flags = segment[13]
+		p.payload = segment[tcpHeaderSize:]
+	} else {
+		p.payload = segment[udpHeaderSize:]
+	}
+	return p
+}
+
+// readCapture checks the section header and the interfaces, and returns the names of the interfaces and the packets
+func readCapture(t *testing.T, b []byte) ([]string, []packet) {
+	t.Helper()
+	blocks := readBlocks(t, b)
+	if len(blocks) == 0 || blocks[0].kind != blockSectionHeader || binary.LittleEndian.Uint32(blocks[0].body) != byteOrderMagic {
+		t.Fatalf("capture does not start with a section header")
+	}
+	var names []string
+	var packets []packet
+	for _, b := range blocks[1:] {
+		if b.kind == blockInterface {
+			if binary.LittleEndian.Uint16(b.body) != linkTypeRaw {
+				t.Errorf("got link type %d, want %d", binary.LittleEndian.Uint16(b.body), linkTypeRaw)
+			}
+			// The name is the first option
+			length := binary.LittleEndian.Uint16(b.body[10:])
+			names = append(names, string(b.body[12:12+length]))
+			continue
+		}
+		packets = append(packets, decode(t, b))
+	}
+	return names, packets
+}
+
+func TestConn(t *testing.T) {
+	var capture bytes.Buffer
+	w, err := NewWriter(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface, err := w.Interface("echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := iface.WrapConn(server)
+
+	client.Write([]byte("hello\n"))
+	buffer := make([]byte, 64)
+	n, _ := conn.Read(buffer)
+	conn.Write(buffer[:n])
+	io.ReadFull(client, buffer[:n])
+	client.Close()
+	if _, err := conn.Read(buffer); err != io.EOF {
+		t.Fatalf("got %v, want EOF", err)
+	}
+	conn.Close()
+	w.Close()
+
+	names, packets := readCapture(t, capture.Bytes())
+	if len(names) != 1 || names[0] != "echo" {
+		t.Errorf("got interfaces %q, want [echo]", names)
+	}
+	clientAddr, serverAddr := client.LocalAddr().(*net.TCPAddr).AddrPort(), client.RemoteAddr().(*net.TCPAddr).AddrPort()
+	want := []struct {
+		fromClient bool
+		flags      byte
+		payload    string
+	}{
+		{true, flagSYN, ""},
+		{false, flagSYN | flagACK, ""},
+		{true, flagACK, ""},
+		{true, flagPSH | flagACK, "hello\n"},
+		{false, flagACK, ""},
+		{false, flagPSH | flagACK, "hello\n"},
+		{true, flagACK, ""},
+		{true, flagFIN | flagACK, ""},
+		{false, flagACK, ""},
+		{false, flagFIN | flagACK, ""},
+		{true, flagACK, ""},
+	}
+	if len(packets) != len(want) {
+		t.Fatalf("got %d packets, want %d", len(packets), len(want))
+	}
+	// next holds the next sequence number of each side, once its SYN has been seen
+	next := map[bool]uint32{}
+	for i, w := range want {
+		p := packets[i]
+		src, dst := clientAddr, serverAddr
+		if !w.fromClient {
+			src, dst = serverAddr, clientAddr
+		}
+		if p.src != src || p.dst != dst || p.protocol != protocolTCP || p.flags != w.flags || string(p.payload) != w.payload {
+			t.Errorf("packet %d: got %v > %v flags 0x%02x %q, want %v > %v flags 0x%02x %q", i, p.src, p.dst, p.flags, p.payload, src, dst, w.flags, w.payload)
+		}
+		if seq, ok := next[w.fromClient]; ok && p.seq != seq {
+			t.Errorf("packet %d: got seq %d, want %d", i, p.seq, seq)
+		}
+		if seq, ok := next[!w.fromClient]; ok && p.ack != seq {
+			t.Errorf("packet %d: got ack %d, want %d", i, p.ack, seq)
+		}
+		next[w.fromClient] = p.seq + uint32(len(p.payload))
+		if p.flags&(flagSYN|flagFIN) != 0 {
+			next[w.fromClient]++
+		}
+	}
+}
+
+func TestSegmentSplit(t *testing.T) {
+	var capture bytes.Buffer
+	w, _ := NewWriter(&capture)
+	iface, _ := w.Interface("big")
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := iface.WrapConn(server)
+	go io.Copy(io.Discard, client)
+	data := bytes.Repeat([]byte("x"), maxSegmentSize*2+10)
+	conn.Write(data)
+
+	_, packets := readCapture(t, capture.Bytes())
+	var sizes []int
+	for _, p := range packets[3:] {
+		if len(p.payload) > 0 {
+			sizes = append(sizes, len(p.payload))
+		}
+	}
+	if len(sizes) != 3 || sizes[0] != maxSegmentSize || sizes[1] != maxSegmentSize || sizes[2] != 10 {
+		t.Errorf("got segments of %v bytes, want %d, %d and 10", sizes, maxSegmentSize, maxSegmentSize)
+	}
+	// net.Pipe has no IP addresses
+	if packets[0].src.Addr() != fallbackClient || packets[0].dst != netip.AddrPortFrom(fallbackServer, 1) {
+		t.Errorf("got %v > %v, want fallback addresses", packets[0].src, packets[0].dst)
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	var capture bytes.Buffer
+	w, _ := NewWriter(&capture)
+	w.Interface("other")
+	iface, _ := w.Interface("kv")
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := iface.WrapPacketConn(server)
+	defer conn.Close()
+	client, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Write([]byte("version"))
+	buffer := make([]byte, 64)
+	_, addr, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteTo([]byte("version=1"), addr)
+
+	names, packets := readCapture(t, capture.Bytes())
+	if len(names) != 2 || names[1] != "kv" {
+		t.Errorf("got interfaces %q, want [other kv]", names)
+	}
+	if len(packets) != 2 {
+		t.Fatalf("got %d packets, want 2", len(packets))
+	}
+	clientAddr, serverAddr := client.LocalAddr().(*net.UDPAddr).AddrPort(), server.LocalAddr().(*net.UDPAddr).AddrPort()
+	tests := []struct {
+		src, dst netip.AddrPort
+		payload  string
+	}{
+		{clientAddr, serverAddr, "version"},
+		{serverAddr, clientAddr, "version=1"},
+	}
+	for i, tt := range tests {
+		p := packets[i]
+		if p.iface != 1 || p.protocol != protocolUDP || p.src != tt.src || p.dst != tt.dst || string(p.payload) != tt.payload {
+			t.Errorf("packet %d: got %d %v > %v %q, want 1 %v > %v %q", i, p.iface, p.src, p.dst, p.payload, tt.src, tt.dst, tt.payload)
+		}
+	}
+}
+
+func TestIPv6(t *testing.T) {
+	// An IPv4 address is mapped when the other one is IPv6, since a packet holds addresses of a single family
+	server, client := sameFamily(netip.MustParseAddrPort("127.0.0.1:7"), netip.MustParseAddrPort("[2001:db8::1]:9"))
+	var capture bytes.Buffer
+	w, _ := NewWriter(&capture)
+	iface, _ := w.Interface("v6")
+	iface.packet(func(ipID uint16) []byte { return udpDatagram(server, client, ipID, []byte("ping")) })
+	iface.packet(func(ipID uint16) []byte { return tcpSegment(client, server, ipID, 1, 2, flagACK, []byte("pong")) })
+
+	_, packets := readCapture(t, capture.Bytes())
+	wantServer := netip.MustParseAddrPort("[::ffff:127.0.0.1]:7")
+	if len(packets) != 2 || packets[0].src != wantServer || packets[0].dst != client || string(packets[0].payload) != "ping" {
+		t.Fatalf("got %+v, want a datagram from %v to %v", packets, wantServer, client)
+	}
+	if p := packets[1]; p.protocol != protocolTCP || p.src != client || p.dst != wantServer || string(p.payload) != "pong" {
+		t.Errorf("got %+v, want a segment from %v to %v", p, client, wantServer)
+	}
+}
+
+func TestClose(t *testing.T) {
+	var capture bytes.Buffer
+	w, _ := NewWriter(&capture)
+	iface, _ := w.Interface("closed")
+	w.Close()
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := iface.WrapConn(server)
+	conn.Close()
+	if _, packets := readCapture(t, capture.Bytes()); len(packets) != 0 {
+		t.Errorf("got %d packets after Close, want none", len(packets))
+	}
+}
@@ -0,0 +1,250 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/acl"
+	"github.com/ananthvk/protohackers-go/internal/netutil"
+)
+
+// DefaultHeaderTimeout is the time given to trusted sources to send their header
+const DefaultHeaderTimeout = time.Second * 5
+
+// Config enables the PROXY protocol on the tcp listeners of a service
+type Config struct {
+	Enabled bool
+	// Trusted are the networks of the load balancers. Connections from trusted sources must start with a header, while
+	// connections from any other source are rejected if they send one.
+	Trusted       acl.Prefixes
+	HeaderTimeout time.Duration
+}
+
+// RegisterFlags binds the config to command line flags
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Enabled, "proxy-protocol", c.Enabled, "accept PROXY protocol v1/v2 headers on tcp services from -proxy-trusted sources")
+	fs.Var(&c.Trusted, "proxy-trusted", "comma separated networks (CIDR) or IPs allowed to send PROXY protocol headers")
+	fs.DurationVar(&c.HeaderTimeout, "proxy-header-timeout", c.HeaderTimeout, "time given to trusted sources to send the PROXY protocol header")
+}
+
+// Validate checks that the config is usable
+func (c Config) Validate() error {
+	if c.Enabled && len(c.Trusted) == 0 {
+		return errors.New("proxy protocol requires at least one trusted network")
+	}
+	return nil
+}
+
+// IsTrusted returns true if the address belongs to one of the trusted networks
+func (c Config) IsTrusted(addr net.Addr) bool {
+	return c.Trusted.Contains(addr)
+}
+
+// Listener wraps the connections of a listener in a *Conn. The header is not read by Accept, so that a slow client
+// can't block the accept loop. Call Handshake from the goroutine that serves the connection instead.
+type Listener struct {
+	net.Listener
+	cfg      Config
+	onReject func(addr net.Addr, err error)
+}
+
+// NewListener wraps the listener. Connections that send an invalid header, or a header from an untrusted source, are
+// reported to onReject.
+func NewListener(listener net.Listener, cfg Config, onReject func(addr net.Addr, err error)) *Listener {
+	if cfg.HeaderTimeout == 0 {
+		cfg.HeaderTimeout = DefaultHeaderTimeout
+	}
+	return &Listener{Listener: listener, cfg: cfg, onReject: onReject}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, l.cfg, l.onReject), nil
+}
+
+// Conn is a connection that may start with a PROXY protocol header. Once Handshake has succeeded, RemoteAddr and
+// LocalAddr return the addresses from the header.
+type Conn struct {
+	net.Conn
+	cfg      Config
+	reader   *bufio.Reader
+	onReject func(addr net.Addr, err error)
+
+	handshakeOnce sync.Once
+	handshakeErr  error
+	header        *Header
+
+	// untrusted connections are checked for a header on the first read, since the client may be waiting for the
+	// server to speak first
+	checkOnce sync.Once
+	checkErr  error
+
+	// deadlineMu guards readDeadline, the deadline set by the user of the connection, which is restored after the
+	// header has been read. It is also held while the deadline of the wrapped connection is changed, so that a deadline
+	// set during the handshake (such as the interrupt of a shutdown) is not lost
+	deadlineMu        sync.Mutex
+	readDeadline      time.Time
+	handshakeDeadline time.Time // Zero once the header has been read
+}
+
+// NewConn wraps a connection. onReject may be nil
+func NewConn(conn net.Conn, cfg Config, onReject func(addr net.Addr, err error)) *Conn {
+	return &Conn{Conn: conn, cfg: cfg, reader: bufio.NewReader(conn), onReject: onReject}
+}
+
+func (c *Conn) reject(err error) {
+	if c.onReject != nil {
+		c.onReject(c.Conn.RemoteAddr(), err)
+	}
+}
+
+// Handshake reads the header if the connection comes from a trusted source. It is safe to call more than once, the
+// header is only read the first time.
+func (c *Conn) Handshake() error {
+	c.handshakeOnce.Do(func() {
+		if !c.cfg.IsTrusted(c.Conn.RemoteAddr()) {
+			return
+		}
+		if c.cfg.HeaderTimeout > 0 {
+			c.setHandshakeDeadline(time.Now().Add(c.cfg.HeaderTimeout))
+			defer c.endHandshakeDeadline()
+		}
+		c.header, c.handshakeErr = ReadHeader(c.reader)
+		if c.handshakeErr != nil {
+			c.reject(c.handshakeErr)
+		}
+		// The check is not needed, since the header has already been read
+		c.checkOnce.Do(func() {})
+	})
+	return c.handshakeErr
+}
+
+// setHandshakeDeadline bounds the time taken to read the header
+func (c *Conn) setHandshakeDeadline(deadline time.Time) {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.handshakeDeadline = deadline
+	c.applyReadDeadline()
+}
+
+// endHandshakeDeadline restores the deadline of the user once the header has been read
+func (c *Conn) endHandshakeDeadline() {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.handshakeDeadline = time.Time{}
+	c.applyReadDeadline()
+}
+
+// applyReadDeadline sets the earlier of the deadline of the user and the header timeout on the wrapped connection. The
+// caller must hold deadlineMu
+func (c *Conn) applyReadDeadline() error {
+	deadline := c.readDeadline
+	if deadline.IsZero() || !c.handshakeDeadline.IsZero() && c.handshakeDeadline.Before(deadline) {
+		deadline = c.handshakeDeadline
+	}
+	return c.Conn.SetReadDeadline(deadline)
+}
+
+// SetReadDeadline sets the read deadline of the wrapped connection. During the handshake the header timeout still
+// applies if it's earlier
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline = t
+	return c.applyReadDeadline()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// rejectHeader returns ErrUntrustedHeader if the data sent by the client starts with a header
+func (c *Conn) rejectHeader() error {
+	n := 1
+	for {
+		data, err := c.reader.Peek(n)
+		if err != nil {
+			// Let the caller see the error when it reads
+			return nil
+		}
+		data, _ = c.reader.Peek(c.reader.Buffered())
+		complete, partial := hasSignaturePrefix(data)
+		if complete {
+			return ErrUntrustedHeader
+		}
+		if !partial {
+			return nil
+		}
+		n = len(data) + 1
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	c.checkOnce.Do(func() {
+		c.checkErr = c.rejectHeader()
+		if c.checkErr != nil {
+			c.reject(c.checkErr)
+		}
+	})
+	if c.checkErr != nil {
+		return 0, c.checkErr
+	}
+	return c.reader.Read(b)
+}
+
+// Header returns the header sent by the client, calling Handshake first if needed. It returns nil for untrusted sources,
+// and if the handshake failed.
+func (c *Conn) Header() *Header {
+	if c.Handshake() != nil {
+		return nil
+	}
+	return c.header
+}
+
+// RemoteAddr returns the source address from the header if there is one, and the address of the peer otherwise
+func (c *Conn) RemoteAddr() net.Addr {
+	if header := c.Header(); header != nil && header.Source != nil {
+		return header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address from the header if there is one, and the local address otherwise
+func (c *Conn) LocalAddr() net.Addr {
+	if header := c.Header(); header != nil && header.Destination != nil {
+		return header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// Unwrap returns the wrapped connection
+func (c *Conn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// find looks for a *Conn in the chain of wrapped connections
+func find(conn net.Conn) *Conn {
+	c, _ := netutil.Find[*Conn](conn)
+	return c
+}
+
+// Handshake calls Handshake on the *Conn wrapped by conn. It does nothing if conn does not wrap a *Conn
+func Handshake(conn net.Conn) error {
+	if c := find(conn); c != nil {
+		return c.Handshake()
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Package proxyproto implements the receiving side of the HAProxy PROXY protocol (version 1 and 2), which load balancers
+// use to pass the address of the original client to the server.
+//
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+var (
+	// signatureV1 starts a version 1 (text) header
+	signatureV1 = []byte("PROXY ")
+	// signatureV2 starts a version 2 (binary) header
+	signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// maxV1Length is the maximum length of a version 1 header, including the CRLF
+	maxV1Length = 107
+	// v2HeaderLength is the length of the fixed part of a version 2 header
+	v2HeaderLength = 16
+)
+
+var (
+	ErrNoHeader      = errors.New("proxyproto: connection did not start with a PROXY protocol header")
+	ErrInvalidHeader = errors.New("proxyproto: invalid PROXY protocol header")
+	// ErrUntrustedHeader is returned when a source that is not trusted sends a header
+	ErrUntrustedHeader = errors.New("proxyproto: PROXY protocol header from an untrusted source")
+)
+
+// Header is a parsed PROXY protocol header
+type Header struct {
+	Version int
+	// Source and Destination are nil if the header does not carry addresses (UNKNOWN in version 1, LOCAL or an
+	// unsupported family in version 2). The connection then keeps its own addresses.
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// ReadHeader reads a version 1 or version 2 header from the reader. It returns ErrNoHeader if the data does not start with
+// either signature, and ErrInvalidHeader if the header is malformed.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case signatureV1[0]:
+		return readV1(r)
+	case signatureV2[0]:
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	// Read up to the maximum header length, without consuming data past the header
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxV1Length {
+			return nil, fmt.Errorf("%w: version 1 header too long", ErrInvalidHeader)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		line = append(line, b)
+		if len(line) == len(signatureV1) && !bytes.Equal(line, signatureV1) {
+			return nil, ErrNoHeader
+		}
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return &Header{Version: 1}, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("%w: expected 6 fields in version 1 header, got %d", ErrInvalidHeader, len(fields))
+	}
+	source, err := parseV1Address(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	destination, err := parseV1Address(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	return &Header{Version: 1, Source: source, Destination: destination}, nil
+}
+
+func parseV1Address(family, ip, port string) (net.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	if (family == "TCP4" && !addr.Is4()) || (family == "TCP6" && !addr.Is6()) || (family != "TCP4" && family != "TCP6") {
+		return nil, fmt.Errorf("%w: address %q does not match family %q", ErrInvalidHeader, ip, family)
+	}
+	// Leading zeros are not allowed in the port
+	if port == "" || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrInvalidHeader, port)
+	}
+	number, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrInvalidHeader, port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(number))), nil
+}
+
+// Version 2 commands and address families
+const (
+	commandLocal = 0x0
+	commandProxy = 0x1
+
+	familyInet  = 0x1
+	familyInet6 = 0x2
+
+	protocolStream   = 0x1
+	protocolDatagram = 0x2
+)
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, v2HeaderLength)
+	for i := range signatureV2 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if b != signatureV2[i] {
+			return nil, ErrNoHeader
+		}
+		fixed[i] = b
+	}
+	if _, err := io.ReadFull(r, fixed[len(signatureV2):]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	version, command := fixed[12]>>4, fixed[12]&0xf
+	family, protocol := fixed[13]>>4, fixed[13]&0xf
+	length := binary.BigEndian.Uint16(fixed[14:16])
+	if version != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, version)
+	}
+	if command != commandLocal && command != commandProxy {
+		return nil, fmt.Errorf("%w: unsupported command %d", ErrInvalidHeader, command)
+	}
+
+	// The rest of the header (addresses and TLVs) is always consumed, even if it's not used
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	header := &Header{Version: 2}
+	if command == commandLocal {
+		return header, nil
+	}
+
+	var size int
+	switch family {
+	case familyInet:
+		size = 4
+	case familyInet6:
+		size = 16
+	default:
+		// AF_UNSPEC and AF_UNIX addresses are ignored
+		return header, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, fmt.Errorf("%w: address block too short", ErrInvalidHeader)
+	}
+	source, _ := netip.AddrFromSlice(payload[:size])
+	destination, _ := netip.AddrFromSlice(payload[size : 2*size])
+	sourcePort := binary.BigEndian.Uint16(payload[2*size:])
+	destinationPort := binary.BigEndian.Uint16(payload[2*size+2:])
+	switch protocol {
+	case protocolStream:
+		header.Source = net.TCPAddrFromAddrPort(netip.AddrPortFrom(source, sourcePort))
+		header.Destination = net.TCPAddrFromAddrPort(netip.AddrPortFrom(destination, destinationPort))
+	case protocolDatagram:
+		header.Source = net.UDPAddrFromAddrPort(netip.AddrPortFrom(source, sourcePort))
+		header.Destination = net.UDPAddrFromAddrPort(netip.AddrPortFrom(destination, destinationPort))
+	}
+	return header, nil
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, since the connection was closed in the middle of a header
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// hasSignaturePrefix returns whether data starts with a signature (complete), or could still become one once more
+// data arrives (partial)
+func hasSignaturePrefix(data []byte) (complete bool, partial bool) {
+	for _, signature := range [][]byte{signatureV1, signatureV2} {
+		n := min(len(data), len(signature))
+		if bytes.Equal(data[:n], signature[:n]) {
+			if n == len(signature) {
+				return true, false
+			}
+			partial = true
+		}
+	}
+	return false, partial
+}
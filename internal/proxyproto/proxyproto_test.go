@@ -0,0 +1,254 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/acl"
+)
+
+func TestReadHeaderV1(t *testing.T) {
+	tests := []struct {
+		input  string
+		source string
+		err    error
+	}{
+		{"PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\nhello", "192.168.0.1:56324", nil},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 4000 80\r\nhello", "[2001:db8::1]:4000", nil},
+		{"PROXY UNKNOWN\r\nhello", "", nil},
+		{"PROXY UNKNOWN ffff::1 ffff::2 1 2\r\nhello", "", nil},
+		{"PROXY TCP4 192.168.0.1 10.0.0.1 56324\r\n", "", ErrInvalidHeader},
+		{"PROXY TCP4 2001:db8::1 10.0.0.1 1 2\r\n", "", ErrInvalidHeader},
+		{"PROXY TCP4 192.168.0.1 10.0.0.1 080 443\r\n", "", ErrInvalidHeader},
+		{"PROXY TCP4 192.168.0.1 10.0.0.1 70000 443\r\n", "", ErrInvalidHeader},
+		{"PROXY UDP4 192.168.0.1 10.0.0.1 1 2\r\n", "", ErrInvalidHeader},
+		{"PROXY " + strings.Repeat("x", 200), "", ErrInvalidHeader},
+		{"PROXX TCP4", "", ErrNoHeader},
+		{"GET / HTTP/1.1\r\n", "", ErrNoHeader},
+		{"PROXY TCP4 192.168.0.1", "", io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(strings.NewReader(test.input))
+		header, err := ReadHeader(r)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%q: got error %v, want %v", test.input, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.input, err)
+			continue
+		}
+		if test.source == "" && header.Source != nil {
+			t.Errorf("%q: got source %v, want none", test.input, header.Source)
+		}
+		if test.source != "" && (header.Source == nil || header.Source.String() != test.source) {
+			t.Errorf("%q: got source %v, want %s", test.input, header.Source, test.source)
+		}
+		// The data after the header must not be consumed
+		if rest, _ := io.ReadAll(r); string(rest) != "hello" {
+			t.Errorf("%q: got remaining data %q, want %q", test.input, rest, "hello")
+		}
+	}
+}
+
+// v2Header builds a version 2 header for a tcp connection
+func v2Header(command byte, source, destination netip.AddrPort, tlv []byte) []byte {
+	family := byte(familyInet)
+	if source.Addr().Is6() {
+		family = familyInet6
+	}
+	var addresses []byte
+	addresses = append(addresses, source.Addr().AsSlice()...)
+	addresses = append(addresses, destination.Addr().AsSlice()...)
+	addresses = binary.BigEndian.AppendUint16(addresses, source.Port())
+	addresses = binary.BigEndian.AppendUint16(addresses, destination.Port())
+	addresses = append(addresses, tlv...)
+
+	header := append([]byte{}, signatureV2...)
+	header = append(header, 0x20|command, family<<4|protocolStream)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestReadHeaderV2(t *testing.T) {
+	source := netip.MustParseAddrPort("203.0.113.7:5000")
+	destination := netip.MustParseAddrPort("10.0.0.1:443")
+	source6 := netip.MustParseAddrPort("[2001:db8::7]:5000")
+	destination6 := netip.MustParseAddrPort("[2001:db8::1]:443")
+
+	tests := []struct {
+		name   string
+		input  []byte
+		source string
+	}{
+		{"ipv4", v2Header(commandProxy, source, destination, nil), "203.0.113.7:5000"},
+		{"ipv6", v2Header(commandProxy, source6, destination6, nil), "[2001:db8::7]:5000"},
+		{"tlv", v2Header(commandProxy, source, destination, []byte{0x04, 0x00, 0x01, 0xff}), "203.0.113.7:5000"},
+		{"local", v2Header(commandLocal, source, destination, nil), ""},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(strings.NewReader(string(test.input) + "hello"))
+		header, err := ReadHeader(r)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if header.Version != 2 {
+			t.Errorf("%s: got version %d, want 2", test.name, header.Version)
+		}
+		if got := ""; header.Source != nil {
+			got = header.Source.String()
+			if got != test.source {
+				t.Errorf("%s: got source %s, want %s", test.name, got, test.source)
+			}
+		} else if test.source != "" {
+			t.Errorf("%s: got no source, want %s", test.name, test.source)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "hello" {
+			t.Errorf("%s: got remaining data %q, want %q", test.name, rest, "hello")
+		}
+	}
+
+	// Wrong version, and an address block shorter than the family requires
+	invalid := v2Header(commandProxy, source, destination, nil)
+	invalid[12] = 0x31
+	if _, err := ReadHeader(bufio.NewReader(strings.NewReader(string(invalid)))); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("got %v, want %v for version 3", err, ErrInvalidHeader)
+	}
+	short := v2Header(commandProxy, source, destination, nil)
+	binary.BigEndian.PutUint16(short[14:], 4)
+	if _, err := ReadHeader(bufio.NewReader(strings.NewReader(string(short)))); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("got %v, want %v for a short address block", err, ErrInvalidHeader)
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	var p acl.Prefixes
+	if err := p.Set("10.0.0.0/8, 127.0.0.1,::1"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if got := p.String(); got != "10.0.0.0/8,127.0.0.1/32,::1/128" {
+		t.Errorf("got %q", got)
+	}
+	if err := p.Set("not-an-ip"); err == nil {
+		t.Errorf("expected an error for an invalid network")
+	}
+
+	cfg := Config{Enabled: true, Trusted: p}
+	trusted := map[string]bool{
+		"10.1.2.3:80":          true,
+		"127.0.0.1:1234":       true,
+		"[::ffff:10.0.0.1]:80": true,
+		"[::1]:80":             true,
+		"192.168.0.1:80":       false,
+	}
+	for addr, want := range trusted {
+		if got := cfg.IsTrusted(net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr))); got != want {
+			t.Errorf("IsTrusted(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	if (Config{Enabled: true}).Validate() == nil {
+		t.Errorf("expected an error without trusted networks")
+	}
+}
+
+// serve accepts a single connection on a listener wrapped with the config, and returns it after the handshake
+func serve(t *testing.T, cfg Config, onReject func(net.Addr, error)) (net.Conn, chan net.Conn, chan error) {
+	t.Helper()
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { raw.Close() })
+	listener := NewListener(raw, cfg, onReject)
+	accepted := make(chan net.Conn, 1)
+	handshake := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		handshake <- Handshake(conn)
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, accepted, handshake
+}
+
+func TestTrustedSource(t *testing.T) {
+	cfg := Config{Enabled: true, Trusted: acl.Prefixes{netip.MustParsePrefix("127.0.0.0/8")}}
+	client, accepted, handshake := serve(t, cfg, nil)
+	client.Write([]byte("PROXY TCP4 198.51.100.4 10.0.0.1 40000 8000\r\nhello"))
+	if err := <-handshake; err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	conn := <-accepted
+	if got := conn.RemoteAddr().String(); got != "198.51.100.4:40000" {
+		t.Errorf("got remote address %s, want %s", got, "198.51.100.4:40000")
+	}
+	buffer := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buffer); err != nil || string(buffer) != "hello" {
+		t.Errorf("got %q, %v want %q", buffer, err, "hello")
+	}
+}
+
+func TestTrustedSourceWithoutHeader(t *testing.T) {
+	cfg := Config{Enabled: true, Trusted: acl.Prefixes{netip.MustParsePrefix("127.0.0.0/8")}, HeaderTimeout: time.Millisecond * 100}
+	var rejected error
+	client, _, handshake := serve(t, cfg, func(addr net.Addr, err error) { rejected = err })
+	client.Write([]byte("hello"))
+	if err := <-handshake; !errors.Is(err, ErrNoHeader) || !errors.Is(rejected, ErrNoHeader) {
+		t.Errorf("got %v (reported %v), want %v", err, rejected, ErrNoHeader)
+	}
+
+	// A trusted source that never sends anything times out
+	_, _, handshake = serve(t, cfg, nil)
+	if err := <-handshake; err == nil {
+		t.Errorf("expected the handshake to time out")
+	}
+}
+
+func TestUntrustedSource(t *testing.T) {
+	cfg := Config{Enabled: true, Trusted: acl.Prefixes{netip.MustParsePrefix("192.0.2.0/24")}}
+
+	// Untrusted clients are served with their own address, and the handshake does not wait for data
+	client, accepted, handshake := serve(t, cfg, nil)
+	if err := <-handshake; err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	conn := <-accepted
+	if conn.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Errorf("got remote address %s, want %s", conn.RemoteAddr(), client.LocalAddr())
+	}
+	// Data that only looks like the start of a signature is passed through
+	client.Write([]byte("PRO"))
+	time.Sleep(time.Millisecond * 20)
+	client.Write([]byte("P"))
+	buffer := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buffer); err != nil || string(buffer) != "PROP" {
+		t.Errorf("got %q, %v want %q", buffer, err, "PROP")
+	}
+
+	// A header from an untrusted client is rejected on the first read
+	var rejected error
+	client, accepted, _ = serve(t, cfg, func(addr net.Addr, err error) { rejected = err })
+	conn = <-accepted
+	client.Write([]byte("PROXY TCP4 198.51.100.4 10.0.0.1 40000 8000\r\n"))
+	if _, err := conn.Read(buffer); !errors.Is(err, ErrUntrustedHeader) || !errors.Is(rejected, ErrUntrustedHeader) {
+		t.Errorf("got %v (reported %v), want %v", err, rejected, ErrUntrustedHeader)
+	}
+}
@@ -0,0 +1,248 @@
+// Package record writes the traffic of connections to files, and replays the client side of those files against a
+// server, so that real sessions can be turned into regression fixtures.
+//
+// A recording is a JSON lines file. The first line is the Header, and every other line is a Chunk of bytes that was
+// read from the client (Inbound) or written to it (Outbound). Chunks that are valid UTF-8 are stored as text, so that
+// recordings of line based protocols stay readable, and binary chunks are stored as base64.
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Directions of a chunk
+const (
+	// Inbound chunks were sent by the client
+	Inbound = "in"
+	// Outbound chunks were sent by the server
+	Outbound = "out"
+)
+
+// Header describes the recorded connection
+type Header struct {
+	Service string    `json:"service"`
+	Remote  string    `json:"remote"`
+	Local   string    `json:"local"`
+	Start   time.Time `json:"start"`
+}
+
+// Chunk is the data returned by a single Read, or passed to a single Write
+type Chunk struct {
+	// Offset is the time since the start of the recording
+	Offset    time.Duration `json:"offset"`
+	Direction string        `json:"dir"`
+	Text      string        `json:"text,omitempty"`
+	Binary    []byte        `json:"data,omitempty"`
+}
+
+// Data returns the bytes of the chunk
+func (c Chunk) Data() []byte {
+	if c.Binary != nil {
+		return c.Binary
+	}
+	return []byte(c.Text)
+}
+
+func newChunk(offset time.Duration, direction string, data []byte) Chunk {
+	chunk := Chunk{Offset: offset, Direction: direction}
+	if utf8.Valid(data) {
+		chunk.Text = string(data)
+	} else {
+		chunk.Binary = append([]byte(nil), data...)
+	}
+	return chunk
+}
+
+// Recording is a recorded connection
+type Recording struct {
+	Header Header
+	Chunks []Chunk
+}
+
+// Output returns everything the server sent, in order
+func (r *Recording) Output() []byte {
+	var output []byte
+	for _, chunk := range r.Chunks {
+		if chunk.Direction == Outbound {
+			output = append(output, chunk.Data()...)
+		}
+	}
+	return output
+}
+
+// ReadFile loads a recording
+func ReadFile(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	recording, err := Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return recording, nil
+}
+
+// Decode reads a recording from r
+func Decode(r io.Reader) (*Recording, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	var recording Recording
+	if err := decoder.Decode(&recording.Header); err != nil {
+		return nil, fmt.Errorf("invalid recording header: %w", err)
+	}
+	for {
+		var chunk Chunk
+		err := decoder.Decode(&chunk)
+		if errors.Is(err, io.EOF) {
+			return &recording, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk %d: %w", len(recording.Chunks)+1, err)
+		}
+		if chunk.Direction != Inbound && chunk.Direction != Outbound {
+			return nil, fmt.Errorf("invalid direction %q in chunk %d", chunk.Direction, len(recording.Chunks)+1)
+		}
+		recording.Chunks = append(recording.Chunks, chunk)
+	}
+}
+
+// Writer appends chunks to a recording. It is safe for concurrent use
+type Writer struct {
+	mu      sync.Mutex
+	w       io.WriteCloser
+	encoder *json.Encoder
+	start   time.Time
+	err     error
+	closed  bool
+}
+
+// NewWriter writes the header to w, and returns a Writer that appends chunks after it. The header's Start is used as
+// the reference of chunk offsets.
+func NewWriter(w io.WriteCloser, header Header) (*Writer, error) {
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, encoder: encoder, start: header.Start}, nil
+}
+
+// Write appends a chunk. Once a write fails, the error is returned by every later call. Chunks written after Close are
+// dropped.
+func (w *Writer) Write(direction string, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	if w.err == nil {
+		w.err = w.encoder.Encode(newChunk(time.Since(w.start), direction, data))
+	}
+	return w.err
+}
+
+// Close closes the underlying writer
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return w.w.Close()
+}
+
+// Conn records everything that is read from and written to the wrapped connection
+type Conn struct {
+	net.Conn
+	w         *Writer
+	onError   func(error)
+	errorOnce sync.Once
+	closeOnce sync.Once
+}
+
+// NewConn wraps conn, recording its traffic to w. onError is called once if the recording fails, the connection itself
+// keeps working. It may be nil.
+func NewConn(conn net.Conn, w *Writer, onError func(error)) *Conn {
+	return &Conn{Conn: conn, w: w, onError: onError}
+}
+
+func (c *Conn) record(direction string, data []byte) {
+	if err := c.w.Write(direction, data); err != nil && c.onError != nil {
+		c.errorOnce.Do(func() { c.onError(err) })
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.record(Inbound, b[:n])
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.record(Outbound, b[:n])
+	return n, err
+}
+
+// Close closes the connection and the recording
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { c.w.Close() })
+	return err
+}
+
+// Unwrap returns the wrapped connection
+func (c *Conn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// Recorder creates a recording in Dir for every connection it wraps
+type Recorder struct {
+	Dir     string
+	Service string
+	// OnError is called when a recording fails after it was created. It may be nil
+	OnError func(conn net.Conn, err error)
+}
+
+// Wrap creates a new recording file for the connection, named after the service and the time it started
+func (r *Recorder) Wrap(conn net.Conn) (*Conn, error) {
+	start := time.Now()
+	file, err := os.CreateTemp(r.Dir, fmt.Sprintf("%s-%s-*.jsonl", r.Service, start.UTC().Format("20060102T150405.000")))
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(file, Header{
+		Service: r.Service,
+		Remote:  conn.RemoteAddr().String(),
+		Local:   conn.LocalAddr().String(),
+		Start:   start,
+	})
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	var onError func(error)
+	if r.OnError != nil {
+		onError = func(err error) { r.OnError(conn, err) }
+	}
+	return NewConn(conn, w, onError), nil
+}
+
+// Path returns the path of the recording file, or an empty string if it isn't written to a file
+func (c *Conn) Path() string {
+	if file, ok := c.w.w.(*os.File); ok {
+		return file.Name()
+	}
+	return ""
+}
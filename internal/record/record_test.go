@@ -0,0 +1,113 @@
+package record
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upper answers every line with the line in upper case (ASCII only), after greeting the client
+func upper(ctx context.Context, conn net.Conn) {
+	conn.Write([]byte("hello\n"))
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		for i, c := range line {
+			if 'a' <= c && c <= 'z' {
+				line[i] = c - 'a' + 'A'
+			}
+		}
+		conn.Write(line)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	recorder := &Recorder{Dir: dir, Service: "upper"}
+	client, server := net.Pipe()
+	recorded, err := recorder.Wrap(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer recorded.Close()
+		upper(context.Background(), recorded)
+	}()
+
+	reader := bufio.NewReader(client)
+	reader.ReadString('\n')
+	client.Write([]byte("abc\n"))
+	reader.ReadString('\n')
+	client.Write([]byte{0xff, 0xfe, '\n'})
+	reader.ReadString('\n')
+	client.Close()
+	<-done
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "upper-*.jsonl"))
+	if len(paths) != 1 || paths[0] != recorded.Path() {
+		t.Fatalf("got recordings %v, want %s", paths, recorded.Path())
+	}
+	recording, err := ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recording.Header.Service != "upper" || recording.Header.Remote != "pipe" {
+		t.Errorf("got header %+v", recording.Header)
+	}
+	if got, want := string(recording.Output()), "hello\nABC\n\xff\xfe\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+	// Binary data is stored as base64
+	contents, _ := os.ReadFile(paths[0])
+	if !strings.Contains(string(contents), `"text":"abc\n"`) || !strings.Contains(string(contents), `"data":"//4K"`) {
+		t.Errorf("unexpected encoding:\n%s", contents)
+	}
+
+	output, err := ReplayHandler(context.Background(), upper, recording, ReplayOptions{Wait: time.Second})
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if diff := Diff(recording.Output(), output); diff != "" {
+		t.Error(diff)
+	}
+
+	// A server that answers differently is reported
+	lower := func(ctx context.Context, conn net.Conn) {
+		conn.Write([]byte("hello\n"))
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(line))
+		}
+	}
+	output, _ = ReplayHandler(context.Background(), lower, recording, ReplayOptions{Wait: time.Millisecond * 100})
+	if diff := Diff(recording.Output(), output); !strings.Contains(diff, "byte 6 (line 2)") {
+		t.Errorf("got diff %q", diff)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	invalid := []string{
+		``,
+		`{"service": "prime"}` + "\n" + `{"dir": "sideways", "text": "x"}`,
+		`{"service": "prime"}` + "\n" + `{"dir": "in", "text": `,
+	}
+	for _, input := range invalid {
+		if _, err := Decode(strings.NewReader(input)); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Package recordtest replays recordings in tests. It's separate from package record, so that the servers that record
+// connections don't link the testing package.
+package recordtest
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/ananthvk/protohackers-go/internal/record"
+)
+
+// TestFile replays the recording at path against handler, and fails the test if the output doesn't match the recording
+func TestFile(t *testing.T, handler func(context.Context, net.Conn), path string) {
+	t.Helper()
+	recording, err := record.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := record.ReplayHandler(t.Context(), handler, recording, record.ReplayOptions{})
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if diff := record.Diff(recording.Output(), output); diff != "" {
+		t.Error(diff)
+	}
+}
+
+// TestDir replays every recording of dir in a subtest, see TestFile. Each recording runs against a handler returned by
+// newHandler, so that the sessions don't share any state. It fails if dir has no recordings
+func TestDir(t *testing.T, newHandler func() func(context.Context, net.Conn), dir string) {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no recordings found in %s: %v", dir, err)
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			TestFile(t, newHandler(), path)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultWait is the time Replay waits for output the server still owes before moving on
+const DefaultWait = time.Second * 2
+
+// ReplayOptions controls how a recording is played back
+type ReplayOptions struct {
+	// Realtime sends every chunk at its recorded offset. Otherwise a chunk is sent as soon as the server has sent as
+	// much output as it had when the chunk was recorded.
+	Realtime bool
+	// Wait is the longest time to wait for the server to send output it owes, while it sends nothing. Zero uses
+	// DefaultWait
+	Wait time.Duration
+}
+
+// output collects what the server sends
+type output struct {
+	mu       sync.Mutex
+	data     []byte
+	done     bool          // the server closed the connection, or the read failed
+	progress chan struct{} // signalled whenever data or done changes
+}
+
+func (o *output) readFrom(conn net.Conn) {
+	buffer := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buffer)
+		o.mu.Lock()
+		o.data = append(o.data, buffer[:n]...)
+		if err != nil {
+			o.done = true
+		}
+		o.mu.Unlock()
+		select {
+		case o.progress <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// waitFor waits until at least n bytes have been received, the server stops sending, or it sends nothing for wait
+func (o *output) waitFor(ctx context.Context, n int, wait time.Duration) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		o.mu.Lock()
+		received, done := len(o.data), o.done
+		o.mu.Unlock()
+		if received >= n || done {
+			return
+		}
+		select {
+		case <-o.progress:
+			timer.Reset(wait)
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (o *output) bytes() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return bytes.Clone(o.data)
+}
+
+// Replay plays the client side of the recording over conn, and returns what the server sent back. It returns once the
+// server has sent as much output as in the recording, or has stopped sending. The caller closes conn.
+func Replay(ctx context.Context, conn net.Conn, recording *Recording, opts ReplayOptions) ([]byte, error) {
+	wait := opts.Wait
+	if wait <= 0 {
+		wait = DefaultWait
+	}
+	received := &output{progress: make(chan struct{}, 1)}
+	go received.readFrom(conn)
+
+	start := time.Now()
+	expected := 0 // Output recorded before the current chunk
+	for _, chunk := range recording.Chunks {
+		if chunk.Direction == Outbound {
+			expected += len(chunk.Data())
+			continue
+		}
+		if opts.Realtime {
+			select {
+			case <-time.After(time.Until(start.Add(chunk.Offset))):
+			case <-ctx.Done():
+				return received.bytes(), ctx.Err()
+			}
+		} else {
+			received.waitFor(ctx, expected, wait)
+		}
+		if _, err := conn.Write(chunk.Data()); err != nil {
+			return received.bytes(), fmt.Errorf("send chunk at %v: %w", chunk.Offset, err)
+		}
+	}
+	received.waitFor(ctx, expected, wait)
+	return received.bytes(), ctx.Err()
+}
+
+// ReplayHandler plays the recording against an in-process handler over a net.Pipe, and returns what the handler sent
+// back. The handler is given a context that is cancelled once the replay is over, and the call waits for it to return.
+func ReplayHandler(ctx context.Context, handler func(context.Context, net.Conn), recording *Recording, opts ReplayOptions) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer server.Close()
+		handler(ctx, server)
+	}()
+	output, err := Replay(ctx, client, recording, opts)
+	cancel()
+	client.Close()
+	<-done
+	return output, err
+}
+
+// Diff compares the output of a replay with the recorded output. It returns an empty string if they are equal, and a
+// description of the first difference otherwise.
+func Diff(want, got []byte) string {
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	i := 0
+	for i < len(want) && i < len(got) && want[i] == got[i] {
+		i++
+	}
+	line := bytes.Count(want[:i], []byte("\n")) + 1
+	return fmt.Sprintf("output differs at byte %d (line %d): want %q, got %q (%d bytes recorded, %d received)",
+		i, line, snippet(want, i), snippet(got, i), len(want), len(got))
+}
+
+// snippet returns the data around offset i, starting at the beginning of its line
+func snippet(data []byte, i int) []byte {
+	const before, after = 40, 40
+	start := max(bytes.LastIndexByte(data[:min(i, len(data))], '\n')+1, i-before, 0)
+	end := min(i+after, len(data))
+	if start > end {
+		return nil
+	}
+	return data[start:end]
+}
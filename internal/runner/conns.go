@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// deadliner is implemented by both net.Conn and net.PacketConn
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// connSet keeps track of the live connections of a service, so that they can be interrupted and closed on shutdown
+type connSet struct {
+	mu       sync.Mutex
+	conns    map[deadliner]struct{}
+	draining bool
+	wg       sync.WaitGroup
+}
+
+// add starts tracking the connection. It returns false if the set is draining, and no new connections are allowed.
+func (c *connSet) add(conn deadliner) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.draining {
+		return false
+	}
+	if c.conns == nil {
+		c.conns = map[deadliner]struct{}{}
+	}
+	c.conns[conn] = struct{}{}
+	c.wg.Add(1)
+	return true
+}
+
+func (c *connSet) done(conn deadliner) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, conn)
+	c.wg.Done()
+}
+
+// serve runs the handler in a new goroutine, and closes the connection once the handler returns. If the set is draining
+// the connection is closed immediately, and false is returned.
+func (c *connSet) serve(ctx context.Context, conn net.Conn, handler Handler) bool {
+	if !c.add(conn) {
+		conn.Close()
+		return false
+	}
+	go func() {
+		defer c.done(conn)
+		defer conn.Close()
+		handler(ctx, conn)
+	}()
+	return true
+}
+
+func (c *connSet) servePacket(ctx context.Context, conn net.PacketConn, handler PacketHandler) {
+	if !c.add(conn) {
+		return
+	}
+	go func() {
+		defer c.done(conn)
+		handler(ctx, conn)
+	}()
+}
+
+// interrupter is implemented by timeout.Conn, whose interrupt can't be undone by a later deadline
+type interrupter interface {
+	Interrupt()
+}
+
+// interrupt stops new connections from being added, and makes the reads of every connection fail, so that handlers
+// blocked waiting for the next request return, while writes that are in progress can still complete. Stream
+// connections stay interrupted, packet connections only get an immediate read deadline
+func (c *connSet) interrupt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.draining = true
+	now := time.Now()
+	for conn := range c.conns {
+		if i, ok := conn.(interrupter); ok {
+			i.Interrupt()
+			continue
+		}
+		conn.SetReadDeadline(now)
+	}
+}
+
+// closeAll closes every tracked connection and returns the number of connections that were closed
+func (c *connSet) closeAll() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for conn := range c.conns {
+		conn.Close()
+	}
+	return len(c.conns)
+}
+
+func (c *connSet) wait() {
+	c.wg.Wait()
+}
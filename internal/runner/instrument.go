@@ -0,0 +1,98 @@
+package runner
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/ananthvk/protohackers-go/internal/metrics"
+	"github.com/ananthvk/protohackers-go/internal/netutil"
+)
+
+var (
+	connectionsActive = metrics.NewGaugeVec("protohackers_connections_active", "Number of connections currently being handled", "service")
+	connectionsTotal  = metrics.NewCounterVec("protohackers_connections_total", "Number of connections accepted", "service")
+	receivedBytes     = metrics.NewCounterVec("protohackers_received_bytes_total", "Number of bytes read from clients", "service")
+	sentBytes         = metrics.NewCounterVec("protohackers_sent_bytes_total", "Number of bytes written to clients", "service")
+
+	connectionsRejected = metrics.NewCounterVec("protohackers_connections_rejected_total", "Number of connections closed because of a limit, by reason", "service", "reason")
+	packetsDropped      = metrics.NewCounterVec("protohackers_packets_dropped_total", "Number of udp packets dropped because of the packet rate limit", "service")
+	packetsDenied       = metrics.NewCounterVec("protohackers_packets_denied_total", "Number of udp packets dropped because of the access lists", "service")
+	throttled           = metrics.NewCounterVec("protohackers_throttled_milliseconds_total", "Time reads and writes were delayed by the byte rate limits", "service")
+)
+
+// serviceMetrics holds the per service children of the connection metrics
+type serviceMetrics struct {
+	active   *metrics.Gauge
+	total    *metrics.Counter
+	received *metrics.Counter
+	sent     *metrics.Counter
+}
+
+func newServiceMetrics(name string) serviceMetrics {
+	return serviceMetrics{
+		active:   connectionsActive.With(name),
+		total:    connectionsTotal.With(name),
+		received: receivedBytes.With(name),
+		sent:     sentBytes.With(name),
+	}
+}
+
+// countingConn counts the bytes that are read from and written to the connection, both in the metrics of the service
+// and for the connection itself
+type countingConn struct {
+	net.Conn
+	m        *serviceMetrics
+	received atomic.Uint64
+	sent     atomic.Uint64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.m.received.Add(uint64(n))
+	c.received.Add(uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.m.sent.Add(uint64(n))
+	c.sent.Add(uint64(n))
+	return n, err
+}
+
+// bytes returns the number of bytes transferred over the connection. It can be called on a nil *countingConn
+func (c *countingConn) bytes() (received, sent uint64) {
+	if c == nil {
+		return 0, 0
+	}
+	return c.received.Load(), c.sent.Load()
+}
+
+// findCountingConn looks for the *countingConn in the chain of wrapped connections, and returns nil if there is none
+func findCountingConn(conn net.Conn) *countingConn {
+	c, _ := netutil.Find[*countingConn](conn)
+	return c
+}
+
+// Unwrap returns the wrapped connection
+func (c *countingConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// countingPacketConn counts the bytes of the packets that are read and written
+type countingPacketConn struct {
+	net.PacketConn
+	m *serviceMetrics
+}
+
+func (c *countingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	c.m.received.Add(uint64(n))
+	return n, addr, err
+}
+
+func (c *countingPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	c.m.sent.Add(uint64(n))
+	return n, err
+}
@@ -0,0 +1,751 @@
+// Package runner contains the accept loop and shutdown logic shared by all the challenge servers.
+package runner
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/acl"
+	"github.com/ananthvk/protohackers-go/internal/admin"
+	"github.com/ananthvk/protohackers-go/internal/chaos"
+	"github.com/ananthvk/protohackers-go/internal/config"
+	"github.com/ananthvk/protohackers-go/internal/limit"
+	"github.com/ananthvk/protohackers-go/internal/logging"
+	"github.com/ananthvk/protohackers-go/internal/metrics"
+	"github.com/ananthvk/protohackers-go/internal/pcap"
+	"github.com/ananthvk/protohackers-go/internal/proxyproto"
+	"github.com/ananthvk/protohackers-go/internal/record"
+	"github.com/ananthvk/protohackers-go/internal/session"
+	"github.com/ananthvk/protohackers-go/internal/socket"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+	"github.com/ananthvk/protohackers-go/internal/tlsutil"
+)
+
+const (
+	// DefaultDrainTimeout is the time given to in-flight handlers to finish after a shutdown signal
+	DefaultDrainTimeout = time.Second * 10
+
+	// minAcceptDelay and maxAcceptDelay bound the wait after a failed accept, which doubles while accepts keep failing
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+	// forceCloseGrace is how long to wait for handlers to return after their connections have been forcefully closed
+	forceCloseGrace = time.Second
+)
+
+// Exit codes used by Main
+const (
+	ExitOK     = 0 // All handlers finished within the drain timeout
+	ExitError  = 1 // The servers could not be started
+	ExitForced = 2 // Some connections had to be closed forcefully during shutdown
+)
+
+// Options holds the settings shared by all the services in a process
+type Options struct {
+	// DrainTimeout is the time given to in-flight handlers to finish after shutdown begins
+	DrainTimeout time.Duration
+	// MetricsAddress is the address of the HTTP listener that exposes metrics on /metrics. Metrics are not served if empty
+	MetricsAddress string
+	// AdminAddress is the address of the HTTP listener of the admin API, which lists and closes live sessions (see the
+	// admin package). It is disabled if empty
+	AdminAddress string
+	// Limits are applied to each service separately
+	Limits limit.Config
+	// Access restricts the clients of every service, in addition to Service.Access
+	Access acl.List
+	// Timeouts override the timeouts of every service, see timeout.Policy.Override
+	Timeouts timeout.Policy
+	// TLS is the certificate served by tcp services. See Service.TLSAddress for where TLS is served
+	TLS tlsutil.Config
+	// ProxyProtocol enables PROXY protocol headers on the tcp listeners of every service
+	ProxyProtocol proxyproto.Config
+	// Logging is installed as the default logger by Main
+	Logging logging.Config
+	// Chaos injects network faults into every connection, see the chaos package. It is meant for testing only
+	Chaos chaos.Config
+	// RecordDir is the directory where the traffic of every stream connection is recorded, see the record package.
+	// Nothing is recorded if empty
+	RecordDir string
+	// CaptureFile is the pcapng file where the traffic of every service is captured, with synthetic IP headers, see the
+	// pcap package. Nothing is captured if empty
+	CaptureFile string
+}
+
+// DefaultOptions returns the options used when no flags are given
+func DefaultOptions() Options {
+	return Options{
+		DrainTimeout:  DefaultDrainTimeout,
+		ProxyProtocol: proxyproto.Config{HeaderTimeout: proxyproto.DefaultHeaderTimeout},
+		Logging:       logging.DefaultConfig(),
+	}
+}
+
+// RegisterFlags binds the options to command line flags
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	o.registerFlags(fs, true, true)
+}
+
+// RegisterPacketFlags binds the options that apply to udp services to command line flags, for processes that only run
+// udp services. The tcp only options (TLS and the PROXY protocol) are left out, and so are the options of stream
+// connections unless streams is true, for services that run a StreamListener such as lrcp.
+func (o *Options) RegisterPacketFlags(fs *flag.FlagSet, streams bool) {
+	o.registerFlags(fs, false, streams)
+}
+
+// registerFlags binds the options to command line flags, with the tcp only options if tcp is true, and the options of
+// stream connections if streams is true
+func (o *Options) registerFlags(fs *flag.FlagSet, tcp, streams bool) {
+	fs.DurationVar(&o.DrainTimeout, "drain-timeout", o.DrainTimeout, "time to wait for connections to finish on shutdown")
+	fs.StringVar(&o.MetricsAddress, "metrics-address", o.MetricsAddress, "serve prometheus metrics on this address (disabled if empty)")
+	fs.StringVar(&o.AdminAddress, "admin-address", o.AdminAddress, "serve the admin API, which lists and closes live sessions, on this address (disabled if empty, no authentication)")
+	if streams {
+		o.Limits.RegisterConnFlags(fs)
+	}
+	o.Limits.RegisterPacketFlags(fs)
+	o.Access.RegisterFlags(fs, "", "clients of every service")
+	if streams {
+		o.Timeouts.RegisterFlags(fs)
+	}
+	if tcp {
+		o.TLS.RegisterFlags(fs)
+		o.ProxyProtocol.RegisterFlags(fs)
+	}
+	o.Logging.RegisterFlags(fs)
+	fs.Var(&o.Chaos, "chaos", "inject network faults into every connection, for testing only: comma separated key=value pairs such as seed=1,fragment=0.5,latency=20ms,drop=0.1")
+	if streams {
+		fs.StringVar(&o.RecordDir, "record-dir", o.RecordDir, "record the traffic of every tcp (and lrcp) connection to a file in this directory, for the replay command")
+	}
+	fs.StringVar(&o.CaptureFile, "capture", o.CaptureFile, "capture the traffic of every service to this pcapng file, with synthetic IP headers, for Wireshark")
+}
+
+// reasonProxyProtocol is the reason reported for connections closed because of their PROXY protocol header
+const reasonProxyProtocol = "proxy_protocol"
+
+// Validate checks the options, so that mistakes are reported before any service is started
+func (o *Options) Validate() error {
+	if o.DrainTimeout < 0 {
+		return errors.New("drain timeout must not be negative")
+	}
+	if err := o.Limits.Validate(); err != nil {
+		return err
+	}
+	if err := o.TLS.Validate(); err != nil {
+		return err
+	}
+	if err := o.ProxyProtocol.Validate(); err != nil {
+		return err
+	}
+	return o.Logging.Validate()
+}
+
+// ParseFlags parses the command line flags, and then fills the flags that were not given from the file passed to
+// -config and from PROTOHACKERS_* environment variables (see the config package). The validators are called on the
+// result. With -print-config, the effective settings are printed to stdout and the process exits.
+func ParseFlags(validators ...func() error) {
+	fs := flag.CommandLine
+	configPath := fs.String("config", "", "JSON config file, with flag names as keys")
+	printConfig := fs.Bool("print-config", false, "print the effective settings as a config file and exit")
+	flag.Parse()
+
+	err := config.Apply(fs, *configPath, os.Environ())
+	for _, validate := range validators {
+		if err != nil {
+			break
+		}
+		err = validate()
+	}
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(ExitError)
+	}
+	if *printConfig {
+		if err := config.Print(os.Stdout, fs, "config", "print-config"); err != nil {
+			slog.Error("print config failed", "error", err)
+			os.Exit(ExitError)
+		}
+		os.Exit(ExitOK)
+	}
+}
+
+// ErrDrainTimeout is returned when handlers did not finish within the drain timeout, and their connections were closed
+var ErrDrainTimeout = errors.New("drain timeout exceeded, remaining connections closed forcefully")
+
+// Handler handles a single stream connection. The context is cancelled when the server starts shutting down.
+type Handler func(ctx context.Context, conn net.Conn)
+
+// PacketHandler serves a packet connection. It should return once the context is cancelled or a read fails.
+type PacketHandler func(ctx context.Context, conn net.PacketConn)
+
+// Service describes a single server, the address it listens on and how connections are handled
+type Service struct {
+	// Name identifies the service in logs
+	Name string
+	// Network is either "tcp" or "udp"
+	Network string
+	// Address is host:port, or unix:/path or systemd:name (see the socket package). A "udp" service binds a datagram
+	// socket at unix:/path
+	Address string
+
+	// TLSAddress is an additional address of a "tcp" service that serves TLS, while Address keeps serving plain text.
+	// If it's empty and a certificate is configured in Options, Address serves TLS instead.
+	TLSAddress string
+
+	// Handler is called in a new goroutine for every accepted stream connection
+	Handler Handler
+
+	// PacketHandler is called once with the packet connection of a "udp" service
+	PacketHandler PacketHandler
+
+	// StreamListener turns the packet connection of a "udp" service into a stream listener (for example lrcp), whose
+	// connections are then passed to Handler. The context carries the logging attributes of the service.
+	StreamListener func(ctx context.Context, conn net.PacketConn) net.Listener
+
+	// Timeouts is the default timeout policy of the protocol, applied to every stream connection
+	Timeouts timeout.Policy
+
+	// Access restricts the clients of the service, in addition to Options.Access. It is checked once the PROXY protocol
+	// header has been read for stream connections, and on every packet for "udp" services
+	Access acl.List
+
+	timeouts    timeout.Policy // Timeouts after applying the overrides from Options
+	listener    net.Listener
+	tlsListener net.Listener // Only set when TLS is served on TLSAddress
+	packetConn  net.PacketConn
+	conns       connSet
+	metrics     serviceMetrics
+	limiter     *limit.Limiter
+	access      acl.List          // Access from Options
+	recorder    *record.Recorder  // nil unless Options.RecordDir is set
+	capture     *pcap.Interface   // nil unless Options.CaptureFile is set
+	sessions    *session.Registry // nil unless Options.AdminAddress is set
+	// proxyProtocol is set when the address of tcp clients comes from a PROXY protocol header, which is only read in
+	// the goroutine of the connection
+	proxyProtocol bool
+}
+
+// Addr returns the address the service is bound to. It returns nil until the service is listening.
+func (s *Service) Addr() net.Addr {
+	if s.listener != nil {
+		return s.listener.Addr()
+	}
+	if s.packetConn != nil {
+		return s.packetConn.LocalAddr()
+	}
+	return nil
+}
+
+// TLSAddr returns the address of the TLS listener on TLSAddress. It returns nil if there is no such listener.
+func (s *Service) TLSAddr() net.Addr {
+	if s.tlsListener != nil {
+		return s.tlsListener.Addr()
+	}
+	return nil
+}
+
+func (s *Service) validate(opts Options) error {
+	switch s.Network {
+	case "tcp":
+		if s.Handler == nil {
+			return fmt.Errorf("service %q: tcp service requires a Handler", s.Name)
+		}
+		if s.TLSAddress != "" && !opts.TLS.Enabled() {
+			return fmt.Errorf("service %q: TLSAddress requires a certificate", s.Name)
+		}
+	case "udp":
+		if s.TLSAddress != "" {
+			return fmt.Errorf("service %q: TLS is not supported on udp services", s.Name)
+		}
+		if s.StreamListener != nil && s.Handler == nil {
+			return fmt.Errorf("service %q: StreamListener requires a Handler", s.Name)
+		}
+		if s.StreamListener == nil && s.PacketHandler == nil {
+			return fmt.Errorf("service %q: udp service requires a PacketHandler or StreamListener", s.Name)
+		}
+	default:
+		return fmt.Errorf("service %q: unsupported network %q", s.Name, s.Network)
+	}
+	return nil
+}
+
+// listen binds the service. tlsConfig is nil unless a certificate is configured
+func (s *Service) listen(ctx context.Context, opts Options, tlsConfig *tls.Config) error {
+	s.metrics = newServiceMetrics(s.Name)
+	s.limiter = limit.New(opts.Limits)
+	s.access = opts.Access
+	s.timeouts = s.Timeouts.Override(opts.Timeouts)
+	s.proxyProtocol = s.Network == "tcp" && opts.ProxyProtocol.Enabled
+	if opts.RecordDir != "" {
+		if err := os.MkdirAll(opts.RecordDir, 0o755); err != nil {
+			return err
+		}
+		s.recorder = &record.Recorder{Dir: opts.RecordDir, Service: s.Name, OnError: s.recordFailed}
+	}
+	if s.Network == "tcp" {
+		// The PROXY protocol header comes before the TLS handshake, so it has to be handled by the innermost listener
+		listen := func(address string) (net.Listener, error) {
+			listener, err := socket.Listen(ctx, address)
+			if err != nil {
+				return nil, err
+			}
+			// Faults are injected closest to the network, below the PROXY protocol and TLS
+			if opts.Chaos.Enabled() {
+				listener = chaos.NewListener(listener, opts.Chaos)
+			}
+			if !opts.ProxyProtocol.Enabled {
+				return listener, nil
+			}
+			return proxyproto.NewListener(listener, opts.ProxyProtocol, s.rejectProxyHeader), nil
+		}
+		listener, err := listen(s.Address)
+		if err != nil {
+			return err
+		}
+		if tlsConfig != nil && s.TLSAddress == "" {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		s.listener = listener
+		if s.TLSAddress != "" {
+			tlsListener, err := listen(s.TLSAddress)
+			if err != nil {
+				listener.Close()
+				return err
+			}
+			s.tlsListener = tls.NewListener(tlsListener, tlsConfig)
+		}
+		return nil
+	}
+	packetConn, err := socket.ListenPacket(ctx, s.Address)
+	if err != nil {
+		return err
+	}
+	if opts.Chaos.Enabled() {
+		packetConn = chaos.NewPacketConn(packetConn, opts.Chaos)
+	}
+	if !s.access.IsZero() || !s.Access.IsZero() {
+		// Denied packets are dropped before they count towards the packet rate limit
+		packetConn = acl.NewPacketConn(packetConn, s.permits, func(addr net.Addr) {
+			packetsDenied.With(s.Name).Inc()
+		})
+	}
+	if s.limiter.HasPacketLimit() {
+		packetConn = limit.NewPacketConn(packetConn, s.limiter, func(addr net.Addr) {
+			packetsDropped.With(s.Name).Inc()
+		})
+	}
+	if s.capture != nil {
+		// Captured after the access lists and the limits, so that the file holds the datagrams the server handles
+		packetConn = s.capture.WrapPacketConn(packetConn)
+	}
+	if s.StreamListener != nil {
+		s.listener = s.StreamListener(s.logContext(ctx), packetConn)
+		return nil
+	}
+	s.packetConn = &countingPacketConn{PacketConn: packetConn, m: &s.metrics}
+	return nil
+}
+
+// permits returns true if both the shared and the service access lists permit the address
+func (s *Service) permits(addr net.Addr) bool {
+	return s.access.Permits(addr) && s.Access.Permits(addr)
+}
+
+// rejectProxyHeader is called for connections with an invalid PROXY protocol header, or with a header from an
+// untrusted source
+func (s *Service) rejectProxyHeader(addr net.Addr, err error) {
+	connectionsRejected.With(s.Name, reasonProxyProtocol).Inc()
+	slog.Warn("proxy protocol header rejected", "service", s.Name, "remote_address", addr.String(), "error", err)
+}
+
+// logContext returns a context that tags log lines with the name of the service
+func (s *Service) logContext(ctx context.Context) context.Context {
+	return logging.WithAttrs(ctx, "service", s.Name)
+}
+
+func (s *Service) close() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if s.tlsListener != nil {
+		s.tlsListener.Close()
+	}
+	if s.packetConn != nil {
+		s.packetConn.Close()
+	}
+}
+
+// isPacketBacked returns true if the stream listener is running on top of a packet connection. Closing such a listener
+// tears down the transport of all its connections, so it must only be closed after the handlers have finished.
+func (s *Service) isPacketBacked() bool {
+	return s.StreamListener != nil
+}
+
+func (s *Service) acceptLoop(ctx context.Context, listener net.Listener) {
+	// delay backs off after failed accepts, such as running out of file descriptors, as net/http.Server does
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			delay = min(max(delay*2, minAcceptDelay), maxAcceptDelay)
+			slog.Warn("accept failed", "service", s.Name, "error", err, "retry_in", delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		delay = 0
+		s.metrics.total.Inc()
+		conn = &countingConn{Conn: conn, m: &s.metrics}
+		// Wrapped even without timeouts, so that shutdown can interrupt reads whatever deadlines the handler sets
+		conn = timeout.New(conn, s.timeouts)
+		// Every log line of the connection is tagged with its ID, so that a client can be followed across goroutines
+		id := logging.ConnID(conn)
+		connCtx := context.WithValue(logging.WithAttrs(ctx, "conn_id", id), connIDKey{}, id)
+		// Once the server starts shutting down, connections are closed as soon as they are accepted
+		if s.proxyProtocol {
+			s.conns.serve(connCtx, conn, s.admit)
+			continue
+		}
+		// Without the PROXY protocol the address is already known, and rejected clients don't get a goroutine
+		release, ok := s.check(connCtx, conn)
+		if !ok {
+			conn.Close()
+			continue
+		}
+		served := s.conns.serve(connCtx, conn, func(ctx context.Context, conn net.Conn) {
+			defer release()
+			s.serve(ctx, conn)
+		})
+		if !served {
+			release()
+		}
+	}
+}
+
+// admit reads the PROXY protocol header of the connection, and then applies the access lists and the limits to the
+// address of the client before serving it. This runs in the goroutine of the connection, so that a client that is slow
+// to send its header can't hold up the accept loop.
+func (s *Service) admit(ctx context.Context, conn net.Conn) {
+	if err := proxyproto.Handshake(conn); err != nil {
+		// Already reported by rejectProxyHeader
+		return
+	}
+	release, ok := s.check(ctx, conn)
+	if !ok {
+		return
+	}
+	defer release()
+	s.serve(ctx, conn)
+}
+
+// check applies the access lists and the limits to the address of the client. It returns false if the connection has
+// to be closed, and otherwise a function that releases the slot taken by the connection
+func (s *Service) check(ctx context.Context, conn net.Conn) (func(), bool) {
+	if !s.permits(conn.RemoteAddr()) {
+		connectionsRejected.With(s.Name, acl.Reason).Inc()
+		slog.DebugContext(ctx, "connection rejected", "remote_address", conn.RemoteAddr().String(), "reason", acl.Reason)
+		return nil, false
+	}
+	release, reason := s.limiter.Admit(conn.RemoteAddr())
+	if release == nil {
+		connectionsRejected.With(s.Name, reason).Inc()
+		slog.DebugContext(ctx, "connection rejected", "remote_address", conn.RemoteAddr().String(), "reason", reason)
+		return nil, false
+	}
+	return release, true
+}
+
+// serve wraps the connection of an admitted client with the byte rate limits, the recorder, the capture and the
+// session, and runs the handler
+func (s *Service) serve(ctx context.Context, conn net.Conn) {
+	if s.limiter.HasByteLimit() {
+		// A write may not wait for the rate limit longer than the write timeout would let it write
+		conn = limit.NewConn(ctx, conn, s.limiter, s.timeouts.Write, func(d time.Duration) {
+			throttled.With(s.Name).Add(uint64(d.Milliseconds()))
+		})
+	}
+	if s.recorder != nil {
+		// Recorded after the PROXY protocol and TLS, so that the file holds what the handler sees
+		recorded, err := s.recorder.Wrap(conn)
+		if err != nil {
+			slog.WarnContext(ctx, "start recording failed", "error", err)
+		} else {
+			slog.DebugContext(ctx, "recording connection", "path", recorded.Path())
+			defer recorded.Close()
+			conn = recorded
+		}
+	}
+	if s.capture != nil && !s.isPacketBacked() {
+		// The datagrams of packet backed connections are already captured by the packet connection
+		captured := s.capture.WrapConn(conn)
+		defer captured.Close()
+		conn = captured
+	}
+	if s.sessions != nil {
+		id, _ := ctx.Value(connIDKey{}).(uint64)
+		sess := session.New(id, s.Name, conn, findCountingConn(conn).bytes)
+		defer s.sessions.Add(sess)()
+		ctx = session.NewContext(ctx, sess)
+	}
+	s.handle(ctx, conn)
+}
+
+// connIDKey is the context key of the ID given to a connection by the accept loop
+type connIDKey struct{}
+
+func (s *Service) recordFailed(conn net.Conn, err error) {
+	slog.Warn("recording failed, the rest of the connection is not recorded", "service", s.Name, "remote_address", conn.RemoteAddr().String(), "error", err)
+}
+
+// handle runs the handler of the service, and keeps track of the number of active connections
+func (s *Service) handle(ctx context.Context, conn net.Conn) {
+	s.metrics.active.Inc()
+	defer s.metrics.active.Dec()
+	s.Handler(ctx, conn)
+}
+
+// Group is a set of services that are started and shut down together
+type Group struct {
+	opts     Options
+	services []*Service
+	certs    *tlsutil.Reloader // nil if TLS is disabled
+	sessions *session.Registry // nil if the admin API is disabled
+	capture  *pcap.Writer      // nil unless Options.CaptureFile is set
+}
+
+// close closes the listeners of all the services without serving them
+func (g *Group) close() {
+	for _, s := range g.services {
+		s.close()
+	}
+	g.closeCapture()
+}
+
+// closeCapture flushes and closes the capture file, once no connection can write to it anymore
+func (g *Group) closeCapture() {
+	if g.capture == nil {
+		return
+	}
+	if err := g.capture.Close(); err != nil {
+		slog.Warn("closing the capture file failed", "path", g.opts.CaptureFile, "error", err)
+	}
+}
+
+// Listen binds all the services. If any of them fails, the services that were already bound are closed.
+func Listen(ctx context.Context, opts Options, services ...*Service) (*Group, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	for _, s := range services {
+		if err := s.validate(opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Chaos.Enabled() {
+		slog.Warn("injecting network faults into every connection", "chaos", opts.Chaos.String())
+	}
+	group := &Group{opts: opts, services: services}
+	if opts.AdminAddress != "" {
+		group.sessions = session.NewRegistry()
+		for _, s := range services {
+			s.sessions = group.sessions
+		}
+	}
+	var tlsConfig *tls.Config
+	if opts.TLS.Enabled() {
+		certs, err := tlsutil.NewReloader(opts.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		group.certs = certs
+		tlsConfig = certs.ServerConfig()
+	}
+	if opts.CaptureFile != "" {
+		capture, err := pcap.Create(opts.CaptureFile)
+		if err != nil {
+			return nil, fmt.Errorf("capture: %w", err)
+		}
+		capture.OnError = func(err error) {
+			slog.Warn("capture failed, the rest of the traffic is not captured", "path", opts.CaptureFile, "error", err)
+		}
+		group.capture = capture
+		for _, s := range services {
+			if s.capture, err = capture.Interface(s.Name); err != nil {
+				group.closeCapture()
+				return nil, fmt.Errorf("capture: %w", err)
+			}
+		}
+	}
+	for i, s := range services {
+		if err := s.listen(ctx, opts, tlsConfig); err != nil {
+			for _, opened := range services[:i] {
+				opened.close()
+			}
+			group.closeCapture()
+			return nil, fmt.Errorf("service %q: %w", s.Name, err)
+		}
+		// Without a TLSAddress, every tcp listener serves TLS once a certificate is configured
+		isTLS := tlsConfig != nil && s.Network == "tcp" && s.TLSAddress == ""
+		slog.Info("server listening", "service", s.Name, "network", s.Network, "address", s.Addr().String(), "tls", isTLS)
+		if s.tlsListener != nil {
+			slog.Info("server listening", "service", s.Name, "network", s.Network, "address", s.TLSAddr().String(), "tls", true)
+		}
+	}
+	return group, nil
+}
+
+// ReloadCertificates loads the TLS certificate from disk again. New connections use the new certificate, while
+// established connections are not affected. It does nothing if TLS is disabled.
+func (g *Group) ReloadCertificates() error {
+	if g.certs == nil {
+		return nil
+	}
+	return g.certs.Reload()
+}
+
+// Serve accepts connections on all services until the context is cancelled. It then stops accepting new connections,
+// and waits up to the drain timeout for in-flight handlers to finish before closing their connections. The context passed
+// to handlers is cancelled as soon as shutdown begins.
+func (g *Group) Serve(ctx context.Context) error {
+	drainTimeout := g.opts.DrainTimeout
+	var loops sync.WaitGroup
+	for _, s := range g.services {
+		serviceCtx := s.logContext(ctx)
+		if s.packetConn != nil {
+			s.conns.servePacket(serviceCtx, s.packetConn, s.PacketHandler)
+			continue
+		}
+		loops.Go(func() { s.acceptLoop(serviceCtx, s.listener) })
+		if s.tlsListener != nil {
+			loops.Go(func() { s.acceptLoop(serviceCtx, s.tlsListener) })
+		}
+	}
+
+	<-ctx.Done()
+	slog.Info("shutting down", "drain_timeout", drainTimeout)
+
+	for _, s := range g.services {
+		if !s.isPacketBacked() {
+			s.close()
+		}
+		s.conns.interrupt()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		for _, s := range g.services {
+			s.conns.wait()
+		}
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-time.After(drainTimeout):
+		err = ErrDrainTimeout
+		for _, s := range g.services {
+			n := s.conns.closeAll()
+			slog.Warn("closing connections forcefully", "service", s.Name, "count", n)
+		}
+		select {
+		case <-drained:
+		case <-time.After(forceCloseGrace):
+			slog.Error("handlers did not return after their connections were closed")
+		}
+	}
+
+	for _, s := range g.services {
+		s.close()
+	}
+	loops.Wait()
+	g.closeCapture()
+	return err
+}
+
+// Run listens on all the services and serves them until the context is cancelled
+func Run(ctx context.Context, opts Options, services ...*Service) error {
+	group, err := Listen(ctx, opts, services...)
+	if err != nil {
+		return err
+	}
+	return serve(ctx, group)
+}
+
+func serve(ctx context.Context, group *Group) error {
+	if group.opts.MetricsAddress != "" {
+		metricsServer, err := metrics.Listen(ctx, group.opts.MetricsAddress)
+		if err != nil {
+			group.close()
+			return fmt.Errorf("metrics: %w", err)
+		}
+		go metricsServer.Serve(ctx)
+	}
+	if group.sessions != nil {
+		adminServer, err := admin.Listen(ctx, group.opts.AdminAddress, group.sessions)
+		if err != nil {
+			group.close()
+			return fmt.Errorf("admin: %w", err)
+		}
+		go adminServer.Serve(ctx)
+	}
+	return group.Serve(ctx)
+}
+
+// reloadOnHangup reloads the TLS certificates every time SIGHUP is received, until the context is cancelled
+func reloadOnHangup(ctx context.Context, group *Group) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			if err := group.ReloadCertificates(); err != nil {
+				slog.Error("certificate reload failed, keeping the previous certificate", "error", err)
+				continue
+			}
+			slog.Info("certificates reloaded")
+		}
+	}
+}
+
+// Main runs the services until SIGINT or SIGTERM is received, and then exits the process with one of the Exit* codes.
+// The default logger is set up from opts.Logging first. If TLS is enabled, SIGHUP reloads the certificate.
+func Main(opts Options, services ...*Service) {
+	logging.Setup(os.Stderr, opts.Logging)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	group, err := Listen(ctx, opts, services...)
+	if err != nil {
+		slog.Error("listen failed", "error", err)
+		os.Exit(ExitError)
+	}
+	if group.certs != nil {
+		go reloadOnHangup(ctx, group)
+	}
+	if err := serve(ctx, group); err != nil {
+		if errors.Is(err, ErrDrainTimeout) {
+			slog.Error("shutdown incomplete", "error", err)
+			os.Exit(ExitForced)
+		}
+		slog.Error("serve failed", "error", err)
+		os.Exit(ExitError)
+	}
+	slog.Info("shutdown complete")
+	os.Exit(ExitOK)
+}
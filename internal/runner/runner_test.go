@@ -0,0 +1,632 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/acl"
+	"github.com/ananthvk/protohackers-go/internal/limit"
+	"github.com/ananthvk/protohackers-go/internal/proxyproto"
+	"github.com/ananthvk/protohackers-go/internal/session"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+	"github.com/ananthvk/protohackers-go/internal/tlsutil"
+)
+
+func echoHandler(ctx context.Context, conn net.Conn) {
+	io.Copy(conn, conn)
+}
+
+func startGroup(t *testing.T, services ...*Service) (context.CancelFunc, chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	group, err := Listen(ctx, Options{DrainTimeout: time.Millisecond * 200}, services...)
+	if err != nil {
+		cancel()
+		t.Fatalf("listen failed: %v", err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- group.Serve(ctx)
+	}()
+	return cancel, result
+}
+
+func waitResult(t *testing.T, result chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Serve did not return after shutdown")
+		return nil
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	service := &Service{Name: "echo", Network: "tcp", Address: "127.0.0.1:0", Handler: echoHandler}
+	cancel, result := startGroup(t, service)
+
+	conn, err := net.Dial("tcp", service.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Fatalf("got %q, %v want %q", line, err, "hello\n")
+	}
+
+	cancel()
+	if err := waitResult(t, result); err != nil {
+		t.Errorf("unexpected error on shutdown: %v", err)
+	}
+
+	// The listener should be closed
+	if c, err := net.Dial("tcp", service.Addr().String()); err == nil {
+		c.Close()
+		t.Errorf("dial succeeded after shutdown")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	started := make(chan struct{})
+	service := &Service{
+		Name:    "stubborn",
+		Network: "tcp",
+		Address: "127.0.0.1:0",
+		Handler: func(ctx context.Context, conn net.Conn) {
+			close(started)
+			// Ignore the read deadline, and keep reading until the connection is closed
+			buffer := make([]byte, 16)
+			for {
+				if _, err := conn.Read(buffer); errors.Is(err, net.ErrClosed) {
+					return
+				}
+			}
+		},
+	}
+	cancel, result := startGroup(t, service)
+
+	conn, err := net.Dial("tcp", service.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	<-started
+
+	cancel()
+	if err := waitResult(t, result); !errors.Is(err, ErrDrainTimeout) {
+		t.Errorf("got %v, want %v", err, ErrDrainTimeout)
+	}
+}
+
+func TestPacketService(t *testing.T) {
+	service := &Service{
+		Name:    "udp-echo",
+		Network: "udp",
+		Address: "127.0.0.1:0",
+		PacketHandler: func(ctx context.Context, conn net.PacketConn) {
+			buffer := make([]byte, 64)
+			for {
+				n, addr, err := conn.ReadFrom(buffer)
+				if err != nil {
+					return
+				}
+				conn.WriteTo(buffer[:n], addr)
+			}
+		},
+	}
+	cancel, result := startGroup(t, service)
+
+	conn, err := net.Dial("udp", service.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	buffer := make([]byte, 64)
+	n, err := conn.Read(buffer)
+	if err != nil || string(buffer[:n]) != "ping" {
+		t.Fatalf("got %q, %v want %q", buffer[:n], err, "ping")
+	}
+
+	cancel()
+	if err := waitResult(t, result); err != nil {
+		t.Errorf("unexpected error on shutdown: %v", err)
+	}
+}
+
+func TestInvalidService(t *testing.T) {
+	tests := []*Service{
+		{Name: "no-handler", Network: "tcp", Address: "127.0.0.1:0"},
+		{Name: "no-packet-handler", Network: "udp", Address: "127.0.0.1:0"},
+		{Name: "bad-network", Network: "sctp", Address: "127.0.0.1:0", Handler: echoHandler},
+	}
+	for _, service := range tests {
+		if _, err := Listen(context.Background(), DefaultOptions(), service); err == nil {
+			t.Errorf("%s: expected error", service.Name)
+		}
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := &Service{Name: "limited", Network: "tcp", Address: "127.0.0.1:0", Handler: echoHandler}
+	group, err := Listen(ctx, Options{DrainTimeout: time.Millisecond * 200, Limits: limit.Config{MaxConnsPerIP: 1}}, service)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	go group.Serve(ctx)
+
+	first, err := net.Dial("tcp", service.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer first.Close()
+	first.Write([]byte("a"))
+	first.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if _, err := first.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("first connection was not served: %v", err)
+	}
+
+	// The second connection from the same IP is closed without being served
+	second, err := net.Dial("tcp", service.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want %v for a connection over the limit", err, io.EOF)
+	}
+}
+
+func TestAccessLists(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	loopback := acl.List{Allow: acl.Prefixes{netip.MustParsePrefix("127.0.0.0/8")}}
+	denied := acl.List{Deny: acl.Prefixes{netip.MustParsePrefix("127.0.0.1/32")}}
+	allowed := &Service{Name: "allowed", Network: "tcp", Address: "127.0.0.1:0", Handler: echoHandler}
+	stream := &Service{Name: "denied", Network: "tcp", Address: "127.0.0.1:0", Handler: echoHandler, Access: denied}
+	packets := make(chan string, 1)
+	packet := &Service{
+		Name:    "denied-udp",
+		Network: "udp",
+		Address: "127.0.0.1:0",
+		Access:  denied,
+		PacketHandler: func(ctx context.Context, conn net.PacketConn) {
+			buffer := make([]byte, 64)
+			n, _, err := conn.ReadFrom(buffer)
+			if err == nil {
+				packets <- string(buffer[:n])
+			}
+		},
+	}
+	group, err := Listen(ctx, Options{DrainTimeout: time.Millisecond * 200, Access: loopback}, allowed, stream, packet)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	go group.Serve(ctx)
+
+	conn, err := net.Dial("tcp", allowed.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	echoOnce(t, conn)
+
+	// Both the shared and the service lists must permit the client
+	conn, err = net.Dial("tcp", stream.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want %v for a denied connection", err, io.EOF)
+	}
+
+	udpConn, err := net.Dial("udp", packet.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer udpConn.Close()
+	udpConn.Write([]byte("ping"))
+	select {
+	case p := <-packets:
+		t.Errorf("denied packet %q was delivered", p)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	service := &Service{
+		Name:     "idle",
+		Network:  "tcp",
+		Address:  "127.0.0.1:0",
+		Handler:  echoHandler,
+		Timeouts: timeout.Policy{Idle: time.Hour},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The option overrides the default of the service
+	group, err := Listen(ctx, Options{DrainTimeout: time.Millisecond * 200, Timeouts: timeout.Policy{Idle: time.Millisecond * 100}}, service)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	go group.Serve(ctx)
+
+	conn, err := net.Dial("tcp", service.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want %v for an idle connection", err, io.EOF)
+	}
+}
+
+// writeCert writes a self signed certificate with the common name to dir
+func writeCert(t *testing.T, dir string, commonName string) tlsutil.Config {
+	t.Helper()
+	certPEM, keyPEM, err := tlsutil.SelfSigned(commonName, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("generate certificate: %v", err)
+	}
+	cfg := tlsutil.Config{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	os.WriteFile(cfg.CertFile, certPEM, 0o600)
+	os.WriteFile(cfg.KeyFile, keyPEM, 0o600)
+	return cfg
+}
+
+// echoOnce writes a line to the connection and checks that it's echoed back
+func echoOnce(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(time.Second * 2))
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Fatalf("got %q, %v want %q", line, err, "hello\n")
+	}
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	tlsConfig := writeCert(t, dir, "first")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := &Service{Name: "tls", Network: "tcp", Address: "127.0.0.1:0", TLSAddress: "127.0.0.1:0", Handler: echoHandler}
+	group, err := Listen(ctx, Options{DrainTimeout: time.Millisecond * 200, TLS: tlsConfig}, service)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	go group.Serve(ctx)
+
+	// Plain text is still served on Address
+	plain, err := net.Dial("tcp", service.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer plain.Close()
+	echoOnce(t, plain)
+
+	dialTLS := func() *tls.Conn {
+		t.Helper()
+		conn, err := tls.Dial("tcp", service.TLSAddr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("tls dial failed: %v", err)
+		}
+		echoOnce(t, conn)
+		return conn
+	}
+	first := dialTLS()
+	defer first.Close()
+	if name := first.ConnectionState().PeerCertificates[0].Subject.CommonName; name != "first" {
+		t.Errorf("got certificate %q, want %q", name, "first")
+	}
+
+	writeCert(t, dir, "second")
+	if err := group.ReloadCertificates(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	second := dialTLS()
+	defer second.Close()
+	if name := second.ConnectionState().PeerCertificates[0].Subject.CommonName; name != "second" {
+		t.Errorf("got certificate %q after reload, want %q", name, "second")
+	}
+	// Established connections keep working
+	echoOnce(t, first)
+}
+
+func TestTLSRequiresCertificate(t *testing.T) {
+	service := &Service{Name: "tls", Network: "tcp", Address: "127.0.0.1:0", TLSAddress: "127.0.0.1:0", Handler: echoHandler}
+	if _, err := Listen(context.Background(), DefaultOptions(), service); err == nil {
+		t.Errorf("expected an error for a TLSAddress without a certificate")
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := &Service{
+		Name:    "proxied",
+		Network: "tcp",
+		Address: "127.0.0.1:0",
+		Handler: func(ctx context.Context, conn net.Conn) {
+			conn.Write([]byte(conn.RemoteAddr().String() + "\n"))
+			io.Copy(io.Discard, conn)
+		},
+	}
+	opts := Options{
+		DrainTimeout:  time.Millisecond * 200,
+		Limits:        limit.Config{MaxConnsPerIP: 1},
+		ProxyProtocol: proxyproto.Config{Enabled: true, Trusted: acl.Prefixes{netip.MustParsePrefix("127.0.0.1/32")}},
+	}
+	group, err := Listen(ctx, opts, service)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	go group.Serve(ctx)
+
+	// Both connections come from the same balancer, but the per IP limit applies to the clients behind it
+	for _, source := range []string{"198.51.100.1", "198.51.100.2"} {
+		conn, err := net.Dial("tcp", service.Addr().String())
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("PROXY TCP4 " + source + " 127.0.0.1 4000 8000\r\n"))
+		conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if want := source + ":4000\n"; err != nil || line != want {
+			t.Fatalf("got %q, %v want %q", line, err, want)
+		}
+	}
+
+	// Without a header, the trusted balancer is rejected
+	conn, err := net.Dial("tcp", service.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello\n"))
+	conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	// The connection is closed with unread data, which may be reported as a reset instead of EOF
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %v, want the connection to be closed for a missing header", err)
+	}
+}
+
+// TestInterruptHandshake checks that shutdown interrupts a connection waiting for its PROXY protocol header, which has
+// its own deadline, so that the group drains without forcing connections closed
+func TestInterruptHandshake(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	service := &Service{Name: "proxied", Network: "tcp", Address: "127.0.0.1:0", Handler: echoHandler}
+	opts := Options{
+		DrainTimeout: time.Second,
+		ProxyProtocol: proxyproto.Config{
+			Enabled:       true,
+			Trusted:       acl.Prefixes{netip.MustParsePrefix("127.0.0.1/32")},
+			HeaderTimeout: time.Minute,
+		},
+	}
+	group, err := Listen(ctx, opts, service)
+	if err != nil {
+		cancel()
+		t.Fatalf("listen failed: %v", err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- group.Serve(ctx)
+	}()
+
+	conn, err := net.Dial("tcp", service.Addr().String())
+	if err != nil {
+		cancel()
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	// Give the handshake time to start
+	time.Sleep(time.Millisecond * 50)
+	start := time.Now()
+	cancel()
+	if err := waitResult(t, result); err != nil {
+		t.Errorf("unexpected error on shutdown: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+		t.Errorf("shutdown took %v", elapsed)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	annotated := make(chan struct{})
+	service := &Service{Name: "sessions", Network: "tcp", Address: "127.0.0.1:0", Handler: func(ctx context.Context, conn net.Conn) {
+		session.Annotate(ctx, "role", "echo")
+		close(annotated)
+		echoHandler(ctx, conn)
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	group, err := Listen(ctx, Options{DrainTimeout: time.Millisecond * 200, AdminAddress: "127.0.0.1:0"}, service)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	go group.Serve(ctx)
+
+	conn, err := net.Dial("tcp", service.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	echoOnce(t, conn)
+	<-annotated
+
+	snapshots := group.sessions.List()
+	if len(snapshots) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(snapshots))
+	}
+	snapshot := snapshots[0]
+	if snapshot.Service != "sessions" || snapshot.Remote != conn.LocalAddr().String() || snapshot.Attributes["role"] != "echo" {
+		t.Errorf("got %+v", snapshot)
+	}
+	if snapshot.BytesReceived == 0 || snapshot.BytesSent == 0 {
+		t.Errorf("bytes were not counted: %+v", snapshot)
+	}
+
+	// Closing the session disconnects the client, and removes the session once the handler returns
+	s, _ := group.sessions.Get(snapshot.ID)
+	s.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want %v after closing the session", err, io.EOF)
+	}
+	deadline := time.Now().Add(time.Second * 2)
+	for len(group.sessions.List()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := len(group.sessions.List()); n != 0 {
+		t.Errorf("got %d sessions after the handler returned, want 0", n)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	stream := &Service{Name: "stream", Network: "tcp", Address: "127.0.0.1:0", Handler: echoHandler}
+	packet := &Service{Name: "packet", Network: "udp", Address: "127.0.0.1:0", PacketHandler: func(ctx context.Context, conn net.PacketConn) {
+		buffer := make([]byte, 64)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(append([]byte("echo "), buffer[:n]...), addr)
+		}
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	group, err := Listen(ctx, Options{DrainTimeout: time.Millisecond * 200, CaptureFile: path}, stream, packet)
+	if err != nil {
+		cancel()
+		t.Fatalf("listen failed: %v", err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- group.Serve(ctx)
+	}()
+
+	conn, err := net.Dial("tcp", stream.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	echoOnce(t, conn)
+	conn.Close()
+	udp, err := net.Dial("udp", packet.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer udp.Close()
+	udp.Write([]byte("datagram"))
+	udp.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if _, err := udp.Read(make([]byte, 64)); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	cancel()
+	waitResult(t, result)
+
+	// The format itself is checked by the pcap package
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"stream", "packet", "hello\n", "datagram", "echo datagram"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("capture does not contain %q", want)
+		}
+	}
+}
+
+// failingListener fails every accept until it's closed
+type failingListener struct {
+	net.Listener
+	accepts atomic.Int32
+	closed  chan struct{}
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts.Add(1)
+	select {
+	case <-l.closed:
+		return nil, net.ErrClosed
+	default:
+		return nil, errors.New("accept: too many open files")
+	}
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	listener := &failingListener{closed: make(chan struct{})}
+	service := &Service{Name: "failing"}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.acceptLoop(context.Background(), listener)
+	}()
+	// Waits of 5, 10, 20, 40 and 80ms fit in 200ms
+	time.Sleep(time.Millisecond * 200)
+	close(listener.closed)
+	if n := listener.accepts.Load(); n > 8 {
+		t.Errorf("got %d accepts in 200ms, want the loop to back off", n)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("accept loop did not return after the listener was closed")
+	}
+}
+
+func TestRegisterPacketFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		streams  bool
+		present  []string
+		excluded []string
+	}{
+		{"packets", false, []string{"packet-rate", "allow", "capture"}, []string{"tls-cert", "proxy-protocol", "max-conns", "byte-rate", "idle-timeout", "record-dir"}},
+		{"streams", true, []string{"packet-rate", "max-conns", "byte-rate", "idle-timeout", "record-dir"}, []string{"tls-cert", "proxy-protocol"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := flag.NewFlagSet(test.name, flag.ContinueOnError)
+			opts := DefaultOptions()
+			opts.RegisterPacketFlags(fs, test.streams)
+			for _, name := range test.present {
+				if fs.Lookup(name) == nil {
+					t.Errorf("-%s is not registered", name)
+				}
+			}
+			for _, name := range test.excluded {
+				if fs.Lookup(name) != nil {
+					t.Errorf("-%s is registered", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Messages encodes the named messages of a protocol, such as ticket{plate: UN1X, road: 66} in a script. Encoders read
+// the fields of the message from Fields, which reports missing and invalid fields
+type Messages map[string]func(f *Fields) []byte
+
+// encode encodes a message written as name{key: value, ...}
+func (m Messages) encode(s string) ([]byte, error) {
+	name, rest, ok := strings.Cut(s, "{")
+	if !ok || !strings.HasSuffix(rest, "}") {
+		return nil, fmt.Errorf("invalid payload %q, want a quoted string, line, hex or name{fields}", s)
+	}
+	name = strings.TrimSpace(name)
+	encode := m[name]
+	if encode == nil {
+		return nil, fmt.Errorf("unknown message %q", name)
+	}
+	values, err := parseFields(strings.TrimSuffix(rest, "}"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	f := &Fields{values: values, used: map[string]bool{}}
+	payload := encode(f)
+	if f.err != nil {
+		return nil, fmt.Errorf("%s: %w", name, f.err)
+	}
+	for key := range values {
+		if !f.used[key] {
+			return nil, fmt.Errorf("%s: unknown field %q", name, key)
+		}
+	}
+	return payload, nil
+}
+
+// parseFields parses a comma separated list of key: value pairs. Values are Go quoted strings, lists in brackets, or
+// bare words
+func parseFields(s string) (map[string]string, error) {
+	values := map[string]string{}
+	s = strings.TrimSpace(s)
+	for s != "" {
+		key, rest, ok := strings.Cut(s, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid field %q, want key: value", s)
+		}
+		key = strings.TrimSpace(key)
+		rest = strings.TrimSpace(rest)
+		var value string
+		switch {
+		case strings.HasPrefix(rest, `"`):
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %w", key, err)
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		case strings.HasPrefix(rest, "["):
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("field %q: missing ]", key)
+			}
+			value, rest = rest[1:end], rest[end+1:]
+		default:
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = strings.TrimSpace(rest[:end]), rest[end:]
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("duplicate field %q", key)
+		}
+		values[key] = value
+		rest = strings.TrimSpace(rest)
+		if rest != "" {
+			if rest[0] != ',' {
+				return nil, fmt.Errorf("field %q: want a comma after the value", key)
+			}
+			rest = rest[1:]
+		}
+		s = strings.TrimSpace(rest)
+	}
+	return values, nil
+}
+
+// Fields are the fields of a named message. Like bufio.Scanner, it keeps the first error, so that encoders can read
+// every field before it's checked
+type Fields struct {
+	values map[string]string
+	used   map[string]bool
+	err    error
+}
+
+func (f *Fields) value(key string) (string, bool) {
+	f.used[key] = true
+	value, ok := f.values[key]
+	if !ok {
+		f.fail(fmt.Errorf("missing field %q", key))
+	}
+	return value, ok
+}
+
+func (f *Fields) fail(err error) {
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// String returns a text field
+func (f *Fields) String(key string) string {
+	value, _ := f.value(key)
+	return value
+}
+
+// Uint returns an unsigned integer field that fits in the given number of bits
+func (f *Fields) Uint(key string, bits int) uint64 {
+	value, ok := f.value(key)
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseUint(value, 0, bits)
+	if err != nil {
+		f.fail(fmt.Errorf("field %q: %w", key, err))
+	}
+	return n
+}
+
+// Int returns a signed integer field that fits in the given number of bits
+func (f *Fields) Int(key string, bits int) int64 {
+	value, ok := f.value(key)
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(value, 0, bits)
+	if err != nil {
+		f.fail(fmt.Errorf("field %q: %w", key, err))
+	}
+	return n
+}
+
+// Uints returns a list of unsigned integers, such as [66, 368], that fit in the given number of bits
+func (f *Fields) Uints(key string, bits int) []uint64 {
+	value, ok := f.value(key)
+	if !ok {
+		return nil
+	}
+	var list []uint64
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		n, err := strconv.ParseUint(item, 0, bits)
+		if err != nil {
+			f.fail(fmt.Errorf("field %q: %w", key, err))
+			return nil
+		}
+		list = append(list, n)
+	}
+	return list
+}
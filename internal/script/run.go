@@ -0,0 +1,154 @@
+package script
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Run runs a script. name identifies the script in errors, usually the path of its file
+func (h *Harness) Run(name, source string) {
+	h.t.Helper()
+	for i, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := h.step(line); err != nil {
+			h.t.Fatalf("%s:%d: %v", name, i+1, err)
+		}
+	}
+}
+
+// RunFile runs the script stored in a file
+func (h *Harness) RunFile(path string) {
+	h.t.Helper()
+	source, err := os.ReadFile(path)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.Run(path, string(source))
+}
+
+// RunFiles runs every script matching the pattern in a subtest, each against a new server started by setup
+func RunFiles(t *testing.T, pattern string, setup func(h *Harness)) {
+	t.Helper()
+	paths, err := filepath.Glob(pattern)
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no scripts match %q: %v", pattern, err)
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			h := New(t)
+			setup(h)
+			h.RunFile(path)
+		})
+	}
+}
+
+// step runs a single line of a script
+func (h *Harness) step(line string) error {
+	words := strings.Fields(line)
+	switch {
+	case words[0] == "advance" && len(words) == 2:
+		d, err := time.ParseDuration(words[1])
+		if err != nil {
+			return err
+		}
+		h.Advance(d)
+		return nil
+	case words[0] == "expect" && len(words) == 4 && words[1] == "silence" && words[2] == "for":
+		d, err := time.ParseDuration(words[3])
+		if err != nil {
+			return err
+		}
+		return h.expectSilence(d, h.order...)
+	case words[0] == "client" && len(words) >= 3:
+		_, rest, _ := strings.Cut(line, " ")
+		name, step, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		return h.clientStep(name, strings.TrimSpace(step))
+	}
+	return fmt.Errorf("unknown step %q", line)
+}
+
+// clientStep runs a step of a client, such as "sends hex 00 01"
+func (h *Harness) clientStep(name, step string) error {
+	verb, rest, _ := strings.Cut(step, " ")
+	rest = strings.TrimSpace(rest)
+	switch verb {
+	case "connects":
+		if rest == "" {
+			_, err := h.client(name)
+			return err
+		}
+		from, ok := strings.CutPrefix(rest, "from ")
+		if !ok {
+			return fmt.Errorf("unknown step %q, want connects from address", step)
+		}
+		_, err := h.connect(name, strings.TrimSpace(from))
+		return err
+	case "closes":
+		c, err := h.client(name)
+		if err != nil {
+			return err
+		}
+		c.close()
+		return nil
+	case "sends":
+		payload, err := h.payload(rest)
+		if err != nil {
+			return err
+		}
+		c, err := h.client(name)
+		if err != nil {
+			return err
+		}
+		return c.send(payload)
+	case "expects":
+		c, err := h.client(name)
+		if err != nil {
+			return err
+		}
+		if rest == "close" {
+			return c.expectClose()
+		}
+		if duration, ok := strings.CutPrefix(rest, "silence for "); ok {
+			d, err := time.ParseDuration(strings.TrimSpace(duration))
+			if err != nil {
+				return err
+			}
+			return h.expectSilence(d, c)
+		}
+		payload, err := h.payload(rest)
+		if err != nil {
+			return err
+		}
+		return c.expect(payload)
+	}
+	return fmt.Errorf("unknown step %q for client %s", step, name)
+}
+
+// payload parses the payload of a sends or expects step
+func (h *Harness) payload(s string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "`"):
+		text, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted string %s: %w", s, err)
+		}
+		return []byte(text), nil
+	case s == "line" || strings.HasPrefix(s, "line "):
+		return []byte(strings.TrimPrefix(strings.TrimPrefix(s, "line"), " ") + "\n"), nil
+	case strings.HasPrefix(s, "hex "):
+		payload, err := decodeHex(strings.TrimPrefix(s, "hex "))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex payload: %w", err)
+		}
+		return payload, nil
+	}
+	return h.server.Messages.encode(s)
+}
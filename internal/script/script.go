@@ -0,0 +1,426 @@
+// Package script runs conversation tests against a server in the same process. Scripted clients send bytes to the
+// server and check what it sends back, on a vnet network whose clock only moves when the conversation says so.
+//
+// Conversations are written in Go with a Harness and its clients, or as scripts run by Harness.Run, one step per line:
+//
+//	# Comments start with a hash
+//	client A connects from 10.1.2.3:4000
+//	client A sends hex 80 00 42 00 64 00 3c
+//	client A sends plate{plate: UN1X, timestamp: 0}
+//	client B sends "hello\n"
+//	client B sends line hello
+//	client B expects ticket{plate: UN1X, road: 66, mile1: 100, timestamp1: 0, mile2: 110, timestamp2: 45, speed: 8000}
+//	client B expects silence for 1s
+//	client A closes
+//	client B expects close
+//	expect silence for 10s
+//	advance 3s
+//
+// Payloads are Go quoted strings, "line" followed by text to which a newline is appended, "hex" followed by bytes in
+// hexadecimal, or named messages of the protocol, see Messages. A client that sends or expects something before it
+// connects is connected from a new address.
+//
+// Expectations wait for the server, and only fail after Timeout of wall-clock time. Silence is checked by moving the
+// clock forward, and then giving the server Settle of wall-clock time to react.
+package script
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ananthvk/protohackers-go/internal/clock"
+	"github.com/ananthvk/protohackers-go/internal/vnet"
+)
+
+const (
+	// ServerAddress is the address the server under test is bound to
+	ServerAddress = "10.0.0.1:7"
+	// DefaultTimeout is the default value of Harness.Timeout
+	DefaultTimeout = time.Second * 5
+	// DefaultSettle is the default value of Harness.Settle
+	DefaultSettle = time.Millisecond * 10
+)
+
+// Server is the server under test. Clients connect to Stream if it's set, and send datagrams to Packet otherwise
+type Server struct {
+	// Stream serves a stream client
+	Stream func(ctx context.Context, conn net.Conn)
+	// Packet serves the datagrams sent to the server, until ctx is done
+	Packet func(ctx context.Context, conn net.PacketConn)
+	// Messages are the named messages of the protocol
+	Messages Messages
+}
+
+// Harness runs a server on a virtual network, and the clients of a conversation
+type Harness struct {
+	// Clock runs the timers of the network. Servers that have timers of their own must run them on it too
+	Clock *clock.Virtual
+	// Network connects the clients to the server
+	Network *vnet.Network
+	// Timeout is the wall-clock time after which an expectation fails
+	Timeout time.Duration
+	// Settle is the wall-clock time the server is given to react before the clock moves, or before silence is checked
+	Settle time.Duration
+
+	t       testing.TB
+	server  Server
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	clients map[string]*Client
+	order   []*Client // Clients in the order they connected
+}
+
+// New creates a harness, which is stopped when the test ends. The server is started by Start
+func New(t testing.TB) *Harness {
+	virtual := clock.NewVirtual()
+	h := &Harness{
+		Clock:   virtual,
+		Network: vnet.New(virtual),
+		Timeout: DefaultTimeout,
+		Settle:  DefaultSettle,
+		t:       t,
+		cancel:  func() {},
+		clients: map[string]*Client{},
+	}
+	t.Cleanup(h.stop)
+	return h
+}
+
+// Start binds the server to ServerAddress
+func (h *Harness) Start(server Server) {
+	h.t.Helper()
+	if server.Stream == nil && server.Packet == nil {
+		h.t.Fatal("script: the server has neither a Stream nor a Packet handler")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.server, h.cancel = server, cancel
+	if server.Stream != nil {
+		listener, err := h.Network.Listen(ServerAddress)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		h.wg.Go(func() { vnet.Serve(ctx, listener, server.Stream) })
+		return
+	}
+	conn, err := h.Network.ListenPacket(ServerAddress)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.wg.Go(func() {
+		defer conn.Close()
+		server.Packet(ctx, conn)
+	})
+}
+
+// stop disconnects the clients, and waits for the server to return
+func (h *Harness) stop() {
+	for _, c := range h.order {
+		c.close()
+	}
+	h.cancel()
+	h.wg.Wait()
+}
+
+// Client returns the client with the given name, which is connected from a new address the first time
+func (h *Harness) Client(name string) *Client {
+	h.t.Helper()
+	c, err := h.client(name)
+	h.check(err)
+	return c
+}
+
+// Connect connects a client from the given address, port 0 picks a free port
+func (h *Harness) Connect(name, from string) *Client {
+	h.t.Helper()
+	c, err := h.connect(name, from)
+	h.check(err)
+	return c
+}
+
+// Advance moves the clock forward, once the server had time to react to what happened before
+func (h *Harness) Advance(d time.Duration) {
+	time.Sleep(h.Settle)
+	h.Clock.Advance(d)
+}
+
+// ExpectSilence moves the clock forward, and checks that no client received anything
+func (h *Harness) ExpectSilence(d time.Duration) {
+	h.t.Helper()
+	h.check(h.expectSilence(d, h.order...))
+}
+
+func (h *Harness) check(err error) {
+	h.t.Helper()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+func (h *Harness) client(name string) (*Client, error) {
+	if c := h.clients[name]; c != nil {
+		return c, nil
+	}
+	return h.connect(name, net.JoinHostPort(vnet.ClientHost, "0"))
+}
+
+func (h *Harness) connect(name, from string) (*Client, error) {
+	if h.clients[name] != nil {
+		return nil, fmt.Errorf("client %s is already connected", name)
+	}
+	c := &Client{h: h, name: name, changed: make(chan struct{})}
+	if h.server.Stream != nil {
+		conn, err := h.Network.DialFrom(from, ServerAddress)
+		if err != nil {
+			return nil, fmt.Errorf("client %s: %w", name, err)
+		}
+		c.stream = conn
+		go c.readStream()
+	} else {
+		conn, err := h.Network.ListenPacket(from)
+		if err != nil {
+			return nil, fmt.Errorf("client %s: %w", name, err)
+		}
+		c.packet = conn
+		go c.readPackets()
+	}
+	h.clients[name] = c
+	h.order = append(h.order, c)
+	return c, nil
+}
+
+func (h *Harness) expectSilence(d time.Duration, clients ...*Client) error {
+	h.Advance(d)
+	time.Sleep(h.Settle)
+	for _, c := range clients {
+		if err := c.silent(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Client is a client of the conversation. It's either a stream client, or a packet client that sends datagrams
+type Client struct {
+	h      *Harness
+	name   string
+	stream *vnet.Conn
+	packet *vnet.PacketConn
+
+	mu        sync.Mutex
+	received  []byte        // Bytes of the stream that were not expected yet
+	datagrams [][]byte      // Datagrams that were not expected yet
+	err       error         // Error that ended the reads, io.EOF once the server has closed the stream
+	changed   chan struct{} // Closed and replaced every time something is received
+}
+
+// Send sends the payload, as a single datagram for packet clients
+func (c *Client) Send(payload []byte) {
+	c.h.t.Helper()
+	c.h.check(c.send(payload))
+}
+
+// Expect waits until the payload is received, and fails if something else is received instead. Packet clients expect
+// a single datagram with that payload
+func (c *Client) Expect(payload []byte) {
+	c.h.t.Helper()
+	c.h.check(c.expect(payload))
+}
+
+// ExpectClose waits until the server closes the stream, and fails if anything is received before
+func (c *Client) ExpectClose() {
+	c.h.t.Helper()
+	c.h.check(c.expectClose())
+}
+
+// ExpectSilence moves the clock forward, and checks that the client received nothing
+func (c *Client) ExpectSilence(d time.Duration) {
+	c.h.t.Helper()
+	c.h.check(c.h.expectSilence(d, c))
+}
+
+// Close closes the client's end of the stream, or stops receiving datagrams
+func (c *Client) Close() {
+	c.close()
+}
+
+// Addr returns the address of the client
+func (c *Client) Addr() net.Addr {
+	if c.stream != nil {
+		return c.stream.LocalAddr()
+	}
+	return c.packet.LocalAddr()
+}
+
+func (c *Client) close() {
+	if c.stream != nil {
+		c.stream.Close()
+	} else {
+		c.packet.Close()
+	}
+}
+
+func (c *Client) readStream() {
+	buffer := make([]byte, 4096)
+	for {
+		n, err := c.stream.Read(buffer)
+		c.mu.Lock()
+		c.received = append(c.received, buffer[:n]...)
+		if err != nil {
+			c.err = err
+		}
+		c.notify()
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *Client) readPackets() {
+	buffer := make([]byte, 65536)
+	for {
+		n, _, err := c.packet.ReadFrom(buffer)
+		c.mu.Lock()
+		if err != nil {
+			c.err = err
+		} else {
+			c.datagrams = append(c.datagrams, bytes.Clone(buffer[:n]))
+		}
+		c.notify()
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// notify wakes up a waiting expectation. The caller must hold mu
+func (c *Client) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// wait blocks until done returns true, which is called with mu held. mu is still held when wait returns
+func (c *Client) wait(done func() bool) error {
+	timer := time.NewTimer(c.h.Timeout)
+	defer timer.Stop()
+	for !done() {
+		changed := c.changed
+		c.mu.Unlock()
+		select {
+		case <-changed:
+			c.mu.Lock()
+		case <-timer.C:
+			c.mu.Lock()
+			return fmt.Errorf("client %s: nothing received for %v", c.name, c.h.Timeout)
+		}
+	}
+	return nil
+}
+
+func (c *Client) send(payload []byte) error {
+	var err error
+	if c.stream != nil {
+		_, err = c.stream.Write(payload)
+	} else {
+		_, err = c.packet.WriteTo(payload, vnet.Addr{Net: "udp", Address: ServerAddress})
+	}
+	if err != nil {
+		return fmt.Errorf("client %s: send failed: %w", c.name, err)
+	}
+	return nil
+}
+
+func (c *Client) expect(want []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.packet != nil {
+		if err := c.wait(func() bool { return len(c.datagrams) > 0 || c.err != nil }); err != nil {
+			return fmt.Errorf("%w, want %s", err, Format(want))
+		}
+		if len(c.datagrams) == 0 {
+			return fmt.Errorf("client %s: got %v, want %s", c.name, c.err, Format(want))
+		}
+		got := c.datagrams[0]
+		c.datagrams = c.datagrams[1:]
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("client %s: got %s, want %s", c.name, Format(got), Format(want))
+		}
+		return nil
+	}
+	err := c.wait(func() bool { return len(c.received) >= len(want) || c.err != nil })
+	if err != nil || len(c.received) < len(want) {
+		if err == nil {
+			err = fmt.Errorf("client %s: %w", c.name, c.err)
+		}
+		return fmt.Errorf("%w after %s, want %s", err, Format(c.received), Format(want))
+	}
+	got := c.received[:len(want)]
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("client %s: got %s, want %s", c.name, Format(got), Format(want))
+	}
+	c.received = c.received[len(want):]
+	return nil
+}
+
+func (c *Client) expectClose() error {
+	if c.stream == nil {
+		return fmt.Errorf("client %s: packet clients have no connection to close", c.name)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.wait(func() bool { return len(c.received) > 0 || c.err != nil }); err != nil {
+		return fmt.Errorf("%w, want the connection to be closed", err)
+	}
+	if len(c.received) > 0 {
+		return fmt.Errorf("client %s: got %s, want the connection to be closed", c.name, Format(c.received))
+	}
+	if !errors.Is(c.err, io.EOF) {
+		return fmt.Errorf("client %s: got %v, want the connection to be closed", c.name, c.err)
+	}
+	return nil
+}
+
+// silent returns an error if something was received and not expected
+func (c *Client) silent() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.received) > 0 {
+		return fmt.Errorf("client %s: got %s, want silence", c.name, Format(c.received))
+	}
+	if len(c.datagrams) > 0 {
+		return fmt.Errorf("client %s: got %s, want silence", c.name, Format(c.datagrams[0]))
+	}
+	if c.err != nil && !errors.Is(c.err, net.ErrClosed) {
+		return fmt.Errorf("client %s: got %v, want silence", c.name, c.err)
+	}
+	return nil
+}
+
+// Format returns the payload as a quoted string if it's printable text, and in hexadecimal otherwise
+func Format(payload []byte) string {
+	if len(payload) == 0 {
+		return "nothing"
+	}
+	if utf8.Valid(payload) && !bytes.ContainsFunc(payload, func(r rune) bool { return !unicode.IsPrint(r) && !unicode.IsSpace(r) }) {
+		return strconv.Quote(string(payload))
+	}
+	return "hex " + strings.TrimSpace(fmt.Sprintf("% x", payload))
+}
+
+// decodeHex parses bytes in hexadecimal, separated by spaces or not
+func decodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,124 @@
+package script
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upper answers every line with the line in upper case, and sends "tick\n" every second
+func upper(h *Harness) Server {
+	return Server{
+		Stream: func(ctx context.Context, conn net.Conn) {
+			ticker := h.Clock.NewTicker(time.Second)
+			defer ticker.Stop()
+			go func() {
+				for range ticker.C() {
+					conn.Write([]byte("tick\n"))
+				}
+			}()
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == "quit\n" {
+					return
+				}
+				conn.Write([]byte(strings.ToUpper(line)))
+			}
+		},
+		Messages: Messages{
+			"pair": func(f *Fields) []byte {
+				b := binary.BigEndian.AppendUint16(nil, uint16(f.Uint("a", 16)))
+				for _, n := range f.Uints("rest", 8) {
+					b = append(b, byte(n))
+				}
+				return append(b, f.String("name")...)
+			},
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	h := New(t)
+	h.Start(upper(h))
+	h.Run("upper", `
+# Text
+client A connects from 10.1.2.3:4000
+client A sends "hello\n"
+client A expects "HELLO\n"
+client B sends line abc
+client B expects line ABC
+client A expects silence for 999ms
+
+# The ticker of both clients fires
+advance 1ms
+client A expects line tick
+client B expects line tick
+
+# Binary
+client B sends pair{a: 258, rest: [3, 4], name: "x\n"}
+client B expects hex 01 02 03 04 58 0a
+client A sends line quit
+client A expects close
+`)
+	if got := h.Client("A").Addr().String(); got != "10.1.2.3:4000" {
+		t.Errorf("got client address %q", got)
+	}
+}
+
+func TestStepErrors(t *testing.T) {
+	h := New(t)
+	h.Start(upper(h))
+	h.Timeout = time.Millisecond * 50
+	for _, test := range []struct {
+		step string
+		want string
+	}{
+		{`client A sends "hi\n"`, ""},
+		{`client A expects "hi\n"`, `client A: got "HI\n", want "hi\n"`},
+		// Failed expectations consume nothing
+		{`client A expects line more`, `client A: nothing received for 50ms after "HI\n", want "more\n"`},
+		{`client A expects close`, `client A: got "HI\n", want the connection to be closed`},
+		{`client A sends pair{a: 70000, rest: [], name: x}`, `pair: field "a": strconv.ParseUint: parsing "70000": value out of range`},
+		{`client A sends pair{a: 1}`, `pair: missing field "rest"`},
+		{`client A sends pair{a: 1, rest: [], name: x, extra: 1}`, `pair: unknown field "extra"`},
+		{`client A sends ticket{}`, `unknown message "ticket"`},
+		{`client A sends hex 0`, "invalid hex payload: encoding/hex: odd length hex string"},
+		{`client A dances`, `unknown step "dances" for client A`},
+		{`expect nothing`, `unknown step "expect nothing"`},
+		{`client A connects from 10.0.0.2:1`, "client A is already connected"},
+		{`client B sends line tick`, ""},
+		{`advance 1s`, ""},
+		{`expect silence for 1ms`, `client A: got "HI\ntick\n", want silence`},
+	} {
+		err := h.step(test.step)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.step, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.step, err, test.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for payload, want := range map[string]string{
+		"":          "nothing",
+		"hello\n":   `"hello\n"`,
+		"\x00\x01ß": "hex 00 01 c3 9f",
+	} {
+		if got := Format([]byte(payload)); got != want {
+			t.Errorf("Format(%q) = %s, want %s", payload, got, want)
+		}
+	}
+}
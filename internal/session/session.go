@@ -0,0 +1,174 @@
+// Package session keeps track of the live connections of every service, so that they can be listed and closed from the
+// admin endpoint. Handlers describe the role of their client with Annotate, and connections that have state of their
+// own (such as lrcp sessions) implement Describer.
+package session
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/netutil"
+)
+
+// Describer is implemented by connections that report live details about themselves, such as the number of
+// unacknowledged segments of an lrcp session
+type Describer interface {
+	Describe() map[string]any
+}
+
+// Session is a live connection
+type Session struct {
+	ID      uint64
+	Service string
+	Remote  string
+	Start   time.Time
+
+	conn  net.Conn
+	bytes func() (received, sent uint64) // may be nil
+
+	mu    sync.Mutex
+	attrs map[string]any
+}
+
+// New creates a session for conn. bytes returns the number of bytes transferred so far, and may be nil
+func New(id uint64, service string, conn net.Conn, bytes func() (received, sent uint64)) *Session {
+	return &Session{
+		ID:      id,
+		Service: service,
+		Remote:  conn.RemoteAddr().String(),
+		Start:   time.Now(),
+		conn:    conn,
+		bytes:   bytes,
+	}
+}
+
+// Set stores an attribute of the session, replacing any previous value
+func (s *Session) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = map[string]any{}
+	}
+	s.attrs[key] = value
+}
+
+// Close closes the connection, which makes the handler return
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+// Snapshot is the state of a session at one point in time, as served by the admin endpoint
+type Snapshot struct {
+	ID            uint64         `json:"id"`
+	Service       string         `json:"service"`
+	Remote        string         `json:"remote_address"`
+	Start         time.Time      `json:"start"`
+	Age           string         `json:"age"`
+	BytesReceived uint64         `json:"bytes_received"`
+	BytesSent     uint64         `json:"bytes_sent"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+}
+
+// Snapshot returns the current state of the session
+func (s *Session) Snapshot() Snapshot {
+	snapshot := Snapshot{
+		ID:      s.ID,
+		Service: s.Service,
+		Remote:  s.Remote,
+		Start:   s.Start,
+		Age:     time.Since(s.Start).Round(time.Second).String(),
+	}
+	if s.bytes != nil {
+		snapshot.BytesReceived, snapshot.BytesSent = s.bytes()
+	}
+	s.mu.Lock()
+	if len(s.attrs) > 0 {
+		snapshot.Attributes = maps.Clone(s.attrs)
+	}
+	s.mu.Unlock()
+	if d := findDescriber(s.conn); d != nil {
+		if snapshot.Attributes == nil {
+			snapshot.Attributes = map[string]any{}
+		}
+		maps.Copy(snapshot.Attributes, d.Describe())
+	}
+	return snapshot
+}
+
+// findDescriber looks for a Describer in the chain of wrapped connections
+func findDescriber(conn net.Conn) Describer {
+	d, _ := netutil.Find[Describer](conn)
+	return d
+}
+
+type sessionKey struct{}
+
+// NewContext returns a copy of ctx carrying the session
+func NewContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// FromContext returns the session of the context, or nil
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+// Annotate sets an attribute of the session of the context, such as the username of a chat client. It does nothing if
+// the context has no session, so handlers can call it unconditionally.
+func Annotate(ctx context.Context, key string, value any) {
+	if s := FromContext(ctx); s != nil {
+		s.Set(key, value)
+	}
+}
+
+// Registry is a set of live sessions. It is safe for concurrent use
+type Registry struct {
+	mu       sync.Mutex
+	sessions map[uint64]*Session
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{sessions: map[uint64]*Session{}}
+}
+
+// Add registers the session, and returns a function that removes it
+func (r *Registry) Add(s *Session) (remove func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[s.ID] = s
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.sessions, s.ID)
+	}
+}
+
+// Get returns the session with the given ID
+func (r *Registry) Get(id uint64) (*Session, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sessions[id]
+	return s, ok
+}
+
+// List returns a snapshot of every session, oldest first
+func (r *Registry) List() []Snapshot {
+	r.mu.Lock()
+	sessions := slices.Collect(maps.Values(r.sessions))
+	r.mu.Unlock()
+	snapshots := make([]Snapshot, len(sessions))
+	for i, s := range sessions {
+		snapshots[i] = s.Snapshot()
+	}
+	slices.SortFunc(snapshots, func(a, b Snapshot) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return snapshots
+}
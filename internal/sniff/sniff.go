@@ -0,0 +1,170 @@
+// Package sniff serves several protocols on a single port. It peeks at the first bytes sent by each client, picks the
+// route whose matcher recognizes them, and runs its handler on a connection that still returns those bytes.
+//
+// Protocols where the server speaks first, such as the greeting of budget chat, can't be recognized from their first
+// bytes: their clients send nothing until they are greeted. Connections that stay silent for SilenceTimeout are given to
+// the Silent route instead.
+package sniff
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/acl"
+	"github.com/ananthvk/protohackers-go/internal/metrics"
+	"github.com/ananthvk/protohackers-go/internal/session"
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+)
+
+// DefaultSilenceTimeout is how long a client may stay silent before it is given to the Silent route
+const DefaultSilenceTimeout = time.Second
+
+// maxPeek bounds the number of bytes read while looking for a route. Connections that are still undecided after that
+// many bytes are given to the Fallback route
+const maxPeek = 64
+
+var (
+	routed = metrics.NewCounterVec("protohackers_sniff_routed_total", "Number of connections routed by protocol detection, by protocol", "protocol")
+	denied = metrics.NewCounterVec("protohackers_sniff_denied_total", "Number of connections closed because the access lists of their protocol deny them, by protocol", "protocol")
+)
+
+// Verdict is the answer of a Matcher about the bytes received so far
+type Verdict int
+
+const (
+	// NeedMore means that the bytes received so far could belong to the protocol, but more are needed to tell
+	NeedMore Verdict = iota
+	// Match means that the connection speaks the protocol
+	Match
+	// NoMatch means that the connection doesn't speak the protocol
+	NoMatch
+)
+
+// Matcher looks at the first bytes sent by a client, which are never empty
+type Matcher func(prefix []byte) Verdict
+
+// Route is a protocol served by the router
+type Route struct {
+	// Name identifies the protocol in logs and in the admin API
+	Name string
+	// Match recognizes the protocol. It is not used for the Fallback and Silent routes
+	Match Matcher
+	// Handler serves the connection once it has been routed
+	Handler func(ctx context.Context, conn net.Conn)
+	// Timeouts is the timeout policy of the protocol, which replaces the policy of the connection once it's routed
+	Timeouts timeout.Policy
+	// Access restricts the clients of the protocol, in addition to the access lists of the service that sniffs it.
+	// Connections routed to a protocol that denies them are closed
+	Access acl.List
+}
+
+// Router picks a route for every connection
+type Router struct {
+	// Routes are tried in order, the first one that matches wins
+	Routes []Route
+	// Fallback serves connections that no route matches. Such connections are closed if it's nil
+	Fallback *Route
+	// Silent serves connections that send nothing for SilenceTimeout. Such connections are given to Fallback if it's nil
+	Silent *Route
+	// SilenceTimeout bounds the time taken to pick a route. Zero uses DefaultSilenceTimeout
+	SilenceTimeout time.Duration
+	// Timeouts override the policy of every route, see timeout.Policy.Override
+	Timeouts timeout.Policy
+}
+
+// Conn returns the bytes read while sniffing before reading from the wrapped connection again
+type Conn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// Unwrap returns the wrapped connection
+func (c *Conn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// Handle routes the connection, and runs the handler of the route
+func (r *Router) Handle(ctx context.Context, conn net.Conn) {
+	silenceTimeout := r.SilenceTimeout
+	if silenceTimeout <= 0 {
+		silenceTimeout = DefaultSilenceTimeout
+	}
+	// The deadline set by the service, if any, is restored once the protocol is known
+	previous := timeout.ReadDeadline(conn)
+	if err := conn.SetReadDeadline(time.Now().Add(silenceTimeout)); err != nil {
+		slog.WarnContext(ctx, "set deadline failed", "error", err)
+		return
+	}
+	prefix, route, err := r.sniff(conn)
+	// A read interrupted by shutdown also looks like a timeout, the connection must not be routed then
+	if err != nil && (ctx.Err() != nil || !errors.Is(err, os.ErrDeadlineExceeded)) {
+		slog.DebugContext(ctx, "read failed before the protocol was detected", "error", err)
+		return
+	}
+	if route == nil {
+		slog.DebugContext(ctx, "protocol not detected, closing connection", "received", len(prefix))
+		return
+	}
+	if err := conn.SetReadDeadline(previous); err != nil {
+		return
+	}
+	if !route.Access.Permits(conn.RemoteAddr()) {
+		slog.DebugContext(ctx, "connection rejected", "protocol", route.Name, "remote_address", conn.RemoteAddr().String(), "reason", acl.Reason)
+		denied.With(route.Name).Inc()
+		return
+	}
+	timeout.SetPolicy(conn, route.Timeouts.Override(r.Timeouts))
+	slog.DebugContext(ctx, "protocol detected", "protocol", route.Name, "received", len(prefix))
+	session.Annotate(ctx, "protocol", route.Name)
+	routed.With(route.Name).Inc()
+	route.Handler(ctx, &Conn{Conn: conn, prefix: prefix})
+}
+
+// sniff reads from the connection until a route is found. The route is nil if no route matches and there is no
+// fallback. A read error is returned along with the fallback (or silent) route that should serve the connection anyway.
+func (r *Router) sniff(conn net.Conn) ([]byte, *Route, error) {
+	var buffer [maxPeek]byte
+	received := 0
+	for received < len(buffer) {
+		n, err := conn.Read(buffer[received:])
+		received += n
+		if received > 0 {
+			if route, decided := r.match(buffer[:received]); decided {
+				return buffer[:received], route, nil
+			}
+		}
+		if err != nil {
+			if received == 0 && r.Silent != nil {
+				return nil, r.Silent, err
+			}
+			return buffer[:received], r.Fallback, err
+		}
+	}
+	return buffer[:received], r.Fallback, nil
+}
+
+// match returns the route of the prefix. decided is false while some route still needs more bytes
+func (r *Router) match(prefix []byte) (route *Route, decided bool) {
+	for i := range r.Routes {
+		switch r.Routes[i].Match(prefix) {
+		case Match:
+			return &r.Routes[i], true
+		case NeedMore:
+			return nil, false
+		}
+	}
+	return r.Fallback, true
+}
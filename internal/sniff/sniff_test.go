@@ -0,0 +1,139 @@
+package sniff
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/timeout"
+)
+
+// recordingHandler writes the name of the route, followed by everything it reads from the connection
+func recordingHandler(name string) func(ctx context.Context, conn net.Conn) {
+	return func(ctx context.Context, conn net.Conn) {
+		conn.Write([]byte(name + ":"))
+		io.Copy(conn, conn)
+	}
+}
+
+func testRouter() *Router {
+	fallback := Route{Name: "echo", Handler: recordingHandler("echo")}
+	silent := Route{Name: "chat", Handler: recordingHandler("chat")}
+	return &Router{
+		Routes: []Route{
+			{Name: "json", Handler: recordingHandler("json"), Match: func(prefix []byte) Verdict {
+				if prefix[0] == '{' {
+					return Match
+				}
+				return NoMatch
+			}},
+			// Needs two bytes to decide
+			{Name: "hello", Handler: recordingHandler("hello"), Match: func(prefix []byte) Verdict {
+				if prefix[0] != 'h' {
+					return NoMatch
+				}
+				if len(prefix) < 2 {
+					return NeedMore
+				}
+				if prefix[1] == 'i' {
+					return Match
+				}
+				return NoMatch
+			}},
+		},
+		Fallback:       &fallback,
+		Silent:         &silent,
+		SilenceTimeout: time.Millisecond * 50,
+	}
+}
+
+func TestRouter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"first byte", []string{`{"a":1}`}, `json:{"a":1}`},
+		{"split prefix", []string{"h", "i there"}, "hello:hi there"},
+		{"not matched after more bytes", []string{"h", "ello"}, "echo:hello"},
+		{"fallback", []string{"xyz"}, "echo:xyz"},
+		{"silent", nil, "chat:"},
+		{"silent then data", []string{"", "late"}, "chat:late"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() {
+				defer server.Close()
+				testRouter().Handle(context.Background(), server)
+			}()
+			client.SetDeadline(time.Now().Add(time.Second * 2))
+			go func() {
+				for _, w := range test.writes {
+					if w == "" {
+						time.Sleep(time.Millisecond * 100)
+						continue
+					}
+					client.Write([]byte(w))
+				}
+			}()
+			got := make([]byte, len(test.want))
+			if _, err := io.ReadFull(client, got); err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRouterShutdown(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	router := testRouter()
+	router.SilenceTimeout = time.Millisecond * 10
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		router.Handle(ctx, server)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("Handle did not return")
+	}
+	// A connection whose read is interrupted by shutdown must not be greeted by the silent route
+	server.Close()
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want %v", err, io.EOF)
+	}
+}
+
+// TestRouterInterrupted checks that a connection interrupted by shutdown before it's sniffed is not kept open until the
+// silence timeout, even though the router sets its own deadline
+func TestRouterInterrupted(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	conn := timeout.New(server, timeout.Policy{})
+	conn.Interrupt()
+	router := testRouter()
+	router.SilenceTimeout = time.Minute
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		router.Handle(ctx, conn)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("Handle did not return")
+	}
+}
@@ -0,0 +1,129 @@
+// Package socket binds the addresses of services. Besides host:port, an address can be
+//
+//	unix:/run/protohackers/chat.sock  a unix domain socket (datagram for packet services), created at that path
+//	systemd:name                      a socket passed by systemd socket activation, by its FileDescriptorName
+//	systemd:0                         the same, by its position in LISTEN_FDS
+//
+// Inherited sockets stay open when the process restarts, so that clients connecting during a restart are queued
+// instead of refused.
+package socket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Address prefixes
+const (
+	unixPrefix    = "unix:"
+	systemdPrefix = "systemd:"
+)
+
+// Listen binds a stream listener, over tcp unless the address says otherwise
+func Listen(ctx context.Context, address string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(address, unixPrefix); ok {
+		if err := removeStale(ctx, "unix", path); err != nil {
+			return nil, err
+		}
+		listenerConfig := net.ListenConfig{}
+		return listenerConfig.Listen(ctx, "unix", path)
+	}
+	if name, ok := strings.CutPrefix(address, systemdPrefix); ok {
+		f, err := inherited(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		listener, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %q is not a stream listener: %w", name, err)
+		}
+		return listener, nil
+	}
+	listenerConfig := net.ListenConfig{}
+	return listenerConfig.Listen(ctx, "tcp", address)
+}
+
+// ListenPacket binds a packet connection, over udp unless the address says otherwise
+func ListenPacket(ctx context.Context, address string) (net.PacketConn, error) {
+	if path, ok := strings.CutPrefix(address, unixPrefix); ok {
+		if err := removeStale(ctx, "unixgram", path); err != nil {
+			return nil, err
+		}
+		listenerConfig := net.ListenConfig{}
+		conn, err := listenerConfig.ListenPacket(ctx, "unixgram", path)
+		if err != nil {
+			return nil, err
+		}
+		return &unlinkOnClose{PacketConn: conn, path: path}, nil
+	}
+	if name, ok := strings.CutPrefix(address, systemdPrefix); ok {
+		f, err := inherited(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		conn, err := net.FilePacketConn(f)
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %q is not a datagram socket: %w", name, err)
+		}
+		return conn, nil
+	}
+	listenerConfig := net.ListenConfig{}
+	return listenerConfig.ListenPacket(ctx, "udp", address)
+}
+
+// removeStale removes the socket left behind at path by a previous process, which would make the bind fail. The socket
+// is only removed if nothing answers on it, a socket that is still bound (such as by another instance of the server)
+// is reported as in use instead of being taken over. Any other kind of file is kept, and reported by the bind instead
+func removeStale(ctx context.Context, network, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return nil
+	}
+	dialer := net.Dialer{Timeout: staleDialTimeout}
+	conn, err := dialer.DialContext(ctx, network, path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use by another process: %w", path, syscall.EADDRINUSE)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		// Let the bind report the problem
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// staleDialTimeout bounds the check of an existing socket by removeStale
+const staleDialTimeout = time.Second
+
+// unlinkOnClose removes the socket file of a unixgram connection once it's closed, as net.UnixListener does for stream
+// sockets
+type unlinkOnClose struct {
+	net.PacketConn
+	path string
+}
+
+func (c *unlinkOnClose) Close() error {
+	err := c.PacketConn.Close()
+	os.Remove(c.path)
+	return err
+}
+
+// Unwrap returns the wrapped connection
+func (c *unlinkOnClose) Unwrap() net.PacketConn {
+	return c.PacketConn
+}
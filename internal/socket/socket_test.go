@@ -0,0 +1,185 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestUnixStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.sock")
+	// A socket left behind by a previous process doesn't prevent binding
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := Listen(context.Background(), "unix:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Write([]byte("hi"))
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 2)
+	if _, err := conn.Read(buffer); err != nil || string(buffer) != "hi" {
+		t.Errorf("got %q, %v", buffer, err)
+	}
+	conn.Close()
+	listener.Close()
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("socket file was not removed: %v", err)
+	}
+}
+
+func TestUnixInUse(t *testing.T) {
+	for _, network := range []string{"unix", "unixgram"} {
+		t.Run(network, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "live.sock")
+			var err error
+			if network == "unix" {
+				var live net.Listener
+				live, err = Listen(context.Background(), "unix:"+path)
+				if err == nil {
+					defer live.Close()
+					_, err = Listen(context.Background(), "unix:"+path)
+				}
+			} else {
+				var live net.PacketConn
+				live, err = ListenPacket(context.Background(), "unix:"+path)
+				if err == nil {
+					defer live.Close()
+					_, err = ListenPacket(context.Background(), "unix:"+path)
+				}
+			}
+			// The socket of the live server is kept
+			if !errors.Is(err, syscall.EADDRINUSE) {
+				t.Errorf("got %v, want %v", err, syscall.EADDRINUSE)
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("socket of the live server was removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnixStreamKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(context.Background(), "unix:"+path); err == nil {
+		t.Fatal("bound over a regular file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("regular file was removed: %v", err)
+	}
+}
+
+func TestUnixDatagram(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "packet.sock")
+	conn, err := ListenPacket(context.Background(), "unix:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "client.sock"), Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.WriteTo([]byte("ping"), &net.UnixAddr{Name: path, Net: "unixgram"}); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 16)
+	n, from, err := conn.ReadFrom(buffer)
+	if err != nil || string(buffer[:n]) != "ping" {
+		t.Fatalf("got %q, %v", buffer[:n], err)
+	}
+	// Replies go back to the socket of the client
+	if _, err := conn.WriteTo([]byte("pong"), from); err != nil {
+		t.Fatal(err)
+	}
+	if n, _, err := client.ReadFrom(buffer); err != nil || string(buffer[:n]) != "pong" {
+		t.Errorf("got %q, %v", buffer[:n], err)
+	}
+	conn.Close()
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("socket file was not removed: %v", err)
+	}
+}
+
+func TestFromEnvironment(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+	a, err := fromEnvironment(env(map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "3", "LISTEN_FDNAMES": "chat:kv"}), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.names) != 3 || a.names[0] != "chat" || a.names[1] != "kv" || a.names[2] != "" {
+		t.Errorf("got names %q", a.names)
+	}
+
+	// Variables meant for another process are ignored
+	a, err = fromEnvironment(env(map[string]string{"LISTEN_PID": "41", "LISTEN_FDS": "3"}), 42)
+	if err != nil || len(a.names) != 0 {
+		t.Errorf("got %q, %v", a.names, err)
+	}
+	if _, err := a.take("chat"); err == nil {
+		t.Errorf("took a socket that was not passed")
+	}
+
+	if _, err := fromEnvironment(env(map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "x"}), 42); err == nil {
+		t.Errorf("invalid LISTEN_FDS was accepted")
+	}
+}
+
+func TestTake(t *testing.T) {
+	// A socket opened by the test stands in for one passed by systemd
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	f, err := udpConn.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &activation{start: int(f.Fd()), names: []string{"lrcp"}, used: []bool{false}}
+
+	if _, err := a.take("chat"); err == nil {
+		t.Errorf("took a socket with an unknown name")
+	}
+	inherited, err := a.take("0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.FilePacketConn(inherited)
+	inherited.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.LocalAddr().String() != udpConn.LocalAddr().String() {
+		t.Errorf("got %v, want %v", conn.LocalAddr(), udpConn.LocalAddr())
+	}
+	if _, err := a.take("lrcp"); err == nil {
+		t.Errorf("took the same socket twice")
+	}
+}
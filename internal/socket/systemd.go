@@ -0,0 +1,89 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd, see sd_listen_fds(3)
+const listenFDsStart = 3
+
+// activation holds the sockets passed by systemd. Each of them can only be bound once
+type activation struct {
+	start int
+	names []string // FileDescriptorName of each socket, empty if LISTEN_FDNAMES is not set
+
+	mu   sync.Mutex
+	used []bool
+}
+
+// fromEnvironment reads the sockets passed to the process with the given pid. It returns an empty activation if the
+// variables are not set, or were meant for another process
+func fromEnvironment(getenv func(string) string, pid int) (*activation, error) {
+	a := &activation{start: listenFDsStart}
+	if getenv("LISTEN_PID") == "" {
+		return a, nil
+	}
+	listenPID, err := strconv.Atoi(getenv("LISTEN_PID"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_PID: %w", err)
+	}
+	if listenPID != pid {
+		return a, nil
+	}
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+	a.names = make([]string, count)
+	a.used = make([]bool, count)
+	if names := getenv("LISTEN_FDNAMES"); names != "" {
+		copy(a.names, strings.Split(names, ":"))
+	}
+	return a, nil
+}
+
+// take returns the socket with the given name, or at the given position if no socket has that name
+func (a *activation) take(name string) (*os.File, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.names) == 0 {
+		return nil, errors.New("no sockets were passed by systemd (LISTEN_FDS is not set)")
+	}
+	i := slices.Index(a.names, name)
+	if i < 0 {
+		index, err := strconv.Atoi(name)
+		if err != nil || index < 0 || index >= len(a.names) {
+			return nil, fmt.Errorf("no socket named %q was passed by systemd, the sockets are %q", name, a.names)
+		}
+		i = index
+	}
+	if a.used[i] {
+		return nil, fmt.Errorf("systemd socket %q is already in use", name)
+	}
+	a.used[i] = true
+	return os.NewFile(uintptr(a.start+i), a.names[i]), nil
+}
+
+// sockets reads the environment once. The variables are then removed, so that they don't leak into child processes
+var sockets = sync.OnceValues(func() (*activation, error) {
+	a, err := fromEnvironment(os.Getenv, os.Getpid())
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return a, err
+})
+
+// inherited returns a socket passed by systemd
+func inherited(name string) (*os.File, error) {
+	a, err := sockets()
+	if err != nil {
+		return nil, err
+	}
+	return a.take(name)
+}
@@ -0,0 +1,280 @@
+// Package timeout enforces idle, read and write timeouts on connections through a wrapping net.Conn.
+//
+// A connection is either idle (waiting for the first byte of the next message), or in the middle of a message. The
+// idle timeout bounds how long a read may wait without receiving anything, and the read timeout bounds the total time
+// taken to receive a message once its first byte has arrived. Handlers mark the end of each message with EndMessage,
+// which returns the connection to the idle state.
+package timeout
+
+import (
+	"flag"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/netutil"
+)
+
+// Policy holds the timeouts applied to a connection. A zero duration disables the timeout
+type Policy struct {
+	// Idle is the maximum time a read may block without receiving any data
+	Idle time.Duration
+	// Read is the maximum time taken to receive a whole message, measured from its first byte
+	Read time.Duration
+	// Write is the maximum time a single write may take
+	Write time.Duration
+}
+
+// IsZero returns true if all timeouts are disabled
+func (p Policy) IsZero() bool {
+	return p == Policy{}
+}
+
+// Override returns a copy of the policy, where every positive duration of o replaces the one in p, and every negative
+// duration of o disables the timeout. Zero durations in o keep the value from p.
+func (p Policy) Override(o Policy) Policy {
+	merge := func(base, override time.Duration) time.Duration {
+		if override < 0 {
+			return 0
+		}
+		if override > 0 {
+			return override
+		}
+		return base
+	}
+	return Policy{
+		Idle:  merge(p.Idle, o.Idle),
+		Read:  merge(p.Read, o.Read),
+		Write: merge(p.Write, o.Write),
+	}
+}
+
+// RegisterFlags binds the policy to command line flags. The flags override the defaults of each service (see
+// Policy.Override), so they default to zero.
+func (p *Policy) RegisterFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&p.Idle, "idle-timeout", p.Idle, "override the idle timeout of every service (negative to disable)")
+	fs.DurationVar(&p.Read, "read-timeout", p.Read, "override the per message read timeout of every service (negative to disable)")
+	fs.DurationVar(&p.Write, "write-timeout", p.Write, "override the write timeout of every service (negative to disable)")
+}
+
+// Conn applies a Policy to the wrapped connection. Deadlines set explicitly with SetReadDeadline / SetWriteDeadline are
+// still honoured, the earlier of the explicit deadline and the policy deadline is used.
+type Conn struct {
+	net.Conn
+
+	mu           sync.Mutex
+	policy       Policy
+	inMessage    bool
+	messageStart time.Time
+
+	readDeadline   time.Time // Set explicitly by the user of the connection
+	writeDeadline  time.Time
+	policyDeadline time.Time // Deadline applied to the read in progress
+	interrupted    bool      // Set by Interrupt, every read fails from then on
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// aLongTimeAgo is a read deadline that has always passed, applied once the connection is interrupted
+var aLongTimeAgo = time.Unix(1, 0)
+
+func New(conn net.Conn, policy Policy) *Conn {
+	return &Conn{Conn: conn, policy: policy, closed: make(chan struct{})}
+}
+
+// earliest returns the earlier of two deadlines, where the zero time means no deadline
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() {
+		return b
+	}
+	if b.IsZero() || a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// readPolicyDeadline returns the deadline of the next read according to the policy. The caller must hold the lock
+func (c *Conn) readPolicyDeadline(now time.Time) time.Time {
+	var deadline time.Time
+	if c.policy.Idle > 0 {
+		deadline = now.Add(c.policy.Idle)
+	}
+	if c.inMessage && c.policy.Read > 0 {
+		deadline = earliest(deadline, c.messageStart.Add(c.policy.Read))
+	}
+	return deadline
+}
+
+// applyReadDeadline sets the read deadline of the wrapped connection to the earlier of the policy and the explicit
+// deadlines, or to a deadline in the past once the connection is interrupted. The caller must hold the lock, so that
+// an interrupt can't be overwritten by a deadline computed before it
+func (c *Conn) applyReadDeadline() error {
+	deadline := earliest(c.policyDeadline, c.readDeadline)
+	if c.interrupted {
+		deadline = aLongTimeAgo
+	}
+	return c.Conn.SetReadDeadline(deadline)
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	c.policyDeadline = c.readPolicyDeadline(time.Now())
+	err := c.applyReadDeadline()
+	c.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		if !c.inMessage {
+			c.inMessage = true
+			c.messageStart = time.Now()
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.writeDeadline
+	if c.policy.Write > 0 {
+		deadline = earliest(deadline, time.Now().Add(c.policy.Write))
+	}
+	c.mu.Unlock()
+
+	if err := c.Conn.SetWriteDeadline(deadline); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets an explicit read deadline. It is applied immediately, so that a blocked Read is interrupted
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.applyReadDeadline()
+}
+
+// ReadDeadline returns the read deadline set explicitly with SetReadDeadline, so that code that needs its own deadline
+// for a while can restore it afterwards
+func (c *Conn) ReadDeadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readDeadline
+}
+
+// Interrupt makes the read in progress and every later read fail, whatever the deadlines set afterwards. It's used on
+// shutdown, so that a handler that resets its deadlines still stops reading. Writes are not affected
+func (c *Conn) Interrupt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interrupted = true
+	c.applyReadDeadline()
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// BeginMessage starts the read timeout now, even though no data has been received yet. It's used when the server
+// is waiting for a reply, for example after sending a prompt.
+func (c *Conn) BeginMessage() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inMessage = true
+	c.messageStart = time.Now()
+}
+
+// EndMessage marks the end of a message, so that the connection is idle until the next byte arrives
+func (c *Conn) EndMessage() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inMessage = false
+}
+
+// SetIdleTimeout changes the idle timeout of this connection. Zero disables it.
+func (c *Conn) SetIdleTimeout(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy.Idle = d
+}
+
+// SetPolicy replaces the policy of this connection, for example once the protocol spoken over it is known
+func (c *Conn) SetPolicy(p Policy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = p
+}
+
+// Close closes the connection, and the channel returned by Done
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// Done returns a channel that is closed once the connection is closed, so that wrappers which wait without reading or
+// writing (such as the rate limits) can stop waiting
+func (c *Conn) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Unwrap returns the wrapped connection
+func (c *Conn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// find looks for a *Conn in the chain of wrapped connections
+func find(conn net.Conn) *Conn {
+	c, _ := netutil.Find[*Conn](conn)
+	return c
+}
+
+// BeginMessage calls BeginMessage on the *Conn wrapped by conn, if there is one
+func BeginMessage(conn net.Conn) {
+	if c := find(conn); c != nil {
+		c.BeginMessage()
+	}
+}
+
+// EndMessage calls EndMessage on the *Conn wrapped by conn, if there is one
+func EndMessage(conn net.Conn) {
+	if c := find(conn); c != nil {
+		c.EndMessage()
+	}
+}
+
+// SetIdleTimeout calls SetIdleTimeout on the *Conn wrapped by conn, if there is one
+func SetIdleTimeout(conn net.Conn, d time.Duration) {
+	if c := find(conn); c != nil {
+		c.SetIdleTimeout(d)
+	}
+}
+
+// ReadDeadline returns the explicit read deadline of the *Conn wrapped by conn. Without one, it returns the zero time
+func ReadDeadline(conn net.Conn) time.Time {
+	if c := find(conn); c != nil {
+		return c.ReadDeadline()
+	}
+	return time.Time{}
+}
+
+// SetPolicy calls SetPolicy on the *Conn wrapped by conn, if there is one
+func SetPolicy(conn net.Conn, p Policy) {
+	if c := find(conn); c != nil {
+		c.SetPolicy(p)
+	}
+}
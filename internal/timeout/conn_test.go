@@ -0,0 +1,197 @@
+package timeout
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func pipe(t *testing.T, policy Policy) (*Conn, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return New(server, policy), client
+}
+
+// readResult reads from the connection in a new goroutine
+func readResult(conn net.Conn, buffer []byte) chan error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(buffer)
+		result <- err
+	}()
+	return result
+}
+
+func waitErr(t *testing.T, result chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(time.Second * 2):
+		t.Fatalf("read did not return")
+		return nil
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	conn, _ := pipe(t, Policy{Idle: time.Millisecond * 50})
+	start := time.Now()
+	_, err := conn.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*50 {
+		t.Errorf("read returned after %v, before the idle timeout", elapsed)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	// The client keeps sending a byte every 20ms, which never triggers the idle timeout, but the message never ends
+	conn, client := pipe(t, Policy{Idle: time.Millisecond * 100, Read: time.Millisecond * 150})
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond * 20):
+				client.Write([]byte("x"))
+			}
+		}
+	}()
+
+	start := time.Now()
+	buffer := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buffer); err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("got %v, want %v", err, os.ErrDeadlineExceeded)
+			}
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("read timeout did not fire")
+		}
+	}
+}
+
+func TestEndMessage(t *testing.T) {
+	conn, client := pipe(t, Policy{Read: time.Millisecond * 100})
+	buffer := make([]byte, 1)
+	for range 3 {
+		go client.Write([]byte("x"))
+		if _, err := conn.Read(buffer); err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		conn.EndMessage()
+		time.Sleep(time.Millisecond * 60)
+	}
+	// Without EndMessage, the total time taken would exceed the read timeout
+	go client.Write([]byte("x"))
+	if _, err := conn.Read(buffer); err != nil {
+		t.Fatalf("read failed after idle period: %v", err)
+	}
+}
+
+func TestBeginMessage(t *testing.T) {
+	conn, _ := pipe(t, Policy{Read: time.Millisecond * 50})
+	conn.BeginMessage()
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestDisabledIdleTimeout(t *testing.T) {
+	conn, client := pipe(t, Policy{Idle: time.Millisecond * 50})
+	conn.SetIdleTimeout(0)
+	result := readResult(conn, make([]byte, 1))
+	time.Sleep(time.Millisecond * 100)
+	client.Write([]byte("x"))
+	if err := waitErr(t, result); err != nil {
+		t.Fatalf("read failed with the idle timeout disabled: %v", err)
+	}
+}
+
+func TestExplicitDeadline(t *testing.T) {
+	// An explicit deadline interrupts a blocked read, and is not replaced by the policy on the next read
+	conn, _ := pipe(t, Policy{Idle: time.Hour})
+	result := readResult(conn, make([]byte, 1))
+	time.Sleep(time.Millisecond * 20)
+	conn.SetReadDeadline(time.Now())
+	if err := waitErr(t, result); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if err := waitErr(t, readResult(conn, make([]byte, 1))); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want %v on the next read", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	// Nobody reads from the other end of the pipe, so the write blocks
+	conn, _ := pipe(t, Policy{Write: time.Millisecond * 50})
+	if _, err := conn.Write([]byte("x")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+type wrapper struct {
+	net.Conn
+}
+
+func (w *wrapper) Unwrap() net.Conn {
+	return w.Conn
+}
+
+func TestFind(t *testing.T) {
+	conn, _ := pipe(t, Policy{})
+	if find(&wrapper{Conn: &wrapper{Conn: conn}}) != conn {
+		t.Errorf("find did not return the wrapped *Conn")
+	}
+	if find(&wrapper{Conn: conn.Conn}) != nil {
+		t.Errorf("find returned a *Conn that is not in the chain")
+	}
+	// The helpers are no-ops on connections without a policy
+	EndMessage(conn.Conn)
+	SetIdleTimeout(conn.Conn, time.Second)
+}
+
+func TestOverride(t *testing.T) {
+	base := Policy{Idle: time.Minute, Read: time.Second, Write: time.Second}
+	got := base.Override(Policy{Idle: -1, Read: time.Hour})
+	want := Policy{Idle: 0, Read: time.Hour, Write: time.Second}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if !(Policy{}).IsZero() || base.IsZero() {
+		t.Errorf("IsZero returned the wrong result")
+	}
+}
+
+func TestSetPolicy(t *testing.T) {
+	conn, _ := pipe(t, Policy{})
+	SetPolicy(&wrapper{Conn: conn}, Policy{Idle: time.Millisecond * 50})
+	if err := waitErr(t, readResult(conn, make([]byte, 1))); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	conn, _ := pipe(t, Policy{})
+	result := readResult(conn, make([]byte, 1))
+	conn.Interrupt()
+	if err := waitErr(t, result); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	// Deadlines set after the interrupt don't undo it
+	conn.SetReadDeadline(time.Time{})
+	if err := waitErr(t, readResult(conn, make([]byte, 1))); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %v after clearing the deadline, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
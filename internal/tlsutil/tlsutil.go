@@ -0,0 +1,108 @@
+// Package tlsutil loads the certificates used to serve and dial TLS connections, and reloads them without restarting
+// the servers.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Config holds the certificate served by TLS listeners. TLS is disabled when both files are empty
+type Config struct {
+	CertFile string
+	KeyFile  string
+}
+
+// RegisterFlags binds the config to command line flags
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.CertFile, "tls-cert", c.CertFile, "PEM certificate (chain) served by tcp services, enables TLS")
+	fs.StringVar(&c.KeyFile, "tls-key", c.KeyFile, "PEM private key of the certificate given by -tls-cert")
+}
+
+// Enabled returns true if a certificate has been configured
+func (c Config) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// Validate checks that either both files or none of them are given
+func (c Config) Validate() error {
+	if c.Enabled() && (c.CertFile == "" || c.KeyFile == "") {
+		return errors.New("both a certificate and a key are required for TLS")
+	}
+	return nil
+}
+
+// Reloader serves the certificate of a Config, and loads it again from disk on Reload. Connections that are already
+// established keep using the certificate they were created with.
+type Reloader struct {
+	cfg Config
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewReloader loads the certificate of the config. It fails if the files can't be loaded
+func NewReloader(cfg Config) (*Reloader, error) {
+	if !cfg.Enabled() {
+		return nil, errors.New("no certificate configured")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	r := &Reloader{cfg: cfg}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate from disk. If loading fails, the previous certificate is kept
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	return nil
+}
+
+// GetCertificate returns the current certificate, it's meant to be used as tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// ServerConfig returns a TLS config that always serves the current certificate
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// ClientConfig returns a TLS config for dialing servers. If caFile is not empty, server certificates are verified
+// against the PEM bundle in it instead of the system roots.
+func ClientConfig(caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return cfg, nil
+	}
+	bundle, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %q", caFile)
+	}
+	cfg.RootCAs = pool
+	return cfg, nil
+}
@@ -0,0 +1,118 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeCert writes a new self signed certificate to dir, overwriting the previous one
+func writeCert(t *testing.T, dir string, commonName string) Config {
+	t.Helper()
+	certPEM, keyPEM, err := SelfSigned(commonName, "127.0.0.1", "localhost")
+	if err != nil {
+		t.Fatalf("generate certificate: %v", err)
+	}
+	cfg := Config{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	if err := os.WriteFile(cfg.CertFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cfg.KeyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func currentCommonName(t *testing.T, r *Reloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate failed: %v", err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	cfg := writeCert(t, dir, "first")
+	r, err := NewReloader(cfg)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+	if name := currentCommonName(t, r); name != "first" {
+		t.Fatalf("got %q, want %q", name, "first")
+	}
+
+	writeCert(t, dir, "second")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if name := currentCommonName(t, r); name != "second" {
+		t.Fatalf("got %q after reload, want %q", name, "second")
+	}
+
+	// A broken certificate keeps the previous one
+	os.WriteFile(cfg.CertFile, []byte("garbage"), 0o600)
+	if err := r.Reload(); err == nil {
+		t.Fatalf("expected an error when reloading an invalid certificate")
+	}
+	if name := currentCommonName(t, r); name != "second" {
+		t.Fatalf("got %q after failed reload, want %q", name, "second")
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	if _, err := NewReloader(Config{CertFile: "cert.pem"}); err == nil {
+		t.Errorf("expected an error without a key")
+	}
+	if _, err := NewReloader(Config{CertFile: "missing.pem", KeyFile: "missing.pem"}); err == nil {
+		t.Errorf("expected an error for missing files")
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	cfg := writeCert(t, dir, "server")
+	r, err := NewReloader(cfg)
+	if err != nil {
+		t.Fatalf("NewReloader failed: %v", err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", r.ServerConfig())
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("x"))
+			conn.Close()
+		}
+	}()
+
+	// The self signed certificate is its own CA
+	clientConfig, err := ClientConfig(cfg.CertFile)
+	if err != nil {
+		t.Fatalf("ClientConfig failed: %v", err)
+	}
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("dial with CA bundle failed: %v", err)
+	}
+	conn.Close()
+
+	// The system roots don't trust the certificate
+	clientConfig, _ = ClientConfig("")
+	if conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig); err == nil {
+		conn.Close()
+		t.Errorf("dial without CA bundle succeeded")
+	}
+
+	if _, err := ClientConfig(cfg.KeyFile); err == nil {
+		t.Errorf("expected an error for a bundle without certificates")
+	}
+}
@@ -0,0 +1,123 @@
+package vnet
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type datagram struct {
+	from net.Addr
+	data []byte
+}
+
+// PacketConn sends and receives the datagrams of an address
+type PacketConn struct {
+	network *Network
+	addr    Addr
+
+	mu            sync.Mutex
+	queue         []datagram
+	closed        bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+	changed       chan struct{} // Closed and replaced every time the connection changes
+}
+
+func newPacketConn(n *Network, addr Addr) *PacketConn {
+	return &PacketConn{network: n, addr: addr, changed: make(chan struct{})}
+}
+
+// notify wakes up the reader. The caller must hold mu
+func (c *PacketConn) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *PacketConn) enqueue(from net.Addr, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.queue = append(c.queue, datagram{from: from, data: append([]byte(nil), data...)})
+	c.notify()
+}
+
+// ReadFrom returns the next datagram. Like UDP, the part of the datagram that doesn't fit in b is discarded
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return 0, nil, net.ErrClosed
+		}
+		if len(c.queue) > 0 {
+			d := c.queue[0]
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+			return copy(b, d.data), d.from, nil
+		}
+		deadline, changed := c.readDeadline, c.changed
+		c.mu.Unlock()
+		if !wait(c.network.clock, deadline, changed) {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// WriteTo sends a datagram. It is dropped if no connection is bound to the address, or if the network loses it
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	closed, deadline := c.closed, c.writeDeadline
+	c.mu.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	if expired(c.network.clock, deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	c.network.deliver(c.addr, addr, b)
+	return len(b), nil
+}
+
+// Close unbinds the address, and discards the datagrams that were not read
+func (c *PacketConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.queue = nil
+	c.notify()
+	c.network.unbindPacketConn(c)
+	return nil
+}
+
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	c.SetWriteDeadline(t)
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of reads, on the clock of the network
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.notify()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of writes, on the clock of the network
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return nil
+}
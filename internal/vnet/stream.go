@@ -0,0 +1,230 @@
+package vnet
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/clock"
+)
+
+// backlogSize is the number of connections a listener queues before Dial fails
+const backlogSize = 128
+
+// Listener accepts the connections dialed to its address
+type Listener struct {
+	network   *Network
+	addr      Addr
+	backlog   chan *Conn
+	done      chan struct{} // Closed when the listener is closed
+	closeOnce sync.Once
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.backlog:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close unbinds the listener. Connections that were not accepted yet are closed
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		l.network.unbindListener(l)
+		close(l.done)
+		for {
+			select {
+			case conn := <-l.backlog:
+				conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// pipe carries the bytes of one direction of a stream
+type pipe struct {
+	mu       sync.Mutex
+	buffer   []byte
+	eof      bool          // The writer has closed its end
+	closed   bool          // The reader has closed its end
+	deadline time.Time     // Read deadline
+	changed  chan struct{} // Closed and replaced every time the pipe changes
+}
+
+func newPipe() *pipe {
+	return &pipe{changed: make(chan struct{})}
+}
+
+// notify wakes up the reader. The caller must hold mu
+func (p *pipe) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// Conn is one end of a stream
+type Conn struct {
+	clock      clock.Clock
+	localAddr  Addr
+	remoteAddr Addr
+	in, out    *pipe
+
+	mu            sync.Mutex
+	closed        bool
+	writeDeadline time.Time
+}
+
+// newStreamPair creates the two ends of a stream between a client and a server
+func newStreamPair(c clock.Clock, clientAddr, serverAddr Addr) (client, server *Conn) {
+	toServer, toClient := newPipe(), newPipe()
+	client = &Conn{clock: c, localAddr: clientAddr, remoteAddr: serverAddr, in: toClient, out: toServer}
+	server = &Conn{clock: c, localAddr: serverAddr, remoteAddr: clientAddr, in: toServer, out: toClient}
+	return client, server
+}
+
+// Read reads the bytes written by the other end. It returns io.EOF once the other end is closed and every byte has been
+// read
+func (c *Conn) Read(b []byte) (int, error) {
+	p := c.in
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if len(p.buffer) > 0 {
+			n := copy(b, p.buffer)
+			p.buffer = p.buffer[n:]
+			p.mu.Unlock()
+			return n, nil
+		}
+		if p.eof {
+			p.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline, changed := p.deadline, p.changed
+		p.mu.Unlock()
+		if !wait(c.clock, deadline, changed) {
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// Write never blocks, the bytes are buffered until the other end reads them
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	closed, deadline := c.closed, c.writeDeadline
+	c.mu.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	if expired(c.clock, deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	p := c.out
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	p.buffer = append(p.buffer, b...)
+	p.notify()
+	return len(b), nil
+}
+
+// Close closes both directions. The other end reads io.EOF, and its writes fail
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.mu.Unlock()
+	c.in.mu.Lock()
+	c.in.closed = true
+	c.in.buffer = nil
+	c.in.notify()
+	c.in.mu.Unlock()
+	c.out.mu.Lock()
+	c.out.eof = true
+	c.out.notify()
+	c.out.mu.Unlock()
+	return nil
+}
+
+// CloseWrite closes the direction towards the other end, which reads io.EOF once it has read everything
+func (c *Conn) CloseWrite() error {
+	c.out.mu.Lock()
+	defer c.out.mu.Unlock()
+	c.out.eof = true
+	c.out.notify()
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetWriteDeadline(t)
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of reads, on the clock of the network
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.in.mu.Lock()
+	defer c.in.mu.Unlock()
+	c.in.deadline = t
+	// Wake up a blocked Read() so that it picks up the new deadline
+	c.in.notify()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of writes, on the clock of the network. Since writes never block, it only makes
+// writes fail once it has passed
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return nil
+}
+
+// expired returns true if the deadline is set and has passed on the clock
+func expired(c clock.Clock, deadline time.Time) bool {
+	return !deadline.IsZero() && !c.Now().Before(deadline)
+}
+
+// wait blocks until changed is closed, or until the deadline passes on the clock. It returns false if the deadline has
+// passed
+func wait(c clock.Clock, deadline time.Time, changed <-chan struct{}) bool {
+	if deadline.IsZero() {
+		<-changed
+		return true
+	}
+	if expired(c, deadline) {
+		return false
+	}
+	timer := c.NewTimer(deadline.Sub(c.Now()))
+	defer timer.Stop()
+	select {
+	case <-changed:
+		return true
+	case <-timer.C():
+		return false
+	}
+}
@@ -0,0 +1,224 @@
+// Package vnet is an in-memory network for end-to-end tests. Its streams and packets never leave the process, and its
+// deadlines run on a clock.Clock, so that handlers and listeners can be driven by scripted clients without sockets or
+// waiting in wall-clock time.
+//
+// Streams behave like TCP connections with unbounded buffers: writes never block, and closing one end makes the other
+// end read io.EOF once it has read everything. Packets behave like UDP datagrams: they are delivered to the packet
+// connection bound to their destination, or silently dropped if there is none.
+package vnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/ananthvk/protohackers-go/internal/clock"
+)
+
+// firstEphemeralPort is the first port given to listeners bound to port 0, and to clients that don't pick an address
+const firstEphemeralPort = 49152
+
+// ClientHost is the host of the addresses given to clients by Dial and by ListenPacket with port 0
+const ClientHost = "192.0.2.1"
+
+// Errors returned when an address can't be bound or reached
+var (
+	ErrAddressInUse      = errors.New("vnet: address already in use")
+	ErrConnectionRefused = errors.New("vnet: connection refused")
+)
+
+// Addr is an address of the network
+type Addr struct {
+	Net     string // "tcp" or "udp"
+	Address string // host:port
+}
+
+func (a Addr) Network() string { return a.Net }
+func (a Addr) String() string  { return a.Address }
+
+// DropFunc decides whether a packet is lost on its way from one address to another
+type DropFunc func(from, to net.Addr, data []byte) bool
+
+// Network holds the listeners and packet connections bound to its addresses
+type Network struct {
+	clock clock.Clock
+
+	mu        sync.Mutex
+	listeners map[string]*Listener
+	packets   map[string]*PacketConn
+	nextPort  int
+	drop      DropFunc
+}
+
+// New creates an empty network whose deadlines run on the clock. A nil clock uses the system clock
+func New(c clock.Clock) *Network {
+	return &Network{
+		clock:     clock.Or(c),
+		listeners: map[string]*Listener{},
+		packets:   map[string]*PacketConn{},
+		nextPort:  firstEphemeralPort,
+	}
+}
+
+// Clock returns the clock of the network
+func (n *Network) Clock() clock.Clock {
+	return n.clock
+}
+
+// SetDrop sets the function that decides which packets are lost. nil delivers every packet
+func (n *Network) SetDrop(drop DropFunc) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.drop = drop
+}
+
+// Listen binds a stream listener to a host:port address. Port 0 picks a free port
+func (n *Network) Listen(address string) (*Listener, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	address, err := n.bind(address, func(a string) bool { return n.listeners[a] != nil })
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		network: n,
+		addr:    Addr{Net: "tcp", Address: address},
+		backlog: make(chan *Conn, backlogSize),
+		done:    make(chan struct{}),
+	}
+	n.listeners[address] = l
+	return l, nil
+}
+
+// ListenPacket binds a packet connection to a host:port address. Port 0 picks a free port
+func (n *Network) ListenPacket(address string) (*PacketConn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	address, err := n.bind(address, func(a string) bool { return n.packets[a] != nil })
+	if err != nil {
+		return nil, err
+	}
+	c := newPacketConn(n, Addr{Net: "udp", Address: address})
+	n.packets[address] = c
+	return c, nil
+}
+
+// Dial connects to the listener bound to the address, from a new client address
+func (n *Network) Dial(address string) (*Conn, error) {
+	return n.DialFrom(net.JoinHostPort(ClientHost, "0"), address)
+}
+
+// DialFrom connects to the listener bound to the address, from the given client address. Port 0 picks a free port
+func (n *Network) DialFrom(from, address string) (*Conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	l := n.listeners[address]
+	if l == nil {
+		return nil, fmt.Errorf("dial %s: %w", address, ErrConnectionRefused)
+	}
+	from, err := n.bind(from, func(string) bool { return false })
+	if err != nil {
+		return nil, err
+	}
+	client, server := newStreamPair(n.clock, Addr{Net: "tcp", Address: from}, l.addr)
+	select {
+	case l.backlog <- server:
+		return client, nil
+	case <-l.done:
+		return nil, fmt.Errorf("dial %s: %w", address, ErrConnectionRefused)
+	default:
+		return nil, fmt.Errorf("dial %s: backlog is full: %w", address, ErrConnectionRefused)
+	}
+}
+
+// bind validates a host:port address, and replaces port 0 by a free port. The caller must hold mu
+func (n *Network) bind(address string, used func(string) bool) (string, error) {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return "", fmt.Errorf("vnet: invalid address %q, want ip:port", address)
+	}
+	if addrPort.Port() != 0 {
+		if used(address) {
+			return "", fmt.Errorf("bind %s: %w", address, ErrAddressInUse)
+		}
+		return address, nil
+	}
+	for {
+		candidate := netip.AddrPortFrom(addrPort.Addr(), uint16(n.nextPort)).String()
+		n.nextPort++
+		if n.nextPort > 65535 {
+			n.nextPort = firstEphemeralPort
+		}
+		if !used(candidate) {
+			return candidate, nil
+		}
+	}
+}
+
+// deliver queues a packet on the connection bound to its destination
+func (n *Network) deliver(from, to net.Addr, data []byte) {
+	n.mu.Lock()
+	drop, target := n.drop, n.packets[to.String()]
+	n.mu.Unlock()
+	if target == nil || (drop != nil && drop(from, to, data)) {
+		return
+	}
+	target.enqueue(from, data)
+}
+
+func (n *Network) unbindListener(l *Listener) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.listeners[l.addr.Address] == l {
+		delete(n.listeners, l.addr.Address)
+	}
+}
+
+func (n *Network) unbindPacketConn(c *PacketConn) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.packets[c.addr.Address] == c {
+		delete(n.packets, c.addr.Address)
+	}
+}
+
+// Serve accepts connections from the listener, and runs handle on each of them in its own goroutine. Once ctx is done,
+// it closes the listener and the connections, and waits for the handlers to return.
+func Serve(ctx context.Context, l net.Listener, handle func(ctx context.Context, conn net.Conn)) {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		conns = map[net.Conn]struct{}{}
+	)
+	stop := context.AfterFunc(ctx, func() {
+		l.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for conn := range conns {
+			conn.Close()
+		}
+	})
+	defer stop()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			break
+		}
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+		wg.Go(func() {
+			defer func() {
+				conn.Close()
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+			}()
+			handle(ctx, conn)
+		})
+	}
+	wg.Wait()
+}
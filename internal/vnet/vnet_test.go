@@ -0,0 +1,119 @@
+package vnet
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ananthvk/protohackers-go/internal/clock"
+)
+
+func TestStream(t *testing.T) {
+	network := New(clock.NewVirtual())
+	listener, err := network.Listen("10.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := network.Listen(listener.Addr().String()); !errors.Is(err, ErrAddressInUse) {
+		t.Errorf("got %v, want ErrAddressInUse", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		Serve(ctx, listener, func(ctx context.Context, conn net.Conn) {
+			io.Copy(conn, conn)
+		})
+	}()
+
+	client, err := network.DialFrom("10.1.2.3:4000", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := client.LocalAddr().String(); got != "10.1.2.3:4000" {
+		t.Errorf("got local address %q", got)
+	}
+	client.Write([]byte("hello\n"))
+	line, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Errorf("got %q, %v, want the echo", line, err)
+	}
+
+	// Stopping the server closes the connection
+	cancel()
+	<-served
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+	if _, err := network.Dial(listener.Addr().String()); !errors.Is(err, ErrConnectionRefused) {
+		t.Errorf("got %v, want ErrConnectionRefused", err)
+	}
+}
+
+func TestStreamReadDeadline(t *testing.T) {
+	virtual := clock.NewVirtual()
+	network := New(virtual)
+	listener, err := network.Listen("10.0.0.1:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := network.Dial("10.0.0.1:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.SetReadDeadline(virtual.Now().Add(time.Second))
+	result := make(chan error)
+	go func() {
+		_, err := client.Read(make([]byte, 1))
+		result <- err
+	}()
+	virtual.WaitTimers(1)
+	virtual.Advance(time.Second)
+	if err := <-result; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %v, want os.ErrDeadlineExceeded", err)
+	}
+}
+
+func TestPackets(t *testing.T) {
+	network := New(clock.NewVirtual())
+	server, err := network.ListenPacket("10.0.0.1:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := network.ListenPacket("10.0.0.2:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	network.SetDrop(func(from, to net.Addr, data []byte) bool {
+		return string(data) == "lost"
+	})
+	for _, payload := range []string{"first", "lost", "second"} {
+		if _, err := client.WriteTo([]byte(payload), server.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Packets to unbound addresses disappear
+	if _, err := client.WriteTo([]byte("nowhere"), Addr{Net: "udp", Address: "10.9.9.9:9"}); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := make([]byte, 16)
+	for _, want := range []string{"first", "second"} {
+		n, from, err := server.ReadFrom(buffer)
+		if err != nil || string(buffer[:n]) != want || from.String() != client.LocalAddr().String() {
+			t.Errorf("got %q from %v, %v, want %q from %v", buffer[:n], from, err, want, client.LocalAddr())
+		}
+	}
+}